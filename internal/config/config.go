@@ -9,7 +9,11 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	Redis    RedisConfig
+	Hub      HubConfig
 	JWT      JWTConfig
+	Messages MessagesConfig
+	Presence PresenceConfig
+	Password PasswordConfig
 }
 
 type ServerConfig struct {
@@ -18,6 +22,8 @@ type ServerConfig struct {
 }
 
 type DatabaseConfig struct {
+	Driver   string // memory, postgres или bolt
+	Path     string // файл базы для bolt
 	Host     string
 	Port     string
 	User     string
@@ -33,9 +39,50 @@ type RedisConfig struct {
 	DB       int
 }
 
+type HubConfig struct {
+	Backplane         string // none (один экземпляр сервера) или redis
+	TypingThrottle    int    // в секундах: как часто рассылать typing: true от пользователя, 0 - каждый кадр
+	TypingTimeout     int    // в секундах: через сколько снимать индикатор без новых typing: true
+	ClientQueueSize   int    // сколько кадров ждут отправки одному клиенту
+	SlowClientTimeout int    // в секундах: сколько очередь клиента может быть переполнена до отключения
+}
+
+// DefaultJWTSecret значение JWT_SECRET по умолчанию. С ним сервер не
+// запускается: секрет известен всем, кто видел исходники.
+const DefaultJWTSecret = "your-secret-key-change-in-production"
+
 type JWTConfig struct {
-	SecretKey string
-	ExpiresIn int // в часах
+	SecretKey        string // секрет HS256, если не задан набор ключей
+	ExpiresIn        int    // в часах
+	RefreshExpiresIn int    // в часах
+	Issuer           string // claim iss выдаваемых токенов
+	Audience         string // claim aud; токены с другой аудиторией не принимаются
+	Keys             string // ключи подписи "kid:алгоритм:ключ" через запятую, пусто - HS256 с SecretKey
+	KeyID            string // ключ для новых токенов, пусто - последний в списке с закрытой частью
+	Leeway           int    // в секундах: допустимое расхождение часов при проверке exp, nbf и iat
+}
+
+type MessagesConfig struct {
+	EditWindow         int    // в минутах, 0 - без ограничения
+	RevisionRetention  int    // в днях, 0 - хранить ревизии бессрочно
+	DedupWindow        int    // в минутах, 0 - повтор client_msg_id всегда считается дублем
+	ReceiptsMaxMembers int    // отметки о доставке ведутся в группах не больше этого размера, 0 - только в личных чатах
+	EncryptionKeys     string // ключи шифрования текста "id:алгоритм:base64" через запятую, пусто - не шифровать
+	EncryptionKeyID    string // ключ для нового текста, пусто - последний в списке
+	ReencryptInterval  int    // в минутах, 0 - не перешифровывать текст активным ключом
+	EncryptionStrict   bool   // не принимать открытый текст: весь прежний текст уже перешифрован
+}
+
+type PresenceConfig struct {
+	IdleTimeout int // в минутах, 0 - не переводить в away по неактивности
+}
+
+type PasswordConfig struct {
+	Algorithm         string // argon2id или bcrypt
+	Argon2Memory      int    // в КиБ
+	Argon2Iterations  int
+	Argon2Parallelism int
+	BcryptCost        int
 }
 
 func Load() *Config {
@@ -51,6 +98,8 @@ func Load() *Config {
 			Host: serverHost,
 		},
 		Database: DatabaseConfig{
+			Driver:   getEnv("DB_DRIVER", "memory"),
+			Path:     getEnv("DB_PATH", "gomessage.db"),
 			Host:     getEnv("DB_HOST", "localhost"),
 			Port:     getEnv("DB_PORT", "5432"),
 			User:     getEnv("DB_USER", "gomessage"),
@@ -64,9 +113,42 @@ func Load() *Config {
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
+		Hub: HubConfig{
+			Backplane:         getEnv("HUB_BACKPLANE", "none"),
+			TypingThrottle:    getEnvAsInt("TYPING_THROTTLE", 3),
+			TypingTimeout:     getEnvAsInt("TYPING_TIMEOUT", 10),
+			ClientQueueSize:   getEnvAsInt("HUB_CLIENT_QUEUE", 256),
+			SlowClientTimeout: getEnvAsInt("HUB_SLOW_CLIENT_TIMEOUT", 10),
+		},
 		JWT: JWTConfig{
-			SecretKey: getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-			ExpiresIn: getEnvAsInt("JWT_EXPIRES_IN", 24),
+			SecretKey:        getEnv("JWT_SECRET", DefaultJWTSecret),
+			ExpiresIn:        getEnvAsInt("JWT_EXPIRES_IN", 1),
+			RefreshExpiresIn: getEnvAsInt("JWT_REFRESH_EXPIRES_IN", 720),
+			Issuer:           getEnv("JWT_ISSUER", "gomessage"),
+			Audience:         getEnv("JWT_AUDIENCE", "gomessage"),
+			Keys:             getEnv("JWT_KEYS", ""),
+			KeyID:            getEnv("JWT_KEY_ID", ""),
+			Leeway:           getEnvAsInt("JWT_LEEWAY", 30),
+		},
+		Messages: MessagesConfig{
+			EditWindow:         getEnvAsInt("MESSAGE_EDIT_WINDOW", 2880),
+			RevisionRetention:  getEnvAsInt("MESSAGE_REVISION_RETENTION", 365),
+			DedupWindow:        getEnvAsInt("MESSAGE_DEDUP_WINDOW", 1440),
+			ReceiptsMaxMembers: getEnvAsInt("MESSAGE_RECEIPTS_MAX_MEMBERS", 32),
+			EncryptionKeys:     getEnv("MESSAGE_ENCRYPTION_KEYS", ""),
+			EncryptionKeyID:    getEnv("MESSAGE_ENCRYPTION_KEY_ID", ""),
+			ReencryptInterval:  getEnvAsInt("MESSAGE_REENCRYPT_INTERVAL", 1440),
+			EncryptionStrict:   getEnvAsBool("MESSAGE_ENCRYPTION_STRICT", false),
+		},
+		Presence: PresenceConfig{
+			IdleTimeout: getEnvAsInt("PRESENCE_IDLE_TIMEOUT", 5),
+		},
+		Password: PasswordConfig{
+			Algorithm:         getEnv("PASSWORD_HASH", "argon2id"),
+			Argon2Memory:      getEnvAsInt("ARGON2_MEMORY", 64*1024),
+			Argon2Iterations:  getEnvAsInt("ARGON2_ITERATIONS", 3),
+			Argon2Parallelism: getEnvAsInt("ARGON2_PARALLELISM", 4),
+			BcryptCost:        getEnvAsInt("BCRYPT_COST", 12),
 		},
 	}
 }
@@ -78,6 +160,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+// legacyVectors хеши hash_password из прежней реализации на C (crypto.c
+// с OpenSSL), по которым сохранены пароли старых пользователей
+var legacyVectors = []struct {
+	name     string
+	salt     string
+	password string
+	hash     string
+}{
+	{"empty", "", "",
+		"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"},
+	{"empty salt", "", "password123",
+		"bed4efa1d4fdbd954bd3705d6a2a78270ec9a52ecfbfb010c61862af5c76af1761ffeb1aef6aca1bf5d02b3781aa854fabd2b69c790de74e17ecfec3cb6ac4bf"},
+	{"generated salt", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "password123",
+		"da7a4faaf9ecb3288700be563d9645f2d7037ef8a7298aeae20d72e2f5d717ebda84ac6cc43e050a8e9560d89d10ec9934edf966a2d3be512b01053e52ce796d"},
+	{"passphrase", "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90", "correct horse battery staple",
+		"d943e90b347d7221ea2beca13ef97db5997e2f3e7c1100eca93326918ddfa1034dfcba6e1c2b656b565e4c0916f93d37b46b376d80337525e7f24b900d5aebd5"},
+	{"utf-8 password", "5a17", "пароль",
+		"6b24eded2c3f32424d68ae9e80958b7cb51abb1f5d6fd6af0b736dc3c1034bcd34b6aa442e768d0010f2b5c358f4f4720e3bbc2e8c3b850d37e865c705c360b5"},
+	// strlen в C останавливался на нулевом байте: хешировался только "pass"
+	{"embedded NUL", "salt", "pass\x00word",
+		"4ab3490b9dd9fbcd6eb9ec6e2078a99c8de5d4d0ae1371faad97fdc83774dbeeec52c971c41971f71b131587c1becb1707435b24771d392631298647ba04e37d"},
+	{"NUL prefix", "salt", "pass",
+		"4ab3490b9dd9fbcd6eb9ec6e2078a99c8de5d4d0ae1371faad97fdc83774dbeeec52c971c41971f71b131587c1becb1707435b24771d392631298647ba04e37d"},
+}
+
+func TestHashPasswordMatchesLegacy(t *testing.T) {
+	c := NewCryptoService()
+	for _, tt := range legacyVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := c.HashPassword(tt.password, tt.salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tt.hash {
+				t.Fatalf("HashPassword = %s, want %s", hash, tt.hash)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordLegacy(t *testing.T) {
+	c := NewCryptoService()
+	for _, tt := range legacyVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			if !c.VerifyPassword(tt.password, tt.hash, tt.salt) {
+				t.Fatal("VerifyPassword rejected the legacy hash")
+			}
+			if c.VerifyPassword("x"+tt.password, tt.hash, tt.salt) {
+				t.Fatal("VerifyPassword accepted a wrong password")
+			}
+			if c.VerifyPassword(tt.password, tt.hash, tt.salt+"0") {
+				t.Fatal("VerifyPassword accepted a wrong salt")
+			}
+			if c.VerifyPassword(tt.password, strings.ToUpper(tt.hash), tt.salt) {
+				t.Fatal("VerifyPassword accepted an upper-case hash")
+			}
+		})
+	}
+}
+
+func TestGenerateSalt(t *testing.T) {
+	c := NewCryptoService()
+	first, err := c.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2*saltLength || strings.Trim(first, "0123456789abcdef") != "" {
+		t.Fatalf("salt %q is not %d hex characters", first, 2*saltLength)
+	}
+	if first == second {
+		t.Fatal("GenerateSalt returned the same salt twice")
+	}
+}
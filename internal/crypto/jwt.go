@@ -0,0 +1,361 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Алгоритмы подписи JWT (RFC 7518, RFC 8037)
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgEdDSA = "EdDSA"
+	JWTAlgRS256 = "RS256"
+)
+
+const (
+	minHMACKeyLength = 32
+	minRSAKeyBits    = 2048
+)
+
+// Ошибки подписи и проверки JWT
+var (
+	ErrJWTKeySet     = errors.New("некорректный набор ключей JWT")
+	ErrJWTMalformed  = errors.New("некорректный формат JWT")
+	ErrJWTAlgorithm  = errors.New("неподдерживаемый алгоритм JWT")
+	ErrJWTUnknownKey = errors.New("неизвестный ключ JWT")
+	ErrJWTSignature  = errors.New("неверная подпись JWT")
+)
+
+// jwtKey ключ подписи JWT. У ключей, оставленных только для проверки
+// токенов, выданных до смены ключа, нет закрытой части.
+type jwtKey struct {
+	alg     string
+	secret  []byte          // HS256
+	private gocrypto.Signer // EdDSA и RS256; nil - только проверка
+	public  gocrypto.PublicKey
+}
+
+// JWTKeySet набор ключей подписи JWT. Новые токены подписываются
+// активным ключом, а проверяются ключом из заголовка kid, поэтому после
+// смены ключа старые токены остаются действительными до истечения срока.
+type JWTKeySet struct {
+	keys   map[string]*jwtKey
+	active string
+}
+
+// NewHMACKeySet набор из одного ключа HS256. Секрет, как и ключи HS256
+// в ParseJWTKeySet, не короче 32 байт.
+func NewHMACKeySet(keyID string, secret []byte) (*JWTKeySet, error) {
+	if !isValidKeyID(keyID) {
+		return nil, fmt.Errorf("%w: некорректный kid %q", ErrJWTKeySet, keyID)
+	}
+	if len(secret) < minHMACKeyLength {
+		return nil, fmt.Errorf("%w: секрет HS256 должен быть не меньше %d байт", ErrJWTKeySet, minHMACKeyLength)
+	}
+	return &JWTKeySet{
+		keys:   map[string]*jwtKey{keyID: {alg: JWTAlgHS256, secret: secret}},
+		active: keyID,
+	}, nil
+}
+
+// ParseJWTKeySet разбирает список ключей вида "kid:алгоритм:ключ" через
+// запятую. Для HS256 ключ - не меньше 32 байт в base64, для EdDSA и RS256 -
+// путь к PEM файлу с закрытым ключом (PKCS#8, для RSA и PKCS#1) или с
+// открытым ключом, если ключ оставлен только для проверки. activeID -
+// ключ для новых токенов, пустой - последний в списке.
+func ParseJWTKeySet(keys, activeID string) (*JWTKeySet, error) {
+	set := &JWTKeySet{keys: make(map[string]*jwtKey)}
+	for _, spec := range strings.Split(keys, ",") {
+		parts := strings.SplitN(strings.TrimSpace(spec), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: ожидается kid:алгоритм:ключ", ErrJWTKeySet)
+		}
+		id, alg, value := parts[0], parts[1], parts[2]
+		if !isValidKeyID(id) {
+			return nil, fmt.Errorf("%w: некорректный kid %q", ErrJWTKeySet, id)
+		}
+		if _, exists := set.keys[id]; exists {
+			return nil, fmt.Errorf("%w: ключ %q указан дважды", ErrJWTKeySet, id)
+		}
+
+		key, err := parseJWTKey(alg, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ключ %q: %v", ErrJWTKeySet, id, err)
+		}
+		set.keys[id] = key
+		if key.canSign() {
+			set.active = id
+		}
+	}
+
+	if activeID != "" {
+		set.active = activeID
+	}
+	if set.active == "" {
+		return nil, fmt.Errorf("%w: нет ключа с закрытой частью", ErrJWTKeySet)
+	}
+	key, exists := set.keys[set.active]
+	if !exists {
+		return nil, fmt.Errorf("%w: активного ключа %q нет в наборе", ErrJWTKeySet, set.active)
+	}
+	if !key.canSign() {
+		return nil, fmt.Errorf("%w: у активного ключа %q нет закрытой части", ErrJWTKeySet, set.active)
+	}
+	return set, nil
+}
+
+// parseJWTKey разбирает ключ одного алгоритма
+func parseJWTKey(alg, value string) (*jwtKey, error) {
+	switch alg {
+	case JWTAlgHS256:
+		secret, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(secret) < minHMACKeyLength {
+			return nil, errors.New("ключ HS256 должен быть не меньше 32 байт в base64")
+		}
+		return &jwtKey{alg: alg, secret: secret}, nil
+
+	case JWTAlgEdDSA, JWTAlgRS256:
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("файл не в формате PEM")
+		}
+
+		key := &jwtKey{alg: alg}
+		var parsed interface{}
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("неподдерживаемый блок PEM %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := parsed.(type) {
+		case ed25519.PrivateKey:
+			key.private, key.public = k, k.Public()
+		case ed25519.PublicKey:
+			key.public = k
+		case *rsa.PrivateKey:
+			key.private, key.public = k, k.Public()
+		case *rsa.PublicKey:
+			key.public = k
+		default:
+			return nil, fmt.Errorf("неподдерживаемый тип ключа %T", parsed)
+		}
+
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			if alg != JWTAlgEdDSA {
+				return nil, fmt.Errorf("ключ Ed25519 для алгоритма %s", alg)
+			}
+		case *rsa.PublicKey:
+			if alg != JWTAlgRS256 {
+				return nil, fmt.Errorf("ключ RSA для алгоритма %s", alg)
+			}
+			if public.N.BitLen() < minRSAKeyBits {
+				return nil, fmt.Errorf("ключ RSA короче %d бит", minRSAKeyBits)
+			}
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("неизвестный алгоритм %q", alg)
+	}
+}
+
+// canSign проверяет, что ключом можно подписывать
+func (k *jwtKey) canSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+// ActiveKeyID kid ключа, которым подписываются новые токены
+func (s *JWTKeySet) ActiveKeyID() string {
+	return s.active
+}
+
+// ActiveAlgorithm алгоритм активного ключа
+func (s *JWTKeySet) ActiveAlgorithm() string {
+	return s.keys[s.active].alg
+}
+
+// jwtHeader заголовок JWT
+type jwtHeader struct {
+	Algorithm string   `json:"alg"`
+	Type      string   `json:"typ,omitempty"`
+	KeyID     string   `json:"kid,omitempty"`
+	Critical  []string `json:"crit,omitempty"`
+}
+
+// Sign сериализует claims в JSON и подписывает токен активным ключом
+func (s *JWTKeySet) Sign(claims interface{}) (string, error) {
+	key := s.keys[s.active]
+	header, err := json.Marshal(jwtHeader{Algorithm: key.alg, Type: "JWT", KeyID: s.active})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := key.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify проверяет подпись токена ключом из заголовка kid и возвращает
+// payload. Алгоритм должен совпадать с алгоритмом ключа, поэтому подменить
+// его на "none" или выдать открытый ключ RSA за секрет HS256 нельзя.
+// Claims (срок действия, издатель, аудитория) проверяет вызывающий.
+func (s *JWTKeySet) Verify(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, ErrJWTMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if header.Type != "" && !strings.EqualFold(header.Type, "JWT") {
+		return nil, ErrJWTMalformed
+	}
+	// Расширений заголовка мы не понимаем, а RFC 7515 требует отклонять такие токены
+	if len(header.Critical) > 0 {
+		return nil, ErrJWTAlgorithm
+	}
+
+	key, exists := s.keys[header.KeyID]
+	if !exists {
+		return nil, ErrJWTUnknownKey
+	}
+	if header.Algorithm != key.alg {
+		return nil, ErrJWTAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrJWTSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	return payload, nil
+}
+
+// sign подписывает данные ключом
+func (k *jwtKey) sign(data []byte) ([]byte, error) {
+	switch k.alg {
+	case JWTAlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case JWTAlgEdDSA:
+		return k.private.Sign(rand.Reader, data, gocrypto.Hash(0))
+	case JWTAlgRS256:
+		digest := sha256.Sum256(data)
+		return k.private.Sign(rand.Reader, digest[:], gocrypto.SHA256)
+	default:
+		return nil, ErrJWTAlgorithm
+	}
+}
+
+// verify проверяет подпись данных
+func (k *jwtKey) verify(data, signature []byte) bool {
+	switch k.alg {
+	case JWTAlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case JWTAlgEdDSA:
+		return ed25519.Verify(k.public.(ed25519.PublicKey), data, signature)
+	case JWTAlgRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.public.(*rsa.PublicKey), gocrypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// JWK открытый ключ в формате JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS набор открытых ключей для проверки токенов другими сервисами
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKeys возвращает открытые ключи набора по возрастанию kid. Ключи
+// HS256 симметричные и не публикуются.
+func (s *JWTKeySet) PublicKeys() JWKS {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		key := s.keys[id]
+		jwk := JWK{KeyID: id, Algorithm: key.alg, Use: "sig"}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,298 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testHMACSecret секрет HS256 для тестов: 32 байта
+var testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// writePEM сохраняет ключ в PEM файл во временном каталоге теста
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ed25519Files генерирует ключ Ed25519 и возвращает пути к закрытому и открытому ключам
+func ed25519Files(t *testing.T) (private, public string, key ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "ed.pem", "PRIVATE KEY", privateDER), writePEM(t, "ed.pub", "PUBLIC KEY", publicDER), key
+}
+
+// rsaFile генерирует ключ RSA и возвращает путь к закрытому ключу в PKCS#1
+func rsaFile(t *testing.T, bits int) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), key
+}
+
+// forgeJWT собирает токен с произвольным заголовком и подписью
+func forgeJWT(t *testing.T, header map[string]interface{}, payload string, sign func(input []byte) []byte) string {
+	t.Helper()
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+// hmacSign подпись HS256 произвольным секретом
+func hmacSign(secret []byte) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func TestJWTKeySetSignVerify(t *testing.T) {
+	edPrivate, _, _ := ed25519Files(t)
+	rsaPrivate, _ := rsaFile(t, 2048)
+	secret := base64.StdEncoding.EncodeToString(testHMACSecret)
+
+	tests := []struct {
+		name string
+		keys string
+		alg  string
+	}{
+		{"HS256", "h1:HS256:" + secret, JWTAlgHS256},
+		{"EdDSA", "e1:EdDSA:" + edPrivate, JWTAlgEdDSA},
+		{"RS256", "r1:RS256:" + rsaPrivate, JWTAlgRS256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := ParseJWTKeySet(tt.keys, "")
+			if err != nil {
+				t.Fatalf("ParseJWTKeySet: %v", err)
+			}
+			if set.ActiveAlgorithm() != tt.alg {
+				t.Fatalf("ActiveAlgorithm = %s, want %s", set.ActiveAlgorithm(), tt.alg)
+			}
+
+			token, err := set.Sign(map[string]string{"sub": "1"})
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			payload, err := set.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if string(payload) != `{"sub":"1"}` {
+				t.Fatalf("payload = %s", payload)
+			}
+
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"2"}`)) + "." + parts[2]
+			if _, err := set.Verify(tampered); !errors.Is(err, ErrJWTSignature) {
+				t.Fatalf("tampered payload: err = %v, want %v", err, ErrJWTSignature)
+			}
+		})
+	}
+}
+
+func TestJWTKeySetRotation(t *testing.T) {
+	edPrivate, edPublic, _ := ed25519Files(t)
+	rsaPrivate, _ := rsaFile(t, 2048)
+
+	old, err := ParseJWTKeySet("e1:EdDSA:"+edPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.Sign(map[string]string{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Прежний ключ оставлен только для проверки, новые токены подписывает r1
+	rotated, err := ParseJWTKeySet("e1:EdDSA:"+edPublic+",r1:RS256:"+rsaPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ActiveKeyID() != "r1" {
+		t.Fatalf("ActiveKeyID = %s, want r1", rotated.ActiveKeyID())
+	}
+	if _, err := rotated.Verify(token); err != nil {
+		t.Fatalf("token of the retired key: %v", err)
+	}
+
+	// Без ключа e1 токен не проверить
+	withoutOld, err := ParseJWTKeySet("r1:RS256:"+rsaPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withoutOld.Verify(token); !errors.Is(err, ErrJWTUnknownKey) {
+		t.Fatalf("err = %v, want %v", err, ErrJWTUnknownKey)
+	}
+
+	jwks := rotated.PublicKeys()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "e1" || jwks.Keys[0].KeyType != "OKP" ||
+		jwks.Keys[1].KeyID != "r1" || jwks.Keys[1].KeyType != "RSA" || jwks.Keys[1].E != "AQAB" {
+		t.Fatalf("PublicKeys = %+v", jwks)
+	}
+}
+
+func TestJWTKeySetRejectsConfusedTokens(t *testing.T) {
+	edPrivate, _, edKey := ed25519Files(t)
+	rsaPrivate, rsaKey := rsaFile(t, 2048)
+	secret := base64.StdEncoding.EncodeToString(testHMACSecret)
+
+	set, err := ParseJWTKeySet("h1:HS256:"+secret+",e1:EdDSA:"+edPrivate+",r1:RS256:"+rsaPrivate, "h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic := []byte(edKey.Public().(ed25519.PublicKey))
+	edPublicDER, _ := x509.MarshalPKIXPublicKey(edKey.Public())
+	edPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPublicDER})
+	rsaPublicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicDER})
+	payload := `{"sub":"1"}`
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{
+			name:  "alg none without signature",
+			token: forgeJWT(t, map[string]interface{}{"alg": "none", "kid": "h1"}, payload, func([]byte) []byte { return nil }),
+			want:  ErrJWTMalformed,
+		},
+		{
+			name:  "alg none with signature",
+			token: forgeJWT(t, map[string]interface{}{"alg": "none", "kid": "h1"}, payload, hmacSign(testHMACSecret)),
+			want:  ErrJWTAlgorithm,
+		},
+		{
+			name:  "HS256 with raw Ed25519 public key",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "e1"}, payload, hmacSign(edPublic)),
+			want:  ErrJWTAlgorithm,
+		},
+		{
+			name:  "HS256 with Ed25519 public key PEM",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "e1"}, payload, hmacSign(edPublicPEM)),
+			want:  ErrJWTAlgorithm,
+		},
+		{
+			name:  "HS256 with RSA public key PEM",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "r1"}, payload, hmacSign(rsaPublicPEM)),
+			want:  ErrJWTAlgorithm,
+		},
+		{
+			name: "RS256 header on HS256 key",
+			token: forgeJWT(t, map[string]interface{}{"alg": "RS256", "kid": "h1"}, payload, func(input []byte) []byte {
+				digest := sha256.Sum256(input)
+				signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, gocrypto.SHA256, digest[:])
+				return signature
+			}),
+			want: ErrJWTAlgorithm,
+		},
+		{
+			name: "EdDSA signature under RS256 kid",
+			token: forgeJWT(t, map[string]interface{}{"alg": "EdDSA", "kid": "r1"}, payload, func(input []byte) []byte {
+				return ed25519.Sign(edKey, input)
+			}),
+			want: ErrJWTAlgorithm,
+		},
+		{
+			name:  "unknown kid",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "h2"}, payload, hmacSign(testHMACSecret)),
+			want:  ErrJWTUnknownKey,
+		},
+		{
+			name:  "missing kid",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256"}, payload, hmacSign(testHMACSecret)),
+			want:  ErrJWTUnknownKey,
+		},
+		{
+			name:  "critical header",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "h1", "crit": []string{"exp"}}, payload, hmacSign(testHMACSecret)),
+			want:  ErrJWTAlgorithm,
+		},
+		{
+			name:  "wrong secret",
+			token: forgeJWT(t, map[string]interface{}{"alg": "HS256", "kid": "h1"}, payload, hmacSign([]byte("another-secret-another-secret-00"))),
+			want:  ErrJWTSignature,
+		},
+		{"two parts", "eyJhbGciOiJIUzI1NiJ9.e30", ErrJWTMalformed},
+		{"four parts", "a.b.c.d", ErrJWTMalformed},
+		{"header not base64", "!!!.e30.AAAA", ErrJWTMalformed},
+		{"header not JSON", base64.RawURLEncoding.EncodeToString([]byte("alg")) + ".e30.AAAA", ErrJWTMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := set.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJWTKeySetErrors(t *testing.T) {
+	edPrivate, edPublic, _ := ed25519Files(t)
+	weakRSA, _ := rsaFile(t, 1024)
+	secret := base64.StdEncoding.EncodeToString(testHMACSecret)
+
+	tests := []struct {
+		name     string
+		keys     string
+		activeID string
+	}{
+		{"empty", "", ""},
+		{"no algorithm", "h1:" + secret, ""},
+		{"short HS256 secret", "h1:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"unknown algorithm", "h1:HS512:" + secret, ""},
+		{"duplicate kid", "h1:HS256:" + secret + ",h1:HS256:" + secret, ""},
+		{"bad kid", "h 1:HS256:" + secret, ""},
+		{"missing file", "e1:EdDSA:/nonexistent/key.pem", ""},
+		{"Ed25519 key as RS256", "e1:RS256:" + edPrivate, ""},
+		{"RSA shorter than 2048 bits", "r1:RS256:" + weakRSA, ""},
+		{"only public keys", "e1:EdDSA:" + edPublic, ""},
+		{"active key is public", "e1:EdDSA:" + edPublic + ",h1:HS256:" + secret, "e1"},
+		{"active key missing", "h1:HS256:" + secret, "h2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWTKeySet(tt.keys, tt.activeID); !errors.Is(err, ErrJWTKeySet) {
+				t.Fatalf("err = %v, want %v", err, ErrJWTKeySet)
+			}
+		})
+	}
+}
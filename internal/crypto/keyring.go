@@ -0,0 +1,213 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Алгоритмы шифрования текста сообщений
+const (
+	CipherAES256GCM         = "aes-256-gcm"
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+const (
+	// sealedPrefix начало шифротекста: $aead$v=1$<ID ключа>$<base64(nonce || шифротекст)>
+	sealedPrefix    = "$aead$v=1$"
+	sealedKeyLength = 32
+	maxKeyIDLength  = 32
+)
+
+// Ошибки шифрования текста
+var (
+	ErrKeyRing    = errors.New("некорректный набор ключей шифрования")
+	ErrUnknownKey = errors.New("неизвестный ключ шифрования")
+	// ErrCiphertext шифротекст поврежден или зашифрован с другими associated data
+	ErrCiphertext = errors.New("некорректный шифротекст")
+	// ErrNotSealed текст не зашифрован, а набор ключей в строгом режиме
+	ErrNotSealed = errors.New("текст не зашифрован")
+)
+
+// KeyRing набор ключей шифрования текста. Новый текст шифруется активным
+// ключом, а расшифровывается ключом, ID которого записан в заголовке
+// шифротекста, поэтому старые ключи остаются в наборе, пока весь текст
+// не перешифрован.
+type KeyRing struct {
+	keys   map[string]cipher.AEAD
+	active string
+	strict bool // открытый текст не принимается
+}
+
+// ParseKeyRing разбирает список ключей вида "id:алгоритм:base64-ключ"
+// через запятую. activeID - ключ для нового текста, пустой - последний в
+// списке. Пустой список означает, что шифрование выключено: nil, nil.
+func ParseKeyRing(keys, activeID string) (*KeyRing, error) {
+	if strings.TrimSpace(keys) == "" {
+		return nil, nil
+	}
+
+	ring := &KeyRing{keys: make(map[string]cipher.AEAD)}
+	for _, spec := range strings.Split(keys, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: ожидается id:алгоритм:ключ", ErrKeyRing)
+		}
+		id, algorithm, encoded := parts[0], parts[1], parts[2]
+		if !isValidKeyID(id) {
+			return nil, fmt.Errorf("%w: некорректный ID ключа %q", ErrKeyRing, id)
+		}
+		if _, exists := ring.keys[id]; exists {
+			return nil, fmt.Errorf("%w: ключ %q указан дважды", ErrKeyRing, id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != sealedKeyLength {
+			return nil, fmt.Errorf("%w: ключ %q должен быть 32 байтами в base64", ErrKeyRing, id)
+		}
+		aead, err := newAEAD(algorithm, key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ключ %q: %v", ErrKeyRing, id, err)
+		}
+
+		ring.keys[id] = aead
+		ring.active = id
+	}
+
+	if activeID != "" {
+		if _, exists := ring.keys[activeID]; !exists {
+			return nil, fmt.Errorf("%w: активного ключа %q нет в наборе", ErrKeyRing, activeID)
+		}
+		ring.active = activeID
+	}
+	return ring, nil
+}
+
+// newAEAD создает шифр по названию алгоритма
+func newAEAD(algorithm string, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("неизвестный алгоритм %q", algorithm)
+	}
+}
+
+// SetStrict включает строгий режим: Open отклоняет непустой текст без
+// заголовка шифротекста, а не возвращает его как есть. Включается, когда
+// весь текст, сохраненный до шифрования, уже перешифрован: после этого
+// открытый текст в хранилище может появиться только в обход сервера.
+// Вызывается до использования набора.
+func (r *KeyRing) SetStrict(strict bool) {
+	r.strict = strict
+}
+
+// ActiveKeyID ID ключа, которым шифруется новый текст
+func (r *KeyRing) ActiveKeyID() string {
+	return r.active
+}
+
+// Seal шифрует текст активным ключом со случайным nonce. Associated data
+// не шифруется, но без нее текст не расшифровать: так шифротекст
+// привязывается к месту, где он хранится.
+func (r *KeyRing) Seal(plaintext string, associatedData []byte) (string, error) {
+	aead := r.keys[r.active]
+	header := sealedPrefix + r.active + "$"
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.New("ошибка генерации nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), sealedAD(header, associatedData))
+	return header + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open расшифровывает текст, зашифрованный Seal. Текст без заголовка
+// шифротекста (сохраненный до включения шифрования) возвращается как есть,
+// а в строгом режиме - только пустой.
+func (r *KeyRing) Open(content string, associatedData []byte) (string, error) {
+	if !IsSealed(content) {
+		if r.strict && content != "" {
+			return "", ErrNotSealed
+		}
+		return content, nil
+	}
+
+	keyID, encoded, ok := splitSealed(content)
+	if !ok {
+		return "", ErrCiphertext
+	}
+	aead, exists := r.keys[keyID]
+	if !exists {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", ErrCiphertext
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	header := sealedPrefix + keyID + "$"
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sealedAD(header, associatedData))
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReseal проверяет, нужно ли перешифровать текст: он хранится
+// открытым или зашифрован не активным ключом. Пустой текст не шифруется.
+func (r *KeyRing) NeedsReseal(content string) bool {
+	if content == "" {
+		return false
+	}
+	if !IsSealed(content) {
+		return true
+	}
+	keyID, _, ok := splitSealed(content)
+	return !ok || keyID != r.active
+}
+
+// IsSealed проверяет, что текст начинается с заголовка шифротекста
+func IsSealed(content string) bool {
+	return strings.HasPrefix(content, sealedPrefix)
+}
+
+// splitSealed разделяет шифротекст на ID ключа и base64 с nonce
+func splitSealed(content string) (keyID, encoded string, ok bool) {
+	keyID, encoded, ok = strings.Cut(strings.TrimPrefix(content, sealedPrefix), "$")
+	return keyID, encoded, ok && isValidKeyID(keyID)
+}
+
+// sealedAD associated data шифра: заголовок шифротекста и данные
+// вызывающего, чтобы нельзя было подменить ID ключа
+func sealedAD(header string, associatedData []byte) []byte {
+	return append([]byte(header), associatedData...)
+}
+
+// isValidKeyID ID ключа: латиница, цифры, '-' и '_', не длиннее 32 символов
+func isValidKeyID(id string) bool {
+	if id == "" || len(id) > maxKeyIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey ключ из 32 одинаковых байт в base64
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), sealedKeyLength)))
+}
+
+// newTestKeyRing разбирает набор ключей или останавливает тест
+func newTestKeyRing(t *testing.T, keys, activeID string) *KeyRing {
+	t.Helper()
+	ring, err := ParseKeyRing(keys, activeID)
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+	return ring
+}
+
+func TestKeyRingSealOpen(t *testing.T) {
+	for _, algorithm := range []string{CipherAES256GCM, CipherXChaCha20Poly1305} {
+		t.Run(algorithm, func(t *testing.T) {
+			ring := newTestKeyRing(t, "k1:"+algorithm+":"+testKey('a'), "")
+			ad := []byte("message:1:2")
+
+			sealed, err := ring.Seal("привет", ad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(sealed, "$aead$v=1$k1$") || strings.Contains(sealed, "привет") {
+				t.Fatalf("sealed = %q", sealed)
+			}
+			if again, _ := ring.Seal("привет", ad); again == sealed {
+				t.Fatal("Seal reused a nonce")
+			}
+
+			opened, err := ring.Open(sealed, ad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened != "привет" {
+				t.Fatalf("Open = %q", opened)
+			}
+
+			if _, err := ring.Open(sealed, []byte("message:1:3")); !errors.Is(err, ErrCiphertext) {
+				t.Fatalf("Open with other associated data = %v, want %v", err, ErrCiphertext)
+			}
+		})
+	}
+}
+
+func TestKeyRingOpenRejects(t *testing.T) {
+	ring := newTestKeyRing(t, "old:"+CipherAES256GCM+":"+testKey('a')+",new:"+CipherXChaCha20Poly1305+":"+testKey('b'), "")
+	ad := []byte("message:1:2")
+	sealed, err := ring.Seal("hello", ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := strings.TrimPrefix(sealed, "$aead$v=1$new$")
+	raw, _ := base64.RawStdEncoding.DecodeString(encoded)
+	raw[len(raw)-1] ^= 1
+	tampered := "$aead$v=1$new$" + base64.RawStdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{"unknown key", "$aead$v=1$gone$" + encoded, ErrUnknownKey},
+		{"key id swapped", "$aead$v=1$old$" + encoded, ErrCiphertext},
+		{"tampered ciphertext", tampered, ErrCiphertext},
+		{"truncated", "$aead$v=1$new$" + encoded[:10], ErrCiphertext},
+		{"not base64", "$aead$v=1$new$!!!", ErrCiphertext},
+		{"no key separator", "$aead$v=1$new", ErrCiphertext},
+		{"invalid key id", "$aead$v=1$n.w$" + encoded, ErrCiphertext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ring.Open(tt.content, ad); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyRingStrict(t *testing.T) {
+	ring := newTestKeyRing(t, "k1:"+CipherAES256GCM+":"+testKey('a'), "")
+
+	// До перешифрования открытый текст возвращается как есть
+	if opened, err := ring.Open("plain", nil); err != nil || opened != "plain" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+
+	ring.SetStrict(true)
+	if _, err := ring.Open("plain", nil); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("strict Open = %v, want %v", err, ErrNotSealed)
+	}
+	// Пустой текст не шифруется: у удаленных и E2EE сообщений его нет
+	if opened, err := ring.Open("", nil); err != nil || opened != "" {
+		t.Fatalf("strict Open of empty content = %q, %v", opened, err)
+	}
+	sealed, err := ring.Seal("hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := ring.Open(sealed, nil); err != nil || opened != "hello" {
+		t.Fatalf("strict Open of sealed content = %q, %v", opened, err)
+	}
+}
+
+func TestKeyRingNeedsReseal(t *testing.T) {
+	keys := "old:" + CipherAES256GCM + ":" + testKey('a') + ",new:" + CipherXChaCha20Poly1305 + ":" + testKey('b')
+	oldRing := newTestKeyRing(t, keys, "old")
+	ring := newTestKeyRing(t, keys, "")
+	if ring.ActiveKeyID() != "new" {
+		t.Fatalf("active key = %q, want %q", ring.ActiveKeyID(), "new")
+	}
+
+	sealedOld, _ := oldRing.Seal("hello", nil)
+	sealedNew, _ := ring.Seal("hello", nil)
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", false},
+		{"plaintext", "hello", true},
+		{"sealed with old key", sealedOld, true},
+		{"sealed with active key", sealedNew, false},
+		{"malformed header", "$aead$v=1$new", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ring.NeedsReseal(tt.content); got != tt.want {
+				t.Fatalf("NeedsReseal = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Старые шифротексты расшифровываются своим ключом
+	if opened, err := ring.Open(sealedOld, nil); err != nil || opened != "hello" {
+		t.Fatalf("Open with old key = %q, %v", opened, err)
+	}
+}
+
+func TestParseKeyRing(t *testing.T) {
+	if ring, err := ParseKeyRing(" ", ""); ring != nil || err != nil {
+		t.Fatalf("ParseKeyRing of empty list = %v, %v", ring, err)
+	}
+
+	tests := []struct {
+		name     string
+		keys     string
+		activeID string
+	}{
+		{"missing algorithm", "k1:" + testKey('a'), ""},
+		{"unknown algorithm", "k1:des:" + testKey('a'), ""},
+		{"short key", "k1:" + CipherAES256GCM + ":" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"invalid key id", "k$1:" + CipherAES256GCM + ":" + testKey('a'), ""},
+		{"duplicate key id", "k1:" + CipherAES256GCM + ":" + testKey('a') + ",k1:" + CipherAES256GCM + ":" + testKey('b'), ""},
+		{"unknown active key", "k1:" + CipherAES256GCM + ":" + testKey('a'), "k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeyRing(tt.keys, tt.activeID); !errors.Is(err, ErrKeyRing) {
+				t.Fatalf("ParseKeyRing = %v, want %v", err, ErrKeyRing)
+			}
+		})
+	}
+}
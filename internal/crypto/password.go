@@ -0,0 +1,192 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gomessage/internal/config"
+)
+
+// Алгоритмы хеширования паролей
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	argon2Prefix     = "$argon2id$"
+)
+
+// ErrPasswordConfig некорректные параметры хеширования паролей
+var ErrPasswordConfig = errors.New("некорректные параметры хеширования паролей")
+
+var (
+	passwordConfig = config.PasswordConfig{
+		Algorithm:         PasswordArgon2id,
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 4,
+		BcryptCost:        12,
+	}
+	passwordMutex sync.RWMutex
+)
+
+// SetPasswordConfig задает алгоритм и параметры для новых хешей паролей.
+// Хеши со старыми параметрами по-прежнему проверяются и пересчитываются
+// при входе.
+func SetPasswordConfig(cfg config.PasswordConfig) error {
+	switch cfg.Algorithm {
+	case PasswordArgon2id:
+		if cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Iterations < 1 ||
+			cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 {
+			return fmt.Errorf("%w: argon2id m=%d, t=%d, p=%d", ErrPasswordConfig,
+				cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism)
+		}
+	case PasswordBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("%w: bcrypt cost=%d", ErrPasswordConfig, cfg.BcryptCost)
+		}
+	default:
+		return fmt.Errorf("%w: неизвестный алгоритм %q", ErrPasswordConfig, cfg.Algorithm)
+	}
+
+	passwordMutex.Lock()
+	defer passwordMutex.Unlock()
+	passwordConfig = cfg
+	return nil
+}
+
+// getPasswordConfig текущие параметры хеширования
+func getPasswordConfig() config.PasswordConfig {
+	passwordMutex.RLock()
+	defer passwordMutex.RUnlock()
+	return passwordConfig
+}
+
+// NewPasswordHash хеширует пароль текущим алгоритмом и возвращает строку
+// в формате PHC: $argon2id$v=19$m=65536,t=3,p=4$<соль>$<хеш> или $2b$...
+// для bcrypt. Соль хранится в самой строке.
+func (c *CryptoService) NewPasswordHash(password string) (string, error) {
+	cfg := getPasswordConfig()
+
+	if cfg.Algorithm == PasswordBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.New("ошибка генерации соли")
+	}
+	params := argon2Params{
+		memory:      uint32(cfg.Argon2Memory),
+		iterations:  uint32(cfg.Argon2Iterations),
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash проверяет, нужно ли пересчитать хеш: он в старом формате
+// SHA-512, другим алгоритмом или с другими параметрами
+func (c *CryptoService) NeedsRehash(hash string) bool {
+	cfg := getPasswordConfig()
+
+	switch {
+	case strings.HasPrefix(hash, argon2Prefix):
+		if cfg.Algorithm != PasswordArgon2id {
+			return true
+		}
+		params, _, _, err := parseArgon2Hash(hash)
+		if err != nil {
+			return true
+		}
+		return params.memory != uint32(cfg.Argon2Memory) ||
+			params.iterations != uint32(cfg.Argon2Iterations) ||
+			params.parallelism != uint8(cfg.Argon2Parallelism)
+	case isBcryptHash(hash):
+		if cfg.Algorithm != PasswordBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != cfg.BcryptCost
+	default:
+		return true
+	}
+}
+
+// verifyPHCPassword проверяет пароль по хешу в формате PHC; ok = false,
+// если хеш не в формате PHC
+func verifyPHCPassword(password, hash string) (match, ok bool) {
+	switch {
+	case strings.HasPrefix(hash, argon2Prefix):
+		params, salt, key, err := parseArgon2Hash(hash)
+		if err != nil {
+			return false, true
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1, true
+	case isBcryptHash(hash):
+		// bcrypt сравнивает хеши за постоянное время
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, true
+	default:
+		return false, false
+	}
+}
+
+// argon2Params параметры argon2id из строки PHC
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// parseArgon2Hash разбирает строку $argon2id$v=19$m=..,t=..,p=..$соль$хеш
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrPasswordConfig
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrPasswordConfig
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, ErrPasswordConfig
+	}
+	if params.memory == 0 || params.iterations == 0 || params.parallelism == 0 {
+		return params, nil, nil, ErrPasswordConfig
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrPasswordConfig
+	}
+	return params, salt, key, nil
+}
+
+// isBcryptHash проверяет префикс хеша bcrypt
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gomessage/internal/config"
+)
+
+// Быстрые параметры хеширования для тестов
+var (
+	testArgon2 = config.PasswordConfig{Algorithm: PasswordArgon2id, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1, BcryptCost: 4}
+	testBcrypt = config.PasswordConfig{Algorithm: PasswordBcrypt, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1, BcryptCost: 4}
+)
+
+// usePasswordConfig задает параметры хеширования на время теста
+func usePasswordConfig(t *testing.T, cfg config.PasswordConfig) {
+	t.Helper()
+	previous := getPasswordConfig()
+	t.Cleanup(func() {
+		passwordMutex.Lock()
+		passwordConfig = previous
+		passwordMutex.Unlock()
+	})
+	if err := SetPasswordConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestHash хеширует пароль с параметрами cfg
+func newTestHash(t *testing.T, cfg config.PasswordConfig, password string) string {
+	t.Helper()
+	usePasswordConfig(t, cfg)
+	hash, err := NewCryptoService().NewPasswordHash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestPasswordHashRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.PasswordConfig
+		prefix string
+	}{
+		{"argon2id", testArgon2, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"bcrypt", testBcrypt, "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCryptoService()
+			hash := newTestHash(t, tt.cfg, "correct horse")
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Fatalf("hash %q does not start with %q", hash, tt.prefix)
+			}
+			if other := newTestHash(t, tt.cfg, "correct horse"); other == hash {
+				t.Fatal("two hashes of one password are equal")
+			}
+
+			// Соль в строке хеша: отдельная соль пользователя не нужна
+			if !c.VerifyPassword("correct horse", hash, "") {
+				t.Fatal("VerifyPassword rejected the password")
+			}
+			if !c.VerifyPassword("correct horse", hash, "ignored") {
+				t.Fatal("VerifyPassword used the legacy salt")
+			}
+			if c.VerifyPassword("correct horsE", hash, "") {
+				t.Fatal("VerifyPassword accepted a wrong password")
+			}
+			if c.NeedsRehash(hash) {
+				t.Fatal("fresh hash needs rehash")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2Hash := newTestHash(t, testArgon2, "secret")
+	bcryptHash := newTestHash(t, testBcrypt, "secret")
+	legacyHash, _ := NewCryptoService().HashPassword("secret", "salt")
+
+	withArgon2 := func(memory, iterations, parallelism int) config.PasswordConfig {
+		cfg := testArgon2
+		cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism = memory, iterations, parallelism
+		return cfg
+	}
+	withBcryptCost := func(cost int) config.PasswordConfig {
+		cfg := testBcrypt
+		cfg.BcryptCost = cost
+		return cfg
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.PasswordConfig
+		hash string
+		want bool
+	}{
+		{"argon2id same parameters", testArgon2, argon2Hash, false},
+		{"argon2id memory changed", withArgon2(128, 1, 1), argon2Hash, true},
+		{"argon2id iterations changed", withArgon2(64, 2, 1), argon2Hash, true},
+		{"argon2id parallelism changed", withArgon2(64, 1, 2), argon2Hash, true},
+		{"argon2id to bcrypt", testBcrypt, argon2Hash, true},
+		{"bcrypt same cost", testBcrypt, bcryptHash, false},
+		{"bcrypt cost changed", withBcryptCost(5), bcryptHash, true},
+		{"bcrypt to argon2id", testArgon2, bcryptHash, true},
+		{"legacy sha-512", testArgon2, legacyHash, true},
+		{"malformed argon2id", testArgon2, "$argon2id$v=19$m=64,t=1,p=1$", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePasswordConfig(t, tt.cfg)
+			if got := NewCryptoService().NeedsRehash(tt.hash); got != tt.want {
+				t.Fatalf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedPHC(t *testing.T) {
+	valid := newTestHash(t, testArgon2, "secret")
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"prefix only", "$argon2id$"},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"extra field", valid + "$extra"},
+		{"unknown version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"version is not a number", "$argon2id$v=x$m=64,t=1,p=1$" + salt + "$" + key},
+		{"zero memory", "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key},
+		{"zero iterations", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"garbled parameters", "$argon2id$v=19$t=1,m=64,p=1$" + salt + "$" + key},
+		{"salt is not base64", "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key},
+		{"key is not base64", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{"truncated bcrypt", "$2b$04$short"},
+		{"argon2i", strings.Replace(valid, "$argon2id$", "$argon2i$", 1)},
+	}
+
+	c := NewCryptoService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c.VerifyPassword("secret", tt.hash, "") {
+				t.Fatalf("VerifyPassword accepted %q", tt.hash)
+			}
+			if !c.NeedsRehash(tt.hash) {
+				t.Fatalf("NeedsRehash(%q) = false", tt.hash)
+			}
+		})
+	}
+}
+
+func TestSetPasswordConfigRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.PasswordConfig
+	}{
+		{"unknown algorithm", config.PasswordConfig{Algorithm: "scrypt"}},
+		{"argon2id without iterations", config.PasswordConfig{Algorithm: PasswordArgon2id, Argon2Memory: 64, Argon2Parallelism: 1}},
+		{"argon2id memory below 8*p", config.PasswordConfig{Algorithm: PasswordArgon2id, Argon2Memory: 15, Argon2Iterations: 1, Argon2Parallelism: 2}},
+		{"argon2id parallelism above 255", config.PasswordConfig{Algorithm: PasswordArgon2id, Argon2Memory: 8 * 256, Argon2Iterations: 1, Argon2Parallelism: 256}},
+		{"bcrypt cost too low", config.PasswordConfig{Algorithm: PasswordBcrypt, BcryptCost: 3}},
+		{"bcrypt cost too high", config.PasswordConfig{Algorithm: PasswordBcrypt, BcryptCost: 32}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePasswordConfig(t, testArgon2)
+			if err := SetPasswordConfig(tt.cfg); !errors.Is(err, ErrPasswordConfig) {
+				t.Fatalf("SetPasswordConfig = %v, want %v", err, ErrPasswordConfig)
+			}
+			if getPasswordConfig() != testArgon2 {
+				t.Fatal("rejected config was applied")
+			}
+		})
+	}
+}
@@ -0,0 +1,600 @@
+// Package client эталонная реализация клиентской части E2EE: ключи
+// устройства, установка сессии по схеме X3DH и шифрование сообщений
+// симметричным ratchet. Сервер видит только открытые ключи и конверты с
+// шифротекстом; пакет нужен, чтобы проверять протокол в одном процессе
+// и как образец для настоящих клиентов.
+//
+// Установка сессии (A пишет B первым, ключи B взяты из пакета с сервера):
+//
+//	DH1 = DH(IK_A, SPK_B)  DH2 = DH(EK_A, IK_B)
+//	DH3 = DH(EK_A, SPK_B)  DH4 = DH(EK_A, OPK_B), если есть одноразовый ключ
+//	SK  = HKDF-SHA256(0xFF*32 || DH1 || DH2 || DH3 || DH4)
+//
+// Из SK выводятся две цепочки ключей, по одной на направление; каждое
+// сообщение шифруется своим ключом XChaCha20-Poly1305, после чего цепочка
+// сдвигается. Пока B не ответил, сообщения A несут заголовок с
+// эфемерным ключом, по которому B повторяет вычисление SK.
+package client
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"gomessage/internal/e2ee"
+	"gomessage/internal/models"
+)
+
+// maxSkippedKeys сколько ключей пропущенных сообщений хранится на сессию
+const maxSkippedKeys = 1000
+
+// Контексты вывода ключей
+const (
+	x3dhInfo  = "gomessage-x3dh"
+	chainInfo = "gomessage-chains"
+	adPrefix  = "gomessage-e2ee"
+)
+
+// Ошибки клиента
+var (
+	ErrNoSession      = errors.New("нет сессии с устройством")
+	ErrInvalidBundle  = errors.New("некорректный пакет ключей")
+	ErrUnknownPreKey  = errors.New("неизвестный prekey")
+	ErrDecrypt        = errors.New("не удалось расшифровать сообщение")
+	ErrTooManySkipped = errors.New("слишком много пропущенных сообщений")
+	ErrWrongRecipient = errors.New("конверт адресован другому устройству")
+)
+
+// peer устройство собеседника
+type peer struct {
+	userID   uint
+	deviceID string
+}
+
+// Device устройство пользователя с закрытыми ключами и сессиями. Методы
+// безопасны для одновременного вызова.
+type Device struct {
+	UserID   uint
+	DeviceID string
+
+	identity       *ecdh.PrivateKey
+	signing        ed25519.PrivateKey
+	signedPreKeyID uint32
+	signedPreKey   *ecdh.PrivateKey
+	oneTimePreKeys map[uint32]*ecdh.PrivateKey
+	nextPreKeyID   uint32
+
+	sessions map[string]*session // ID сессии -> сессия
+	current  map[peer]*session   // сессия для отправки каждому устройству
+	mu       sync.Mutex
+}
+
+// NewDevice создает устройство с новыми identity ключом, ключом подписи
+// и signed prekey
+func NewDevice(userID uint, deviceID string) (*Device, error) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Device{
+		UserID:         userID,
+		DeviceID:       deviceID,
+		identity:       identity,
+		signing:        signing,
+		oneTimePreKeys: make(map[uint32]*ecdh.PrivateKey),
+		nextPreKeyID:   1,
+		sessions:       make(map[string]*session),
+		current:        make(map[peer]*session),
+	}
+	if err := d.RotateSignedPreKey(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RotateSignedPreKey создает новый signed prekey; после смены его нужно
+// снова загрузить на сервер через KeyUpload
+func (d *Device) RotateSignedPreKey() error {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.signedPreKeyID++
+	d.signedPreKey = key
+	return nil
+}
+
+// IdentityKey открытый identity ключ устройства; по нему собеседники
+// сверяют, с кем установлена сессия
+func (d *Device) IdentityKey() []byte {
+	return d.identity.PublicKey().Bytes()
+}
+
+// KeyUpload собирает запрос для PUT /api/v1/keys вместе с count новыми
+// одноразовыми ключами
+func (d *Device) KeyUpload(count int) (models.KeyUploadRequest, error) {
+	prekeys, err := d.GeneratePreKeys(count)
+	if err != nil {
+		return models.KeyUploadRequest{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	identityKey := d.identity.PublicKey().Bytes()
+	signedPreKey := d.signedPreKey.PublicKey().Bytes()
+	return models.KeyUploadRequest{
+		IdentityKey:    identityKey,
+		SigningKey:     d.signing.Public().(ed25519.PublicKey),
+		SignedPreKeyID: d.signedPreKeyID,
+		SignedPreKey:   signedPreKey,
+		SignedPreKeySignature: ed25519.Sign(d.signing,
+			e2ee.SignedPreKeyMessage(identityKey, d.signedPreKeyID, signedPreKey)),
+		OneTimePreKeys: prekeys,
+	}, nil
+}
+
+// GeneratePreKeys создает count одноразовых ключей; открытые части
+// загружаются на сервер, закрытые остаются на устройстве до первого
+// сообщения, в котором их использовали
+func (d *Device) GeneratePreKeys(count int) ([]models.OneTimePreKey, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prekeys := make([]models.OneTimePreKey, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keyID := d.nextPreKeyID
+		d.nextPreKeyID++
+		d.oneTimePreKeys[keyID] = key
+		prekeys = append(prekeys, models.OneTimePreKey{KeyID: keyID, PublicKey: key.PublicKey().Bytes()})
+	}
+	return prekeys, nil
+}
+
+// HasSession проверяет, есть ли сессия для отправки устройству
+func (d *Device) HasSession(userID uint, deviceID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, exists := d.current[peer{userID, deviceID}]
+	return exists
+}
+
+// StartSession устанавливает сессию с устройством по пакету его ключей
+// (GET /api/v1/keys/:userID). Подпись signed prekey проверяется; ключ
+// подписи собеседника стоит сверить вне сервера, иначе сервер может
+// подменить пакет.
+func (d *Device) StartSession(bundle models.KeyBundle) error {
+	if err := e2ee.VerifySignedPreKey(bundle.DeviceKeys); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	curve := ecdh.X25519()
+	identityKey, err := curve.NewPublicKey(bundle.IdentityKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	signedPreKey, err := curve.NewPublicKey(bundle.SignedPreKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	var oneTimePreKey *ecdh.PublicKey
+	if bundle.OneTimePreKey != nil {
+		if oneTimePreKey, err = curve.NewPublicKey(bundle.OneTimePreKey.PublicKey); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+	}
+
+	ephemeral, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	secrets := make([][]byte, 0, 4)
+	for _, pair := range []struct {
+		private *ecdh.PrivateKey
+		public  *ecdh.PublicKey
+	}{
+		{d.identity, signedPreKey},
+		{ephemeral, identityKey},
+		{ephemeral, signedPreKey},
+		{ephemeral, oneTimePreKey},
+	} {
+		if pair.public == nil {
+			continue
+		}
+		secret, err := pair.private.ECDH(pair.public)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	header := &preKeyHeader{
+		IdentityKey:    d.identity.PublicKey().Bytes(),
+		EphemeralKey:   ephemeral.PublicKey().Bytes(),
+		SignedPreKeyID: bundle.SignedPreKeyID,
+	}
+	if bundle.OneTimePreKey != nil {
+		keyID := bundle.OneTimePreKey.KeyID
+		header.OneTimePreKeyID = &keyID
+	}
+
+	s, err := newSession(secrets, header.EphemeralKey, true,
+		associatedData(header.IdentityKey, bundle.IdentityKey))
+	if err != nil {
+		return err
+	}
+	s.peer = peer{bundle.UserID, bundle.DeviceID}
+	s.peerIdentity = append([]byte(nil), bundle.IdentityKey...)
+	s.preKey = header
+	d.sessions[s.id] = s
+	d.current[s.peer] = s
+	return nil
+}
+
+// Encrypt шифрует сообщение для устройства собеседника и возвращает
+// конверт для MessageRequest.Envelopes
+func (d *Device) Encrypt(userID uint, deviceID string, plaintext []byte) (models.Envelope, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, exists := d.current[peer{userID, deviceID}]
+	if !exists {
+		return models.Envelope{}, ErrNoSession
+	}
+
+	message := wireMessage{
+		SessionID: s.id,
+		PreKey:    s.preKey,
+		Counter:   s.sendCounter,
+	}
+	key := s.sendChain.next()
+	s.sendCounter++
+
+	ciphertext, err := seal(key, message.header(), s.ad, plaintext)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+	message.Ciphertext = ciphertext
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+	return models.Envelope{
+		UserID:         userID,
+		DeviceID:       deviceID,
+		SenderDeviceID: d.DeviceID,
+		Ciphertext:     data,
+	}, nil
+}
+
+// Decrypt расшифровывает конверт, полученный от пользователя senderID.
+// Первое сообщение новой сессии устанавливает ее на стороне получателя.
+func (d *Device) Decrypt(senderID uint, envelope models.Envelope) ([]byte, error) {
+	if envelope.UserID != d.UserID || envelope.DeviceID != d.DeviceID {
+		return nil, ErrWrongRecipient
+	}
+
+	var message wireMessage
+	if err := json.Unmarshal(envelope.Ciphertext, &message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	from := peer{senderID, envelope.SenderDeviceID}
+	s, exists := d.sessions[message.SessionID]
+	if exists && s.peer != from {
+		return nil, ErrDecrypt
+	}
+
+	if !exists {
+		if message.PreKey == nil {
+			return nil, ErrNoSession
+		}
+		var err error
+		if s, err = d.acceptSession(from, message); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := s.receiveKey(message.Counter)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(key, message.header(), s.ad, message.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !exists:
+		// Сессия принимается только после успешной расшифровки, иначе
+		// поддельный заголовок сжег бы одноразовый ключ
+		d.sessions[s.id] = s
+		d.current[from] = s
+		if message.PreKey.OneTimePreKeyID != nil {
+			delete(d.oneTimePreKeys, *message.PreKey.OneTimePreKeyID)
+		}
+	case s.initiator:
+		// Ответ собеседника подтверждает сессию: заголовок больше не нужен
+		s.preKey = nil
+	}
+	s.commitReceive(message.Counter)
+	return plaintext, nil
+}
+
+// acceptSession повторяет вычисление X3DH на стороне получателя по
+// заголовку первого сообщения
+func (d *Device) acceptSession(from peer, message wireMessage) (*session, error) {
+	header := message.PreKey
+	if header.SignedPreKeyID != d.signedPreKeyID {
+		return nil, ErrUnknownPreKey
+	}
+	curve := ecdh.X25519()
+	identityKey, err := curve.NewPublicKey(header.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	ephemeralKey, err := curve.NewPublicKey(header.EphemeralKey)
+	if err != nil || !bytes.Equal(header.EphemeralKey, sessionKey(message.SessionID)) {
+		return nil, ErrDecrypt
+	}
+
+	var oneTimePreKey *ecdh.PrivateKey
+	if header.OneTimePreKeyID != nil {
+		var exists bool
+		if oneTimePreKey, exists = d.oneTimePreKeys[*header.OneTimePreKeyID]; !exists {
+			return nil, ErrUnknownPreKey
+		}
+	}
+
+	secrets := make([][]byte, 0, 4)
+	for _, pair := range []struct {
+		private *ecdh.PrivateKey
+		public  *ecdh.PublicKey
+	}{
+		{d.signedPreKey, identityKey},
+		{d.identity, ephemeralKey},
+		{d.signedPreKey, ephemeralKey},
+		{oneTimePreKey, ephemeralKey},
+	} {
+		if pair.private == nil {
+			continue
+		}
+		secret, err := pair.private.ECDH(pair.public)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	s, err := newSession(secrets, header.EphemeralKey, false,
+		associatedData(header.IdentityKey, d.identity.PublicKey().Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	s.peer = from
+	s.peerIdentity = append([]byte(nil), header.IdentityKey...)
+	return s, nil
+}
+
+// PeerIdentity identity ключ устройства собеседника в текущей сессии,
+// для сверки вне сервера
+func (d *Device) PeerIdentity(userID uint, deviceID string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, exists := d.current[peer{userID, deviceID}]
+	if !exists {
+		return nil, false
+	}
+	return append([]byte(nil), s.peerIdentity...), true
+}
+
+// preKeyHeader заголовок сообщений инициатора до первого ответа
+type preKeyHeader struct {
+	IdentityKey     []byte  `json:"ik"`
+	EphemeralKey    []byte  `json:"ek"`
+	SignedPreKeyID  uint32  `json:"spk_id"`
+	OneTimePreKeyID *uint32 `json:"opk_id,omitempty"`
+}
+
+// wireMessage содержимое конверта
+type wireMessage struct {
+	SessionID  string        `json:"sid"`
+	PreKey     *preKeyHeader `json:"prekey,omitempty"`
+	Counter    uint32        `json:"n"`
+	Ciphertext []byte        `json:"ct"`
+}
+
+// header открытая часть сообщения, которую защищает AEAD
+func (m wireMessage) header() []byte {
+	m.Ciphertext = nil
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// chain цепочка ключей одного направления
+type chain struct {
+	key []byte
+}
+
+// next возвращает ключ очередного сообщения и сдвигает цепочку
+func (c *chain) next() []byte {
+	messageKey := hmacSHA256(c.key, []byte{0x01})
+	c.key = hmacSHA256(c.key, []byte{0x02})
+	return messageKey
+}
+
+// session сессия с одним устройством собеседника
+type session struct {
+	id           string
+	peer         peer
+	peerIdentity []byte
+	initiator    bool
+	ad           []byte
+	preKey       *preKeyHeader // nil, когда собеседник уже ответил
+
+	sendChain   chain
+	sendCounter uint32
+
+	receiveChain   chain
+	receiveCounter uint32
+	pending        *chain            // цепочка после еще не подтвержденной расшифровки
+	skipped        map[uint32][]byte // ключи пропущенных сообщений
+}
+
+// newSession выводит из общих секретов X3DH ключи обоих направлений
+func newSession(secrets [][]byte, ephemeralKey []byte, initiator bool, ad []byte) (*session, error) {
+	ikm := bytes.Repeat([]byte{0xFF}, 32)
+	for _, secret := range secrets {
+		ikm = append(ikm, secret...)
+	}
+	sk := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, make([]byte, 32), []byte(x3dhInfo)), sk); err != nil {
+		return nil, err
+	}
+	chains := make([]byte, 64)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sk, nil, []byte(chainInfo)), chains); err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		id:        base64.RawURLEncoding.EncodeToString(ephemeralKey),
+		initiator: initiator,
+		ad:        ad,
+		skipped:   make(map[uint32][]byte),
+	}
+	if initiator {
+		s.sendChain, s.receiveChain = chain{chains[:32]}, chain{chains[32:]}
+	} else {
+		s.sendChain, s.receiveChain = chain{chains[32:]}, chain{chains[:32]}
+	}
+	return s, nil
+}
+
+// receiveKey находит ключ входящего сообщения counter. Цепочка сдвигается
+// только в commitReceive, после успешной расшифровки.
+func (s *session) receiveKey(counter uint32) ([]byte, error) {
+	if counter < s.receiveCounter {
+		key, exists := s.skipped[counter]
+		if !exists {
+			return nil, ErrDecrypt
+		}
+		return key, nil
+	}
+	if counter-s.receiveCounter > maxSkippedKeys || len(s.skipped)+int(counter-s.receiveCounter) > maxSkippedKeys {
+		return nil, ErrTooManySkipped
+	}
+
+	pending := chain{append([]byte(nil), s.receiveChain.key...)}
+	var key []byte
+	for n := s.receiveCounter; n <= counter; n++ {
+		key = pending.next()
+	}
+	s.pending = &pending
+	return key, nil
+}
+
+// commitReceive запоминает ключи пропущенных сообщений и сдвигает
+// цепочку за сообщение counter
+func (s *session) commitReceive(counter uint32) {
+	if counter < s.receiveCounter {
+		delete(s.skipped, counter)
+		return
+	}
+	for n := s.receiveCounter; n < counter; n++ {
+		s.skipped[n] = s.receiveChain.next()
+	}
+	s.receiveChain = *s.pending
+	s.receiveCounter = counter + 1
+	s.pending = nil
+}
+
+// associatedData привязывает шифротексты сессии к identity ключам
+// инициатора и получателя
+func associatedData(initiatorIdentity, responderIdentity []byte) []byte {
+	ad := append([]byte(adPrefix), initiatorIdentity...)
+	return append(ad, responderIdentity...)
+}
+
+// sessionKey эфемерный ключ инициатора из ID сессии
+func sessionKey(sessionID string) []byte {
+	key, err := base64.RawURLEncoding.DecodeString(sessionID)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// seal шифрует сообщение ключом сообщения; nonce случайный и идет в начале
+func seal(key, header, ad, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, messageAD(ad, header)), nil
+}
+
+// open расшифровывает результат seal
+func open(key, header, ad, sealed []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], messageAD(ad, header))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// messageAD associated data сообщения: AD сессии, длина и заголовок
+func messageAD(ad, header []byte) []byte {
+	result := append([]byte(nil), ad...)
+	result = binary.BigEndian.AppendUint32(result, uint32(len(header)))
+	return append(result, header...)
+}
+
+// hmacSHA256 HMAC-SHA256 data на ключе key
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
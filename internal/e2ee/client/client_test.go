@@ -0,0 +1,231 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gomessage/internal/e2ee"
+	"gomessage/internal/messaging"
+	"gomessage/internal/models"
+)
+
+// useMemoryStores подменяет глобальные хранилища пустыми на время теста
+func useMemoryStores(t *testing.T) {
+	t.Helper()
+	users, chats, messages := models.GlobalUserStore, models.GlobalChatStore, models.GlobalMessageStore
+	keys, envelopes := models.GlobalKeyStore, models.GlobalEnvelopeStore
+	t.Cleanup(func() {
+		models.GlobalUserStore, models.GlobalChatStore, models.GlobalMessageStore = users, chats, messages
+		models.GlobalKeyStore, models.GlobalEnvelopeStore = keys, envelopes
+	})
+	models.GlobalUserStore = models.NewUserStore()
+	models.GlobalChatStore = models.NewChatStore()
+	models.GlobalMessageStore = models.NewMessageStore()
+	models.GlobalKeyStore = models.NewKeyStore()
+	models.GlobalEnvelopeStore = models.NewEnvelopeStore()
+}
+
+// newUploadedDevice создает устройство и публикует его ключи вместе с
+// prekeys одноразовыми ключами
+func newUploadedDevice(t *testing.T, keys *e2ee.Service, userID uint, deviceID string, prekeys int) *Device {
+	t.Helper()
+	device, err := NewDevice(userID, deviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := device.KeyUpload(prekeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.UploadKeys(userID, deviceID, upload); err != nil {
+		t.Fatalf("UploadKeys: %v", err)
+	}
+	return device
+}
+
+// newE2EEChat создает личный E2EE чат двух пользователей
+func newE2EEChat(t *testing.T, first, second uint) uint {
+	t.Helper()
+	chat, err := models.GlobalChatStore.CreateChat(
+		&models.Chat{Name: "e2ee", Type: models.ChatTypePrivate, CreatorID: first, E2EE: true},
+		[]models.ChatMember{
+			{UserID: first, Role: models.ChatRoleOwner},
+			{UserID: second, Role: models.ChatRoleMember},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chat.ID
+}
+
+// fetchBundle забирает с сервера единственный пакет ключей userID
+func fetchBundle(t *testing.T, keys *e2ee.Service, requester *Device, userID uint) models.KeyBundle {
+	t.Helper()
+	bundles, err := keys.GetBundles(requester.UserID, requester.DeviceID, userID)
+	if err != nil {
+		t.Fatalf("GetBundles: %v", err)
+	}
+	if len(bundles) != 1 {
+		t.Fatalf("GetBundles returned %d bundles, want 1", len(bundles))
+	}
+	return bundles[0]
+}
+
+// storedEnvelope возвращает сохраненный на сервере конверт сообщения для
+// пользователя
+func storedEnvelope(t *testing.T, userID, messageID uint) models.Envelope {
+	t.Helper()
+	envelopes, err := models.GlobalEnvelopeStore.GetUserEnvelopes(userID, []uint{messageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelopes) != 1 {
+		t.Fatalf("user %d has %d envelopes for message %d, want 1", userID, len(envelopes), messageID)
+	}
+	return envelopes[0]
+}
+
+func TestEncryptedMessageRoundTrip(t *testing.T) {
+	useMemoryStores(t)
+	keys := e2ee.NewService()
+	messages := messaging.NewService()
+
+	alice := newUploadedDevice(t, keys, 1, "alice-phone", 0)
+	bob := newUploadedDevice(t, keys, 2, "bob-laptop", 2)
+
+	// Без общего чата ключи не выдаются
+	if _, err := keys.GetBundles(alice.UserID, alice.DeviceID, bob.UserID); !errors.Is(err, e2ee.ErrNoSharedChat) {
+		t.Fatalf("GetBundles without shared chat = %v, want %v", err, e2ee.ErrNoSharedChat)
+	}
+	chatID := newE2EEChat(t, alice.UserID, bob.UserID)
+
+	// Каждый пакет забирает один одноразовый ключ
+	bundle := fetchBundle(t, keys, alice, bob.UserID)
+	if bundle.OneTimePreKey == nil {
+		t.Fatal("bundle has no one-time prekey")
+	}
+	if left, _ := keys.CountOneTimePreKeys(bob.UserID, bob.DeviceID); left != 1 {
+		t.Fatalf("%d one-time prekeys left, want 1", left)
+	}
+	if err := alice.StartSession(bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("секретная встреча в 19:00")
+	envelope, err := alice.Encrypt(bob.UserID, bob.DeviceID, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _, err := messages.Send(alice.UserID, models.MessageRequest{
+		Type:           models.MessageTypeEncrypted,
+		ChatID:         chatID,
+		Envelopes:      []models.Envelope{envelope},
+		SenderDeviceID: alice.DeviceID,
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Сервер хранит только шифротекст
+	stored, err := models.GlobalMessageStore.GetMessageByID(response.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "" {
+		t.Fatalf("server stored content %q", stored.Content)
+	}
+	received := storedEnvelope(t, bob.UserID, response.ID)
+	if bytes.Contains(received.Ciphertext, plaintext) {
+		t.Fatal("stored envelope contains the plaintext")
+	}
+	if received.SenderDeviceID != alice.DeviceID {
+		t.Fatalf("envelope sender device = %q, want %q", received.SenderDeviceID, alice.DeviceID)
+	}
+
+	decrypted, err := bob.Decrypt(alice.UserID, received)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Decrypt = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := bob.Decrypt(alice.UserID, received); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("replayed envelope = %v, want %v", err, ErrDecrypt)
+	}
+	if identity, ok := bob.PeerIdentity(alice.UserID, alice.DeviceID); !ok || !bytes.Equal(identity, alice.IdentityKey()) {
+		t.Fatal("bob's session is not bound to alice's identity key")
+	}
+
+	// Ответ идет по той же сессии без обращения за ключами
+	reply, err := bob.Encrypt(alice.UserID, alice.DeviceID, []byte("буду"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _, err = messages.Send(bob.UserID, models.MessageRequest{
+		Type:           models.MessageTypeEncrypted,
+		ChatID:         chatID,
+		Envelopes:      []models.Envelope{reply},
+		SenderDeviceID: bob.DeviceID,
+	})
+	if err != nil {
+		t.Fatalf("Send reply: %v", err)
+	}
+	decrypted, err = alice.Decrypt(bob.UserID, storedEnvelope(t, alice.UserID, response.ID))
+	if err != nil {
+		t.Fatalf("Decrypt reply: %v", err)
+	}
+	if string(decrypted) != "буду" {
+		t.Fatalf("Decrypt reply = %q", decrypted)
+	}
+}
+
+func TestSendRejectsPlaintextInE2EEChat(t *testing.T) {
+	useMemoryStores(t)
+	keys := e2ee.NewService()
+	alice := newUploadedDevice(t, keys, 1, "alice-phone", 0)
+	chatID := newE2EEChat(t, alice.UserID, 2)
+
+	_, _, err := messaging.NewService().Send(alice.UserID, models.MessageRequest{
+		Type:           models.MessageTypeText,
+		Content:        "открытый текст",
+		ChatID:         chatID,
+		SenderDeviceID: alice.DeviceID,
+	})
+	if !errors.Is(err, messaging.ErrEncryptionRequired) {
+		t.Fatalf("Send = %v, want %v", err, messaging.ErrEncryptionRequired)
+	}
+}
+
+func TestTamperedSignedPreKey(t *testing.T) {
+	useMemoryStores(t)
+	keys := e2ee.NewService()
+	alice := newUploadedDevice(t, keys, 1, "alice-phone", 0)
+	bob := newUploadedDevice(t, keys, 2, "bob-laptop", 1)
+	newE2EEChat(t, alice.UserID, bob.UserID)
+
+	// Сервер не принимает ключи с неверной подписью
+	mallory, err := NewDevice(bob.UserID, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := mallory.KeyUpload(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.SignedPreKeySignature[0] ^= 1
+	if err := keys.UploadKeys(bob.UserID, "mallory", upload); !errors.Is(err, e2ee.ErrInvalidSignature) {
+		t.Fatalf("UploadKeys = %v, want %v", err, e2ee.ErrInvalidSignature)
+	}
+
+	// Клиент не верит пакету, подмененному по дороге
+	bundle := fetchBundle(t, keys, alice, bob.UserID)
+	bundle.SignedPreKeySignature = append([]byte(nil), bundle.SignedPreKeySignature...)
+	bundle.SignedPreKeySignature[0] ^= 1
+	if err := alice.StartSession(bundle); !errors.Is(err, ErrInvalidBundle) {
+		t.Fatalf("StartSession = %v, want %v", err, ErrInvalidBundle)
+	}
+	if alice.HasSession(bob.UserID, bob.DeviceID) {
+		t.Fatal("session was started from a tampered bundle")
+	}
+}
@@ -0,0 +1,176 @@
+// Package e2ee раздает открытые ключи устройств для сквозного шифрования
+// личных чатов. Устройства публикуют identity ключ X25519, ключ подписи
+// Ed25519, подписанный prekey и запас одноразовых prekey; собеседник
+// забирает их пакетом (bundle) и устанавливает сессию по схеме X3DH (см.
+// пакет client). Закрытые ключи и открытый текст на сервер не попадают.
+package e2ee
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"gomessage/internal/messaging"
+	"gomessage/internal/models"
+)
+
+// KeySize длина открытого ключа X25519 и Ed25519
+const KeySize = 32
+
+// MaxOneTimePreKeys сколько одноразовых ключей можно загрузить за раз
+const MaxOneTimePreKeys = 100
+
+// signedPreKeyContext начало подписываемых данных signed prekey, чтобы
+// подпись нельзя было выдать за подпись чего-то другого
+const signedPreKeyContext = "gomessage-x3dh-spk"
+
+// Ошибки раздачи ключей
+var (
+	ErrInvalidKey          = errors.New("keys must be 32 bytes")
+	ErrInvalidSignature    = errors.New("signed prekey signature is invalid")
+	ErrTooManyPreKeys      = errors.New("one_time_prekeys must contain at most 100 keys")
+	ErrDuplicatePreKey     = errors.New("one_time_prekeys contains duplicate key_id")
+	ErrNoSharedChat        = errors.New("keys are available only to users sharing a chat")
+	ErrDeviceKeysNotFound  = errors.New("user has no device keys")
+	ErrDeviceNotRegistered = errors.New("upload device keys first")
+)
+
+// SignedPreKeyMessage данные, которые устройство подписывает ключом
+// подписи: контекст, identity ключ, ID и сам signed prekey
+func SignedPreKeyMessage(identityKey []byte, signedPreKeyID uint32, signedPreKey []byte) []byte {
+	message := make([]byte, 0, len(signedPreKeyContext)+len(identityKey)+4+len(signedPreKey))
+	message = append(message, signedPreKeyContext...)
+	message = append(message, identityKey...)
+	message = binary.BigEndian.AppendUint32(message, signedPreKeyID)
+	return append(message, signedPreKey...)
+}
+
+// VerifySignedPreKey проверяет размеры ключей устройства и подпись signed prekey
+func VerifySignedPreKey(keys models.DeviceKeys) error {
+	if len(keys.IdentityKey) != KeySize || len(keys.SigningKey) != ed25519.PublicKeySize ||
+		len(keys.SignedPreKey) != KeySize {
+		return ErrInvalidKey
+	}
+	message := SignedPreKeyMessage(keys.IdentityKey, keys.SignedPreKeyID, keys.SignedPreKey)
+	if !ed25519.Verify(ed25519.PublicKey(keys.SigningKey), message, keys.SignedPreKeySignature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Service публикация и раздача ключей устройств
+type Service struct{}
+
+// NewService создает новый сервис ключей
+func NewService() *Service {
+	return &Service{}
+}
+
+// UploadKeys сохраняет ключи устройства пользователя и, если переданы,
+// одноразовые ключи. Повторная загрузка заменяет signed prekey; при смене
+// identity ключа прежние одноразовые ключи удаляются.
+func (s *Service) UploadKeys(userID uint, deviceID string, req models.KeyUploadRequest) error {
+	keys := models.DeviceKeys{
+		UserID:                userID,
+		DeviceID:              deviceID,
+		IdentityKey:           req.IdentityKey,
+		SigningKey:            req.SigningKey,
+		SignedPreKeyID:        req.SignedPreKeyID,
+		SignedPreKey:          req.SignedPreKey,
+		SignedPreKeySignature: req.SignedPreKeySignature,
+		UpdatedAt:             time.Now(),
+	}
+	if err := VerifySignedPreKey(keys); err != nil {
+		return err
+	}
+	if err := validatePreKeys(req.OneTimePreKeys); err != nil {
+		return err
+	}
+
+	if err := models.GlobalKeyStore.SaveDeviceKeys(keys); err != nil {
+		return err
+	}
+	if len(req.OneTimePreKeys) == 0 {
+		return nil
+	}
+	return models.GlobalKeyStore.AddOneTimePreKeys(userID, deviceID, req.OneTimePreKeys)
+}
+
+// AddOneTimePreKeys пополняет одноразовые ключи устройства и возвращает,
+// сколько их теперь на сервере
+func (s *Service) AddOneTimePreKeys(userID uint, deviceID string, prekeys []models.OneTimePreKey) (int, error) {
+	if err := validatePreKeys(prekeys); err != nil {
+		return 0, err
+	}
+	err := models.GlobalKeyStore.AddOneTimePreKeys(userID, deviceID, prekeys)
+	if errors.Is(err, models.ErrDeviceKeysNotFound) {
+		return 0, ErrDeviceNotRegistered
+	}
+	if err != nil {
+		return 0, err
+	}
+	return models.GlobalKeyStore.CountOneTimePreKeys(userID, deviceID)
+}
+
+// CountOneTimePreKeys возвращает, сколько одноразовых ключей устройства
+// осталось на сервере; клиент пополняет запас, когда их мало
+func (s *Service) CountOneTimePreKeys(userID uint, deviceID string) (int, error) {
+	return models.GlobalKeyStore.CountOneTimePreKeys(userID, deviceID)
+}
+
+// GetBundles выдает пакеты ключей всех устройств пользователя, кроме
+// устройства, которое запрашивает. Каждому пакету достается один
+// одноразовый ключ, который после этого удаляется с сервера. Ключи
+// доступны самому пользователю и тем, с кем у него есть общий чат.
+func (s *Service) GetBundles(requesterID uint, requesterDeviceID string, userID uint) ([]models.KeyBundle, error) {
+	shared, err := messaging.GlobalService.SharesChat(requesterID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !shared {
+		return nil, ErrNoSharedChat
+	}
+
+	devices, err := models.GlobalKeyStore.GetUserDeviceKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := make([]models.KeyBundle, 0, len(devices))
+	for _, device := range devices {
+		if device.UserID == requesterID && device.DeviceID == requesterDeviceID {
+			continue
+		}
+		prekey, err := models.GlobalKeyStore.TakeOneTimePreKey(device.UserID, device.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, models.KeyBundle{DeviceKeys: device, OneTimePreKey: prekey})
+	}
+	if len(bundles) == 0 {
+		return nil, ErrDeviceKeysNotFound
+	}
+	return bundles, nil
+}
+
+// validatePreKeys проверяет размер пачки одноразовых ключей и сами ключи
+func validatePreKeys(prekeys []models.OneTimePreKey) error {
+	if len(prekeys) > MaxOneTimePreKeys {
+		return ErrTooManyPreKeys
+	}
+	seen := make(map[uint32]bool, len(prekeys))
+	for _, prekey := range prekeys {
+		if len(prekey.PublicKey) != KeySize {
+			return ErrInvalidKey
+		}
+		if seen[prekey.KeyID] {
+			return ErrDuplicatePreKey
+		}
+		seen[prekey.KeyID] = true
+	}
+	return nil
+}
+
+// Глобальный сервис ключей
+var GlobalService = NewService()
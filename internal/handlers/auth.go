@@ -1,16 +1,36 @@
 package handlers
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gomessage/internal/config"
 	"gomessage/internal/crypto"
+	"gomessage/internal/middleware"
 	"gomessage/internal/models"
 )
 
+// jwtConfig параметры подписи токенов, сервер задает их при старте
+var jwtConfig = config.JWTConfig{
+	ExpiresIn:        1,
+	RefreshExpiresIn: 720,
+}
+
+// tokenService подписывает access токены, сервер задает его при старте
+var tokenService *middleware.TokenService
+
+// SetJWTConfig задает время жизни выдаваемых токенов и сервис, который их подписывает
+func SetJWTConfig(cfg config.JWTConfig, tokens *middleware.TokenService) {
+	jwtConfig = cfg
+	tokenService = tokens
+}
+
 // Register обрабатывает регистрацию пользователя
 func Register(c *gin.Context) {
 	var req models.UserRegisterRequest
@@ -22,17 +42,9 @@ func Register(c *gin.Context) {
 	}
 
 	cryptoService := crypto.NewCryptoService()
-	
-	// Генерируем соль и хешируем пароль
-	salt, err := cryptoService.GenerateSalt()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Ошибка генерации соли",
-		})
-		return
-	}
 
-	hash, err := cryptoService.HashPassword(req.Password, salt)
+	// Хешируем пароль; соль хранится в строке хеша
+	hash, err := cryptoService.NewPasswordHash(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Ошибка хеширования пароля",
@@ -42,7 +54,7 @@ func Register(c *gin.Context) {
 
 	// Создаем пользователя в хранилище
 	log.Printf("🔍 Проверяем уникальность: username='%s', email='%s'", req.Username, req.Email)
-	
+
 	// Проверяем уникальность перед созданием
 	if models.GlobalUserStore.IsUsernameTaken(req.Username) {
 		log.Printf("❌ Username '%s' уже занят", req.Username)
@@ -60,7 +72,7 @@ func Register(c *gin.Context) {
 		return
 	}
 	
-	user, err := models.GlobalUserStore.CreateUser(req.Username, req.Email, hash, salt)
+	user, err := models.GlobalUserStore.CreateUser(req.Username, req.Email, hash, "")
 	if err != nil {
 		log.Printf("❌ Ошибка создания пользователя: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -118,15 +130,35 @@ func Login(c *gin.Context) {
 	
 	log.Printf("✅ Пароль верный для пользователя '%s'", req.Username)
 
-	// Генерируем JWT токен
-	payloadObj := map[string]interface{}{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+	// Хеш в старом формате или со старыми параметрами пересчитываем,
+	// пока знаем пароль. Ошибка не мешает входу: попробуем в следующий раз.
+	if cryptoService.NeedsRehash(user.Password) {
+		if hash, err := cryptoService.NewPasswordHash(req.Password); err != nil {
+			log.Printf("❌ Ошибка пересчета хеша пароля для '%s': %v", req.Username, err)
+		} else if err := models.GlobalUserStore.UpdatePassword(user.ID, hash, ""); err != nil {
+			log.Printf("❌ Ошибка сохранения хеша пароля для '%s': %v", req.Username, err)
+		} else {
+			log.Printf("🔐 Хеш пароля пользователя '%s' обновлен", req.Username)
+		}
+	}
+
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = models.DefaultDeviceID
+	}
+
+	// Новая сессия устройства: новая семья refresh токенов
+	familyID, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка генерации токена",
+		})
+		return
 	}
-	payloadBytes, _ := json.Marshal(payloadObj)
-	token, err := cryptoService.GenerateJWT(string(payloadBytes), "your-secret-key")
+
+	tokens, err := issueTokens(user, deviceID, familyID)
 	if err != nil {
+		log.Printf("❌ Ошибка выдачи токенов для '%s': %v", req.Username, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Ошибка генерации токена",
 		})
@@ -134,8 +166,10 @@ func Login(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Вход выполнен успешно",
-		"token":   token,
+		"message":       "Вход выполнен успешно",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
@@ -143,11 +177,240 @@ func Login(c *gin.Context) {
 	})
 }
 
-// RefreshToken обновляет JWT токен
+// RefreshToken обменивает refresh токен на новую пару токенов.
+// Старый refresh токен при этом становится недействительным.
 func RefreshToken(c *gin.Context) {
-	// TODO: Реализовать обновление токена
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Неверные данные запроса: " + err.Error(),
+		})
+		return
+	}
+
+	oldHash := hashRefreshToken(req.RefreshToken)
+
+	refreshToken, err := randomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка генерации токена",
+		})
+		return
+	}
+	next, err := newRefreshTokenRecord(refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка генерации токена",
+		})
+		return
+	}
+
+	old, err := models.GlobalRefreshTokenStore.Rotate(oldHash, next)
+	switch {
+	case errors.Is(err, models.ErrRefreshTokenReused):
+		log.Printf("⚠️ Повторное использование refresh токена, сессия отозвана")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Refresh token reused, session revoked",
+			"code":  "refresh_token_reused",
+		})
+		return
+	case errors.Is(err, models.ErrRefreshTokenExpired):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Refresh token expired",
+			"code":  "refresh_token_expired",
+		})
+		return
+	case errors.Is(err, models.ErrRefreshTokenNotFound):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid refresh token",
+			"code":  "refresh_token_invalid",
+		})
+		return
+	case err != nil:
+		log.Printf("❌ Ошибка обновления refresh токена: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to refresh token",
+		})
+		return
+	}
+
+	user, err := models.GlobalUserStore.GetUserByID(old.UserID)
+	if err != nil {
+		models.GlobalRefreshTokenStore.RevokeFamily(old.UserID, old.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not found",
+			"code":  "user_not_found",
+		})
+		return
+	}
+
+	accessToken, err := generateAccessToken(user, old.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка генерации токена",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Token refreshed",
-		"token":   "new_token_here",
+		"message":       "Token refreshed",
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    jwtConfig.ExpiresIn * 3600,
 	})
 }
+
+// GetSessions возвращает активные сессии (устройства) пользователя
+func GetSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tokens, err := models.GlobalRefreshTokenStore.GetActiveByUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get sessions",
+		})
+		return
+	}
+	sessions := make([]gin.H, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, gin.H{
+			"id":         t.FamilyID,
+			"device_id":  t.DeviceID,
+			"created_at": t.CreatedAt,
+			"expires_at": t.ExpiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession отзывает сессию пользователя вместе со всеми ее refresh токенами
+func RevokeSession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	familyID := c.Param("id")
+
+	err := models.GlobalRefreshTokenStore.RevokeFamily(userID.(uint), familyID)
+	if errors.Is(err, models.ErrRefreshTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Session not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Session revoked",
+		"session_id": familyID,
+	})
+}
+
+// WebSocketTicket выдает одноразовый тикет для подключения к /ws
+func WebSocketTicket(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	ticket, err := middleware.GlobalTicketStore.Issue(*claims.(*middleware.Claims))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to issue ticket",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(middleware.TicketTTL.Seconds()),
+	})
+}
+
+// issuedTokens пара токенов, выдаваемая клиенту
+type issuedTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // в секундах
+}
+
+// issueTokens выдает access токен и новый refresh токен в семье familyID
+func issueTokens(user *models.User, deviceID, familyID string) (*issuedTokens, error) {
+	accessToken, err := generateAccessToken(user, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	record, err := newRefreshTokenRecord(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	record.UserID = user.ID
+	record.DeviceID = deviceID
+	record.FamilyID = familyID
+
+	if err := models.GlobalRefreshTokenStore.Create(record); err != nil {
+		return nil, err
+	}
+
+	return &issuedTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    jwtConfig.ExpiresIn * 3600,
+	}, nil
+}
+
+// JWKS отдает открытые ключи подписи access токенов (RFC 7517). Ключи
+// HS256 не публикуются, поэтому без EdDSA или RS256 набор пустой.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, tokenService.Keys().PublicKeys())
+}
+
+// generateAccessToken подписывает короткоживущий JWT для устройства пользователя
+func generateAccessToken(user *models.User, deviceID string) (string, error) {
+	return tokenService.Issue(user, deviceID)
+}
+
+// newRefreshTokenRecord создает запись хранилища для refresh токена
+func newRefreshTokenRecord(refreshToken string) (*models.RefreshToken, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &models.RefreshToken{
+		ID:        id,
+		TokenHash: hashRefreshToken(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(jwtConfig.RefreshExpiresIn) * time.Hour),
+	}, nil
+}
+
+// hashRefreshToken хеширует refresh токен для хранения на сервере
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex возвращает n случайных байт в hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gomessage/internal/config"
+	"gomessage/internal/crypto"
+	"gomessage/internal/middleware"
+	"gomessage/internal/models"
+)
+
+// useTestAuth настраивает выдачу токенов и быстрое хеширование паролей
+// на время теста
+func useTestAuth(t *testing.T) {
+	t.Helper()
+	useMemoryStores(t)
+
+	refreshTokens := models.GlobalRefreshTokenStore
+	previousConfig, previousTokens := jwtConfig, tokenService
+	t.Cleanup(func() {
+		models.GlobalRefreshTokenStore = refreshTokens
+		jwtConfig, tokenService = previousConfig, previousTokens
+		crypto.SetPasswordConfig(config.Load().Password)
+	})
+	models.GlobalRefreshTokenStore = models.NewRefreshTokenStore()
+
+	cfg := config.JWTConfig{
+		SecretKey:        "0123456789abcdef0123456789abcdef",
+		ExpiresIn:        1,
+		RefreshExpiresIn: 24,
+		Issuer:           "gomessage",
+		Audience:         "gomessage",
+	}
+	tokens, err := middleware.NewTokenService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetJWTConfig(cfg, tokens)
+
+	if err := crypto.SetPasswordConfig(config.PasswordConfig{
+		Algorithm:         crypto.PasswordArgon2id,
+		Argon2Memory:      64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		BcryptCost:        4,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login выполняет POST /login и возвращает код ответа
+func login(t *testing.T, username, password string) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/login", Login)
+
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	useTestAuth(t)
+
+	cryptoService := crypto.NewCryptoService()
+	salt, err := cryptoService.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := cryptoService.HashPassword("password123", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := models.GlobalUserStore.CreateUser("legacy", "legacy@example.com", legacy, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Неверный пароль не трогает старый хеш
+	if code := login(t, "legacy", "wrong-password"); code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password = %d, want %d", code, http.StatusUnauthorized)
+	}
+	stored, err := models.GlobalUserStore.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != legacy || stored.Salt != salt {
+		t.Fatal("failed login changed the password hash")
+	}
+
+	if code := login(t, "legacy", "password123"); code != http.StatusOK {
+		t.Fatalf("login = %d, want %d", code, http.StatusOK)
+	}
+	stored, err = models.GlobalUserStore.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored.Password, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("password hash %q is not argon2id", stored.Password)
+	}
+	if stored.Salt != "" {
+		t.Fatalf("legacy salt %q was kept", stored.Salt)
+	}
+	if cryptoService.NeedsRehash(stored.Password) {
+		t.Fatal("upgraded hash needs rehash")
+	}
+
+	// Новый хеш подходит для следующего входа и больше не пересчитывается
+	upgraded := stored.Password
+	if code := login(t, "legacy", "password123"); code != http.StatusOK {
+		t.Fatalf("login after upgrade = %d, want %d", code, http.StatusOK)
+	}
+	if stored, _ := models.GlobalUserStore.GetUserByID(user.ID); stored.Password != upgraded {
+		t.Fatal("current hash was rehashed again")
+	}
+}
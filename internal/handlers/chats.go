@@ -1,41 +1,54 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"sort"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gomessage/internal/messaging"
+	"gomessage/internal/models"
 )
 
 // GetUserChats получает список чатов пользователя
 func GetUserChats(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	
-	// TODO: Получить чаты пользователя из базы данных
-	
-	chats := []gin.H{
-		{
-			"id":          1,
-			"name":        "Общий чат",
-			"type":        "group",
-			"last_message": "Привет всем!",
-			"last_sender":  "user1",
-			"unread_count": 5,
-			"created_at":   "2024-01-01T00:00:00Z",
-		},
-		{
-			"id":          2,
-			"name":        "Личный чат",
-			"type":        "private",
-			"last_message": "Как дела?",
-			"last_sender":  "user2",
-			"unread_count": 0,
-			"created_at":   "2024-01-01T00:00:00Z",
-		},
-	}
-	
+
+	chats, err := models.GlobalChatStore.GetUserChats(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load chats: " + err.Error(),
+		})
+		return
+	}
+
+	result := make([]gin.H, 0, len(chats))
+	for _, chat := range chats {
+		item := chatResponse(chat)
+		if member, err := models.GlobalChatStore.GetMember(chat.ID, userID.(uint)); err == nil {
+			item["role"] = member.Role
+		}
+
+		// Последнее сообщение чата
+		if last, err := models.GlobalMessageStore.GetChatMessages(chat.ID, 1); err == nil && len(last) > 0 {
+			item["last_message"] = last[0].Content
+			item["last_sender"] = usernameByID(last[0].SenderID)
+			item["last_message_at"] = last[0].CreatedAt
+			item["last_seq"] = last[0].Seq
+		}
+
+		// Непрочитанные считаются от позиции чтения пользователя
+		if state, err := messaging.GlobalService.ReadState(userID.(uint), chat.ID); err == nil {
+			item["last_read_seq"] = state.LastReadSeq
+			item["unread_count"] = state.UnreadCount
+		}
+
+		result = append(result, item)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"chats": chats,
+		"chats":   result,
 		"user_id": userID,
 	})
 }
@@ -43,86 +56,294 @@ func GetUserChats(c *gin.Context) {
 // CreateChat создает новый чат
 func CreateChat(c *gin.Context) {
 	var req struct {
-		Name     string   `json:"name" binding:"required"`
-		Type     string   `json:"type" binding:"required"`
-		UserIDs  []uint   `json:"user_ids" binding:"required"`
+		Name         string `json:"name" binding:"max=100"`
+		Type         string `json:"type" binding:"required"`
+		UserIDs      []uint `json:"user_ids"`
+		ReadReceipts *bool  `json:"read_receipts"` // по умолчанию включены
+		E2EE         bool   `json:"e2ee"`          // только для личных чатов
+		Public       bool   `json:"public"`        // только для групп и каналов
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request data: " + err.Error(),
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Создать чат в базе данных
-	// TODO: Добавить пользователей в чат
-	
-	chat := gin.H{
-		"id":         3,
-		"name":       req.Name,
-		"type":       req.Type,
-		"creator_id": userID,
-		"user_ids":   req.UserIDs,
-		"created_at": "2024-01-01T00:00:00Z",
-	}
-	
+	creatorID := userID.(uint)
+
+	// Участники без дублей и без самого создателя
+	seen := map[uint]bool{creatorID: true}
+	memberIDs := make([]uint, 0, len(req.UserIDs))
+	for _, id := range req.UserIDs {
+		if !seen[id] {
+			seen[id] = true
+			memberIDs = append(memberIDs, id)
+		}
+	}
+
+	switch req.Type {
+	case models.ChatTypePrivate:
+		if len(memberIDs) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Private chat requires exactly one other user",
+			})
+			return
+		}
+		if req.Public {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Private chat cannot be public",
+			})
+			return
+		}
+	case models.ChatTypeGroup, models.ChatTypeChannel:
+		if req.E2EE {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "End-to-end encryption is available only for private chats",
+			})
+			return
+		}
+		if req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Chat name is required",
+			})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid chat type: " + req.Type,
+		})
+		return
+	}
+
+	// Проверяем, что все участники существуют
+	missing := make([]uint, 0)
+	for _, id := range memberIDs {
+		if _, err := models.GlobalUserStore.GetUserByID(id); err != nil {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Users not found",
+			"user_ids": missing,
+		})
+		return
+	}
+
+	// Личный чат между двумя пользователями может быть только один
+	// (и еще один со сквозным шифрованием)
+	if req.Type == models.ChatTypePrivate {
+		if existing := findPrivateChat(creatorID, memberIDs[0], req.E2EE); existing != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "Private chat already exists",
+				"chat":    chatDetails(existing),
+			})
+			return
+		}
+	}
+
+	members := []models.ChatMember{{UserID: creatorID, Role: models.ChatRoleOwner}}
+	for _, id := range memberIDs {
+		members = append(members, models.ChatMember{UserID: id, Role: models.ChatRoleMember})
+	}
+
+	readReceipts := true
+	if req.ReadReceipts != nil {
+		readReceipts = *req.ReadReceipts
+	}
+
+	chat, err := models.GlobalChatStore.CreateChat(&models.Chat{
+		Name:         req.Name,
+		Type:         req.Type,
+		CreatorID:    creatorID,
+		ReadReceipts: readReceipts,
+		E2EE:         req.E2EE,
+		Public:       req.Public,
+	}, members)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create chat: " + err.Error(),
+		})
+		return
+	}
+
+	// Подключенные участники сразу получают события нового чата
+	for _, member := range members {
+		messaging.GlobalService.MemberJoined(chat.ID, member.UserID)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Chat created successfully",
-		"chat":    chat,
+		"chat":    chatDetails(chat),
 	})
 }
 
 // GetChat получает информацию о чате
 func GetChat(c *gin.Context) {
-	chatIDStr := c.Param("id")
-	chatID, err := strconv.ParseUint(chatIDStr, 10, 32)
-	if err != nil {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	chat, ok := loadChatForMember(c, chatID, userID.(uint))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chat":    chatDetails(chat),
+		"user_id": userID,
+	})
+}
+
+// UpdateChat меняет название и настройки чата; доступно владельцу и
+// администраторам
+func UpdateChat(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name         *string `json:"name" binding:"omitempty,max=100"`
+		ReadReceipts *bool   `json:"read_receipts"`
+		Public       *bool   `json:"public"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid chat ID",
+			"error": "Invalid request data: " + err.Error(),
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Получить информацию о чате из базы данных
-	// TODO: Проверить права доступа
-	
-	chat := gin.H{
-		"id":          uint(chatID),
-		"name":        "Тестовый чат",
-		"type":        "group",
-		"creator_id":  1,
-		"user_ids":    []uint{1, 2, 3},
-		"created_at":  "2024-01-01T00:00:00Z",
-		"description": "Описание чата",
-	}
-	
+
+	chat, ok := loadChatForMember(c, chatID, userID.(uint))
+	if !ok {
+		return
+	}
+
+	if !requireChatAdmin(c, chatID, userID.(uint)) {
+		return
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" && chat.Type != models.ChatTypePrivate {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Chat name is required",
+			})
+			return
+		}
+		chat.Name = *req.Name
+	}
+	if req.ReadReceipts != nil {
+		chat.ReadReceipts = *req.ReadReceipts
+	}
+	if req.Public != nil {
+		if *req.Public && chat.Type == models.ChatTypePrivate {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Private chat cannot be public",
+			})
+			return
+		}
+		chat.Public = *req.Public
+	}
+
+	if err := models.GlobalChatStore.UpdateChat(chat); err != nil {
+		respondChatError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"chat": chat,
-		"user_id": userID,
+		"message": "Chat updated successfully",
+		"chat":    chatDetails(chat),
 	})
 }
 
-// JoinChat присоединяет пользователя к чату
-func JoinChat(c *gin.Context) {
-	chatIDStr := c.Param("id")
-	chatID, err := strconv.ParseUint(chatIDStr, 10, 32)
-	if err != nil {
+// MarkChatRead сдвигает позицию чтения пользователя в чате до seq
+func MarkChatRead(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Seq uint64 `json:"seq" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid chat ID",
+			"error": "Invalid request data: " + err.Error(),
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Добавить пользователя в чат
-	// TODO: Отправить уведомление через WebSocket
-	
+
+	state, err := messaging.GlobalService.MarkRead(userID.(uint), chatID, req.Seq)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// JoinChat присоединяет пользователя к открытому чату. В закрытый чат
+// участников добавляет владелец или администратор (AddChatMember).
+func JoinChat(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	chat, err := models.GlobalChatStore.GetChatByID(chatID)
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	// Уже участник - ничего не меняем
+	if _, err := models.GlobalChatStore.GetMember(chatID, userID.(uint)); err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Already a member of this chat",
+			"chat_id": chatID,
+			"user_id": userID,
+		})
+		return
+	}
+
+	// В личный чат нельзя вступить со стороны
+	if chat.Type == models.ChatTypePrivate {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Cannot join a private chat",
+		})
+		return
+	}
+	if !chat.Public {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Chat is not public, ask an admin to add you",
+		})
+		return
+	}
+
+	if err := models.GlobalChatStore.AddMember(models.ChatMember{
+		ChatID: chatID,
+		UserID: userID.(uint),
+		Role:   models.ChatRoleMember,
+	}); err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	messaging.GlobalService.MemberJoined(chatID, userID.(uint))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Successfully joined chat",
 		"chat_id": chatID,
@@ -130,25 +351,352 @@ func JoinChat(c *gin.Context) {
 	})
 }
 
-// LeaveChat выводит пользователя из чата
-func LeaveChat(c *gin.Context) {
-	chatIDStr := c.Param("id")
-	chatID, err := strconv.ParseUint(chatIDStr, 10, 32)
+// AddChatMember добавляет пользователя в группу или канал; доступно
+// владельцу и администраторам
+func AddChatMember(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	chat, ok := loadChatForMember(c, chatID, userID.(uint))
+	if !ok {
+		return
+	}
+	if !requireChatAdmin(c, chatID, userID.(uint)) {
+		return
+	}
+	if chat.Type == models.ChatTypePrivate {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Cannot add members to a private chat",
+		})
+		return
+	}
+
+	if _, err := models.GlobalUserStore.GetUserByID(req.UserID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	if _, err := models.GlobalChatStore.GetMember(chatID, req.UserID); err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Already a member of this chat",
+			"chat_id": chatID,
+			"user_id": req.UserID,
+		})
+		return
+	}
+
+	if err := models.GlobalChatStore.AddMember(models.ChatMember{
+		ChatID: chatID,
+		UserID: req.UserID,
+		Role:   models.ChatRoleMember,
+	}); err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	messaging.GlobalService.MemberJoined(chatID, req.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member added",
+		"chat_id": chatID,
+		"user_id": req.UserID,
+	})
+}
+
+// UpdateChatMemberRole назначает участника группы или канала
+// администратором или снимает с него эту роль; доступно только владельцу
+func UpdateChatMemberRole(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("userID"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid chat ID",
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required,oneof=admin member"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data: " + err.Error(),
 		})
 		return
 	}
-	
+
+	userID, _ := c.Get("userID")
+
+	chat, ok := loadChatForMember(c, chatID, userID.(uint))
+	if !ok {
+		return
+	}
+	requester, err := models.GlobalChatStore.GetMember(chatID, userID.(uint))
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+	if requester.Role != models.ChatRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only chat owner can change member roles",
+		})
+		return
+	}
+	if chat.Type == models.ChatTypePrivate {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Private chat has no roles",
+		})
+		return
+	}
+
+	member, err := models.GlobalChatStore.GetMember(chatID, uint(memberID))
+	if errors.Is(err, models.ErrMemberNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User is not a member of this chat",
+		})
+		return
+	}
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+	// Владелец один; передать чат можно, только выйдя из него
+	if member.Role == models.ChatRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Cannot change the owner's role",
+		})
+		return
+	}
+
+	if member.Role != req.Role {
+		member.Role = req.Role
+		if err := models.GlobalChatStore.AddMember(*member); err != nil {
+			respondChatError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member role updated",
+		"chat_id": chatID,
+		"user_id": member.UserID,
+		"role":    member.Role,
+	})
+}
+
+// LeaveChat выводит пользователя из чата
+func LeaveChat(c *gin.Context) {
+	chatID, ok := parseChatID(c)
+	if !ok {
+		return
+	}
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Удалить пользователя из чата
-	// TODO: Отправить уведомление через WebSocket
-	
+
+	member, err := models.GlobalChatStore.GetMember(chatID, userID.(uint))
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	if err := models.GlobalChatStore.RemoveMember(chatID, userID.(uint)); err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	messaging.GlobalService.MemberLeft(chatID, userID.(uint))
+
+	// Владелец ушел: передаем чат следующему по старшинству участнику
+	if member.Role == models.ChatRoleOwner {
+		transferOwnership(chatID)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Successfully left chat",
 		"chat_id": chatID,
 		"user_id": userID,
 	})
 }
+
+// parseChatID читает ID чата из пути; при ошибке отвечает 400
+func parseChatID(c *gin.Context) (uint, bool) {
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid chat ID",
+		})
+		return 0, false
+	}
+	return uint(chatID), true
+}
+
+// loadChatForMember загружает чат и проверяет, что пользователь в нем состоит.
+// При ошибке сам отвечает клиенту.
+func loadChatForMember(c *gin.Context, chatID, userID uint) (*models.Chat, bool) {
+	chat, err := models.GlobalChatStore.GetChatByID(chatID)
+	if err != nil {
+		respondChatError(c, err)
+		return nil, false
+	}
+
+	if _, err := models.GlobalChatStore.GetMember(chatID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You are not a member of this chat",
+		})
+		return nil, false
+	}
+
+	return chat, true
+}
+
+// requireChatAdmin проверяет, что пользователь владелец или администратор
+// чата; иначе отвечает 403
+func requireChatAdmin(c *gin.Context, chatID, userID uint) bool {
+	member, err := models.GlobalChatStore.GetMember(chatID, userID)
+	if err != nil {
+		respondChatError(c, err)
+		return false
+	}
+	if member.Role != models.ChatRoleOwner && member.Role != models.ChatRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only chat owner or admins can do this",
+		})
+		return false
+	}
+	return true
+}
+
+// respondChatError отвечает клиенту по ошибке хранилища чатов
+func respondChatError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrChatNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Chat not found",
+		})
+	case errors.Is(err, models.ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "You are not a member of this chat",
+		})
+	case errors.Is(err, models.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Chat storage error: " + err.Error(),
+		})
+	}
+}
+
+// transferOwnership назначает нового владельца чата: сначала из админов,
+// затем из остальных участников, по времени вступления
+func transferOwnership(chatID uint) {
+	members, err := models.GlobalChatStore.GetMembers(chatID)
+	if err != nil || len(members) == 0 {
+		return
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		iAdmin := members[i].Role == models.ChatRoleAdmin
+		jAdmin := members[j].Role == models.ChatRoleAdmin
+		if iAdmin != jAdmin {
+			return iAdmin
+		}
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+
+	next := members[0]
+	next.Role = models.ChatRoleOwner
+	models.GlobalChatStore.AddMember(next)
+}
+
+// findPrivateChat ищет существующий личный чат двух пользователей с тем
+// же режимом шифрования
+func findPrivateChat(userID, otherID uint, e2ee bool) *models.Chat {
+	chats, err := models.GlobalChatStore.GetUserChats(userID)
+	if err != nil {
+		return nil
+	}
+
+	for _, chat := range chats {
+		if chat.Type != models.ChatTypePrivate || chat.E2EE != e2ee {
+			continue
+		}
+		if _, err := models.GlobalChatStore.GetMember(chat.ID, otherID); err == nil {
+			return chat
+		}
+	}
+	return nil
+}
+
+// chatResponse основные поля чата для ответа
+func chatResponse(chat *models.Chat) gin.H {
+	return gin.H{
+		"id":            chat.ID,
+		"name":          chat.Name,
+		"type":          chat.Type,
+		"creator_id":    chat.CreatorID,
+		"read_receipts": chat.ReadReceipts,
+		"e2ee":          chat.E2EE,
+		"public":        chat.Public,
+		"created_at":    chat.CreatedAt,
+	}
+}
+
+// chatDetails чат вместе со списком участников
+func chatDetails(chat *models.Chat) gin.H {
+	result := chatResponse(chat)
+
+	members, err := models.GlobalChatStore.GetMembers(chat.ID)
+	if err != nil {
+		members = []models.ChatMember{}
+	}
+
+	memberList := make([]gin.H, 0, len(members))
+	userIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		item := gin.H{
+			"user_id":   member.UserID,
+			"username":  usernameByID(member.UserID),
+			"role":      member.Role,
+			"joined_at": member.JoinedAt,
+		}
+		// Кто до какого сообщения дочитал, видно, только если чат это разрешает
+		if chat.ReadReceipts {
+			item["last_read_seq"] = member.LastReadSeq
+		}
+		memberList = append(memberList, item)
+		userIDs = append(userIDs, member.UserID)
+	}
+
+	result["members"] = memberList
+	result["user_ids"] = userIDs
+	return result
+}
+
+// usernameByID возвращает имя пользователя или пустую строку, если его нет
+func usernameByID(userID uint) string {
+	if user, err := models.GlobalUserStore.GetUserByID(userID); err == nil {
+		return user.Username
+	}
+	return ""
+}
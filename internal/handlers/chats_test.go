@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gomessage/internal/models"
+)
+
+// useMemoryStores подменяет глобальные хранилища пустыми на время теста
+func useMemoryStores(t *testing.T) {
+	t.Helper()
+	users, chats := models.GlobalUserStore, models.GlobalChatStore
+	t.Cleanup(func() {
+		models.GlobalUserStore, models.GlobalChatStore = users, chats
+	})
+	models.GlobalUserStore = models.NewUserStore()
+	models.GlobalChatStore = models.NewChatStore()
+}
+
+// chatRouter роутер чатов, где пользователь берется из заголовка X-User-ID
+func chatRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		var userID uint
+		fmt.Sscan(c.GetHeader("X-User-ID"), &userID)
+		c.Set("userID", userID)
+	})
+	router.POST("/chats/:id/join", JoinChat)
+	router.POST("/chats/:id/members", AddChatMember)
+	router.PATCH("/chats/:id/members/:userID", UpdateChatMemberRole)
+	router.PATCH("/chats/:id", UpdateChat)
+	return router
+}
+
+func TestJoinChatRequiresPublicChat(t *testing.T) {
+	useMemoryStores(t)
+
+	var users []*models.User
+	for _, name := range []string{"owner", "admin", "member", "stranger", "invitee"} {
+		user, err := models.GlobalUserStore.CreateUser(name, name+"@example.com", "hash", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, admin, member, stranger, invitee := users[0], users[1], users[2], users[3], users[4]
+
+	chat, err := models.GlobalChatStore.CreateChat(&models.Chat{Name: "team", Type: models.ChatTypeGroup, CreatorID: owner.ID}, []models.ChatMember{
+		{UserID: owner.ID, Role: models.ChatRoleOwner},
+		{UserID: admin.ID, Role: models.ChatRoleAdmin},
+		{UserID: member.ID, Role: models.ChatRoleMember},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatPath := fmt.Sprintf("/chats/%d", chat.ID)
+
+	router := chatRouter()
+	tests := []struct {
+		name   string
+		userID uint
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"stranger joins closed group", stranger.ID, http.MethodPost, chatPath + "/join", "", http.StatusForbidden},
+		{"member cannot add members", member.ID, http.MethodPost, chatPath + "/members", fmt.Sprintf(`{"user_id":%d}`, invitee.ID), http.StatusForbidden},
+		{"stranger cannot add members", stranger.ID, http.MethodPost, chatPath + "/members", fmt.Sprintf(`{"user_id":%d}`, stranger.ID), http.StatusForbidden},
+		{"admin adds invitee", admin.ID, http.MethodPost, chatPath + "/members", fmt.Sprintf(`{"user_id":%d}`, invitee.ID), http.StatusOK},
+		{"admin adds unknown user", admin.ID, http.MethodPost, chatPath + "/members", `{"user_id":999}`, http.StatusNotFound},
+		{"member cannot open chat", member.ID, http.MethodPatch, chatPath, `{"public":true}`, http.StatusForbidden},
+		{"owner opens chat", owner.ID, http.MethodPatch, chatPath, `{"public":true}`, http.StatusOK},
+		{"stranger joins public group", stranger.ID, http.MethodPost, chatPath + "/join", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-User-ID", fmt.Sprint(tt.userID))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+
+	for _, user := range []*models.User{invitee, stranger} {
+		if _, err := models.GlobalChatStore.GetMember(chat.ID, user.ID); err != nil {
+			t.Fatalf("%s is not a member: %v", user.Username, err)
+		}
+	}
+}
+
+func TestUpdateChatMemberRole(t *testing.T) {
+	useMemoryStores(t)
+
+	var users []*models.User
+	for _, name := range []string{"owner", "member", "invitee", "stranger"} {
+		user, err := models.GlobalUserStore.CreateUser(name, name+"@example.com", "hash", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	owner, member, invitee, stranger := users[0], users[1], users[2], users[3]
+
+	chat, err := models.GlobalChatStore.CreateChat(&models.Chat{Name: "team", Type: models.ChatTypeGroup, CreatorID: owner.ID}, []models.ChatMember{
+		{UserID: owner.ID, Role: models.ChatRoleOwner},
+		{UserID: member.ID, Role: models.ChatRoleMember},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := models.GlobalChatStore.CreateChat(&models.Chat{Type: models.ChatTypePrivate, CreatorID: owner.ID}, []models.ChatMember{
+		{UserID: owner.ID, Role: models.ChatRoleOwner},
+		{UserID: member.ID, Role: models.ChatRoleMember},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatPath := fmt.Sprintf("/chats/%d", chat.ID)
+	rolePath := func(userID uint) string { return fmt.Sprintf("%s/members/%d", chatPath, userID) }
+
+	router := chatRouter()
+	tests := []struct {
+		name   string
+		userID uint
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"member cannot add members", member.ID, http.MethodPost, chatPath + "/members", fmt.Sprintf(`{"user_id":%d}`, invitee.ID), http.StatusForbidden},
+		{"member cannot promote self", member.ID, http.MethodPatch, rolePath(member.ID), `{"role":"admin"}`, http.StatusForbidden},
+		{"stranger cannot change roles", stranger.ID, http.MethodPatch, rolePath(member.ID), `{"role":"admin"}`, http.StatusForbidden},
+		{"unknown role", owner.ID, http.MethodPatch, rolePath(member.ID), `{"role":"owner"}`, http.StatusBadRequest},
+		{"invalid user ID", owner.ID, http.MethodPatch, chatPath + "/members/abc", `{"role":"admin"}`, http.StatusBadRequest},
+		{"target is not a member", owner.ID, http.MethodPatch, rolePath(stranger.ID), `{"role":"admin"}`, http.StatusNotFound},
+		{"owner cannot demote self", owner.ID, http.MethodPatch, rolePath(owner.ID), `{"role":"member"}`, http.StatusForbidden},
+		{"private chat has no roles", owner.ID, http.MethodPatch, fmt.Sprintf("/chats/%d/members/%d", private.ID, member.ID), `{"role":"admin"}`, http.StatusForbidden},
+		{"owner promotes member", owner.ID, http.MethodPatch, rolePath(member.ID), `{"role":"admin"}`, http.StatusOK},
+		{"admin adds invitee", member.ID, http.MethodPost, chatPath + "/members", fmt.Sprintf(`{"user_id":%d}`, invitee.ID), http.StatusOK},
+		{"admin updates chat", member.ID, http.MethodPatch, chatPath, `{"name":"team 2"}`, http.StatusOK},
+		{"admin cannot change roles", member.ID, http.MethodPatch, rolePath(invitee.ID), `{"role":"admin"}`, http.StatusForbidden},
+		{"owner demotes admin", owner.ID, http.MethodPatch, rolePath(member.ID), `{"role":"member"}`, http.StatusOK},
+		{"demoted admin cannot update chat", member.ID, http.MethodPatch, chatPath, `{"name":"team 3"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-User-ID", fmt.Sprint(tt.userID))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+
+	if stored, err := models.GlobalChatStore.GetMember(chat.ID, owner.ID); err != nil || stored.Role != models.ChatRoleOwner {
+		t.Fatalf("owner = %+v, %v", stored, err)
+	}
+	if stored, err := models.GlobalChatStore.GetMember(chat.ID, invitee.ID); err != nil || stored.Role != models.ChatRoleMember {
+		t.Fatalf("invitee = %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gomessage/internal/e2ee"
+	"gomessage/internal/models"
+)
+
+// UploadKeys публикует ключи текущего устройства для E2EE чатов
+func UploadKeys(c *gin.Context) {
+	var req models.KeyUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	deviceID, _ := c.Get("deviceID")
+
+	if err := e2ee.GlobalService.UploadKeys(userID.(uint), deviceID.(string), req); err != nil {
+		respondKeyError(c, err)
+		return
+	}
+
+	count, err := e2ee.GlobalService.CountOneTimePreKeys(userID.(uint), deviceID.(string))
+	if err != nil {
+		respondKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Device keys uploaded",
+		"device_id":        deviceID,
+		"one_time_prekeys": count,
+	})
+}
+
+// AddOneTimePreKeys пополняет одноразовые ключи текущего устройства
+func AddOneTimePreKeys(c *gin.Context) {
+	var req models.OneTimePreKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	deviceID, _ := c.Get("deviceID")
+
+	count, err := e2ee.GlobalService.AddOneTimePreKeys(userID.(uint), deviceID.(string), req.OneTimePreKeys)
+	if err != nil {
+		respondKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":        deviceID,
+		"one_time_prekeys": count,
+	})
+}
+
+// CountOneTimePreKeys возвращает, сколько одноразовых ключей текущего
+// устройства осталось на сервере
+func CountOneTimePreKeys(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	deviceID, _ := c.Get("deviceID")
+
+	count, err := e2ee.GlobalService.CountOneTimePreKeys(userID.(uint), deviceID.(string))
+	if err != nil {
+		respondKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":        deviceID,
+		"one_time_prekeys": count,
+	})
+}
+
+// GetKeyBundles выдает пакеты ключей устройств пользователя для установки
+// E2EE сессий; каждый вызов расходует по одноразовому ключу на устройство
+func GetKeyBundles(c *gin.Context) {
+	targetID, err := strconv.ParseUint(c.Param("userID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	deviceID, _ := c.Get("deviceID")
+
+	bundles, err := e2ee.GlobalService.GetBundles(userID.(uint), deviceID.(string), uint(targetID))
+	if err != nil {
+		respondKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": targetID,
+		"devices": bundles,
+	})
+}
+
+// respondKeyError отвечает клиенту по ошибке сервиса ключей
+func respondKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, e2ee.ErrInvalidKey), errors.Is(err, e2ee.ErrInvalidSignature),
+		errors.Is(err, e2ee.ErrTooManyPreKeys), errors.Is(err, e2ee.ErrDuplicatePreKey):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, e2ee.ErrNoSharedChat):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, e2ee.ErrDeviceKeysNotFound), errors.Is(err, models.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, e2ee.ErrDeviceNotRegistered):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Key storage error: " + err.Error(),
+		})
+	}
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gomessage/internal/messaging"
 	"gomessage/internal/models"
 )
 
+// defaultMessagesLimit сколько сообщений отдавать, если limit не указан
+const defaultMessagesLimit = 50
+
 // SendMessage отправляет сообщение
 func SendMessage(c *gin.Context) {
 	var req models.MessageRequest
@@ -17,22 +22,28 @@ func SendMessage(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Сохранить сообщение в базе данных
-	// TODO: Отправить через WebSocket
-	
-	message := models.MessageResponse{
-		ID:        1,
-		Content:   req.Content,
-		Type:      req.Type,
-		Sender:    models.UserResponse{ID: userID.(uint), Username: "testuser"},
-		ChatID:    req.ChatID,
-		ReplyToID: req.ReplyToID,
-		IsEdited:  false,
-	}
-	
+	deviceID, _ := c.Get("deviceID")
+	req.SenderDeviceID, _ = deviceID.(string)
+
+	// Сохраняем и рассылаем через WebSocket участникам чата
+	message, duplicate, err := messaging.GlobalService.Send(userID.(uint), req)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	// Повтор с тем же client_msg_id: отдаем уже сохраненное сообщение
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Message already sent",
+			"data":      message,
+			"duplicate": true,
+		})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Message sent successfully",
 		"data":    message,
@@ -49,14 +60,62 @@ func GetChatMessages(c *gin.Context) {
 		})
 		return
 	}
-	
-	// TODO: Получить сообщения из базы данных
-	
-	messages := []models.MessageResponse{}
-	
+
+	query := messaging.PageQuery{Limit: defaultMessagesLimit}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		query.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || query.Limit <= 0 || query.Limit > messaging.MaxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid limit",
+			})
+			return
+		}
+	}
+
+	// Курсоры пагинации: before, after или around (ID сообщения)
+	cursors := map[string]*uint{
+		"before": &query.Before,
+		"after":  &query.After,
+		"around": &query.Around,
+	}
+	for name, cursor := range cursors {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid " + name + " cursor",
+			})
+			return
+		}
+		*cursor = uint(id)
+	}
+	// after_seq: номер последнего полученного сообщения чата
+	if value := c.Query("after_seq"); value != "" {
+		query.AfterSeq, err = strconv.ParseUint(value, 10, 64)
+		if err != nil || query.AfterSeq == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid after_seq cursor",
+			})
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+
+	page, err := messaging.GlobalService.GetChatMessages(userID.(uint), uint(chatID), query)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"messages": messages,
-		"chat_id":  chatID,
+		"messages":    page.Messages,
+		"chat_id":     chatID,
+		"prev_cursor": page.PrevCursor,
+		"next_cursor": page.NextCursor,
 	})
 }
 
@@ -70,28 +129,29 @@ func EditMessage(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	var req struct {
 		Content string `json:"content" binding:"required,max=2000"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request data: " + err.Error(),
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Проверить права на редактирование
-	// TODO: Обновить сообщение в базе данных
-	
+
+	message, err := messaging.GlobalService.Edit(userID.(uint), uint(messageID), req.Content)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Message edited successfully",
-		"message_id": messageID,
-		"user_id": userID,
-		"content": req.Content,
+		"data":    message,
 	})
 }
 
@@ -105,15 +165,121 @@ func DeleteMessage(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	userID, _ := c.Get("userID")
-	
-	// TODO: Проверить права на удаление
-	// TODO: Удалить сообщение из базы данных
-	
+
+	if _, err := messaging.GlobalService.Delete(userID.(uint), uint(messageID)); err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Message deleted successfully",
 		"message_id": messageID,
 		"user_id": userID,
 	})
 }
+
+// GetMessageHistory возвращает историю правок сообщения (для администраторов чата)
+func GetMessageHistory(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	message, revisions, err := messaging.GlobalService.GetHistory(userID.(uint), uint(messageID))
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	result := make([]gin.H, 0, len(revisions))
+	for _, revision := range revisions {
+		result = append(result, gin.H{
+			"id":        revision.ID,
+			"content":   revision.Content,
+			"action":    revision.Action,
+			"editor_id": revision.EditorID,
+			"editor":    usernameByID(revision.EditorID),
+			"edited_at": revision.EditedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   message,
+		"revisions": result,
+	})
+}
+
+// GetMessageReceipts возвращает, кому сообщение доставлено и кто его
+// прочитал. Доступно только отправителю сообщения.
+func GetMessageReceipts(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	receipts, err := messaging.GlobalService.Receipts(userID.(uint), uint(messageID))
+	if errors.Is(err, messaging.ErrForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only the sender can view message receipts",
+		})
+		return
+	}
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, receipts)
+}
+
+// respondMessageError отвечает клиенту по ошибке сервиса сообщений
+func respondMessageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Message not found",
+		})
+	case errors.Is(err, messaging.ErrNotChatMember):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You are not a member of this chat",
+		})
+	case errors.Is(err, messaging.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Not allowed to modify this message",
+		})
+	case errors.Is(err, messaging.ErrNotChatAdmin):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only chat owner or admins can do this",
+		})
+	case errors.Is(err, messaging.ErrEditWindowExpired):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Message can no longer be edited",
+		})
+	case errors.Is(err, messaging.ErrEncryptedEdit), errors.Is(err, messaging.ErrUnreadableMessage):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, messaging.ErrInvalidMessageType), errors.Is(err, messaging.ErrInvalidReply),
+		errors.Is(err, messaging.ErrInvalidContent), errors.Is(err, messaging.ErrInvalidCursor),
+		errors.Is(err, messaging.ErrInvalidClientMsgID), errors.Is(err, messaging.ErrInvalidReadSeq),
+		errors.Is(err, messaging.ErrEncryptionRequired), errors.Is(err, messaging.ErrNotEncryptedChat),
+		errors.Is(err, messaging.ErrInvalidEnvelopes):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		respondChatError(c, err)
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gomessage/internal/messaging"
 	"gomessage/internal/models"
+	"gomessage/internal/presence"
 )
 
 // GetProfile получает профиль пользователя
@@ -26,15 +29,23 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 	
+	// status - выбранный пользователем статус, presence - текущий
+	current, lastSeenAt, err := presence.GlobalService.Status(user.ID)
+	if err != nil {
+		current = models.UserStatusOffline
+	}
+	
 	// Возвращаем профиль пользователя
 	profile := gin.H{
-		"id":         user.ID,
-		"username":   user.Username,
-		"email":      user.Email,
-		"avatar":     user.Avatar,
-		"status":     user.Status,
-		"created_at": user.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		"updated_at": user.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		"id":           user.ID,
+		"username":     user.Username,
+		"email":        user.Email,
+		"avatar":       user.Avatar,
+		"status":       user.Status,
+		"presence":     current,
+		"last_seen_at": lastSeenAt,
+		"created_at":   user.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		"updated_at":   user.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
 	
 	c.JSON(http.StatusOK, profile)
@@ -64,6 +75,13 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 	
+	if req.Status != "" && !models.IsValidUserStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid status",
+		})
+		return
+	}
+	
 	// Обновляем данные пользователя в хранилище
 	updates := make(map[string]interface{})
 	if req.Username != "" {
@@ -87,6 +105,10 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 	
+	if req.Status != "" {
+		presence.GlobalService.StatusChanged(user.ID)
+	}
+	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Profile updated successfully",
 		"user": gin.H{
@@ -123,3 +145,45 @@ func SearchUsers(c *gin.Context) {
 		"query": query,
 	})
 }
+
+// GetUserPresence возвращает статус пользователя и время, когда он был в
+// сети последний раз. Доступно самому пользователю и участникам общих чатов.
+func GetUserPresence(c *gin.Context) {
+	targetID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+	
+	userID, _ := c.Get("userID")
+	
+	visible, err := messaging.GlobalService.SharesChat(userID.(uint), uint(targetID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check shared chats",
+		})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "No shared chat with this user",
+		})
+		return
+	}
+	
+	status, lastSeenAt, err := presence.GlobalService.Status(uint(targetID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      targetID,
+		"status":       status,
+		"last_seen_at": lastSeenAt,
+	})
+}
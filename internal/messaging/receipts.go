@@ -0,0 +1,237 @@
+package messaging
+
+import (
+	"log"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// MaxDeliveryAck сколько сообщений можно подтвердить одним запросом
+const MaxDeliveryAck = 100
+
+// Receipt состояние сообщения у одного получателя. ReadAt заполняется,
+// только если в чате включены отметки о прочтении.
+type Receipt struct {
+	UserID      uint       `json:"user_id"`
+	Username    string     `json:"username"`
+	Status      string     `json:"status"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+}
+
+// MessageReceipts состояние сообщения у всех получателей. Status - общее
+// состояние: read, когда прочитали все, delivered, когда доставлено всем,
+// иначе sent. Tracked false означает, что чат слишком большой и отметки
+// о доставке в нем не ведутся.
+type MessageReceipts struct {
+	MessageID uint      `json:"message_id"`
+	ChatID    uint      `json:"chat_id"`
+	Seq       uint64    `json:"seq"`
+	Status    string    `json:"status"`
+	Tracked   bool      `json:"tracked"`
+	Receipts  []Receipt `json:"receipts"`
+}
+
+// SetReceiptsMaxMembers задает, до какого размера группы ведутся отметки
+// о доставке; в личных чатах они ведутся всегда
+func (s *Service) SetReceiptsMaxMembers(members int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxTracked = members
+}
+
+// tracksDeliveries проверяет, ведутся ли в чате отметки о доставке
+func (s *Service) tracksDeliveries(chat *models.Chat) (bool, error) {
+	if chat.Type == models.ChatTypePrivate {
+		return true, nil
+	}
+
+	s.mu.RLock()
+	maxTracked := s.maxTracked
+	s.mu.RUnlock()
+
+	if maxTracked <= 0 {
+		return false, nil
+	}
+	members, err := models.GlobalChatStore.GetMembers(chat.ID)
+	if err != nil {
+		return false, err
+	}
+	return len(members) <= maxTracked, nil
+}
+
+// MarkDelivered отмечает сообщения доставленными пользователю: его
+// устройство получило их и подтвердило. Свои и удаленные сообщения,
+// сообщения чужих чатов и чатов без отметок о доставке пропускаются.
+// Отправитель узнает о первой доставке каждого сообщения кадром "delivered".
+func (s *Service) MarkDelivered(userID uint, messageIDs []uint) error {
+	if len(messageIDs) == 0 || len(messageIDs) > MaxDeliveryAck {
+		return ErrInvalidDeliveryAck
+	}
+
+	now := time.Now()
+	tracked := make(map[uint]bool) // chatID -> можно ли отмечать доставку
+	seen := make(map[uint]bool, len(messageIDs))
+
+	for _, messageID := range messageIDs {
+		if seen[messageID] {
+			continue
+		}
+		seen[messageID] = true
+
+		message, err := s.loadMessage(messageID)
+		if err != nil || message.SenderID == userID {
+			continue
+		}
+
+		allowed, checked := tracked[message.ChatID]
+		if !checked {
+			allowed = s.canMarkDelivered(userID, message.ChatID)
+			tracked[message.ChatID] = allowed
+		}
+		if !allowed {
+			continue
+		}
+
+		added, err := models.GlobalDeliveryStore.MarkDelivered(message.ID, userID, now)
+		if err != nil {
+			log.Printf("❌ Ошибка сохранения доставки сообщения %d пользователю %d: %v", message.ID, userID, err)
+			continue
+		}
+		if added {
+			s.sendDelivered(message, userID, now)
+		}
+	}
+	return nil
+}
+
+// canMarkDelivered проверяет, что пользователь состоит в чате и в чате
+// ведутся отметки о доставке
+func (s *Service) canMarkDelivered(userID, chatID uint) bool {
+	chat, err := models.GlobalChatStore.GetChatByID(chatID)
+	if err != nil {
+		return false
+	}
+	if _, err := models.GlobalChatStore.GetMember(chatID, userID); err != nil {
+		return false
+	}
+	tracks, err := s.tracksDeliveries(chat)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки участников чата %d: %v", chatID, err)
+		return false
+	}
+	return tracks
+}
+
+// sendDelivered сообщает отправителю, что сообщение доставлено получателю
+func (s *Service) sendDelivered(message *models.Message, userID uint, deliveredAt time.Time) {
+	broadcaster := s.getBroadcaster()
+	if broadcaster == nil {
+		return
+	}
+
+	event := models.WSDeliveredEvent{
+		MessageID:   message.ID,
+		ChatID:      message.ChatID,
+		Seq:         message.Seq,
+		UserID:      userID,
+		DeliveredAt: deliveredAt,
+	}
+	if user, err := models.GlobalUserStore.GetUserByID(userID); err == nil {
+		event.Username = user.Username
+	}
+
+	data, err := models.EncodeWebSocketMessage(models.WSMessageTypeDelivered, "", event)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации события %s: %v", models.WSMessageTypeDelivered, err)
+		return
+	}
+	broadcaster.SendToUser(message.SenderID, data)
+}
+
+// Receipts возвращает состояние сообщения у каждого получателя. Доступно
+// только отправителю, пока он состоит в чате. Прочитанным сообщение
+// считается по позиции чтения получателя; прочитанное, но не
+// подтвержденное устройством сообщение тоже считается доставленным.
+func (s *Service) Receipts(userID, messageID uint) (*MessageReceipts, error) {
+	message, err := s.loadMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrForbidden
+	}
+	if err := s.RequireMember(message.ChatID, userID); err != nil {
+		return nil, err
+	}
+
+	chat, err := models.GlobalChatStore.GetChatByID(message.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	tracked, err := s.tracksDeliveries(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MessageReceipts{
+		MessageID: message.ID,
+		ChatID:    message.ChatID,
+		Seq:       message.Seq,
+		Status:    models.ReceiptStatusSent,
+		Tracked:   tracked,
+		Receipts:  make([]Receipt, 0),
+	}
+	if !tracked {
+		return result, nil
+	}
+
+	members, err := models.GlobalChatStore.GetMembers(message.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := models.GlobalDeliveryStore.GetMessageDeliveries(message.ID)
+	if err != nil {
+		return nil, err
+	}
+	deliveredAt := make(map[uint]time.Time, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveredAt[delivery.UserID] = delivery.DeliveredAt
+	}
+
+	allDelivered, allRead := true, true
+	for _, member := range members {
+		if member.UserID == message.SenderID {
+			continue
+		}
+
+		receipt := Receipt{UserID: member.UserID, Status: models.ReceiptStatusSent}
+		if user, err := models.GlobalUserStore.GetUserByID(member.UserID); err == nil {
+			receipt.Username = user.Username
+		}
+		if at, delivered := deliveredAt[member.UserID]; delivered {
+			receipt.DeliveredAt = &at
+			receipt.Status = models.ReceiptStatusDelivered
+		}
+		if chat.ReadReceipts && member.LastReadSeq >= message.Seq {
+			receipt.ReadAt = member.LastReadAt
+			receipt.Status = models.ReceiptStatusRead
+		}
+
+		allDelivered = allDelivered && receipt.Status != models.ReceiptStatusSent
+		allRead = allRead && receipt.Status == models.ReceiptStatusRead
+		result.Receipts = append(result.Receipts, receipt)
+	}
+
+	if len(result.Receipts) > 0 {
+		switch {
+		case allRead:
+			result.Status = models.ReceiptStatusRead
+		case allDelivered:
+			result.Status = models.ReceiptStatusDelivered
+		}
+	}
+	return result, nil
+}
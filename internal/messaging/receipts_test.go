@@ -0,0 +1,270 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+
+	"gomessage/internal/models"
+)
+
+// newPrivateChat создает личный чат двух пользователей
+func newPrivateChat(t *testing.T, first, second uint) uint {
+	t.Helper()
+	chat, err := models.GlobalChatStore.CreateChat(
+		&models.Chat{Name: "private", Type: models.ChatTypePrivate, CreatorID: first, ReadReceipts: true},
+		[]models.ChatMember{
+			{UserID: first, Role: models.ChatRoleOwner},
+			{UserID: second, Role: models.ChatRoleMember},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chat.ID
+}
+
+// receiptStatuses возвращает общее состояние сообщения и состояние у
+// каждого получателя
+func receiptStatuses(t *testing.T, s *Service, senderID, messageID uint) (string, map[uint]string) {
+	t.Helper()
+	receipts, err := s.Receipts(senderID, messageID)
+	if err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	statuses := make(map[uint]string, len(receipts.Receipts))
+	for _, receipt := range receipts.Receipts {
+		statuses[receipt.UserID] = receipt.Status
+	}
+	return receipts.Status, statuses
+}
+
+func TestTracksDeliveries(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t) // три участника
+	private := newPrivateChat(t, chat.owner, chat.member)
+
+	tests := []struct {
+		name       string
+		chatID     uint
+		maxMembers int
+		tracked    bool
+	}{
+		{"private chat without limit", private, 0, true},
+		{"private chat with small limit", private, 1, true},
+		{"group without limit", chat.id, 0, false},
+		{"group above limit", chat.id, 2, false},
+		{"group at limit", chat.id, 3, true},
+		{"group below limit", chat.id, 10, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService()
+			s.SetReceiptsMaxMembers(tt.maxMembers)
+			broadcaster := &recordingBroadcaster{}
+			s.SetBroadcaster(broadcaster)
+
+			message := send(t, s, chat.owner, tt.chatID, "hello")
+			broadcaster.take()
+			if err := s.MarkDelivered(chat.member, []uint{message.ID}); err != nil {
+				t.Fatal(err)
+			}
+
+			receipts, err := s.Receipts(chat.owner, message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if receipts.Tracked != tt.tracked {
+				t.Fatalf("tracked = %v, want %v", receipts.Tracked, tt.tracked)
+			}
+			deliveries, err := models.GlobalDeliveryStore.GetMessageDeliveries(message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := broadcaster.take()
+			if tt.tracked {
+				if len(deliveries) != 1 || len(events) != 1 || events[0].method != "user" || events[0].id != chat.owner {
+					t.Fatalf("deliveries = %+v, events = %+v", deliveries, events)
+				}
+				return
+			}
+			if len(deliveries) != 0 || len(events) != 0 || len(receipts.Receipts) != 0 || receipts.Status != models.ReceiptStatusSent {
+				t.Fatalf("untracked chat: deliveries = %+v, events = %+v, receipts = %+v", deliveries, events, receipts)
+			}
+		})
+	}
+}
+
+func TestMarkDeliveredAck(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	other := newGroup(t, "other", chat.owner, chat.stranger)
+	s := NewService()
+	s.SetReceiptsMaxMembers(10)
+	broadcaster := &recordingBroadcaster{}
+	s.SetBroadcaster(broadcaster)
+
+	message := send(t, s, chat.owner, chat.id, "hello")
+	own := send(t, s, chat.member, chat.id, "mine")
+	foreign := send(t, s, chat.owner, other, "elsewhere")
+	deleted := send(t, s, chat.owner, chat.id, "gone")
+	if _, err := s.Delete(chat.owner, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	broadcaster.take()
+
+	tooMany := make([]uint, MaxDeliveryAck+1)
+	for i := range tooMany {
+		tooMany[i] = message.ID
+	}
+	for name, ids := range map[string][]uint{"empty ack": nil, "ack above limit": tooMany} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.MarkDelivered(chat.member, ids); !errors.Is(err, ErrInvalidDeliveryAck) {
+				t.Fatalf("MarkDelivered = %v, want %v", err, ErrInvalidDeliveryAck)
+			}
+		})
+	}
+
+	// Свои, удаленные, чужие и неизвестные сообщения пропускаются молча,
+	// повтор в одном подтверждении учитывается один раз
+	ack := []uint{message.ID, message.ID, own.ID, foreign.ID, deleted.ID, deleted.ID + 100}
+	if err := s.MarkDelivered(chat.member, ack); err != nil {
+		t.Fatal(err)
+	}
+	events := broadcaster.take()
+	if len(events) != 1 || events[0].id != chat.owner {
+		t.Fatalf("events = %+v, want one delivered event for the sender", events)
+	}
+	for _, id := range []uint{own.ID, foreign.ID, deleted.ID} {
+		if deliveries, _ := models.GlobalDeliveryStore.GetMessageDeliveries(id); len(deliveries) != 0 {
+			t.Fatalf("message %d has deliveries %+v", id, deliveries)
+		}
+	}
+
+	// Повторное подтверждение не сообщает отправителю снова
+	if err := s.MarkDelivered(chat.member, []uint{message.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if events := broadcaster.take(); len(events) != 0 {
+		t.Fatalf("repeated ack sent %+v", events)
+	}
+}
+
+func TestReceiptsAccess(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	s.SetReceiptsMaxMembers(10)
+	message := send(t, s, chat.member, chat.id, "hello")
+	left := send(t, s, chat.admin, chat.id, "bye")
+	if err := models.GlobalChatStore.RemoveMember(chat.id, chat.admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    uint
+		messageID uint
+		wantErr   error
+	}{
+		{"sender", chat.member, message.ID, nil},
+		{"chat owner", chat.owner, message.ID, ErrForbidden},
+		{"stranger", chat.stranger, message.ID, ErrForbidden},
+		{"sender who left the chat", chat.admin, left.ID, ErrNotChatMember},
+		{"unknown message", chat.member, left.ID + 100, models.ErrMessageNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Receipts(tt.userID, tt.messageID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Receipts = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReceiptsProgress(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	s.SetReceiptsMaxMembers(10)
+	message := send(t, s, chat.owner, chat.id, "hello")
+
+	delivered := func(userID uint) func() {
+		return func() {
+			if err := s.MarkDelivered(userID, []uint{message.ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	read := func(userID uint) func() {
+		return func() {
+			if _, err := s.MarkRead(userID, chat.id, message.Seq); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Шаги выполняются по порядку, состояние накапливается
+	tests := []struct {
+		name        string
+		step        func()
+		status      string
+		admin, user string
+	}{
+		{"sent", func() {}, models.ReceiptStatusSent, models.ReceiptStatusSent, models.ReceiptStatusSent},
+		{"delivered to one", delivered(chat.member), models.ReceiptStatusSent, models.ReceiptStatusSent, models.ReceiptStatusDelivered},
+		{"delivered to all", delivered(chat.admin), models.ReceiptStatusDelivered, models.ReceiptStatusDelivered, models.ReceiptStatusDelivered},
+		{"read by one", read(chat.member), models.ReceiptStatusDelivered, models.ReceiptStatusDelivered, models.ReceiptStatusRead},
+		{"late ack does not unread", delivered(chat.member), models.ReceiptStatusDelivered, models.ReceiptStatusDelivered, models.ReceiptStatusRead},
+		{"read by all", read(chat.admin), models.ReceiptStatusRead, models.ReceiptStatusRead, models.ReceiptStatusRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step()
+			status, statuses := receiptStatuses(t, s, chat.owner, message.ID)
+			if status != tt.status || statuses[chat.admin] != tt.admin || statuses[chat.member] != tt.user || len(statuses) != 2 {
+				t.Fatalf("status = %s, receipts = %v, want %s, admin %s, member %s", status, statuses, tt.status, tt.admin, tt.user)
+			}
+		})
+	}
+}
+
+func TestReceiptsReadWithoutAck(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	s.SetReceiptsMaxMembers(10)
+	message := send(t, s, chat.owner, chat.id, "hello")
+
+	// Прочитанное без подтверждения доставки считается доставленным
+	if _, err := s.MarkRead(chat.member, chat.id, message.Seq); err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := s.Receipts(chat.owner, message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, receipt := range receipts.Receipts {
+		if receipt.UserID == chat.member && (receipt.Status != models.ReceiptStatusRead || receipt.ReadAt == nil || receipt.DeliveredAt != nil) {
+			t.Fatalf("receipt = %+v", receipt)
+		}
+	}
+
+	// Без отметок о прочтении в чате позиция чтения не раскрывается
+	chatModel, err := models.GlobalChatStore.GetChatByID(chat.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatModel.ReadReceipts = false
+	if err := models.GlobalChatStore.UpdateChat(chatModel); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkDelivered(chat.admin, []uint{message.ID}); err != nil {
+		t.Fatal(err)
+	}
+	status, statuses := receiptStatuses(t, s, chat.owner, message.ID)
+	if status != models.ReceiptStatusSent || statuses[chat.member] != models.ReceiptStatusSent || statuses[chat.admin] != models.ReceiptStatusDelivered {
+		t.Fatalf("status = %s, receipts = %v", status, statuses)
+	}
+}
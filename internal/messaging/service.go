@@ -0,0 +1,933 @@
+package messaging
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gomessage/internal/models"
+)
+
+// Ошибки сервиса сообщений
+var (
+	ErrNotChatMember      = errors.New("not a chat member")
+	ErrForbidden          = errors.New("forbidden")
+	ErrInvalidMessageType = errors.New("invalid message type")
+	ErrInvalidReply       = errors.New("reply target is not in this chat")
+	ErrInvalidContent     = errors.New("message content must be 1-2000 characters")
+	ErrInvalidCursor      = errors.New("only one of before, after, after_seq and around can be set")
+	ErrEditWindowExpired  = errors.New("edit window has expired")
+	ErrNotChatAdmin       = errors.New("chat admin role required")
+	ErrInvalidClientMsgID = errors.New("client_msg_id must be at most 64 characters")
+	ErrInvalidReadSeq     = errors.New("seq must be positive")
+	ErrInvalidDeliveryAck = errors.New("message_ids must contain 1-100 IDs")
+	ErrEncryptionRequired = errors.New("e2ee chat accepts only encrypted messages")
+	ErrNotEncryptedChat   = errors.New("encrypted messages are allowed only in e2ee chats")
+	ErrInvalidEnvelopes   = errors.New("envelopes must contain 1-64 ciphertexts for registered devices of chat members")
+	ErrEncryptedEdit      = errors.New("encrypted messages cannot be edited")
+	ErrUnreadableMessage  = errors.New("message content cannot be decrypted")
+)
+
+// maxContentLength максимальная длина сообщения в символах
+const maxContentLength = 2000
+
+// maxClientMsgIDLength максимальная длина клиентского ID сообщения
+const maxClientMsgIDLength = 64
+
+// maxEnvelopes сколько конвертов (устройств получателей) может быть у
+// одного E2EE сообщения
+const maxEnvelopes = 64
+
+// maxCiphertextLength максимальный размер шифротекста в конверте
+const maxCiphertextLength = 64 * 1024
+
+// MaxPageLimit максимальный размер страницы истории
+const MaxPageLimit = 100
+
+// PageQuery параметры страницы истории чата. Задается не больше одного
+// курсора: Before - сообщения старше ID, After - новее ID, AfterSeq - новее
+// номера в чате (докачка после переподключения), Around - окно вокруг
+// сообщения (например, цели ответа ReplyToID). Без курсора возвращаются
+// последние сообщения.
+type PageQuery struct {
+	Before   uint
+	After    uint
+	AfterSeq uint64
+	Around   uint
+	Limit    int
+}
+
+// Page страница истории чата в порядке отправки. PrevCursor передается
+// в before для загрузки более старых сообщений, NextCursor - в after для
+// более новых; nil означает, что в эту сторону сообщений больше нет.
+type Page struct {
+	Messages   []models.MessageResponse `json:"messages"`
+	PrevCursor *uint                    `json:"prev_cursor"`
+	NextCursor *uint                    `json:"next_cursor"`
+}
+
+// Broadcaster доставляет события участникам чата (WebSocket Hub)
+type Broadcaster interface {
+	BroadcastToChat(chatID uint, message []byte)
+	// SendToUser доставляет событие всем соединениям пользователя
+	SendToUser(userID uint, message []byte)
+	// AddUserToChat и RemoveUserFromChat синхронизируют подписки
+	// подключенных клиентов с составом чата
+	AddUserToChat(userID, chatID uint)
+	RemoveUserFromChat(userID, chatID uint)
+}
+
+// Service единая точка отправки и чтения сообщений для REST и WebSocket.
+// Сообщение сохраняется в models.GlobalMessageStore и рассылается участникам
+// чата через Broadcaster, откуда бы оно ни пришло.
+type Service struct {
+	broadcaster Broadcaster
+	editWindow  time.Duration
+	dedupWindow time.Duration
+	maxTracked  int // размер группы, до которого ведутся отметки о доставке
+	mu          sync.RWMutex
+}
+
+// NewService создает новый сервис сообщений
+func NewService() *Service {
+	return &Service{}
+}
+
+// SetBroadcaster подключает доставку событий в реальном времени
+func (s *Service) SetBroadcaster(broadcaster Broadcaster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcaster = broadcaster
+}
+
+// SetEditWindow задает, сколько времени после отправки сообщение можно
+// редактировать; 0 снимает ограничение
+func (s *Service) SetEditWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.editWindow = window
+}
+
+// SetDedupWindow задает, сколько времени повтор с тем же client_msg_id
+// считается дублем; 0 - бессрочно
+func (s *Service) SetDedupWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dedupWindow = window
+}
+
+// getDedupWindow возвращает окно дедупликации
+func (s *Service) getDedupWindow() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.dedupWindow
+}
+
+// Send сохраняет сообщение пользователя и рассылает его участникам чата.
+// Если у отправителя уже есть сообщение с тем же ClientMsgID в пределах окна
+// дедупликации, новое не создается: возвращается прежнее с duplicate = true.
+// В E2EE чате текст приходит только шифротекстами в конвертах для
+// устройств получателей; каждый участник получает лишь свои конверты.
+func (s *Service) Send(senderID uint, req models.MessageRequest) (*models.MessageResponse, bool, error) {
+	if !isValidMessageType(req.Type) {
+		return nil, false, ErrInvalidMessageType
+	}
+	encrypted := req.Type == models.MessageTypeEncrypted
+	if encrypted && req.Content != "" || !encrypted && !isValidContent(req.Content) {
+		return nil, false, ErrInvalidContent
+	}
+	if len(req.ClientMsgID) > maxClientMsgIDLength {
+		return nil, false, ErrInvalidClientMsgID
+	}
+	if err := s.RequireMember(req.ChatID, senderID); err != nil {
+		return nil, false, err
+	}
+
+	chat, err := models.GlobalChatStore.GetChatByID(req.ChatID)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case chat.E2EE && !encrypted:
+		return nil, false, ErrEncryptionRequired
+	case !chat.E2EE && encrypted:
+		return nil, false, ErrNotEncryptedChat
+	case encrypted:
+		if err := validateEnvelopes(senderID, req); err != nil {
+			return nil, false, err
+		}
+	case len(req.Envelopes) > 0:
+		return nil, false, ErrNotEncryptedChat
+	}
+
+	if req.ReplyToID != nil {
+		target, err := s.loadMessage(*req.ReplyToID)
+		if err != nil || target.ChatID != req.ChatID {
+			return nil, false, ErrInvalidReply
+		}
+	}
+
+	message, duplicate, err := s.createMessage(senderID, req)
+	if err != nil {
+		return nil, false, err
+	}
+	if duplicate {
+		responses := []models.MessageResponse{ToResponse(message)}
+		if err := attachEnvelopes(senderID, responses); err != nil {
+			return nil, false, err
+		}
+		return &responses[0], true, nil
+	}
+
+	var envelopes []models.Envelope
+	if encrypted {
+		envelopes = make([]models.Envelope, 0, len(req.Envelopes))
+		for _, envelope := range req.Envelopes {
+			envelope.MessageID = message.ID
+			envelope.SenderDeviceID = req.SenderDeviceID
+			envelopes = append(envelopes, envelope)
+		}
+		// Сообщение без конвертов никто не сможет прочитать, поэтому при
+		// ошибке оно удаляется
+		if err := models.GlobalEnvelopeStore.SaveEnvelopes(envelopes); err != nil {
+			if delErr := models.GlobalMessageStore.DeleteMessage(message.ID, senderID); delErr != nil {
+				log.Printf("❌ Ошибка удаления сообщения %d без конвертов: %v", message.ID, delErr)
+			}
+			return nil, false, err
+		}
+	}
+
+	// Свое сообщение отправитель уже прочитал
+	if _, err := models.GlobalChatStore.UpdateReadCursor(message.ChatID, senderID, message.Seq, message.CreatedAt); err != nil {
+		log.Printf("❌ Ошибка обновления позиции чтения %d в чате %d: %v", senderID, message.ChatID, err)
+	}
+
+	response := ToResponse(message)
+	if !encrypted {
+		s.broadcast(message.ChatID, models.WSMessageTypeChat, ChatEvent(response))
+		return &response, false, nil
+	}
+
+	s.sendEnvelopes(response, envelopes)
+	response.Envelopes = userEnvelopes(envelopes, senderID)
+	return &response, false, nil
+}
+
+// validateEnvelopes проверяет конверты E2EE сообщения: каждый адресован
+// зарегистрированному устройству участника чата, кроме устройства
+// отправителя, и ни одно устройство не получает два конверта
+func validateEnvelopes(senderID uint, req models.MessageRequest) error {
+	if len(req.Envelopes) == 0 || len(req.Envelopes) > maxEnvelopes {
+		return ErrInvalidEnvelopes
+	}
+
+	members := make(map[uint]bool)
+	chatMembers, err := models.GlobalChatStore.GetMembers(req.ChatID)
+	if err != nil {
+		return err
+	}
+	for _, member := range chatMembers {
+		members[member.UserID] = true
+	}
+
+	type device struct {
+		userID   uint
+		deviceID string
+	}
+	seen := make(map[device]bool, len(req.Envelopes))
+	for _, envelope := range req.Envelopes {
+		key := device{envelope.UserID, envelope.DeviceID}
+		if !members[envelope.UserID] || seen[key] ||
+			envelope.UserID == senderID && envelope.DeviceID == req.SenderDeviceID ||
+			len(envelope.Ciphertext) == 0 || len(envelope.Ciphertext) > maxCiphertextLength {
+			return ErrInvalidEnvelopes
+		}
+		seen[key] = true
+
+		_, err := models.GlobalKeyStore.GetDeviceKeys(envelope.UserID, envelope.DeviceID)
+		if errors.Is(err, models.ErrDeviceKeysNotFound) {
+			return ErrInvalidEnvelopes
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEnvelopes рассылает E2EE сообщение участникам чата: каждому
+// пользователю достаются только конверты для его устройств
+func (s *Service) sendEnvelopes(response models.MessageResponse, envelopes []models.Envelope) {
+	broadcaster := s.getBroadcaster()
+	if broadcaster == nil {
+		return
+	}
+
+	members, err := models.GlobalChatStore.GetMembers(response.ChatID)
+	if err != nil {
+		log.Printf("❌ Ошибка получения участников чата %d: %v", response.ChatID, err)
+		return
+	}
+	for _, member := range members {
+		response.Envelopes = userEnvelopes(envelopes, member.UserID)
+		data, err := models.EncodeWebSocketMessage(models.WSMessageTypeChat, "", ChatEvent(response))
+		if err != nil {
+			log.Printf("❌ Ошибка сериализации события %s: %v", models.WSMessageTypeChat, err)
+			return
+		}
+		broadcaster.SendToUser(member.UserID, data)
+	}
+}
+
+// userEnvelopes выбирает конверты для устройств пользователя
+func userEnvelopes(envelopes []models.Envelope, userID uint) []models.Envelope {
+	result := make([]models.Envelope, 0)
+	for _, envelope := range envelopes {
+		if envelope.UserID == userID {
+			result = append(result, envelope)
+		}
+	}
+	return result
+}
+
+// attachEnvelopes добавляет к E2EE сообщениям конверты для устройств
+// пользователя, который их читает
+func attachEnvelopes(userID uint, responses []models.MessageResponse) error {
+	ids := make([]uint, 0)
+	for _, response := range responses {
+		if response.Type == models.MessageTypeEncrypted && !response.IsDeleted {
+			ids = append(ids, response.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	envelopes, err := models.GlobalEnvelopeStore.GetUserEnvelopes(userID, ids)
+	if err != nil {
+		return err
+	}
+	byMessage := make(map[uint][]models.Envelope)
+	for _, envelope := range envelopes {
+		byMessage[envelope.MessageID] = append(byMessage[envelope.MessageID], envelope)
+	}
+	for i := range responses {
+		if responses[i].Type == models.MessageTypeEncrypted && !responses[i].IsDeleted {
+			responses[i].Envelopes = byMessage[responses[i].ID]
+			if responses[i].Envelopes == nil {
+				responses[i].Envelopes = make([]models.Envelope, 0)
+			}
+		}
+	}
+	return nil
+}
+
+// createAttempts сколько раз createMessage пробует сохранить сообщение,
+// пока клиентский ID переходит от старого сообщения к новому
+const createAttempts = 3
+
+// createMessage сохраняет сообщение. Клиентский ID уникален для отправителя
+// на уровне хранилища, поэтому из одновременных повторов, в том числе на
+// разных узлах, сохраняется одно сообщение, а остальные получают его как
+// дубль (true). Если ID занят сообщением в другом чате или вне окна
+// дедупликации, он снимается со старого сообщения и достается новому.
+func (s *Service) createMessage(senderID uint, req models.MessageRequest) (*models.Message, bool, error) {
+	message := &models.Message{
+		Content:     req.Content,
+		Type:        req.Type,
+		SenderID:    senderID,
+		ChatID:      req.ChatID,
+		ReplyToID:   req.ReplyToID,
+		ClientMsgID: req.ClientMsgID,
+		CreatedAt:   time.Now(),
+	}
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		created, err := models.GlobalMessageStore.CreateMessage(message)
+		if !errors.Is(err, models.ErrDuplicateClientMsgID) {
+			return created, false, err
+		}
+
+		existing, err := models.GlobalMessageStore.GetMessageByClientID(senderID, req.ClientMsgID)
+		if errors.Is(err, models.ErrMessageNotFound) {
+			// ID успели снять параллельно, пробуем еще раз
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if s.isDuplicate(existing, req) {
+			return existing, true, nil
+		}
+		if err := models.GlobalMessageStore.ReleaseClientMsgID(senderID, req.ClientMsgID, existing.ID); err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, false, models.ErrDuplicateClientMsgID
+}
+
+// isDuplicate проверяет, что сообщение с тем же клиентским ID - повтор
+// запроса: оно в том же чате и в пределах окна дедупликации
+func (s *Service) isDuplicate(existing *models.Message, req models.MessageRequest) bool {
+	if existing.ChatID != req.ChatID {
+		return false
+	}
+	if window := s.getDedupWindow(); window > 0 && time.Since(existing.CreatedAt) > window {
+		return false
+	}
+	return true
+}
+
+// GetChatMessages возвращает страницу истории чата для его участника
+func (s *Service) GetChatMessages(userID, chatID uint, query PageQuery) (*Page, error) {
+	cursors := 0
+	for _, cursor := range []uint{query.Before, query.After, query.Around} {
+		if cursor > 0 {
+			cursors++
+		}
+	}
+	if query.AfterSeq > 0 {
+		cursors++
+	}
+	if cursors > 1 {
+		return nil, ErrInvalidCursor
+	}
+	if query.Limit <= 0 || query.Limit > MaxPageLimit {
+		query.Limit = MaxPageLimit
+	}
+
+	if err := s.RequireMember(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	store := models.GlobalMessageStore
+	var messages []*models.Message
+	var hasOlder, hasNewer bool
+
+	switch {
+	case query.After > 0 || query.AfterSeq > 0:
+		// Запрашиваем на одно сообщение больше, чтобы узнать, есть ли продолжение
+		var newer []*models.Message
+		var err error
+		if query.AfterSeq > 0 {
+			newer, err = store.GetChatMessagesAfterSeq(chatID, query.AfterSeq, query.Limit+1)
+		} else {
+			newer, err = store.GetChatMessagesAfter(chatID, query.After, query.Limit+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		hasNewer = len(newer) > query.Limit
+		if hasNewer {
+			newer = newer[:query.Limit]
+		}
+		messages = newer
+		// Старше курсора как минимум само сообщение-курсор
+		hasOlder = true
+
+	case query.Around > 0:
+		target, err := store.GetMessageByID(query.Around)
+		if err != nil {
+			return nil, err
+		}
+		if target.ChatID != chatID {
+			return nil, models.ErrMessageNotFound
+		}
+
+		// Половина окна до сообщения, остальное - после
+		olderLimit := (query.Limit - 1) / 2
+		newerLimit := query.Limit - 1 - olderLimit
+
+		older, err := store.GetChatMessagesBefore(chatID, target.ID, olderLimit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder = len(older) > olderLimit
+		if hasOlder {
+			older = older[1:]
+		}
+
+		newer, err := store.GetChatMessagesAfter(chatID, target.ID, newerLimit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasNewer = len(newer) > newerLimit
+		if hasNewer {
+			newer = newer[:newerLimit]
+		}
+
+		messages = append(append(older, target), newer...)
+
+	default:
+		older, err := store.GetChatMessagesBefore(chatID, query.Before, query.Limit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder = len(older) > query.Limit
+		if hasOlder {
+			older = older[1:]
+		}
+		messages = older
+		// Новее курсора как минимум само сообщение-курсор
+		hasNewer = query.Before > 0
+	}
+
+	page := &Page{Messages: make([]models.MessageResponse, 0, len(messages))}
+	for _, message := range messages {
+		page.Messages = append(page.Messages, ToResponse(message))
+	}
+	if err := attachEnvelopes(userID, page.Messages); err != nil {
+		return nil, err
+	}
+
+	// Курсоры ставим только там, где за границей страницы что-то есть
+	if len(messages) > 0 {
+		first, last := messages[0].ID, messages[len(messages)-1].ID
+		if hasOlder {
+			page.PrevCursor = &first
+		}
+		if hasNewer {
+			page.NextCursor = &last
+		}
+	}
+	return page, nil
+}
+
+// ReadState позиция чтения пользователя в чате
+type ReadState struct {
+	ChatID      uint       `json:"chat_id"`
+	LastReadSeq uint64     `json:"last_read_seq"`
+	LastReadAt  *time.Time `json:"last_read_at"`
+	UnreadCount int        `json:"unread_count"`
+}
+
+// MarkRead отмечает прочитанными сообщения чата до seq включительно.
+// Позиция только растет, seq за последним сообщением урезается до него.
+// О сдвиге позиции узнают участники чата, а если в чате отключены отметки
+// о прочтении - только устройства самого пользователя.
+func (s *Service) MarkRead(userID, chatID uint, seq uint64) (*ReadState, error) {
+	if seq == 0 {
+		return nil, ErrInvalidReadSeq
+	}
+	if err := s.RequireMember(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	last, err := models.GlobalMessageStore.GetChatMessages(chatID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(last) == 0 {
+		return s.ReadState(userID, chatID)
+	}
+	if seq > last[0].Seq {
+		seq = last[0].Seq
+	}
+
+	advanced, err := models.GlobalChatStore.UpdateReadCursor(chatID, userID, seq, time.Now())
+	if errors.Is(err, models.ErrMemberNotFound) {
+		return nil, ErrNotChatMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.ReadState(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if advanced {
+		s.broadcastRead(userID, chatID, state)
+	}
+	return state, nil
+}
+
+// ReadState возвращает позицию чтения пользователя и число непрочитанных
+// сообщений в чате
+func (s *Service) ReadState(userID, chatID uint) (*ReadState, error) {
+	member, err := models.GlobalChatStore.GetMember(chatID, userID)
+	if err != nil {
+		return nil, ErrNotChatMember
+	}
+
+	unread, err := models.GlobalMessageStore.CountUnread(chatID, member.LastReadSeq, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadState{
+		ChatID:      chatID,
+		LastReadSeq: member.LastReadSeq,
+		LastReadAt:  member.LastReadAt,
+		UnreadCount: unread,
+	}, nil
+}
+
+// broadcastRead рассылает отметку о прочтении с учетом настройки чата
+func (s *Service) broadcastRead(userID, chatID uint, state *ReadState) {
+	broadcaster := s.getBroadcaster()
+	if broadcaster == nil {
+		return
+	}
+
+	chat, err := models.GlobalChatStore.GetChatByID(chatID)
+	if err != nil {
+		return
+	}
+
+	event := models.WSReadEvent{
+		ChatID: chatID,
+		UserID: userID,
+		Seq:    state.LastReadSeq,
+		ReadAt: state.LastReadAt,
+	}
+	if user, err := models.GlobalUserStore.GetUserByID(userID); err == nil {
+		event.Username = user.Username
+	}
+
+	data, err := models.EncodeWebSocketMessage(models.WSMessageTypeRead, "", event)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации события %s: %v", models.WSMessageTypeRead, err)
+		return
+	}
+	if chat.ReadReceipts {
+		broadcaster.BroadcastToChat(chatID, data)
+	} else {
+		broadcaster.SendToUser(userID, data)
+	}
+}
+
+// Edit меняет текст сообщения. Редактировать может только отправитель,
+// пока он состоит в чате и не истекло окно редактирования.
+func (s *Service) Edit(userID, messageID uint, content string) (*models.MessageResponse, error) {
+	if !isValidContent(content) {
+		return nil, ErrInvalidContent
+	}
+
+	message, err := s.loadMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrForbidden
+	}
+	if message.Type == models.MessageTypeEncrypted {
+		return nil, ErrEncryptedEdit
+	}
+	// Без исходного текста правку нельзя записать в историю
+	if message.Unreadable {
+		return nil, ErrUnreadableMessage
+	}
+	if err := s.RequireMember(message.ChatID, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if window := s.getEditWindow(); window > 0 && now.Sub(message.CreatedAt) > window {
+		return nil, ErrEditWindowExpired
+	}
+
+	// Ревизию пишем до изменения: правка без сохраненного оригинала
+	// не должна пройти
+	if err := s.saveRevision(message, models.RevisionActionEdit, userID, now); err != nil {
+		return nil, err
+	}
+
+	message.Content = content
+	message.IsEdited = true
+	message.UpdatedAt = now
+	if err := models.GlobalMessageStore.UpdateMessage(message); err != nil {
+		return nil, err
+	}
+
+	response := ToResponse(message)
+	s.broadcast(message.ChatID, models.WSMessageTypeMessageEdited, ChatEvent(response))
+	return &response, nil
+}
+
+// Delete помечает сообщение удаленным. Удалить может отправитель или
+// владелец/администратор чата; участникам рассылается message_deleted.
+// Нерасшифрованное сообщение тоже удаляется, его ревизия остается без
+// текста.
+func (s *Service) Delete(userID, messageID uint) (*models.Message, error) {
+	message, err := s.loadMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := models.GlobalChatStore.GetMember(message.ChatID, userID)
+	if err != nil {
+		return nil, ErrNotChatMember
+	}
+	if message.SenderID != userID && member.Role != models.ChatRoleOwner && member.Role != models.ChatRoleAdmin {
+		return nil, ErrForbidden
+	}
+
+	deletedAt := time.Now()
+	if err := s.saveRevision(message, models.RevisionActionDelete, userID, deletedAt); err != nil {
+		return nil, err
+	}
+
+	if err := models.GlobalMessageStore.DeleteMessage(messageID, userID); err != nil {
+		return nil, err
+	}
+	if message.Type == models.MessageTypeEncrypted {
+		if err := models.GlobalEnvelopeStore.DeleteMessageEnvelopes(messageID); err != nil {
+			log.Printf("❌ Ошибка удаления конвертов сообщения %d: %v", messageID, err)
+		}
+	}
+
+	message.MarkDeleted(userID, deletedAt)
+	s.broadcast(message.ChatID, models.WSMessageTypeMessageDeleted, models.WSMessageDeletedEvent{
+		ID:        message.ID,
+		ChatID:    message.ChatID,
+		DeletedBy: userID,
+		DeletedAt: deletedAt,
+	})
+	return message, nil
+}
+
+// GetHistory возвращает сообщение и все его прежние версии. Доступно
+// только владельцу и администраторам чата, в том числе для удаленных сообщений.
+func (s *Service) GetHistory(userID, messageID uint) (*models.MessageResponse, []models.MessageRevision, error) {
+	message, err := models.GlobalMessageStore.GetMessageByID(messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := models.GlobalChatStore.GetMember(message.ChatID, userID)
+	if err != nil {
+		return nil, nil, ErrNotChatMember
+	}
+	if member.Role != models.ChatRoleOwner && member.Role != models.ChatRoleAdmin {
+		return nil, nil, ErrNotChatAdmin
+	}
+
+	revisions, err := models.GlobalRevisionStore.GetMessageRevisions(messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := ToResponse(message)
+	return &response, revisions, nil
+}
+
+// PurgeRevisions удаляет ревизии старше retention; 0 означает хранить бессрочно
+func (s *Service) PurgeRevisions(retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	return models.GlobalRevisionStore.DeleteRevisionsBefore(time.Now().Add(-retention))
+}
+
+// reencryptBatch сколько текстов перешифровывается за один проход по хранилищу
+const reencryptBatch = 500
+
+// ReencryptContent перешифровывает текст сообщений и ревизий активным
+// ключом и возвращает, сколько текстов изменилось. Если шифрование
+// выключено, ничего не делает.
+func (s *Service) ReencryptContent() (int, error) {
+	total := 0
+	stores := []interface{}{models.GlobalMessageStore, models.GlobalRevisionStore}
+	for _, store := range stores {
+		rotator, ok := store.(models.ContentRotator)
+		if !ok {
+			continue
+		}
+		for afterID := uint(0); ; {
+			next, rotated, err := rotator.RotateContent(afterID, reencryptBatch)
+			total += rotated
+			if err != nil {
+				return total, err
+			}
+			if next == 0 {
+				break
+			}
+			afterID = next
+		}
+	}
+	return total, nil
+}
+
+// saveRevision сохраняет текущий текст сообщения перед его заменой
+func (s *Service) saveRevision(message *models.Message, action string, editorID uint, at time.Time) error {
+	_, err := models.GlobalRevisionStore.CreateRevision(&models.MessageRevision{
+		MessageID: message.ID,
+		ChatID:    message.ChatID,
+		Content:   message.Content,
+		Action:    action,
+		EditorID:  editorID,
+		EditedAt:  at,
+	})
+	return err
+}
+
+// loadMessage загружает сообщение; удаленные считаются ненайденными
+func (s *Service) loadMessage(messageID uint) (*models.Message, error) {
+	message, err := models.GlobalMessageStore.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.IsDeleted {
+		return nil, models.ErrMessageNotFound
+	}
+	return message, nil
+}
+
+// getEditWindow возвращает окно редактирования
+func (s *Service) getEditWindow() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.editWindow
+}
+
+// RequireMember проверяет, что пользователь состоит в чате
+func (s *Service) RequireMember(chatID, userID uint) error {
+	if _, err := models.GlobalChatStore.GetChatByID(chatID); err != nil {
+		return err
+	}
+	if _, err := models.GlobalChatStore.GetMember(chatID, userID); err != nil {
+		return ErrNotChatMember
+	}
+	return nil
+}
+
+// SharesChat проверяет, есть ли у userID и otherID общий чат; пользователь
+// всегда делит чат сам с собой. По нему решается, видит ли один
+// пользователь статус и ключи устройств другого.
+func (s *Service) SharesChat(userID, otherID uint) (bool, error) {
+	if userID == otherID {
+		return true, nil
+	}
+	chats, err := models.GlobalChatStore.GetUserChats(otherID)
+	if err != nil {
+		return false, err
+	}
+	for _, chat := range chats {
+		if _, err := models.GlobalChatStore.GetMember(chat.ID, userID); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MemberJoined подписывает подключенные клиенты нового участника на чат
+func (s *Service) MemberJoined(chatID, userID uint) {
+	if broadcaster := s.getBroadcaster(); broadcaster != nil {
+		broadcaster.AddUserToChat(userID, chatID)
+	}
+}
+
+// MemberLeft отписывает клиенты бывшего участника, чтобы он больше не
+// получал события чата
+func (s *Service) MemberLeft(chatID, userID uint) {
+	if broadcaster := s.getBroadcaster(); broadcaster != nil {
+		broadcaster.RemoveUserFromChat(userID, chatID)
+	}
+}
+
+// getBroadcaster возвращает подключенную доставку событий
+func (s *Service) getBroadcaster() Broadcaster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.broadcaster
+}
+
+// broadcast отправляет событие участникам чата, если доставка подключена
+func (s *Service) broadcast(chatID uint, eventType string, payload interface{}) {
+	broadcaster := s.getBroadcaster()
+	if broadcaster == nil {
+		return
+	}
+
+	data, err := models.EncodeWebSocketMessage(eventType, "", payload)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации события %s: %v", eventType, err)
+		return
+	}
+	broadcaster.BroadcastToChat(chatID, data)
+}
+
+// ToResponse собирает ответ с сообщением и данными отправителя
+func ToResponse(message *models.Message) models.MessageResponse {
+	sender := models.UserResponse{ID: message.SenderID}
+	if user, err := models.GlobalUserStore.GetUserByID(message.SenderID); err == nil {
+		sender = models.UserResponse{
+			ID:        user.ID,
+			Username:  user.Username,
+			Avatar:    user.Avatar,
+			Status:    user.Status,
+			CreatedAt: user.CreatedAt,
+		}
+	}
+
+	return models.MessageResponse{
+		ID:          message.ID,
+		Content:     message.Content,
+		Type:        message.Type,
+		Sender:      sender,
+		ChatID:      message.ChatID,
+		Seq:         message.Seq,
+		ReplyToID:   message.ReplyToID,
+		ClientMsgID: message.ClientMsgID,
+		IsEdited:    message.IsEdited,
+		IsDeleted:   message.IsDeleted,
+		Unreadable:  message.Unreadable,
+		CreatedAt:   message.CreatedAt,
+		UpdatedAt:   message.UpdatedAt,
+	}
+}
+
+// ChatEvent payload WebSocket кадра "chat" для сообщения
+func ChatEvent(message models.MessageResponse) models.WSChatEvent {
+	return models.WSChatEvent{
+		ID:          message.ID,
+		Content:     message.Content,
+		Type:        message.Type,
+		SenderID:    message.Sender.ID,
+		Username:    message.Sender.Username,
+		ChatID:      message.ChatID,
+		Seq:         message.Seq,
+		ReplyToID:   message.ReplyToID,
+		ClientMsgID: message.ClientMsgID,
+		IsEdited:    message.IsEdited,
+		IsDeleted:   message.IsDeleted,
+		Unreadable:  message.Unreadable,
+		Timestamp:   message.CreatedAt,
+		UpdatedAt:   message.UpdatedAt,
+		Envelopes:   message.Envelopes,
+	}
+}
+
+// HistoryEvent payload кадра "chat" для сообщения из истории
+func HistoryEvent(message models.MessageResponse) models.WSChatEvent {
+	event := ChatEvent(message)
+	event.IsHistory = true
+	return event
+}
+
+// isValidContent проверяет, что текст не пустой и не длиннее maxContentLength
+func isValidContent(content string) bool {
+	return strings.TrimSpace(content) != "" && utf8.RuneCountInString(content) <= maxContentLength
+}
+
+// isValidMessageType проверяет тип сообщения
+func isValidMessageType(messageType string) bool {
+	switch messageType {
+	case models.MessageTypeText, models.MessageTypeImage, models.MessageTypeFile,
+		models.MessageTypeVoice, models.MessageTypeLocation, models.MessageTypeEncrypted:
+		return true
+	}
+	return false
+}
+
+// Глобальный сервис сообщений
+var GlobalService = NewService()
@@ -0,0 +1,677 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gomessage/internal/config"
+	"gomessage/internal/models"
+	"gomessage/internal/storage"
+)
+
+// useBoltStore подменяет глобальные хранилища на bolt в каталоге теста и
+// возвращает открытые репозитории
+func useBoltStore(t *testing.T) *storage.Repositories {
+	t.Helper()
+	previous := &storage.Repositories{
+		Users:         models.GlobalUserStore,
+		Chats:         models.GlobalChatStore,
+		Messages:      models.GlobalMessageStore,
+		Revisions:     models.GlobalRevisionStore,
+		Deliveries:    models.GlobalDeliveryStore,
+		Keys:          models.GlobalKeyStore,
+		Envelopes:     models.GlobalEnvelopeStore,
+		RefreshTokens: models.GlobalRefreshTokenStore,
+	}
+
+	repos, err := storage.Open(config.DatabaseConfig{
+		Driver: storage.DriverBolt,
+		Path:   filepath.Join(t.TempDir(), "gomessage.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		previous.Install()
+		repos.Close()
+	})
+	repos.Install()
+	return repos
+}
+
+// testChat группа: владелец, администратор, участник и посторонний
+type testChat struct {
+	id                             uint
+	owner, admin, member, stranger uint
+}
+
+// newTestUser создает пользователя
+func newTestUser(t *testing.T, name string) uint {
+	t.Helper()
+	user, err := models.GlobalUserStore.CreateUser(name, name+"@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// newTestChat создает группу с пользователями всех ролей
+func newTestChat(t *testing.T) testChat {
+	t.Helper()
+	chat := testChat{
+		owner:    newTestUser(t, "owner"),
+		admin:    newTestUser(t, "admin"),
+		member:   newTestUser(t, "member"),
+		stranger: newTestUser(t, "stranger"),
+	}
+	chat.id = newGroup(t, "team", chat.owner, chat.admin, chat.member)
+	return chat
+}
+
+// newGroup создает группу: первый пользователь - владелец, второй -
+// администратор, остальные - участники
+func newGroup(t *testing.T, name string, userIDs ...uint) uint {
+	t.Helper()
+	members := make([]models.ChatMember, 0, len(userIDs))
+	for i, userID := range userIDs {
+		role := models.ChatRoleMember
+		switch i {
+		case 0:
+			role = models.ChatRoleOwner
+		case 1:
+			role = models.ChatRoleAdmin
+		}
+		members = append(members, models.ChatMember{UserID: userID, Role: role})
+	}
+	chat, err := models.GlobalChatStore.CreateChat(&models.Chat{
+		Name:         name,
+		Type:         models.ChatTypeGroup,
+		CreatorID:    userIDs[0],
+		ReadReceipts: true,
+	}, members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chat.ID
+}
+
+// send отправляет текстовое сообщение или останавливает тест
+func send(t *testing.T, s *Service, senderID, chatID uint, content string) *models.MessageResponse {
+	t.Helper()
+	response, duplicate, err := s.Send(senderID, models.MessageRequest{
+		Content: content,
+		Type:    models.MessageTypeText,
+		ChatID:  chatID,
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if duplicate {
+		t.Fatal("Send returned a duplicate")
+	}
+	return response
+}
+
+// storeOldMessage сохраняет сообщение, отправленное age назад
+func storeOldMessage(t *testing.T, senderID, chatID uint, clientMsgID string, age time.Duration) *models.Message {
+	t.Helper()
+	message, err := models.GlobalMessageStore.CreateMessage(&models.Message{
+		Content:     "old",
+		Type:        models.MessageTypeText,
+		SenderID:    senderID,
+		ChatID:      chatID,
+		ClientMsgID: clientMsgID,
+		CreatedAt:   time.Now().Add(-age),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// recordedEvent вызов Broadcaster
+type recordedEvent struct {
+	method string
+	id     uint // чат или пользователь
+	data   string
+}
+
+// recordingBroadcaster запоминает рассылки сервиса
+type recordingBroadcaster struct {
+	events []recordedEvent
+	mu     sync.Mutex
+}
+
+func (b *recordingBroadcaster) record(method string, id uint, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, recordedEvent{method, id, string(data)})
+}
+
+func (b *recordingBroadcaster) BroadcastToChat(chatID uint, message []byte) {
+	b.record("chat", chatID, message)
+}
+
+func (b *recordingBroadcaster) SendToUser(userID uint, message []byte) {
+	b.record("user", userID, message)
+}
+
+func (b *recordingBroadcaster) AddUserToChat(userID, chatID uint)      {}
+func (b *recordingBroadcaster) RemoveUserFromChat(userID, chatID uint) {}
+
+// take возвращает и забывает записанные события
+func (b *recordingBroadcaster) take() []recordedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := b.events
+	b.events = nil
+	return events
+}
+
+func TestSendValidation(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	other := newGroup(t, "other", chat.owner, chat.stranger)
+	s := NewService()
+	foreign := send(t, s, chat.owner, other, "elsewhere")
+
+	tests := []struct {
+		name     string
+		senderID uint
+		req      models.MessageRequest
+		wantErr  error
+	}{
+		{"unknown type", chat.member, models.MessageRequest{Content: "hi", Type: "sticker", ChatID: chat.id}, ErrInvalidMessageType},
+		{"empty content", chat.member, models.MessageRequest{Type: models.MessageTypeText, ChatID: chat.id}, ErrInvalidContent},
+		{"content too long", chat.member, models.MessageRequest{Content: strings.Repeat("я", maxContentLength+1), Type: models.MessageTypeText, ChatID: chat.id}, ErrInvalidContent},
+		{"client ID too long", chat.member, models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chat.id, ClientMsgID: strings.Repeat("x", maxClientMsgIDLength+1)}, ErrInvalidClientMsgID},
+		{"not a member", chat.stranger, models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chat.id}, ErrNotChatMember},
+		{"unknown chat", chat.member, models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chat.id + 100}, models.ErrChatNotFound},
+		{"reply to another chat", chat.owner, models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chat.id, ReplyToID: &foreign.ID}, ErrInvalidReply},
+		{"encrypted in plain chat", chat.member, models.MessageRequest{Type: models.MessageTypeEncrypted, ChatID: chat.id}, ErrNotEncryptedChat},
+		{"envelopes in plain chat", chat.member, models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chat.id, Envelopes: []models.Envelope{{UserID: chat.owner, DeviceID: "phone", Ciphertext: []byte{1}}}}, ErrNotEncryptedChat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.Send(tt.senderID, tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Send = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Отклоненные запросы не занимают номера в чате
+	first := send(t, s, chat.member, chat.id, strings.Repeat("я", maxContentLength))
+	second := send(t, s, chat.owner, chat.id, "reply")
+	if first.Seq != 1 || second.Seq != 2 {
+		t.Fatalf("seq = %d, %d, want 1, 2", first.Seq, second.Seq)
+	}
+}
+
+func TestSendDedup(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	other := newGroup(t, "other", chat.member, chat.owner)
+	s := NewService()
+	s.SetDedupWindow(time.Hour)
+
+	request := func(chatID uint, clientMsgID string) models.MessageRequest {
+		return models.MessageRequest{Content: "hi", Type: models.MessageTypeText, ChatID: chatID, ClientMsgID: clientMsgID}
+	}
+
+	first, duplicate, err := s.Send(chat.member, request(chat.id, "retry"))
+	if err != nil || duplicate {
+		t.Fatalf("Send = %v, duplicate %v", err, duplicate)
+	}
+
+	tests := []struct {
+		name      string
+		senderID  uint
+		chatID    uint
+		duplicate bool
+	}{
+		{"retry in window", chat.member, chat.id, true},
+		{"same ID of another sender", chat.owner, chat.id, false},
+		{"same ID in another chat", chat.member, other, false},
+		// ID перешел к сообщению в другом чате, повтор в первом - новое сообщение
+		{"retry after the ID moved", chat.member, chat.id, false},
+		{"retry of the moved ID", chat.member, chat.id, true},
+	}
+
+	var previous *models.MessageResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, duplicate, err := s.Send(tt.senderID, request(tt.chatID, "retry"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duplicate != tt.duplicate {
+				t.Fatalf("duplicate = %v, want %v", duplicate, tt.duplicate)
+			}
+			if duplicate && previous != nil && response.ID != previous.ID {
+				t.Fatalf("duplicate of message %d, want %d", response.ID, previous.ID)
+			}
+			if duplicate && previous == nil && response.ID != first.ID {
+				t.Fatalf("duplicate of message %d, want %d", response.ID, first.ID)
+			}
+			if tt.senderID == chat.member {
+				previous = response
+			}
+		})
+	}
+
+	// ID у сообщения, созданного последним
+	owner, err := models.GlobalMessageStore.GetMessageByClientID(chat.member, "retry")
+	if err != nil || owner.ID != previous.ID {
+		t.Fatalf("GetMessageByClientID = %+v, %v, want message %d", owner, err, previous.ID)
+	}
+	if moved, _ := models.GlobalMessageStore.GetMessageByID(first.ID); moved.ClientMsgID != "" {
+		t.Fatalf("first message kept client ID %q", moved.ClientMsgID)
+	}
+}
+
+func TestSendDedupWindow(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+
+	tests := []struct {
+		name      string
+		window    time.Duration
+		age       time.Duration
+		duplicate bool
+	}{
+		{"inside window", time.Hour, time.Minute, true},
+		{"outside window", time.Hour, 2 * time.Hour, false},
+		{"no window", 0, 30 * 24 * time.Hour, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService()
+			s.SetDedupWindow(tt.window)
+			clientMsgID := fmt.Sprintf("retry-%d", i)
+			old := storeOldMessage(t, chat.member, chat.id, clientMsgID, tt.age)
+
+			response, duplicate, err := s.Send(chat.member, models.MessageRequest{
+				Content: "hi", Type: models.MessageTypeText, ChatID: chat.id, ClientMsgID: clientMsgID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duplicate != tt.duplicate || (response.ID == old.ID) != tt.duplicate {
+				t.Fatalf("duplicate = %v, message %d (old %d), want duplicate %v", duplicate, response.ID, old.ID, tt.duplicate)
+			}
+		})
+	}
+}
+
+func TestSendDedupConcurrent(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	s.SetDedupWindow(time.Hour)
+
+	const attempts = 8
+	ids := make(chan uint, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _, err := s.Send(chat.member, models.MessageRequest{
+				Content: "hi", Type: models.MessageTypeText, ChatID: chat.id, ClientMsgID: "burst",
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- response.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	first := <-ids
+	for id := range ids {
+		if id != first {
+			t.Fatalf("concurrent retries created messages %d and %d", first, id)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	s.SetEditWindow(time.Hour)
+
+	fresh := send(t, s, chat.member, chat.id, "draft")
+	expired := storeOldMessage(t, chat.member, chat.id, "", 2*time.Hour)
+	deleted := send(t, s, chat.member, chat.id, "gone")
+	if _, err := s.Delete(chat.member, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    uint
+		messageID uint
+		content   string
+		wantErr   error
+	}{
+		{"admin cannot edit", chat.admin, fresh.ID, "admin", ErrForbidden},
+		{"owner cannot edit", chat.owner, fresh.ID, "owner", ErrForbidden},
+		{"empty content", chat.member, fresh.ID, "", ErrInvalidContent},
+		{"window expired", chat.member, expired.ID, "late", ErrEditWindowExpired},
+		{"deleted message", chat.member, deleted.ID, "back", models.ErrMessageNotFound},
+		{"unknown message", chat.member, deleted.ID + 100, "nothing", models.ErrMessageNotFound},
+		{"sender edits", chat.member, fresh.ID, "final", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.Edit(tt.userID, tt.messageID, tt.content)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Edit = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (response.Content != tt.content || !response.IsEdited) {
+				t.Fatalf("Edit = %+v", response)
+			}
+		})
+	}
+
+	// Окно 0 - без ограничения
+	s.SetEditWindow(0)
+	if _, err := s.Edit(chat.member, expired.ID, "late"); err != nil {
+		t.Fatalf("Edit without window: %v", err)
+	}
+
+	// Прежний текст остается в истории правок
+	_, revisions, err := s.GetHistory(chat.owner, fresh.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Content != "draft" || revisions[0].Action != models.RevisionActionEdit || revisions[0].EditorID != chat.member {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	broadcaster := &recordingBroadcaster{}
+	s.SetBroadcaster(broadcaster)
+
+	tests := []struct {
+		name     string
+		senderID uint
+		userID   uint
+		wantErr  error
+	}{
+		{"sender deletes", chat.member, chat.member, nil},
+		{"owner deletes", chat.member, chat.owner, nil},
+		{"admin deletes", chat.member, chat.admin, nil},
+		{"member cannot delete others", chat.owner, chat.member, ErrForbidden},
+		{"admin deletes owner's message", chat.owner, chat.admin, nil},
+		{"stranger cannot delete", chat.member, chat.stranger, ErrNotChatMember},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := send(t, s, tt.senderID, chat.id, "text")
+			broadcaster.take()
+
+			deleted, err := s.Delete(tt.userID, message.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Delete = %v, want %v", err, tt.wantErr)
+			}
+			events := broadcaster.take()
+			stored, _ := models.GlobalMessageStore.GetMessageByID(message.ID)
+			if err != nil {
+				if stored.IsDeleted || len(events) != 0 {
+					t.Fatal("rejected delete changed the message")
+				}
+				return
+			}
+
+			if !deleted.IsDeleted || deleted.Content != "" || !stored.IsDeleted || stored.Content != "" {
+				t.Fatalf("deleted = %+v, stored = %+v", deleted, stored)
+			}
+			if len(events) != 1 || events[0].method != "chat" || !strings.Contains(events[0].data, models.WSMessageTypeMessageDeleted) {
+				t.Fatalf("events = %+v", events)
+			}
+			if _, err := s.Delete(tt.userID, message.ID); !errors.Is(err, models.ErrMessageNotFound) {
+				t.Fatalf("second Delete = %v, want %v", err, models.ErrMessageNotFound)
+			}
+
+			_, revisions, err := s.GetHistory(chat.owner, message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revisions) != 1 || revisions[0].Content != "text" || revisions[0].Action != models.RevisionActionDelete || revisions[0].EditorID != tt.userID {
+				t.Fatalf("revisions = %+v", revisions)
+			}
+		})
+	}
+}
+
+func TestGetHistoryAccess(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	message := send(t, s, chat.member, chat.id, "text")
+
+	tests := []struct {
+		name    string
+		userID  uint
+		wantErr error
+	}{
+		{"owner", chat.owner, nil},
+		{"admin", chat.admin, nil},
+		{"sender without admin role", chat.member, ErrNotChatAdmin},
+		{"stranger", chat.stranger, ErrNotChatMember},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.GetHistory(tt.userID, message.ID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetHistory = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, _, err := s.GetHistory(chat.owner, message.ID+100); !errors.Is(err, models.ErrMessageNotFound) {
+		t.Fatalf("GetHistory of unknown message = %v", err)
+	}
+}
+
+func TestGetChatMessagesCursors(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	other := newGroup(t, "other", chat.owner, chat.member)
+	s := NewService()
+
+	// Сообщения другого чата вперемешку, чтобы ID шли с пропусками
+	var ids []uint
+	var foreign uint
+	for i := 0; i < 10; i++ {
+		ids = append(ids, send(t, s, chat.member, chat.id, fmt.Sprintf("m%d", i)).ID)
+		foreign = send(t, s, chat.owner, other, "elsewhere").ID
+	}
+
+	// none - курсора нет
+	const none = -1
+	tests := []struct {
+		name       string
+		query      PageQuery
+		want       []int // индексы в ids
+		prev, next int   // индекс курсора в ids или none
+	}{
+		{"latest page", PageQuery{Limit: 3}, []int{7, 8, 9}, 7, none},
+		{"whole chat", PageQuery{Limit: 10}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, none, none},
+		{"limit above max", PageQuery{Limit: MaxPageLimit + 1}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, none, none},
+		{"before middle", PageQuery{Before: ids[5], Limit: 3}, []int{2, 3, 4}, 2, 4},
+		{"before reaches start", PageQuery{Before: ids[3], Limit: 3}, []int{0, 1, 2}, none, 2},
+		{"before first", PageQuery{Before: ids[0], Limit: 3}, nil, none, none},
+		{"after middle", PageQuery{After: ids[4], Limit: 3}, []int{5, 6, 7}, 5, 7},
+		{"after reaches end", PageQuery{After: ids[6], Limit: 3}, []int{7, 8, 9}, 7, none},
+		{"after last", PageQuery{After: ids[9], Limit: 3}, nil, none, none},
+		{"after seq", PageQuery{AfterSeq: 7, Limit: 5}, []int{7, 8, 9}, 7, none},
+		{"after seq with more", PageQuery{AfterSeq: 2, Limit: 3}, []int{2, 3, 4}, 2, 4},
+		{"around middle", PageQuery{Around: ids[5], Limit: 5}, []int{3, 4, 5, 6, 7}, 3, 7},
+		{"around first", PageQuery{Around: ids[0], Limit: 5}, []int{0, 1, 2}, none, 2},
+		{"around last", PageQuery{Around: ids[9], Limit: 5}, []int{7, 8, 9}, 7, none},
+		{"around with whole chat", PageQuery{Around: ids[5], Limit: 20}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, none, none},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.GetChatMessages(chat.owner, chat.id, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]uint, len(page.Messages))
+			for i, message := range page.Messages {
+				got[i] = message.ID
+			}
+			want := make([]uint, len(tt.want))
+			for i, index := range tt.want {
+				want[i] = ids[index]
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("messages = %v, want %v", got, want)
+			}
+
+			checkCursor := func(name string, cursor *uint, index int) {
+				t.Helper()
+				switch {
+				case index == none && cursor != nil:
+					t.Fatalf("%s cursor = %d, want none", name, *cursor)
+				case index != none && (cursor == nil || *cursor != ids[index]):
+					t.Fatalf("%s cursor = %v, want %d", name, cursor, ids[index])
+				}
+			}
+			checkCursor("prev", page.PrevCursor, tt.prev)
+			checkCursor("next", page.NextCursor, tt.next)
+		})
+	}
+
+	errorTests := []struct {
+		name    string
+		userID  uint
+		query   PageQuery
+		wantErr error
+	}{
+		{"two cursors", chat.owner, PageQuery{Before: ids[5], After: ids[2]}, ErrInvalidCursor},
+		{"cursor and seq", chat.owner, PageQuery{Around: ids[5], AfterSeq: 2}, ErrInvalidCursor},
+		{"around message of another chat", chat.owner, PageQuery{Around: foreign}, models.ErrMessageNotFound},
+		{"not a member", chat.stranger, PageQuery{}, ErrNotChatMember},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.GetChatMessages(tt.userID, chat.id, tt.query); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetChatMessages = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMarkReadUnread(t *testing.T) {
+	useBoltStore(t)
+	chat := newTestChat(t)
+	s := NewService()
+	broadcaster := &recordingBroadcaster{}
+	s.SetBroadcaster(broadcaster)
+
+	// Пустой чат: читать нечего
+	state, err := s.MarkRead(chat.admin, chat.id, 5)
+	if err != nil || state.LastReadSeq != 0 || state.UnreadCount != 0 {
+		t.Fatalf("MarkRead in empty chat = %+v, %v", state, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		send(t, s, chat.owner, chat.id, "from owner")
+	}
+	// Свое сообщение с другого устройства, позиция чтения не сдвинута
+	storeOldMessage(t, chat.admin, chat.id, "", 0)
+	deleted := send(t, s, chat.owner, chat.id, "deleted")
+	if _, err := s.Delete(chat.owner, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	send(t, s, chat.member, chat.id, "from member")
+	broadcaster.take()
+
+	// Свои и удаленные сообщения не считаются непрочитанными
+	state, err = s.ReadState(chat.admin, chat.id)
+	if err != nil || state.LastReadSeq != 0 || state.UnreadCount != 4 {
+		t.Fatalf("ReadState = %+v, %v, want 4 unread", state, err)
+	}
+	// Отправка сдвигает позицию отправителя
+	state, err = s.ReadState(chat.member, chat.id)
+	if err != nil || state.LastReadSeq != 6 || state.UnreadCount != 0 {
+		t.Fatalf("sender ReadState = %+v, %v", state, err)
+	}
+
+	tests := []struct {
+		name      string
+		seq       uint64
+		wantSeq   uint64
+		unread    int
+		broadcast bool
+	}{
+		{"read first two", 2, 2, 2, true},
+		{"cursor does not move back", 1, 2, 2, false},
+		{"same seq", 2, 2, 2, false},
+		{"seq past the end is clamped", 100, 6, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := s.MarkRead(chat.admin, chat.id, tt.seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.LastReadSeq != tt.wantSeq || state.UnreadCount != tt.unread {
+				t.Fatalf("state = %+v, want seq %d and %d unread", state, tt.wantSeq, tt.unread)
+			}
+			events := broadcaster.take()
+			if tt.broadcast != (len(events) == 1) || len(events) > 1 {
+				t.Fatalf("events = %+v, want broadcast %v", events, tt.broadcast)
+			}
+			if tt.broadcast && (events[0].method != "chat" || events[0].id != chat.id) {
+				t.Fatalf("event = %+v, want chat broadcast", events[0])
+			}
+		})
+	}
+
+	if _, err := s.MarkRead(chat.member, chat.id, 0); !errors.Is(err, ErrInvalidReadSeq) {
+		t.Fatalf("MarkRead(0) = %v, want %v", err, ErrInvalidReadSeq)
+	}
+	if _, err := s.MarkRead(chat.stranger, chat.id, 1); !errors.Is(err, ErrNotChatMember) {
+		t.Fatalf("MarkRead by stranger = %v, want %v", err, ErrNotChatMember)
+	}
+
+	// Без отметок о прочтении позицию видят только устройства читателя
+	chatModel, err := models.GlobalChatStore.GetChatByID(chat.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatModel.ReadReceipts = false
+	if err := models.GlobalChatStore.UpdateChat(chatModel); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MarkRead(chat.owner, chat.id, 6); err != nil {
+		t.Fatal(err)
+	}
+	events := broadcaster.take()
+	if len(events) != 1 || events[0].method != "user" || events[0].id != chat.owner {
+		t.Fatalf("events = %+v, want one event for the reader", events)
+	}
+}
@@ -1,61 +1,68 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"gomessage/internal/models"
 )
 
+// abortUnauthorized прерывает запрос с ошибкой авторизации и кодом для клиента
+func abortUnauthorized(c *gin.Context, code, message string) {
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error": message,
+		"code":  code,
+	})
+	c.Abort()
+}
+
 // Auth middleware для проверки JWT токена
-func Auth() gin.HandlerFunc {
+func Auth(tokens *TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header required",
-			})
-			c.Abort()
+			abortUnauthorized(c, "token_missing", "Authorization header required")
 			return
 		}
 
 		// Проверяем формат "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format",
-			})
-			c.Abort()
+		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+			abortUnauthorized(c, "token_malformed", "Invalid authorization header format")
+			return
+		}
+
+		claims, err := tokens.Parse(parts[1])
+		switch {
+		case errors.Is(err, ErrTokenExpired):
+			abortUnauthorized(c, "token_expired", "Token expired")
+			return
+		case errors.Is(err, ErrTokenSignature):
+			abortUnauthorized(c, "token_invalid", "Invalid token signature")
+			return
+		case errors.Is(err, ErrTokenClaims):
+			abortUnauthorized(c, "token_invalid", "Invalid token claims")
+			return
+		case err != nil:
+			abortUnauthorized(c, "token_malformed", "Malformed token")
 			return
 		}
 
-		token := parts[1]
-		
-		// В реальном приложении здесь должна быть проверка JWT токена
-		// и извлечение userID из токена
-		
-		// Пока что просто проверяем, что токен не пустой
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
-			c.Abort()
+		// Пользователь мог быть удален после выдачи токена
+		user, err := models.GlobalUserStore.GetUserByID(claims.UserID)
+		if err != nil {
+			abortUnauthorized(c, "user_not_found", "User not found")
 			return
 		}
 
-		// TODO: Добавить проверку JWT токена через C библиотеку
-		// cryptoService := crypto.NewCryptoService()
-		// payload, err := cryptoService.VerifyJWT(token, secretKey)
-		// if err != nil {
-		//     c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		//     c.Abort()
-		//     return
-		// }
+		c.Set("userID", user.ID)
+		c.Set("username", user.Username)
+		c.Set("deviceID", claims.DeviceID)
+		c.Set("user", user)
+		c.Set("claims", claims)
 
-		// Добавляем userID в контекст
-		// c.Set("userID", userID)
-		c.Set("userID", uint(1)) // Временно хардкодим для тестирования
-		
 		c.Next()
 	}
 }
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTicketPrefix префикс ключей тикетов в Redis
+const redisTicketPrefix = "gomessage:ticket:"
+
+// redisTicketTimeout таймаут запросов к Redis
+const redisTicketTimeout = 5 * time.Second
+
+// RedisTicketStore тикеты в Redis, общие для всех экземпляров сервера:
+// тикет, выданный одним узлом, можно обменять на любом. Тикет
+// сохраняется с TTL и забирается атомарно через GETDEL (Redis 6.2+),
+// поэтому обменять его можно только один раз.
+type RedisTicketStore struct {
+	client *redis.Client
+}
+
+// redisTicket тикет в Redis; UserID в JSON claims не попадает
+type redisTicket struct {
+	Claims Claims `json:"claims"`
+	UserID uint   `json:"user_id"`
+}
+
+// NewRedisTicketStore подключается к Redis и проверяет соединение
+func NewRedisTicketStore(addr, password string, db int) (*RedisTicketStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTicketTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return &RedisTicketStore{client: client}, nil
+}
+
+// Issue выдает одноразовый тикет с claims проверенного токена
+func (s *RedisTicketStore) Issue(claims Claims) (string, error) {
+	id, err := newTicketID()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(redisTicket{Claims: claims, UserID: claims.UserID})
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTicketTimeout)
+	defer cancel()
+	created, err := s.client.SetNX(ctx, redisTicketPrefix+id, data, TicketTTL).Result()
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return "", errors.New("ticket id collision")
+	}
+	return id, nil
+}
+
+// Redeem обменивает тикет на claims; повторно тикет использовать нельзя
+func (s *RedisTicketStore) Redeem(id string) (*Claims, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTicketTimeout)
+	defer cancel()
+
+	data, err := s.client.GetDel(ctx, redisTicketPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrTicketInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t redisTicket
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, ErrTicketInvalid
+	}
+	if time.Now().Unix() >= t.Claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	claims := t.Claims
+	claims.UserID = t.UserID
+	return &claims, nil
+}
+
+// Close закрывает соединение с Redis
+func (s *RedisTicketStore) Close() error {
+	return s.client.Close()
+}
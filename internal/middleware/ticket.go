@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TicketTTL время, за которое одноразовый тикет нужно обменять на WebSocket соединение
+const TicketTTL = 30 * time.Second
+
+// ErrTicketInvalid тикет не найден, уже использован или истек
+var ErrTicketInvalid = errors.New("invalid ticket")
+
+type ticket struct {
+	claims    Claims
+	expiresAt time.Time
+}
+
+// TicketStore хранит одноразовые тикеты для подключения к WebSocket.
+// Браузер не может передать Authorization заголовок при открытии WebSocket,
+// поэтому он сначала получает тикет через REST, а потом передает его в URL.
+type TicketStore interface {
+	// Issue выдает одноразовый тикет с claims проверенного токена
+	Issue(claims Claims) (string, error)
+	// Redeem обменивает тикет на claims; повторно тикет использовать нельзя
+	Redeem(id string) (*Claims, error)
+}
+
+// newTicketID случайный ID тикета
+func newTicketID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// MemoryTicketStore тикеты в памяти процесса: годится, пока сервер
+// работает в одном экземпляре
+type MemoryTicketStore struct {
+	tickets map[string]ticket
+	mu      sync.Mutex
+}
+
+// NewMemoryTicketStore создает хранилище тикетов в памяти
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{
+		tickets: make(map[string]ticket),
+	}
+}
+
+// Issue выдает одноразовый тикет с claims проверенного токена
+func (s *MemoryTicketStore) Issue(claims Claims) (string, error) {
+	id, err := newTicketID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, k)
+		}
+	}
+
+	s.tickets[id] = ticket{
+		claims:    claims,
+		expiresAt: now.Add(TicketTTL),
+	}
+	return id, nil
+}
+
+// Redeem обменивает тикет на claims; повторно тикет использовать нельзя
+func (s *MemoryTicketStore) Redeem(id string) (*Claims, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, exists := s.tickets[id]
+	if !exists {
+		return nil, ErrTicketInvalid
+	}
+	delete(s.tickets, id)
+
+	if time.Now().After(t.expiresAt) {
+		return nil, ErrTicketInvalid
+	}
+	if time.Now().Unix() >= t.claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	claims := t.claims
+	return &claims, nil
+}
+
+// Глобальное хранилище тикетов для WebSocket. С backplane сервер
+// заменяет его на общее для всех экземпляров (RedisTicketStore).
+var GlobalTicketStore TicketStore = NewMemoryTicketStore()
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gomessage/internal/config"
+)
+
+// testTicketStore проверяет, что тикет обменивается ровно один раз
+func testTicketStore(t *testing.T, store TicketStore) {
+	claims := validClaims()
+	claims.UserID = 1
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+	issue := func(claims Claims) string {
+		t.Helper()
+		id, err := store.Issue(claims)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return id
+	}
+	valid := issue(claims)
+	other := issue(claims)
+	if valid == other {
+		t.Fatal("two tickets have the same ID")
+	}
+
+	tests := []struct {
+		name    string
+		ticket  string
+		wantErr error
+	}{
+		{"valid ticket", valid, nil},
+		{"same ticket again", valid, ErrTicketInvalid},
+		{"unknown ticket", "unknown", ErrTicketInvalid},
+		{"token expired", issue(expired), ErrTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redeemed, err := store.Redeem(tt.ticket)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Redeem = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (redeemed.UserID != claims.UserID || redeemed.DeviceID != claims.DeviceID || redeemed.ID != claims.ID) {
+				t.Fatalf("claims = %+v", redeemed)
+			}
+		})
+	}
+
+	// Из одновременных попыток обмена проходит одна
+	const attempts = 8
+	ticket := issue(claims)
+	var redeemed int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Redeem(ticket)
+			if err != nil && !errors.Is(err, ErrTicketInvalid) {
+				t.Error(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				redeemed++
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Fatalf("ticket redeemed %d times", redeemed)
+	}
+}
+
+func TestMemoryTicketStore(t *testing.T) {
+	testTicketStore(t, NewMemoryTicketStore())
+}
+
+// TestRedisTicketStore работает с Redis из переменных REDIS_HOST,
+// REDIS_PORT, REDIS_PASSWORD, REDIS_DB и запускается, только если задана
+// GOMESSAGE_TEST_REDIS=1
+func TestRedisTicketStore(t *testing.T) {
+	if os.Getenv("GOMESSAGE_TEST_REDIS") == "" {
+		t.Skip("GOMESSAGE_TEST_REDIS не задана")
+	}
+
+	cfg := config.Load().Redis
+	store, err := NewRedisTicketStore(cfg.Host+":"+cfg.Port, cfg.Password, cfg.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	testTicketStore(t, store)
+
+	// Тикет, выданный одним узлом, обменивается на другом
+	second, err := NewRedisTicketStore(cfg.Host+":"+cfg.Port, cfg.Password, cfg.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { second.Close() })
+	claims := validClaims()
+	claims.UserID = 1
+	id, err := store.Issue(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed, err := second.Redeem(id); err != nil || redeemed.UserID != claims.UserID {
+		t.Fatalf("Redeem on another node = %+v, %v", redeemed, err)
+	}
+	if _, err := store.Redeem(id); !errors.Is(err, ErrTicketInvalid) {
+		t.Fatalf("Redeem after another node = %v, want %v", err, ErrTicketInvalid)
+	}
+}
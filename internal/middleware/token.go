@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"gomessage/internal/config"
+	"gomessage/internal/crypto"
+	"gomessage/internal/models"
+)
+
+// defaultKeyID kid ключа HS256 из JWT_SECRET, когда набор ключей не задан
+const defaultKeyID = "default"
+
+// Claims claims access токена (RFC 7519). UserID берется из sub.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+	Username  string   `json:"username"`
+	DeviceID  string   `json:"device_id"`
+	UserID    uint     `json:"-"`
+}
+
+// Audience claim aud: по RFC 7519 это строка или массив строк
+type Audience []string
+
+// MarshalJSON пишет одну аудиторию строкой
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON принимает и строку, и массив строк
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// contains проверяет, что токен выдан для аудитории
+func (a Audience) contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Ошибки разбора токена
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenClaims    = errors.New("invalid token claims")
+	ErrTokenExpired   = errors.New("token expired")
+)
+
+// TokenService выдает и проверяет access токены. Токены подписываются
+// активным ключом набора, а проверяются ключом из заголовка kid.
+type TokenService struct {
+	keys     *crypto.JWTKeySet
+	issuer   string
+	audience string
+	ttl      time.Duration
+	leeway   time.Duration
+}
+
+// NewTokenService создает сервис токенов из конфигурации. Без JWT_KEYS
+// токены подписываются HS256 секретом JWT_SECRET с kid "default"; секрет
+// по умолчанию не принимается.
+func NewTokenService(cfg config.JWTConfig) (*TokenService, error) {
+	var keys *crypto.JWTKeySet
+	var err error
+	switch {
+	case cfg.Keys == "" && cfg.SecretKey == config.DefaultJWTSecret:
+		return nil, errors.New("JWT_SECRET не задан: укажите случайный секрет не короче 32 байт или ключи в JWT_KEYS")
+	case cfg.Keys == "":
+		keys, err = crypto.NewHMACKeySet(defaultKeyID, []byte(cfg.SecretKey))
+	default:
+		keys, err = crypto.ParseJWTKeySet(cfg.Keys, cfg.KeyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Issuer == "" || cfg.Audience == "" || cfg.ExpiresIn <= 0 || cfg.Leeway < 0 {
+		return nil, errors.New("JWT_ISSUER и JWT_AUDIENCE не должны быть пустыми, JWT_EXPIRES_IN - больше нуля")
+	}
+
+	return &TokenService{
+		keys:     keys,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      time.Duration(cfg.ExpiresIn) * time.Hour,
+		leeway:   time.Duration(cfg.Leeway) * time.Second,
+	}, nil
+}
+
+// Keys набор ключей подписи
+func (s *TokenService) Keys() *crypto.JWTKeySet {
+	return s.keys
+}
+
+// Issue подписывает access токен для устройства пользователя
+func (s *TokenService) Issue(user *models.User, deviceID string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return s.keys.Sign(Claims{
+		Issuer:    s.issuer,
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Audience:  Audience{s.audience},
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		ID:        hex.EncodeToString(id),
+		Username:  user.Username,
+		DeviceID:  deviceID,
+	})
+}
+
+// Parse проверяет подпись, издателя, аудиторию и сроки токена и возвращает
+// его claims. Расхождение часов с другими сервисами допускается в пределах
+// leeway.
+func (s *TokenService) Parse(token string) (*Claims, error) {
+	payload, err := s.keys.Verify(token)
+	if err != nil {
+		if errors.Is(err, crypto.ErrJWTMalformed) {
+			return nil, ErrTokenMalformed
+		}
+		// Чужой алгоритм или неизвестный kid считаем такой же подделкой, как и неверную подпись
+		return nil, ErrTokenSignature
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil || userID == 0 || claims.ExpiresAt == 0 || claims.DeviceID == "" {
+		return nil, ErrTokenMalformed
+	}
+	claims.UserID = uint(userID)
+
+	if claims.Issuer != s.issuer || !claims.Audience.contains(s.audience) {
+		return nil, ErrTokenClaims
+	}
+
+	now := time.Now()
+	if now.Add(-s.leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(s.leeway).Unix() < claims.NotBefore {
+		return nil, ErrTokenClaims
+	}
+	if claims.IssuedAt != 0 && now.Add(s.leeway).Unix() < claims.IssuedAt {
+		return nil, ErrTokenClaims
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gomessage/internal/config"
+	"gomessage/internal/models"
+)
+
+const (
+	testSecret      = "0123456789abcdef0123456789abcdef"
+	otherTestSecret = "fedcba9876543210fedcba9876543210"
+)
+
+// testJWTConfig конфигурация токенов для тестов
+func testJWTConfig(secret string) config.JWTConfig {
+	return config.JWTConfig{
+		SecretKey: secret,
+		ExpiresIn: 1,
+		Issuer:    "gomessage",
+		Audience:  "gomessage",
+		Leeway:    5,
+	}
+}
+
+// newTestTokenService создает сервис токенов или останавливает тест
+func newTestTokenService(t *testing.T, cfg config.JWTConfig) *TokenService {
+	t.Helper()
+	tokens, err := NewTokenService(cfg)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	return tokens
+}
+
+// validClaims claims действующего токена пользователя 1
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		Issuer:    "gomessage",
+		Subject:   "1",
+		Audience:  Audience{"gomessage"},
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		ID:        "jti",
+		Username:  "testuser",
+		DeviceID:  "phone",
+	}
+}
+
+// signRaw подписывает HS256 произвольный заголовок и payload
+func signRaw(header, payload string, secret []byte) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unsigned собирает токен без подписи, как для alg "none"
+func unsigned(header, payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + "."
+}
+
+func TestTokenServiceParse(t *testing.T) {
+	tokens := newTestTokenService(t, testJWTConfig(testSecret))
+	otherTokens := newTestTokenService(t, testJWTConfig(otherTestSecret))
+
+	sign := func(mutate func(*Claims)) string {
+		claims := validClaims()
+		mutate(&claims)
+		token, err := tokens.keys.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	issued, err := tokens.Issue(&models.User{ID: 1, Username: "testuser"}, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := otherTokens.Issue(&models.User{ID: 1, Username: "testuser"}, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(validClaims())
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"issued token", issued, nil},
+		{"audience as array", sign(func(c *Claims) { c.Audience = Audience{"other", "gomessage"} }), nil},
+		{"expired within leeway", sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-2 * time.Second).Unix() }), nil},
+		{"expired", sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }), ErrTokenExpired},
+		{"not yet valid", sign(func(c *Claims) { c.NotBefore = time.Now().Add(time.Minute).Unix() }), ErrTokenClaims},
+		{"issued in the future", sign(func(c *Claims) { c.IssuedAt = time.Now().Add(time.Minute).Unix() }), ErrTokenClaims},
+		{"wrong issuer", sign(func(c *Claims) { c.Issuer = "someone-else" }), ErrTokenClaims},
+		{"wrong audience", sign(func(c *Claims) { c.Audience = Audience{"other"} }), ErrTokenClaims},
+		{"wrong secret", foreign, ErrTokenSignature},
+		{"alg none", unsigned(`{"alg":"none","kid":"default"}`, string(payload)), ErrTokenMalformed},
+		{"alg none with signature", signRaw(`{"alg":"none","kid":"default"}`, string(payload), []byte(testSecret)), ErrTokenSignature},
+		{"alg HS512", signRaw(`{"alg":"HS512","kid":"default"}`, string(payload), []byte(testSecret)), ErrTokenSignature},
+		{"unknown kid", signRaw(`{"alg":"HS256","kid":"k2"}`, string(payload), []byte(testSecret)), ErrTokenSignature},
+		{"missing subject", sign(func(c *Claims) { c.Subject = "" }), ErrTokenMalformed},
+		{"non-numeric subject", sign(func(c *Claims) { c.Subject = "alice" }), ErrTokenMalformed},
+		{"missing exp", sign(func(c *Claims) { c.ExpiresAt = 0 }), ErrTokenMalformed},
+		{"missing device", sign(func(c *Claims) { c.DeviceID = "" }), ErrTokenMalformed},
+		{"payload not JSON", signRaw(`{"alg":"HS256","kid":"default"}`, "not json", []byte(testSecret)), ErrTokenMalformed},
+		{"empty", "", ErrTokenMalformed},
+		{"garbage", "not-a-token", ErrTokenMalformed},
+		{"bad base64", "a.b.!!!", ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tokens.Parse(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (claims.UserID != 1 || claims.Username != "testuser" || claims.DeviceID != "phone") {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestTokenServiceRejectsHS256SignedWithPublicKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	publicDER, _ := x509.MarshalPKIXPublicKey(key.Public())
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testJWTConfig(testSecret)
+	cfg.Keys = "ed1:EdDSA:" + path
+	tokens := newTestTokenService(t, cfg)
+
+	issued, err := tokens.Issue(&models.User{ID: 1, Username: "testuser"}, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Parse(issued); err != nil {
+		t.Fatalf("EdDSA token: %v", err)
+	}
+
+	payload, _ := json.Marshal(validClaims())
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	for name, secret := range map[string][]byte{
+		"raw public key": []byte(key.Public().(ed25519.PublicKey)),
+		"public key DER": publicDER,
+		"public key PEM": publicPEM,
+	} {
+		t.Run(name, func(t *testing.T) {
+			token := signRaw(`{"alg":"HS256","typ":"JWT","kid":"ed1"}`, string(payload), secret)
+			if _, err := tokens.Parse(token); !errors.Is(err, ErrTokenSignature) {
+				t.Fatalf("err = %v, want %v", err, ErrTokenSignature)
+			}
+		})
+	}
+}
+
+func TestNewTokenServiceRejectsWeakSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"default secret", config.DefaultJWTSecret},
+		{"empty secret", ""},
+		{"short secret", testSecret[:31]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTokenService(testJWTConfig(tt.secret)); err == nil {
+				t.Fatal("NewTokenService accepted the secret")
+			}
+		})
+	}
+
+	// С набором ключей JWT_SECRET не используется
+	cfg := testJWTConfig(config.DefaultJWTSecret)
+	cfg.Keys = "k1:HS256:" + base64.StdEncoding.EncodeToString([]byte(testSecret))
+	newTestTokenService(t, cfg)
+}
+
+func TestAuthErrorCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := models.GlobalUserStore
+	defer func() { models.GlobalUserStore = previous }()
+	store := models.NewUserStore()
+	user, err := store.CreateUser("testuser", "test@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	models.GlobalUserStore = store
+
+	tokens := newTestTokenService(t, testJWTConfig(testSecret))
+	otherTokens := newTestTokenService(t, testJWTConfig(otherTestSecret))
+
+	valid, _ := tokens.Issue(user, "phone")
+	foreign, _ := otherTokens.Issue(user, "phone")
+	deleted, _ := tokens.Issue(&models.User{ID: user.ID + 100, Username: "ghost"}, "phone")
+	expiredClaims := validClaims()
+	expiredClaims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	expired, _ := tokens.keys.Sign(expiredClaims)
+	wrongAudience := validClaims()
+	wrongAudience.Audience = Audience{"billing"}
+	otherAudience, _ := tokens.keys.Sign(wrongAudience)
+
+	router := gin.New()
+	router.GET("/", Auth(tokens), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetUint("userID"), "device_id": c.GetString("deviceID")})
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		code   string
+	}{
+		{"valid", "Bearer " + valid, http.StatusOK, ""},
+		{"missing header", "", http.StatusUnauthorized, "token_missing"},
+		{"not bearer", "Basic " + valid, http.StatusUnauthorized, "token_malformed"},
+		{"malformed", "Bearer abc", http.StatusUnauthorized, "token_malformed"},
+		{"expired", "Bearer " + expired, http.StatusUnauthorized, "token_expired"},
+		{"wrong secret", "Bearer " + foreign, http.StatusUnauthorized, "token_invalid"},
+		{"wrong audience", "Bearer " + otherAudience, http.StatusUnauthorized, "token_invalid"},
+		{"deleted user", "Bearer " + deleted, http.StatusUnauthorized, "user_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.code != "" && body["code"] != tt.code {
+				t.Fatalf("code = %v, want %s", body["code"], tt.code)
+			}
+			if tt.code == "" && (body["user_id"] != float64(user.ID) || body["device_id"] != "phone") {
+				t.Fatalf("body = %v", body)
+			}
+		})
+	}
+}
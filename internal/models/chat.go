@@ -0,0 +1,228 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Chat представляет чат
+type Chat struct {
+	ID           uint      `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Type         string    `json:"type" db:"type"`
+	CreatorID    uint      `json:"creator_id" db:"creator_id"`
+	ReadReceipts bool      `json:"read_receipts" db:"read_receipts"` // рассылать ли отметки о прочтении
+	E2EE         bool      `json:"e2ee" db:"e2ee"`                   // личный чат со сквозным шифрованием
+	Public       bool      `json:"public" db:"public"`               // вступить может любой пользователь
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ChatMember участник чата
+type ChatMember struct {
+	ChatID      uint       `json:"chat_id" db:"chat_id"`
+	UserID      uint       `json:"user_id" db:"user_id"`
+	Role        string     `json:"role" db:"role"`
+	JoinedAt    time.Time  `json:"joined_at" db:"joined_at"`
+	LastReadSeq uint64     `json:"last_read_seq" db:"last_read_seq"` // последнее прочитанное сообщение
+	LastReadAt  *time.Time `json:"last_read_at,omitempty" db:"last_read_at"`
+}
+
+// ChatType типы чатов
+const (
+	ChatTypePrivate = "private"
+	ChatTypeGroup   = "group"
+	ChatTypeChannel = "channel"
+)
+
+// ChatRole роли участников чата
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
+// ChatStore in-memory хранилище чатов
+type ChatStore struct {
+	chats   map[uint]*Chat
+	members map[uint]map[uint]ChatMember // chatID -> userID -> участник
+	mu      sync.RWMutex
+	nextID  uint
+}
+
+// NewChatStore создает новое хранилище чатов
+func NewChatStore() *ChatStore {
+	return &ChatStore{
+		chats:   make(map[uint]*Chat),
+		members: make(map[uint]map[uint]ChatMember),
+		nextID:  1,
+	}
+}
+
+// CreateChat создает чат вместе с участниками
+func (s *ChatStore) CreateChat(chat *Chat, members []ChatMember) (*Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	created := *chat
+	created.ID = s.nextID
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	s.nextID++
+
+	s.chats[created.ID] = &created
+	s.members[created.ID] = make(map[uint]ChatMember)
+	for _, member := range members {
+		member.ChatID = created.ID
+		if member.JoinedAt.IsZero() {
+			member.JoinedAt = now
+		}
+		s.members[created.ID][member.UserID] = member
+	}
+
+	result := created
+	return &result, nil
+}
+
+// GetChatByID получает чат по ID
+func (s *ChatStore) GetChatByID(id uint) (*Chat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, exists := s.chats[id]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	result := *chat
+	return &result, nil
+}
+
+// GetUserChats возвращает чаты, в которых состоит пользователь
+func (s *ChatStore) GetUserChats(userID uint) ([]*Chat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chats := make([]*Chat, 0)
+	for chatID, members := range s.members {
+		if _, ok := members[userID]; ok {
+			chat := *s.chats[chatID]
+			chats = append(chats, &chat)
+		}
+	}
+
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ID < chats[j].ID })
+	return chats, nil
+}
+
+// AddMember добавляет участника в чат или обновляет его роль
+func (s *ChatStore) AddMember(member ChatMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.chats[member.ChatID]; !exists {
+		return ErrChatNotFound
+	}
+
+	// При смене роли сохраняем исходное время вступления и позицию чтения
+	if existing, ok := s.members[member.ChatID][member.UserID]; ok {
+		member.JoinedAt = existing.JoinedAt
+		member.LastReadSeq = existing.LastReadSeq
+		member.LastReadAt = existing.LastReadAt
+	}
+	if member.JoinedAt.IsZero() {
+		member.JoinedAt = time.Now()
+	}
+	s.members[member.ChatID][member.UserID] = member
+	return nil
+}
+
+// UpdateChat сохраняет название и настройки чата
+func (s *ChatStore) UpdateChat(chat *Chat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.chats[chat.ID]
+	if !exists {
+		return ErrChatNotFound
+	}
+
+	updated := *existing
+	updated.Name = chat.Name
+	updated.ReadReceipts = chat.ReadReceipts
+	updated.Public = chat.Public
+	updated.UpdatedAt = time.Now()
+	s.chats[chat.ID] = &updated
+	return nil
+}
+
+// UpdateReadCursor сдвигает позицию чтения участника вперед до seq
+func (s *ChatStore) UpdateReadCursor(chatID, userID uint, seq uint64, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member, ok := s.members[chatID][userID]
+	if !ok {
+		return false, ErrMemberNotFound
+	}
+	if seq <= member.LastReadSeq {
+		return false, nil
+	}
+
+	member.LastReadSeq = seq
+	member.LastReadAt = &at
+	s.members[chatID][userID] = member
+	return true, nil
+}
+
+// RemoveMember удаляет участника из чата
+func (s *ChatStore) RemoveMember(chatID, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, exists := s.members[chatID]
+	if !exists {
+		return ErrChatNotFound
+	}
+	if _, ok := members[userID]; !ok {
+		return ErrMemberNotFound
+	}
+
+	delete(members, userID)
+	return nil
+}
+
+// GetMember возвращает участника чата
+func (s *ChatStore) GetMember(chatID, userID uint) (*ChatMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	member, ok := s.members[chatID][userID]
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+	return &member, nil
+}
+
+// GetMembers возвращает участников чата
+func (s *ChatStore) GetMembers(chatID uint) ([]ChatMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members, exists := s.members[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	result := make([]ChatMember, 0, len(members))
+	for _, member := range members {
+		result = append(result, member)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
+
+// Глобальное хранилище чатов
+var GlobalChatStore ChatRepository = NewChatStore()
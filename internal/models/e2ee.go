@@ -0,0 +1,240 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceKeys открытые ключи устройства для установки E2EE сессий.
+// Закрытые ключи остаются на устройстве; сервер только раздает открытые.
+type DeviceKeys struct {
+	UserID   uint   `json:"user_id" db:"user_id"`
+	DeviceID string `json:"device_id" db:"device_id"`
+	// IdentityKey долговременный ключ X25519 устройства
+	IdentityKey []byte `json:"identity_key" db:"identity_key"`
+	// SigningKey ключ Ed25519, которым устройство подписывает signed prekey
+	SigningKey            []byte    `json:"signing_key" db:"signing_key"`
+	SignedPreKeyID        uint32    `json:"signed_prekey_id" db:"signed_prekey_id"`
+	SignedPreKey          []byte    `json:"signed_prekey" db:"signed_prekey"`
+	SignedPreKeySignature []byte    `json:"signed_prekey_signature" db:"signed_prekey_signature"`
+	UpdatedAt             time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OneTimePreKey одноразовый ключ X25519: выдается одному собеседнику и
+// удаляется с сервера
+type OneTimePreKey struct {
+	KeyID     uint32 `json:"key_id" db:"key_id"`
+	PublicKey []byte `json:"public_key" db:"public_key"`
+}
+
+// KeyBundle ключи устройства, по которым собеседник устанавливает сессию.
+// OneTimePreKey nil, если одноразовые ключи устройства кончились.
+type KeyBundle struct {
+	DeviceKeys
+	OneTimePreKey *OneTimePreKey `json:"one_time_prekey"`
+}
+
+// Envelope шифротекст сообщения для одного устройства получателя. Сервер
+// не может его расшифровать и хранит как есть.
+type Envelope struct {
+	MessageID      uint   `json:"message_id" db:"message_id"`
+	UserID         uint   `json:"user_id" db:"user_id"`
+	DeviceID       string `json:"device_id" db:"device_id"`
+	SenderDeviceID string `json:"sender_device_id" db:"sender_device_id"`
+	Ciphertext     []byte `json:"ciphertext" db:"ciphertext"`
+}
+
+// KeyUploadRequest запрос на публикацию ключей устройства. Устройство
+// берется из токена доступа.
+type KeyUploadRequest struct {
+	IdentityKey           []byte          `json:"identity_key" binding:"required"`
+	SigningKey            []byte          `json:"signing_key" binding:"required"`
+	SignedPreKeyID        uint32          `json:"signed_prekey_id"`
+	SignedPreKey          []byte          `json:"signed_prekey" binding:"required"`
+	SignedPreKeySignature []byte          `json:"signed_prekey_signature" binding:"required"`
+	OneTimePreKeys        []OneTimePreKey `json:"one_time_prekeys"`
+}
+
+// OneTimePreKeysRequest запрос на пополнение одноразовых ключей устройства
+type OneTimePreKeysRequest struct {
+	OneTimePreKeys []OneTimePreKey `json:"one_time_prekeys" binding:"required"`
+}
+
+// deviceKey устройство пользователя
+type deviceKey struct {
+	userID   uint
+	deviceID string
+}
+
+// KeyStore in-memory хранилище ключей устройств
+type KeyStore struct {
+	devices map[deviceKey]DeviceKeys
+	prekeys map[deviceKey]map[uint32][]byte // устройство -> KeyID -> одноразовый ключ
+	mu      sync.Mutex
+}
+
+// NewKeyStore создает новое хранилище ключей
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		devices: make(map[deviceKey]DeviceKeys),
+		prekeys: make(map[deviceKey]map[uint32][]byte),
+	}
+}
+
+// SaveDeviceKeys сохраняет ключи устройства
+func (s *KeyStore) SaveDeviceKeys(keys DeviceKeys) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deviceKey{keys.UserID, keys.DeviceID}
+	if existing, exists := s.devices[key]; exists && !bytes.Equal(existing.IdentityKey, keys.IdentityKey) {
+		delete(s.prekeys, key)
+	}
+	s.devices[key] = keys
+	return nil
+}
+
+// GetDeviceKeys получает ключи устройства
+func (s *KeyStore) GetDeviceKeys(userID uint, deviceID string) (*DeviceKeys, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, exists := s.devices[deviceKey{userID, deviceID}]
+	if !exists {
+		return nil, ErrDeviceKeysNotFound
+	}
+	return &keys, nil
+}
+
+// GetUserDeviceKeys возвращает ключи всех устройств пользователя
+func (s *KeyStore) GetUserDeviceKeys(userID uint) ([]DeviceKeys, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]DeviceKeys, 0)
+	for key, keys := range s.devices {
+		if key.userID == userID {
+			result = append(result, keys)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DeviceID < result[j].DeviceID })
+	return result, nil
+}
+
+// AddOneTimePreKeys добавляет одноразовые ключи устройства
+func (s *KeyStore) AddOneTimePreKeys(userID uint, deviceID string, keys []OneTimePreKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deviceKey{userID, deviceID}
+	if _, exists := s.devices[key]; !exists {
+		return ErrDeviceKeysNotFound
+	}
+	prekeys, exists := s.prekeys[key]
+	if !exists {
+		prekeys = make(map[uint32][]byte)
+		s.prekeys[key] = prekeys
+	}
+	for _, prekey := range keys {
+		prekeys[prekey.KeyID] = prekey.PublicKey
+	}
+	return nil
+}
+
+// TakeOneTimePreKey выдает и удаляет одноразовый ключ с наименьшим KeyID
+func (s *KeyStore) TakeOneTimePreKey(userID uint, deviceID string) (*OneTimePreKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prekeys := s.prekeys[deviceKey{userID, deviceID}]
+	if len(prekeys) == 0 {
+		return nil, nil
+	}
+
+	first := true
+	var taken OneTimePreKey
+	for keyID, publicKey := range prekeys {
+		if first || keyID < taken.KeyID {
+			taken = OneTimePreKey{KeyID: keyID, PublicKey: publicKey}
+			first = false
+		}
+	}
+	delete(prekeys, taken.KeyID)
+	return &taken, nil
+}
+
+// CountOneTimePreKeys считает оставшиеся одноразовые ключи устройства
+func (s *KeyStore) CountOneTimePreKeys(userID uint, deviceID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.prekeys[deviceKey{userID, deviceID}]), nil
+}
+
+// EnvelopeStore in-memory хранилище E2EE конвертов
+type EnvelopeStore struct {
+	envelopes map[uint][]Envelope // messageID -> конверты
+	mu        sync.RWMutex
+}
+
+// NewEnvelopeStore создает новое хранилище конвертов
+func NewEnvelopeStore() *EnvelopeStore {
+	return &EnvelopeStore{
+		envelopes: make(map[uint][]Envelope),
+	}
+}
+
+// SaveEnvelopes сохраняет конверты
+func (s *EnvelopeStore) SaveEnvelopes(envelopes []Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, envelope := range envelopes {
+		s.envelopes[envelope.MessageID] = append(s.envelopes[envelope.MessageID], envelope)
+	}
+	return nil
+}
+
+// GetUserEnvelopes возвращает конверты сообщений для устройств пользователя
+func (s *EnvelopeStore) GetUserEnvelopes(userID uint, messageIDs []uint) ([]Envelope, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Envelope, 0)
+	for _, messageID := range messageIDs {
+		for _, envelope := range s.envelopes[messageID] {
+			if envelope.UserID == userID {
+				result = append(result, envelope)
+			}
+		}
+	}
+	sortEnvelopes(result)
+	return result, nil
+}
+
+// DeleteMessageEnvelopes удаляет конверты сообщения
+func (s *EnvelopeStore) DeleteMessageEnvelopes(messageID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.envelopes, messageID)
+	return nil
+}
+
+// sortEnvelopes упорядочивает конверты по сообщению и устройству
+func sortEnvelopes(envelopes []Envelope) {
+	sort.Slice(envelopes, func(i, j int) bool {
+		if envelopes[i].MessageID != envelopes[j].MessageID {
+			return envelopes[i].MessageID < envelopes[j].MessageID
+		}
+		return envelopes[i].DeviceID < envelopes[j].DeviceID
+	})
+}
+
+// Глобальные хранилища ключей устройств и E2EE конвертов
+var (
+	GlobalKeyStore      KeyRepository      = NewKeyStore()
+	GlobalEnvelopeStore EnvelopeRepository = NewEnvelopeStore()
+)
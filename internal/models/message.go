@@ -1,28 +1,44 @@
 package models
 
 import (
+	"sort"
+	"sync"
 	"time"
 )
 
 // Message представляет сообщение в чате
 type Message struct {
-	ID        uint      `json:"id" db:"id"`
-	Content   string    `json:"content" db:"content"`
-	Type      string    `json:"type" db:"type"`
-	SenderID  uint      `json:"sender_id" db:"sender_id"`
-	ChatID    uint      `json:"chat_id" db:"chat_id"`
-	ReplyToID *uint     `json:"reply_to_id,omitempty" db:"reply_to_id"`
-	IsEdited  bool      `json:"is_edited" db:"is_edited"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID          uint       `json:"id" db:"id"`
+	Content     string     `json:"content" db:"content"`
+	Type        string     `json:"type" db:"type"`
+	SenderID    uint       `json:"sender_id" db:"sender_id"`
+	ChatID      uint       `json:"chat_id" db:"chat_id"`
+	Seq         uint64     `json:"seq" db:"seq"` // порядковый номер в чате, без пропусков
+	ReplyToID   *uint      `json:"reply_to_id,omitempty" db:"reply_to_id"`
+	// ClientMsgID ID, сгенерированный клиентом для повторных отправок без дублей
+	ClientMsgID string     `json:"client_msg_id,omitempty" db:"client_msg_id"`
+	IsEdited    bool       `json:"is_edited" db:"is_edited"`
+	IsDeleted   bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy   *uint      `json:"deleted_by,omitempty" db:"deleted_by"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	// Unreadable текст не удалось расшифровать, Content пустой; не хранится
+	Unreadable bool `json:"-" db:"-"`
 }
 
 // MessageRequest запрос на отправку сообщения
 type MessageRequest struct {
-	Content   string `json:"content" binding:"required,max=2000"`
-	Type      string `json:"type" binding:"required"`
-	ChatID    uint   `json:"chat_id" binding:"required"`
-	ReplyToID *uint  `json:"reply_to_id,omitempty"`
+	Content     string `json:"content" binding:"max=2000"` // пустой для type encrypted
+	Type        string `json:"type" binding:"required"`
+	ChatID      uint   `json:"chat_id" binding:"required"`
+	ReplyToID   *uint  `json:"reply_to_id,omitempty"`
+	// ClientMsgID повтор с тем же ID в пределах окна вернет уже созданное сообщение
+	ClientMsgID string `json:"client_msg_id,omitempty" binding:"max=64"`
+	// Envelopes шифротексты для устройств получателей в E2EE чате
+	Envelopes []Envelope `json:"envelopes,omitempty"`
+	// SenderDeviceID устройство отправителя, берется из токена
+	SenderDeviceID string `json:"-"`
 }
 
 // MessageResponse ответ с сообщением
@@ -32,9 +48,17 @@ type MessageResponse struct {
 	Type      string    `json:"type"`
 	Sender    UserResponse `json:"sender"`
 	ChatID    uint      `json:"chat_id"`
+	Seq       uint64    `json:"seq"`
 	ReplyToID *uint     `json:"reply_to_id,omitempty"`
+	ClientMsgID string  `json:"client_msg_id,omitempty"`
+	// Envelopes конверты E2EE сообщения для устройств того, кто его получает
+	Envelopes []Envelope `json:"envelopes,omitempty"`
 	IsEdited  bool      `json:"is_edited"`
+	IsDeleted bool      `json:"is_deleted"`
+	// Unreadable текст хранится, но сервер не смог его расшифровать
+	Unreadable bool     `json:"unreadable,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // MessageType типы сообщений
@@ -44,20 +68,281 @@ const (
 	MessageTypeFile     = "file"
 	MessageTypeVoice    = "voice"
 	MessageTypeLocation = "location"
+	// MessageTypeEncrypted сообщение E2EE чата: текста нет, только конверты
+	MessageTypeEncrypted = "encrypted"
 )
 
-// WebSocketMessage сообщение для WebSocket
-type WebSocketMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+// MarkDeleted превращает сообщение в tombstone: место в истории сохраняется,
+// а текст стирается
+func (m *Message) MarkDeleted(deletedBy uint, at time.Time) {
+	m.Content = ""
+	m.IsDeleted = true
+	m.DeletedAt = &at
+	m.DeletedBy = &deletedBy
+	m.UpdatedAt = at
 }
 
-// WebSocketMessageType типы WebSocket сообщений
-const (
-	WSMessageTypeChat     = "chat"
-	WSMessageTypeStatus   = "status"
-	WSMessageTypeTyping   = "typing"
-	WSMessageTypeRead     = "read"
-	WSMessageTypeJoin     = "join"
-	WSMessageTypeLeave    = "leave"
-)
+// MessageStore in-memory хранилище сообщений
+type MessageStore struct {
+	messages map[uint]*Message
+	byChat   map[uint][]uint // chatID -> ID сообщений в порядке отправки
+	byClient map[clientMsgKey]uint
+	lastSeq  map[uint]uint64 // chatID -> последний номер сообщения
+	mu       sync.RWMutex
+	nextID   uint
+}
+
+// clientMsgKey ключ индекса клиентских ID сообщений
+type clientMsgKey struct {
+	senderID    uint
+	clientMsgID string
+}
+
+// NewMessageStore создает новое хранилище сообщений
+func NewMessageStore() *MessageStore {
+	return &MessageStore{
+		messages: make(map[uint]*Message),
+		byChat:   make(map[uint][]uint),
+		byClient: make(map[clientMsgKey]uint),
+		lastSeq:  make(map[uint]uint64),
+		nextID:   1,
+	}
+}
+
+// CreateMessage сохраняет сообщение
+func (s *MessageStore) CreateMessage(message *Message) (*Message, error) {
+	return s.CreateSealedMessage(message, nil)
+}
+
+// CreateSealedMessage сохраняет сообщение, вызывая seal после присвоения
+// ID и номера в чате
+func (s *MessageStore) CreateSealedMessage(message *Message, seal func(message *Message) error) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message.ClientMsgID != "" {
+		if _, exists := s.byClient[clientMsgKey{message.SenderID, message.ClientMsgID}]; exists {
+			return nil, ErrDuplicateClientMsgID
+		}
+	}
+
+	created := *message
+	created.ID = s.nextID
+	if created.CreatedAt.IsZero() {
+		created.CreatedAt = time.Now()
+	}
+	created.UpdatedAt = created.CreatedAt
+	created.Seq = s.lastSeq[created.ChatID] + 1
+	if seal != nil {
+		if err := seal(&created); err != nil {
+			return nil, err
+		}
+	}
+	s.nextID++
+	s.lastSeq[created.ChatID] = created.Seq
+
+	s.messages[created.ID] = &created
+	s.byChat[created.ChatID] = append(s.byChat[created.ChatID], created.ID)
+	if created.ClientMsgID != "" {
+		s.byClient[clientMsgKey{created.SenderID, created.ClientMsgID}] = created.ID
+	}
+
+	result := created
+	return &result, nil
+}
+
+// GetMessageByID получает сообщение по ID
+func (s *MessageStore) GetMessageByID(id uint) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, exists := s.messages[id]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	result := *message
+	return &result, nil
+}
+
+// GetMessageByClientID получает сообщение отправителя по клиентскому ID
+func (s *MessageStore) GetMessageByClientID(senderID uint, clientMsgID string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, exists := s.byClient[clientMsgKey{senderID, clientMsgID}]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	result := *s.messages[id]
+	return &result, nil
+}
+
+// ReleaseClientMsgID снимает клиентский ID с сообщения, если он все еще за ним
+func (s *MessageStore) ReleaseClientMsgID(senderID uint, clientMsgID string, messageID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := clientMsgKey{senderID, clientMsgID}
+	if id, exists := s.byClient[key]; !exists || id != messageID {
+		return nil
+	}
+	delete(s.byClient, key)
+	s.messages[messageID].ClientMsgID = ""
+	return nil
+}
+
+// GetChatMessages возвращает последние limit сообщений чата
+func (s *MessageStore) GetChatMessages(chatID uint, limit int) ([]*Message, error) {
+	return s.GetChatMessagesBefore(chatID, 0, limit)
+}
+
+// GetChatMessagesBefore возвращает до limit сообщений перед beforeID
+func (s *MessageStore) GetChatMessagesBefore(chatID, beforeID uint, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// ID в byChat растут, поэтому границу ищем бинарным поиском
+	ids := s.byChat[chatID]
+	if beforeID > 0 {
+		ids = ids[:sort.Search(len(ids), func(i int) bool { return ids[i] >= beforeID })]
+	}
+	if limit > 0 && len(ids) > limit {
+		ids = ids[len(ids)-limit:]
+	}
+	return s.copyMessages(ids), nil
+}
+
+// GetChatMessagesAfter возвращает до limit сообщений после afterID
+func (s *MessageStore) GetChatMessagesAfter(chatID, afterID uint, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byChat[chatID]
+	ids = ids[sort.Search(len(ids), func(i int) bool { return ids[i] > afterID }):]
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return s.copyMessages(ids), nil
+}
+
+// GetChatMessagesAfterSeq возвращает до limit сообщений с номером больше afterSeq
+func (s *MessageStore) GetChatMessagesAfterSeq(chatID uint, afterSeq uint64, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byChat[chatID]
+	ids = ids[sort.Search(len(ids), func(i int) bool { return s.messages[ids[i]].Seq > afterSeq }):]
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return s.copyMessages(ids), nil
+}
+
+// CountUnread считает чужие неудаленные сообщения с номером больше afterSeq
+func (s *MessageStore) CountUnread(chatID uint, afterSeq uint64, userID uint) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byChat[chatID]
+	ids = ids[sort.Search(len(ids), func(i int) bool { return s.messages[ids[i]].Seq > afterSeq }):]
+	count := 0
+	for _, id := range ids {
+		message := s.messages[id]
+		if message.SenderID != userID && !message.IsDeleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// copyMessages возвращает копии сообщений по списку ID; вызывается под блокировкой
+func (s *MessageStore) copyMessages(ids []uint) []*Message {
+	messages := make([]*Message, 0, len(ids))
+	for _, id := range ids {
+		message := *s.messages[id]
+		messages = append(messages, &message)
+	}
+	return messages
+}
+
+// UpdateMessage сохраняет изменения сообщения
+func (s *MessageStore) UpdateMessage(message *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.messages[message.ID]
+	if !exists || existing.IsDeleted {
+		return ErrMessageNotFound
+	}
+
+	// Чат, отправитель и время создания не меняются
+	updated := *message
+	updated.ChatID = existing.ChatID
+	updated.SenderID = existing.SenderID
+	updated.CreatedAt = existing.CreatedAt
+	updated.ClientMsgID = existing.ClientMsgID
+	updated.Seq = existing.Seq
+	updated.IsDeleted, updated.DeletedAt, updated.DeletedBy = false, nil, nil
+	s.messages[message.ID] = &updated
+	return nil
+}
+
+// DeleteMessage помечает сообщение удаленным и стирает его текст
+func (s *MessageStore) DeleteMessage(id, deletedBy uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists || message.IsDeleted {
+		return ErrMessageNotFound
+	}
+
+	tombstone := *message
+	tombstone.MarkDeleted(deletedBy, time.Now())
+	s.messages[id] = &tombstone
+	return nil
+}
+
+// ScanContent возвращает непустые тексты сообщений по возрастанию ID
+func (s *MessageStore) ScanContent(afterID uint, limit int) ([]StoredContent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contents := make([]StoredContent, 0)
+	for id, message := range s.messages {
+		if id > afterID && message.Content != "" {
+			contents = append(contents, StoredContent{
+				ID:        id,
+				ChatID:    message.ChatID,
+				MessageID: id,
+				Content:   message.Content,
+			})
+		}
+	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].ID < contents[j].ID })
+	if limit > 0 && len(contents) > limit {
+		contents = contents[:limit]
+	}
+	return contents, nil
+}
+
+// ReplaceContent заменяет текст сообщения, если он не изменился
+func (s *MessageStore) ReplaceContent(id uint, old, new string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists || message.Content != old {
+		return false, nil
+	}
+
+	updated := *message
+	updated.Content = new
+	s.messages[id] = &updated
+	return true, nil
+}
+
+// Глобальное хранилище сообщений
+var GlobalMessageStore MessageRepository = NewMessageStore()
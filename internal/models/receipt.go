@@ -0,0 +1,73 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageDelivery отметка о доставке сообщения получателю: хотя бы одно
+// его устройство получило сообщение и подтвердило это. Хранится первая
+// доставка, подтверждения с остальных устройств ее не меняют.
+type MessageDelivery struct {
+	MessageID   uint      `json:"message_id" db:"message_id"`
+	UserID      uint      `json:"user_id" db:"user_id"`
+	DeliveredAt time.Time `json:"delivered_at" db:"delivered_at"`
+}
+
+// ReceiptStatus состояние сообщения у получателя
+const (
+	ReceiptStatusSent      = "sent"
+	ReceiptStatusDelivered = "delivered"
+	ReceiptStatusRead      = "read"
+)
+
+// DeliveryStore in-memory хранилище отметок о доставке
+type DeliveryStore struct {
+	deliveries map[uint]map[uint]time.Time // messageID -> userID -> время доставки
+	mu         sync.RWMutex
+}
+
+// NewDeliveryStore создает новое хранилище отметок о доставке
+func NewDeliveryStore() *DeliveryStore {
+	return &DeliveryStore{
+		deliveries: make(map[uint]map[uint]time.Time),
+	}
+}
+
+// MarkDelivered сохраняет доставку, если ее еще не было
+func (s *DeliveryStore) MarkDelivered(messageID, userID uint, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, exists := s.deliveries[messageID]
+	if !exists {
+		users = make(map[uint]time.Time)
+		s.deliveries[messageID] = users
+	}
+	if _, delivered := users[userID]; delivered {
+		return false, nil
+	}
+	users[userID] = at
+	return true, nil
+}
+
+// GetMessageDeliveries возвращает доставки сообщения по возрастанию ID получателя
+func (s *DeliveryStore) GetMessageDeliveries(messageID uint) ([]MessageDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]MessageDelivery, 0, len(s.deliveries[messageID]))
+	for userID, deliveredAt := range s.deliveries[messageID] {
+		result = append(result, MessageDelivery{
+			MessageID:   messageID,
+			UserID:      userID,
+			DeliveredAt: deliveredAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
+
+// Глобальное хранилище отметок о доставке
+var GlobalDeliveryStore DeliveryRepository = NewDeliveryStore()
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RefreshToken долгоживущий opaque токен для получения новой пары токенов.
+// Сам токен не хранится, только его SHA-256 хеш.
+type RefreshToken struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     uint       `json:"user_id" db:"user_id"`
+	DeviceID   string     `json:"device_id" db:"device_id"`
+	FamilyID   string     `json:"family_id" db:"family_id"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ReplacedBy string     `json:"-" db:"replaced_by"`
+}
+
+// DefaultDeviceID устройство для клиентов, которые не передают device_id
+const DefaultDeviceID = "default"
+
+// Ошибки работы с refresh токенами
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+	ErrRefreshTokenExists   = errors.New("refresh token already exists")
+)
+
+// RefreshTokenStore in-memory хранилище refresh токенов
+type RefreshTokenStore struct {
+	tokens map[string]*RefreshToken // token hash -> RefreshToken
+	mu     sync.RWMutex
+}
+
+// NewRefreshTokenStore создает новое хранилище refresh токенов
+func NewRefreshTokenStore() *RefreshTokenStore {
+	return &RefreshTokenStore{
+		tokens: make(map[string]*RefreshToken),
+	}
+}
+
+// Create сохраняет новый refresh токен. Предыдущая сессия того же
+// устройства пользователя отзывается, чтобы на устройство была одна сессия.
+func (s *RefreshTokenStore) Create(token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[token.TokenHash]; exists {
+		return ErrRefreshTokenExists
+	}
+
+	now := time.Now()
+	for hash, t := range s.tokens {
+		// Истекшие токены уже не нужны даже для обнаружения повторного использования
+		if now.After(t.ExpiresAt) {
+			delete(s.tokens, hash)
+			continue
+		}
+		if t.UserID == token.UserID && t.DeviceID == token.DeviceID && t.FamilyID != token.FamilyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+
+	s.tokens[token.TokenHash] = token
+	return nil
+}
+
+// Rotate заменяет refresh токен с хешем oldHash на next в той же семье.
+// Повторное использование уже замененного токена отзывает всю семью.
+func (s *RefreshTokenStore) Rotate(oldHash string, next *RefreshToken) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.tokens[oldHash]
+	if !exists {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	now := time.Now()
+	if old.RevokedAt != nil {
+		// Токен уже использовался: скорее всего, он украден
+		s.revokeFamilyLocked(old.FamilyID, now)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if now.After(old.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	old.RevokedAt = &now
+	old.ReplacedBy = next.ID
+
+	next.UserID = old.UserID
+	next.DeviceID = old.DeviceID
+	next.FamilyID = old.FamilyID
+	s.tokens[next.TokenHash] = next
+
+	return old, nil
+}
+
+// RevokeFamily отзывает все токены семьи (сессию устройства) пользователя
+func (s *RefreshTokenStore) RevokeFamily(userID uint, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for _, t := range s.tokens {
+		if t.FamilyID == familyID && t.UserID == userID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrRefreshTokenNotFound
+	}
+
+	s.revokeFamilyLocked(familyID, time.Now())
+	return nil
+}
+
+// revokeFamilyLocked отзывает токены семьи, вызывается под блокировкой
+func (s *RefreshTokenStore) revokeFamilyLocked(familyID string, now time.Time) {
+	for _, t := range s.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+}
+
+// GetActiveByUser возвращает действующие токены пользователя (по одному на сессию)
+func (s *RefreshTokenStore) GetActiveByUser(userID uint) ([]*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	tokens := make([]*RefreshToken, 0)
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.RevokedAt == nil && now.Before(t.ExpiresAt) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// RefreshRequest запрос на обновление токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Глобальное хранилище refresh токенов
+var GlobalRefreshTokenStore RefreshTokenRepository = NewRefreshTokenStore()
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Ошибки хранилищ, общие для всех реализаций
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUsernameTaken      = errors.New("username already exists")
+	ErrEmailTaken         = errors.New("email already exists")
+	ErrChatNotFound       = errors.New("chat not found")
+	ErrMemberNotFound     = errors.New("chat member not found")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrDeviceKeysNotFound = errors.New("device keys not found")
+	// ErrDuplicateClientMsgID у отправителя уже есть сообщение с этим клиентским ID
+	ErrDuplicateClientMsgID = errors.New("duplicate client message id")
+)
+
+// UserRepository хранилище пользователей
+type UserRepository interface {
+	CreateUser(username, email, password, salt string) (*User, error)
+	GetUserByID(id uint) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	IsUsernameTaken(username string) bool
+	IsEmailTaken(email string) bool
+	GetAllUsers() []*User
+	GetUsersCount() int
+	UpdateUser(id uint, updates map[string]interface{}) (*User, error)
+	// UpdateLastSeen запоминает время, когда пользователь был в сети последний раз
+	UpdateLastSeen(id uint, at time.Time) error
+	// UpdatePassword заменяет хеш пароля; salt пуст для хешей в формате
+	// PHC, где соль хранится в самом хеше
+	UpdatePassword(id uint, password, salt string) error
+}
+
+// ChatRepository хранилище чатов и участников
+type ChatRepository interface {
+	// CreateChat сохраняет чат вместе с участниками и заполняет его ID
+	CreateChat(chat *Chat, members []ChatMember) (*Chat, error)
+	GetChatByID(id uint) (*Chat, error)
+	GetUserChats(userID uint) ([]*Chat, error)
+	// UpdateChat сохраняет название и настройки чата (Name, ReadReceipts, Public)
+	UpdateChat(chat *Chat) error
+	AddMember(member ChatMember) error
+	RemoveMember(chatID, userID uint) error
+	GetMember(chatID, userID uint) (*ChatMember, error)
+	GetMembers(chatID uint) ([]ChatMember, error)
+	// UpdateReadCursor сдвигает позицию чтения участника до seq; позиция
+	// только растет, false означает, что она уже была не меньше seq
+	UpdateReadCursor(chatID, userID uint, seq uint64, at time.Time) (bool, error)
+}
+
+// MessageRepository хранилище сообщений
+type MessageRepository interface {
+	// CreateMessage сохраняет сообщение, заполняет его ID и присваивает
+	// следующий номер в чате (Seq). Клиентский ID уникален для отправителя:
+	// если он занят, сообщение не создается и возвращается
+	// ErrDuplicateClientMsgID.
+	CreateMessage(message *Message) (*Message, error)
+	GetMessageByID(id uint) (*Message, error)
+	// GetMessageByClientID возвращает сообщение отправителя с этим
+	// клиентским ID или ErrMessageNotFound
+	GetMessageByClientID(senderID uint, clientMsgID string) (*Message, error)
+	// ReleaseClientMsgID снимает клиентский ID с сообщения messageID, чтобы
+	// отправитель мог использовать его снова. Если ID уже принадлежит
+	// другому сообщению, ничего не меняется.
+	ReleaseClientMsgID(senderID uint, clientMsgID string, messageID uint) error
+	// GetChatMessages возвращает последние limit сообщений чата в порядке отправки
+	GetChatMessages(chatID uint, limit int) ([]*Message, error)
+	// GetChatMessagesBefore возвращает до limit сообщений с ID меньше beforeID,
+	// ближайших к нему, в порядке отправки. beforeID == 0 означает конец чата.
+	GetChatMessagesBefore(chatID, beforeID uint, limit int) ([]*Message, error)
+	// GetChatMessagesAfter возвращает до limit сообщений с ID больше afterID
+	// в порядке отправки
+	GetChatMessagesAfter(chatID, afterID uint, limit int) ([]*Message, error)
+	// GetChatMessagesAfterSeq возвращает до limit сообщений с номером в чате
+	// больше afterSeq в порядке отправки
+	GetChatMessagesAfterSeq(chatID uint, afterSeq uint64, limit int) ([]*Message, error)
+	// CountUnread считает неудаленные сообщения чата с номером больше
+	// afterSeq, отправленные не userID
+	CountUnread(chatID uint, afterSeq uint64, userID uint) (int, error)
+	UpdateMessage(message *Message) error
+	// DeleteMessage помечает сообщение удаленным (tombstone остается в истории);
+	// для уже удаленного сообщения возвращает ErrMessageNotFound
+	DeleteMessage(id, deletedBy uint) error
+}
+
+// RevisionRepository хранилище ревизий сообщений для аудита
+type RevisionRepository interface {
+	// CreateRevision сохраняет ревизию и заполняет ее ID
+	CreateRevision(revision *MessageRevision) (*MessageRevision, error)
+	// GetMessageRevisions возвращает ревизии сообщения от старых к новым
+	GetMessageRevisions(messageID uint) ([]MessageRevision, error)
+	// DeleteRevisionsBefore удаляет ревизии, созданные раньше cutoff,
+	// и возвращает их количество
+	DeleteRevisionsBefore(cutoff time.Time) (int, error)
+}
+
+// DeliveryRepository хранилище отметок о доставке сообщений получателям
+type DeliveryRepository interface {
+	// MarkDelivered сохраняет первую доставку сообщения пользователю;
+	// false означает, что доставка уже была отмечена
+	MarkDelivered(messageID, userID uint, at time.Time) (bool, error)
+	// GetMessageDeliveries возвращает доставки сообщения по возрастанию ID получателя
+	GetMessageDeliveries(messageID uint) ([]MessageDelivery, error)
+}
+
+// KeyRepository хранилище открытых ключей устройств для E2EE
+type KeyRepository interface {
+	// SaveDeviceKeys сохраняет ключи устройства; если сменился identity
+	// ключ, одноразовые ключи устройства удаляются
+	SaveDeviceKeys(keys DeviceKeys) error
+	// GetDeviceKeys возвращает ключи устройства или ErrDeviceKeysNotFound
+	GetDeviceKeys(userID uint, deviceID string) (*DeviceKeys, error)
+	// GetUserDeviceKeys возвращает ключи всех устройств пользователя по возрастанию DeviceID
+	GetUserDeviceKeys(userID uint) ([]DeviceKeys, error)
+	// AddOneTimePreKeys добавляет одноразовые ключи устройства; ключ с
+	// занятым KeyID заменяется
+	AddOneTimePreKeys(userID uint, deviceID string, keys []OneTimePreKey) error
+	// TakeOneTimePreKey выдает и удаляет одноразовый ключ устройства с
+	// наименьшим KeyID; nil, если ключи кончились
+	TakeOneTimePreKey(userID uint, deviceID string) (*OneTimePreKey, error)
+	// CountOneTimePreKeys считает оставшиеся одноразовые ключи устройства
+	CountOneTimePreKeys(userID uint, deviceID string) (int, error)
+}
+
+// EnvelopeRepository хранилище E2EE конвертов: шифротекстов сообщения для
+// каждого устройства получателей
+type EnvelopeRepository interface {
+	SaveEnvelopes(envelopes []Envelope) error
+	// GetUserEnvelopes возвращает конверты сообщений messageIDs для всех
+	// устройств пользователя по возрастанию ID сообщения и DeviceID
+	GetUserEnvelopes(userID uint, messageIDs []uint) ([]Envelope, error)
+	// DeleteMessageEnvelopes удаляет конверты сообщения
+	DeleteMessageEnvelopes(messageID uint) error
+}
+
+// RefreshTokenRepository хранилище refresh токенов. Токены одной сессии
+// устройства образуют семью с общим FamilyID.
+type RefreshTokenRepository interface {
+	// Create сохраняет новый токен и отзывает другие сессии того же
+	// устройства пользователя; истекшие токены при этом можно удалить
+	Create(token *RefreshToken) error
+	// Rotate отзывает токен с хешем oldHash и сохраняет next в его семье.
+	// Повторное использование отозванного токена отзывает всю семью и
+	// возвращает ErrRefreshTokenReused.
+	Rotate(oldHash string, next *RefreshToken) (*RefreshToken, error)
+	// RevokeFamily отзывает сессию пользователя или возвращает ErrRefreshTokenNotFound
+	RevokeFamily(userID uint, familyID string) error
+	// GetActiveByUser возвращает действующие токены пользователя
+	GetActiveByUser(userID uint) ([]*RefreshToken, error)
+}
+
+// StoredContent текст сообщения или ревизии в том виде, в каком он лежит
+// в хранилище; при включенном шифровании - шифротекст
+type StoredContent struct {
+	ID        uint // ID сообщения или ревизии
+	ChatID    uint
+	MessageID uint
+	Content   string
+}
+
+// ContentStore доступ к хранимому тексту в обход шифрования, нужен для
+// перешифрования новым ключом. Его реализуют хранилища сообщений и ревизий.
+type ContentStore interface {
+	// ScanContent возвращает до limit непустых текстов с ID больше afterID
+	// по возрастанию ID
+	ScanContent(afterID uint, limit int) ([]StoredContent, error)
+	// ReplaceContent заменяет текст, только если он все еще равен old;
+	// false означает, что текст успел измениться
+	ReplaceContent(id uint, old, new string) (bool, error)
+}
+
+// MessageSealer создает сообщения, текст которых зависит от их ID
+type MessageSealer interface {
+	// CreateSealedMessage сохраняет сообщение как CreateMessage, но перед
+	// записью вызывает seal с уже присвоенными ID и Seq в той же транзакции
+	CreateSealedMessage(message *Message, seal func(message *Message) error) (*Message, error)
+}
+
+// ContentRotator перешифровывает хранимый текст активным ключом
+type ContentRotator interface {
+	// RotateContent перешифровывает до limit текстов с ID больше afterID.
+	// Возвращает ID последнего просмотренного текста, чтобы продолжить с
+	// него, или 0, если просмотрены все.
+	RotateContent(afterID uint, limit int) (next uint, rotated int, err error)
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageRevision прежняя версия текста сообщения. Запись создается при
+// каждом редактировании и удалении, до того как текст будет заменен.
+type MessageRevision struct {
+	ID        uint      `json:"id" db:"id"`
+	MessageID uint      `json:"message_id" db:"message_id"`
+	ChatID    uint      `json:"chat_id" db:"chat_id"`
+	Content   string    `json:"content" db:"content"`
+	Action    string    `json:"action" db:"action"`
+	EditorID  uint      `json:"editor_id" db:"editor_id"`
+	EditedAt  time.Time `json:"edited_at" db:"edited_at"`
+	// Unreadable текст хранится, но сервер не смог его расшифровать
+	Unreadable bool `json:"unreadable,omitempty" db:"-"`
+}
+
+// RevisionAction что произошло с текстом сообщения
+const (
+	RevisionActionEdit   = "edit"
+	RevisionActionDelete = "delete"
+)
+
+// RevisionStore in-memory хранилище ревизий сообщений
+type RevisionStore struct {
+	revisions map[uint][]MessageRevision // messageID -> ревизии по порядку
+	mu        sync.RWMutex
+	nextID    uint
+}
+
+// NewRevisionStore создает новое хранилище ревизий
+func NewRevisionStore() *RevisionStore {
+	return &RevisionStore{
+		revisions: make(map[uint][]MessageRevision),
+		nextID:    1,
+	}
+}
+
+// CreateRevision сохраняет ревизию
+func (s *RevisionStore) CreateRevision(revision *MessageRevision) (*MessageRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := *revision
+	created.ID = s.nextID
+	if created.EditedAt.IsZero() {
+		created.EditedAt = time.Now()
+	}
+	s.nextID++
+
+	s.revisions[created.MessageID] = append(s.revisions[created.MessageID], created)
+	return &created, nil
+}
+
+// GetMessageRevisions возвращает ревизии сообщения от старых к новым
+func (s *RevisionStore) GetMessageRevisions(messageID uint) ([]MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]MessageRevision, len(s.revisions[messageID]))
+	copy(result, s.revisions[messageID])
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// DeleteRevisionsBefore удаляет ревизии старше cutoff
+func (s *RevisionStore) DeleteRevisionsBefore(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for messageID, revisions := range s.revisions {
+		kept := revisions[:0]
+		for _, revision := range revisions {
+			if revision.EditedAt.Before(cutoff) {
+				deleted++
+				continue
+			}
+			kept = append(kept, revision)
+		}
+		if len(kept) == 0 {
+			delete(s.revisions, messageID)
+		} else {
+			s.revisions[messageID] = kept
+		}
+	}
+	return deleted, nil
+}
+
+// ScanContent возвращает непустые тексты ревизий по возрастанию ID
+func (s *RevisionStore) ScanContent(afterID uint, limit int) ([]StoredContent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contents := make([]StoredContent, 0)
+	for _, revisions := range s.revisions {
+		for _, revision := range revisions {
+			if revision.ID > afterID && revision.Content != "" {
+				contents = append(contents, StoredContent{
+					ID:        revision.ID,
+					ChatID:    revision.ChatID,
+					MessageID: revision.MessageID,
+					Content:   revision.Content,
+				})
+			}
+		}
+	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].ID < contents[j].ID })
+	if limit > 0 && len(contents) > limit {
+		contents = contents[:limit]
+	}
+	return contents, nil
+}
+
+// ReplaceContent заменяет текст ревизии, если он не изменился
+func (s *RevisionStore) ReplaceContent(id uint, old, new string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, revisions := range s.revisions {
+		for i := range revisions {
+			if revisions[i].ID == id {
+				if revisions[i].Content != old {
+					return false, nil
+				}
+				revisions[i].Content = new
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Глобальное хранилище ревизий сообщений
+var GlobalRevisionStore RevisionRepository = NewRevisionStore()
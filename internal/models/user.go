@@ -1,7 +1,6 @@
 package models
 
 import (
-	"errors"
 	"sync"
 	"time"
 )
@@ -14,7 +13,8 @@ type User struct {
 	Password  string    `json:"-" db:"password"` // Не отправляем в JSON
 	Salt      string    `json:"-" db:"salt"`
 	Avatar    string    `json:"avatar" db:"avatar"`
-	Status    string    `json:"status" db:"status"`
+	Status    string    `json:"status" db:"status"` // статус, выбранный пользователем
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -41,13 +41,13 @@ func (s *UserStore) CreateUser(username, email, password, salt string) (*User, e
 	
 	// Проверяем уникальность username
 	if _, exists := s.users[username]; exists {
-		return nil, errors.New("username already exists")
+		return nil, ErrUsernameTaken
 	}
 	
 	// Проверяем уникальность email
 	for _, user := range s.users {
 		if user.Email == email {
-			return nil, errors.New("email already exists")
+			return nil, ErrEmailTaken
 		}
 	}
 	
@@ -76,7 +76,7 @@ func (s *UserStore) GetUserByUsername(username string) (*User, error) {
 	
 	user, exists := s.users[username]
 	if !exists {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	
 	return user, nil
@@ -93,7 +93,7 @@ func (s *UserStore) GetUserByEmail(email string) (*User, error) {
 		}
 	}
 	
-	return nil, errors.New("user not found")
+	return nil, ErrUserNotFound
 }
 
 // IsUsernameTaken проверяет, занят ли username
@@ -149,7 +149,7 @@ func (s *UserStore) GetUserByID(id uint) (*User, error) {
 		}
 	}
 	
-	return nil, errors.New("user not found")
+	return nil, ErrUserNotFound
 }
 
 // UpdateUser обновляет данные пользователя
@@ -166,12 +166,29 @@ func (s *UserStore) UpdateUser(id uint, updates map[string]interface{}) (*User,
 	}
 	
 	if user == nil {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
+	}
+	
+	// Проверяем уникальность новых username и email
+	if username, ok := updates["username"].(string); ok && username != user.Username {
+		if _, exists := s.users[username]; exists {
+			return nil, ErrUsernameTaken
+		}
+	}
+	if email, ok := updates["email"].(string); ok && email != user.Email {
+		for _, u := range s.users {
+			if u.Email == email {
+				return nil, ErrEmailTaken
+			}
+		}
 	}
 	
 	// Обновляем поля
-	if username, ok := updates["username"].(string); ok {
+	if username, ok := updates["username"].(string); ok && username != user.Username {
+		// Хранилище индексировано по username, переносим запись
+		delete(s.users, user.Username)
 		user.Username = username
+		s.users[username] = user
 	}
 	if email, ok := updates["email"].(string); ok {
 		user.Email = email
@@ -188,6 +205,36 @@ func (s *UserStore) UpdateUser(id uint, updates map[string]interface{}) (*User,
 	return user, nil
 }
 
+// UpdateLastSeen запоминает, когда пользователь был в сети последний раз
+func (s *UserStore) UpdateLastSeen(id uint, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	for _, user := range s.users {
+		if user.ID == id {
+			user.LastSeenAt = &at
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// UpdatePassword заменяет хеш пароля пользователя
+func (s *UserStore) UpdatePassword(id uint, password, salt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	for _, user := range s.users {
+		if user.ID == id {
+			user.Password = password
+			user.Salt = salt
+			user.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
 // UserRegisterRequest запрос на регистрацию
 type UserRegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=20"`
@@ -199,6 +246,7 @@ type UserRegisterRequest struct {
 type UserLoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	DeviceID string `json:"device_id" binding:"max=64"`
 }
 
 // UserResponse ответ с данными пользователя
@@ -219,5 +267,15 @@ const (
 	UserStatusBusy    = "busy"
 )
 
-// Глобальное хранилище пользователей
-var GlobalUserStore = NewUserStore()
+// IsValidUserStatus проверяет, что статус входит в список известных
+func IsValidUserStatus(status string) bool {
+	switch status {
+	case UserStatusOnline, UserStatusOffline, UserStatusAway, UserStatusBusy:
+		return true
+	}
+	return false
+}
+
+// Глобальное хранилище пользователей. По умолчанию in-memory,
+// при старте сервер может заменить его на постоянное хранилище.
+var GlobalUserStore UserRepository = NewUserStore()
@@ -0,0 +1,397 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// WSProtocolVersion текущая версия протокола WebSocket
+const WSProtocolVersion = 1
+
+// WebSocketMessage конверт WebSocket кадра. ID задает отправитель запроса,
+// ответ сервера на этот запрос несет его в AckID. Payload разбирается
+// в структуру, соответствующую Type.
+type WebSocketMessage struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	AckID   string          `json:"ack_id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WebSocketMessageType типы WebSocket сообщений
+const (
+	WSMessageTypeChat           = "chat"
+	WSMessageTypeStatus         = "status"
+	WSMessageTypeTyping         = "typing"
+	WSMessageTypeRead           = "read"
+	WSMessageTypeReadState      = "read_state"
+	WSMessageTypeDelivered      = "delivered"
+	WSMessageTypeJoin           = "join"
+	WSMessageTypeLeave          = "leave"
+	WSMessageTypeResume         = "resume"
+	WSMessageTypeHistory        = "history"
+	WSMessageTypeMessageEdited  = "message_edited"
+	WSMessageTypeMessageDeleted = "message_deleted"
+	WSMessageTypeError          = "error"
+	WSMessageTypeAck            = "ack"
+)
+
+// Ошибки разбора WebSocket кадров
+var (
+	ErrWSInvalidFrame       = errors.New("invalid frame")
+	ErrWSUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrWSUnknownType        = errors.New("unknown message type")
+	ErrWSInvalidPayload     = errors.New("invalid payload")
+)
+
+// maxWSIDLength максимальная длина ID запроса
+const maxWSIDLength = 64
+
+// WSPayload payload входящего кадра с проверкой полей
+type WSPayload interface {
+	Validate() error
+}
+
+// DecodeWebSocketMessage строго разбирает конверт кадра. Кадры без версии
+// считаются кадрами текущей версии.
+func DecodeWebSocketMessage(data []byte) (*WebSocketMessage, error) {
+	var message WebSocketMessage
+	if err := decodeStrict(data, &message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWSInvalidFrame, err)
+	}
+
+	if message.Version == 0 {
+		message.Version = WSProtocolVersion
+	}
+	if message.Version != WSProtocolVersion {
+		return &message, fmt.Errorf("%w: %d", ErrWSUnsupportedVersion, message.Version)
+	}
+	if message.Type == "" {
+		return &message, fmt.Errorf("%w: type is required", ErrWSInvalidFrame)
+	}
+	if len(message.ID) > maxWSIDLength {
+		return &message, fmt.Errorf("%w: id is longer than %d characters", ErrWSInvalidFrame, maxWSIDLength)
+	}
+	return &message, nil
+}
+
+// DecodePayload строго разбирает payload в dst и проверяет его поля
+func (m *WebSocketMessage) DecodePayload(dst WSPayload) error {
+	if len(m.Payload) == 0 {
+		return fmt.Errorf("%w: payload is required", ErrWSInvalidPayload)
+	}
+	if err := decodeStrict(m.Payload, dst); err != nil {
+		return fmt.Errorf("%w: %v", ErrWSInvalidPayload, err)
+	}
+	if err := dst.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrWSInvalidPayload, err)
+	}
+	return nil
+}
+
+// EncodeWebSocketMessage собирает кадр текущей версии; ackID связывает
+// ответ с запросом клиента и может быть пустым
+func EncodeWebSocketMessage(messageType, ackID string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(WebSocketMessage{
+		Version: WSProtocolVersion,
+		Type:    messageType,
+		AckID:   ackID,
+		Payload: data,
+	})
+}
+
+// decodeStrict разбирает JSON, запрещая неизвестные поля и лишние данные
+func decodeStrict(data []byte, dst interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return err
+	}
+	// More не замечает лишние ] и }, поэтому после значения ждем конец данных
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// Входящие payload
+
+// WSJoinPayload запрос истории чата; Before, After, AfterSeq и Around -
+// курсоры страницы. AfterSeq - номер последнего полученного сообщения
+// чата, с ним сервер присылает только пропущенные сообщения.
+type WSJoinPayload struct {
+	ChatID   uint   `json:"chat_id"`
+	Before   uint   `json:"before,omitempty"`
+	After    uint   `json:"after,omitempty"`
+	AfterSeq uint64 `json:"after_seq,omitempty"`
+	Around   uint   `json:"around,omitempty"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSJoinPayload) Validate() error {
+	if p.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	cursors := 0
+	for _, set := range []bool{p.Before > 0, p.After > 0, p.AfterSeq > 0, p.Around > 0} {
+		if set {
+			cursors++
+		}
+	}
+	if cursors > 1 {
+		return errors.New("only one of before, after, after_seq and around can be set")
+	}
+	return nil
+}
+
+// maxResumeChats сколько чатов можно докачать одним запросом "resume"
+const maxResumeChats = 100
+
+// WSResumeChat позиция клиента в чате: номер последнего полученного сообщения
+type WSResumeChat struct {
+	ChatID   uint   `json:"chat_id"`
+	AfterSeq uint64 `json:"after_seq"`
+}
+
+// WSResumePayload докачка пропущенных сообщений после переподключения
+type WSResumePayload struct {
+	Chats []WSResumeChat `json:"chats"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSResumePayload) Validate() error {
+	if len(p.Chats) == 0 {
+		return errors.New("chats is required")
+	}
+	if len(p.Chats) > maxResumeChats {
+		return fmt.Errorf("chats has more than %d entries", maxResumeChats)
+	}
+	seen := make(map[uint]bool, len(p.Chats))
+	for _, chat := range p.Chats {
+		if chat.ChatID == 0 {
+			return errors.New("chat_id is required")
+		}
+		if seen[chat.ChatID] {
+			return fmt.Errorf("chat %d is listed twice", chat.ChatID)
+		}
+		seen[chat.ChatID] = true
+	}
+	return nil
+}
+
+// WSLeavePayload отписка от чата
+type WSLeavePayload struct {
+	ChatID uint `json:"chat_id"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSLeavePayload) Validate() error {
+	if p.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	return nil
+}
+
+// WSChatPayload отправка сообщения; Type по умолчанию text
+type WSChatPayload struct {
+	ChatID      uint       `json:"chat_id"`
+	Content     string     `json:"content"`
+	Type        string     `json:"type,omitempty"`
+	ReplyToID   *uint      `json:"reply_to_id,omitempty"`
+	ClientMsgID string     `json:"client_msg_id,omitempty"`
+	Envelopes   []Envelope `json:"envelopes,omitempty"` // для type encrypted вместо content
+}
+
+// Validate проверяет поля запроса
+func (p *WSChatPayload) Validate() error {
+	if p.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	if strings.TrimSpace(p.Content) == "" && p.Type != MessageTypeEncrypted {
+		return errors.New("content is required")
+	}
+	if utf8.RuneCountInString(p.Content) > 2000 {
+		return errors.New("content is longer than 2000 characters")
+	}
+	if len(p.ClientMsgID) > 64 {
+		return errors.New("client_msg_id is longer than 64 characters")
+	}
+	if p.Type == "" {
+		p.Type = MessageTypeText
+	}
+	return nil
+}
+
+// WSTypingPayload индикатор набора текста
+type WSTypingPayload struct {
+	ChatID uint `json:"chat_id"`
+	Typing bool `json:"typing"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSTypingPayload) Validate() error {
+	if p.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	return nil
+}
+
+// WSReadPayload сообщения чата до Seq включительно прочитаны
+type WSReadPayload struct {
+	ChatID uint   `json:"chat_id"`
+	Seq    uint64 `json:"seq"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSReadPayload) Validate() error {
+	if p.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	if p.Seq == 0 {
+		return errors.New("seq is required")
+	}
+	return nil
+}
+
+// maxDeliveredMessages сколько сообщений можно подтвердить одним кадром "delivered"
+const maxDeliveredMessages = 100
+
+// WSDeliveredPayload подтверждение клиента, что сообщения дошли до устройства
+type WSDeliveredPayload struct {
+	MessageIDs []uint `json:"message_ids"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSDeliveredPayload) Validate() error {
+	if len(p.MessageIDs) == 0 {
+		return errors.New("message_ids is required")
+	}
+	if len(p.MessageIDs) > maxDeliveredMessages {
+		return fmt.Errorf("message_ids has more than %d entries", maxDeliveredMessages)
+	}
+	for _, id := range p.MessageIDs {
+		if id == 0 {
+			return errors.New("message_ids must not contain 0")
+		}
+	}
+	return nil
+}
+
+// WSStatusPayload смена статуса пользователя
+type WSStatusPayload struct {
+	Status string `json:"status"`
+}
+
+// Validate проверяет поля запроса
+func (p *WSStatusPayload) Validate() error {
+	if !IsValidUserStatus(p.Status) {
+		return fmt.Errorf("unknown status %q", p.Status)
+	}
+	return nil
+}
+
+// Исходящие payload
+
+// WSChatEvent сообщение чата: новое, из истории или измененное
+type WSChatEvent struct {
+	ID          uint       `json:"id"`
+	Content     string     `json:"content"`
+	Type        string     `json:"type"`
+	SenderID    uint       `json:"sender_id"`
+	Username    string     `json:"username"`
+	ChatID      uint       `json:"chat_id"`
+	Seq         uint64     `json:"seq"`
+	ReplyToID   *uint      `json:"reply_to_id,omitempty"`
+	ClientMsgID string     `json:"client_msg_id,omitempty"`
+	Envelopes   []Envelope `json:"envelopes,omitempty"` // конверты для устройств получателя кадра
+	IsEdited    bool       `json:"is_edited"`
+	IsDeleted   bool       `json:"is_deleted"`
+	Unreadable  bool       `json:"unreadable,omitempty"` // текст не удалось расшифровать
+	IsHistory   bool       `json:"is_history,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// WSAckEvent подтверждение отправки: сообщение сохранено под MessageID.
+// Duplicate означает, что это повтор уже принятого сообщения.
+type WSAckEvent struct {
+	ClientMsgID string    `json:"client_msg_id,omitempty"`
+	MessageID   uint      `json:"message_id"`
+	ChatID      uint      `json:"chat_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Duplicate   bool      `json:"duplicate"`
+}
+
+// WSMessageDeletedEvent сообщение удалено
+type WSMessageDeletedEvent struct {
+	ID        uint      `json:"id"`
+	ChatID    uint      `json:"chat_id"`
+	DeletedBy uint      `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// WSHistoryEvent завершение страницы истории с курсорами для догрузки.
+// LastSeq - номер последнего сообщения страницы (0, если она пуста).
+type WSHistoryEvent struct {
+	ChatID     uint   `json:"chat_id"`
+	Count      int    `json:"count"`
+	LastSeq    uint64 `json:"last_seq"`
+	PrevCursor *uint  `json:"prev_cursor"`
+	NextCursor *uint  `json:"next_cursor"`
+}
+
+// WSReadEvent пользователь дочитал чат до сообщения Seq
+type WSReadEvent struct {
+	ChatID   uint       `json:"chat_id"`
+	UserID   uint       `json:"user_id"`
+	Username string     `json:"username"`
+	Seq      uint64     `json:"seq"`
+	ReadAt   *time.Time `json:"read_at"`
+}
+
+// WSDeliveredEvent сообщение доставлено на устройство получателя UserID;
+// приходит только отправителю
+type WSDeliveredEvent struct {
+	MessageID   uint      `json:"message_id"`
+	ChatID      uint      `json:"chat_id"`
+	Seq         uint64    `json:"seq"`
+	UserID      uint      `json:"user_id"`
+	Username    string    `json:"username"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// WSTypingEvent пользователь набирает текст
+type WSTypingEvent struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	ChatID   uint   `json:"chat_id"`
+	Typing   bool   `json:"typing"`
+}
+
+// WSStatusEvent изменился статус пользователя; LastSeenAt - когда он
+// был в сети последний раз
+type WSStatusEvent struct {
+	UserID     uint       `json:"user_id"`
+	Username   string     `json:"username"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// WSErrorPayload ошибка обработки запроса клиента
+type WSErrorPayload struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RequestType string `json:"request_type,omitempty"`
+	ChatID      uint   `json:"chat_id,omitempty"`
+}
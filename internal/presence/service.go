@@ -0,0 +1,486 @@
+package presence
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// State состояние пользователя на одном узле: есть ли у него соединения
+// и проявлял ли он активность за последние idleTimeout
+type State int
+
+const (
+	StateNone   State = iota // нет соединений
+	StateIdle                // соединения есть, но пользователь неактивен
+	StateActive              // есть активное соединение
+)
+
+// sweepInterval как часто соединения проверяются на неактивность, а
+// состояния этого узла заново рассылаются остальным
+const sweepInterval = 30 * time.Second
+
+// remoteStateTTL сколько хранится состояние другого узла без повторной
+// рассылки: узел, который упал или потерял связь с backplane, перестает
+// их присылать, и его пользователи уходят из сети
+const remoteStateTTL = 3 * sweepInterval
+
+// Notifier доставляет события присутствия (WebSocket Hub)
+type Notifier interface {
+	// SendToUsers доставляет кадр всем соединениям пользователей на всех узлах
+	SendToUsers(userIDs []uint, message []byte)
+	// SendToLocalUsers доставляет кадр соединениям пользователей на этом узле
+	SendToLocalUsers(userIDs []uint, message []byte)
+	// PublishPresence сообщает остальным узлам состояние пользователя на этом узле
+	PublishPresence(userID uint, state State)
+}
+
+// Service отслеживает соединения пользователей со всех устройств и
+// выводит из них статус: offline, когда закрыто последнее соединение,
+// away, когда все соединения неактивны дольше idleTimeout, иначе online.
+// Статус, выбранный пользователем (busy, away или offline - невидимка),
+// действует, пока он в сети. Об изменениях узнают только пользователи,
+// с которыми у него есть общий чат.
+//
+// Каждый узел считает свои соединения сам и обменивается итоговым
+// состоянием с остальными через Notifier; разосланное событие отправляет
+// только узел, на котором произошло изменение. Состояния повторяются
+// каждые sweepInterval, чтобы их узнали новые узлы, а состояние узла,
+// молчащего дольше remoteStateTTL, забывается; об этом каждый узел
+// сообщает только своим клиентам.
+type Service struct {
+	notifier    Notifier
+	idleTimeout time.Duration
+	users       map[uint]*userPresence
+	updateLocks [64]sync.Mutex // по пользователю, чтобы события шли по порядку
+	mu          sync.Mutex
+}
+
+// userPresence соединения и состояние одного пользователя
+type userPresence struct {
+	conns  map[uint]time.Time     // ID соединения -> последняя активность
+	local  State                  // состояние на этом узле
+	remote map[string]remoteState // узел -> состояние на нем
+	status string                 // последний вычисленный статус
+}
+
+// remoteState состояние пользователя на другом узле
+type remoteState struct {
+	state  State
+	seenAt time.Time // когда узел последний раз его прислал
+}
+
+// NewService создает новый сервис присутствия
+func NewService() *Service {
+	return &Service{users: make(map[uint]*userPresence)}
+}
+
+// SetNotifier подключает доставку событий присутствия
+func (s *Service) SetNotifier(notifier Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifier = notifier
+}
+
+// SetIdleTimeout задает, через сколько времени без активности
+// пользователь считается отошедшим; 0 отключает away по неактивности
+func (s *Service) SetIdleTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idleTimeout = timeout
+}
+
+// Connect регистрирует новое соединение пользователя
+func (s *Service) Connect(userID, connID uint) {
+	s.mu.Lock()
+	p := s.get(userID)
+	p.conns[connID] = time.Now()
+	s.mu.Unlock()
+
+	s.update(userID)
+}
+
+// Disconnect снимает соединение пользователя
+func (s *Service) Disconnect(userID, connID uint) {
+	s.mu.Lock()
+	if p, exists := s.users[userID]; exists {
+		delete(p.conns, connID)
+	}
+	s.mu.Unlock()
+
+	s.update(userID)
+}
+
+// Touch отмечает активность в соединении: любой кадр от клиента
+func (s *Service) Touch(userID, connID uint) {
+	s.mu.Lock()
+	p, exists := s.users[userID]
+	if !exists {
+		s.mu.Unlock()
+		return
+	}
+	if _, connected := p.conns[connID]; connected {
+		p.conns[connID] = time.Now()
+	}
+	wasIdle := p.local == StateIdle
+	s.mu.Unlock()
+
+	// Пересчитываем только при возвращении из неактивности
+	if wasIdle {
+		s.update(userID)
+	}
+}
+
+// StatusChanged пересчитывает статус после того, как пользователь сменил
+// выбранный статус
+func (s *Service) StatusChanged(userID uint) {
+	s.update(userID)
+}
+
+// RemoteState принимает состояние пользователя на другом узле. Событие
+// рассылает тот узел, поэтому здесь статус только запоминается.
+func (s *Service) RemoteState(node string, userID uint, state State) {
+	now := time.Now()
+
+	// Повтор известного состояния только продлевает его
+	s.mu.Lock()
+	if p, exists := s.users[userID]; exists && state != StateNone {
+		if known, ok := p.remote[node]; ok && known.state == state {
+			p.remote[node] = remoteState{state: state, seenAt: now}
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	user, err := models.GlobalUserStore.GetUserByID(userID)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.get(userID)
+	if state == StateNone {
+		delete(p.remote, node)
+	} else {
+		p.remote[node] = remoteState{state: state, seenAt: now}
+	}
+	p.status = p.effective(user.Status)
+	s.forget(userID, p)
+}
+
+// Status возвращает текущий статус пользователя и время, когда он был в
+// сети последний раз
+func (s *Service) Status(userID uint) (string, *time.Time, error) {
+	user, err := models.GlobalUserStore.GetUserByID(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, exists := s.users[userID]; exists {
+		return p.effective(user.Status), user.LastSeenAt, nil
+	}
+	return models.UserStatusOffline, user.LastSeenAt, nil
+}
+
+// Run периодически переводит неактивных пользователей в away, повторяет
+// остальным узлам состояния этого узла и забывает устаревшие состояния
+// других узлов, пока не закрыт stop
+func (s *Service) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweep один проход Run
+func (s *Service) sweep(now time.Time) {
+	for _, userID := range s.idleUsers() {
+		s.update(userID)
+	}
+	s.republish()
+	for _, userID := range s.expireRemote(now) {
+		s.remoteExpired(userID)
+	}
+}
+
+// republish повторяет остальным узлам состояния пользователей, подключенных
+// к этому узлу
+func (s *Service) republish() {
+	s.mu.Lock()
+	notifier := s.notifier
+	var userIDs []uint
+	for userID, p := range s.users {
+		if p.local != StateNone {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	s.mu.Unlock()
+
+	if notifier == nil {
+		return
+	}
+	for _, userID := range userIDs {
+		// Под блокировкой update повтор не обгонит более новое состояние
+		lock := &s.updateLocks[userID%uint(len(s.updateLocks))]
+		lock.Lock()
+		s.mu.Lock()
+		var local State
+		if p, exists := s.users[userID]; exists {
+			local = p.local
+		}
+		s.mu.Unlock()
+		if local != StateNone {
+			notifier.PublishPresence(userID, local)
+		}
+		lock.Unlock()
+	}
+}
+
+// expireRemote удаляет состояния, которые другие узлы не повторяли дольше
+// remoteStateTTL, и возвращает пользователей, у которых они были
+func (s *Service) expireRemote(now time.Time) []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userIDs []uint
+	for userID, p := range s.users {
+		expired := false
+		for node, remote := range p.remote {
+			if now.Sub(remote.seenAt) > remoteStateTTL {
+				delete(p.remote, node)
+				expired = true
+			}
+		}
+		if expired {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// remoteExpired пересчитывает статус пользователя после того, как забыто
+// его состояние на другом узле. Тот узел сообщить об этом уже не может,
+// поэтому каждый узел сообщает своим клиентам сам.
+func (s *Service) remoteExpired(userID uint) {
+	lock := &s.updateLocks[userID%uint(len(s.updateLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	user, err := models.GlobalUserStore.GetUserByID(userID)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки пользователя %d для присутствия: %v", userID, err)
+		return
+	}
+
+	s.mu.Lock()
+	p, exists := s.users[userID]
+	if !exists {
+		s.mu.Unlock()
+		return
+	}
+	status := p.effective(user.Status)
+	changed := status != p.status
+	p.status = status
+	s.forget(userID, p)
+	notifier := s.notifier
+	s.mu.Unlock()
+
+	if notifier == nil || !changed {
+		return
+	}
+
+	lastSeenAt := user.LastSeenAt
+	if status == models.UserStatusOffline {
+		now := time.Now()
+		if err := models.GlobalUserStore.UpdateLastSeen(userID, now); err != nil {
+			log.Printf("❌ Ошибка сохранения last_seen_at пользователя %d: %v", userID, err)
+		}
+		lastSeenAt = &now
+	}
+	s.notify(notifier.SendToLocalUsers, user, status, lastSeenAt)
+}
+
+// idleUsers пользователи, у которых истек таймаут неактивности
+func (s *Service) idleUsers() []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var userIDs []uint
+	for userID, p := range s.users {
+		if p.local == StateActive && p.localState(now, s.idleTimeout) == StateIdle {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// update пересчитывает состояние пользователя на этом узле и его статус,
+// сообщает о них остальным узлам и участникам общих чатов
+func (s *Service) update(userID uint) {
+	lock := &s.updateLocks[userID%uint(len(s.updateLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	user, err := models.GlobalUserStore.GetUserByID(userID)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки пользователя %d для присутствия: %v", userID, err)
+		return
+	}
+
+	s.mu.Lock()
+	p := s.get(userID)
+	local := p.localState(time.Now(), s.idleTimeout)
+	localChanged := local != p.local
+	p.local = local
+	status := p.effective(user.Status)
+	changed := status != p.status
+	p.status = status
+	s.forget(userID, p)
+	notifier := s.notifier
+	s.mu.Unlock()
+
+	if notifier == nil {
+		return
+	}
+	if localChanged {
+		notifier.PublishPresence(userID, local)
+	}
+	if !changed {
+		return
+	}
+
+	lastSeenAt := user.LastSeenAt
+	if status == models.UserStatusOffline {
+		now := time.Now()
+		if err := models.GlobalUserStore.UpdateLastSeen(userID, now); err != nil {
+			log.Printf("❌ Ошибка сохранения last_seen_at пользователя %d: %v", userID, err)
+		}
+		lastSeenAt = &now
+	}
+	s.notify(notifier.SendToUsers, user, status, lastSeenAt)
+}
+
+// notify рассылает новый статус через send пользователю и участникам его чатов
+func (s *Service) notify(send func(userIDs []uint, message []byte), user *models.User, status string, lastSeenAt *time.Time) {
+	audience, err := audience(user.ID)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки чатов пользователя %d: %v", user.ID, err)
+		return
+	}
+
+	data, err := models.EncodeWebSocketMessage(models.WSMessageTypeStatus, "", models.WSStatusEvent{
+		UserID:     user.ID,
+		Username:   user.Username,
+		Status:     status,
+		LastSeenAt: lastSeenAt,
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации статуса: %v", err)
+		return
+	}
+	send(audience, data)
+}
+
+// audience сам пользователь и все участники его чатов
+func audience(userID uint) ([]uint, error) {
+	chats, err := models.GlobalChatStore.GetUserChats(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[uint]bool{userID: true}
+	userIDs := []uint{userID}
+	for _, chat := range chats {
+		members, err := models.GlobalChatStore.GetMembers(chat.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if !seen[member.UserID] {
+				seen[member.UserID] = true
+				userIDs = append(userIDs, member.UserID)
+			}
+		}
+	}
+	return userIDs, nil
+}
+
+// get возвращает состояние пользователя, создавая его; вызывается под блокировкой
+func (s *Service) get(userID uint) *userPresence {
+	p, exists := s.users[userID]
+	if !exists {
+		p = &userPresence{
+			conns:  make(map[uint]time.Time),
+			remote: make(map[string]remoteState),
+			status: models.UserStatusOffline,
+		}
+		s.users[userID] = p
+	}
+	return p
+}
+
+// forget удаляет состояние пользователя, который не в сети ни на одном
+// узле; вызывается под блокировкой
+func (s *Service) forget(userID uint, p *userPresence) {
+	if len(p.conns) == 0 && len(p.remote) == 0 {
+		delete(s.users, userID)
+	}
+}
+
+// localState состояние по соединениям этого узла
+func (p *userPresence) localState(now time.Time, idleTimeout time.Duration) State {
+	if len(p.conns) == 0 {
+		return StateNone
+	}
+	if idleTimeout <= 0 {
+		return StateActive
+	}
+	for _, lastActive := range p.conns {
+		if now.Sub(lastActive) < idleTimeout {
+			return StateActive
+		}
+	}
+	return StateIdle
+}
+
+// effective статус с учетом всех узлов и статуса, выбранного пользователем
+func (p *userPresence) effective(preferred string) string {
+	best := p.local
+	for _, remote := range p.remote {
+		if remote.state > best {
+			best = remote.state
+		}
+	}
+
+	switch {
+	case best == StateNone:
+		return models.UserStatusOffline
+	case preferred == models.UserStatusBusy, preferred == models.UserStatusAway, preferred == models.UserStatusOffline:
+		return preferred
+	case best == StateIdle:
+		return models.UserStatusAway
+	default:
+		return models.UserStatusOnline
+	}
+}
+
+// Глобальный сервис присутствия
+var GlobalService = NewService()
@@ -0,0 +1,142 @@
+package presence
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// recordingNotifier запоминает события присутствия
+type recordingNotifier struct {
+	global    [][]byte
+	local     [][]byte
+	published map[uint][]State
+	mu        sync.Mutex
+}
+
+func newRecordingNotifier() *recordingNotifier {
+	return &recordingNotifier{published: make(map[uint][]State)}
+}
+
+func (n *recordingNotifier) SendToUsers(userIDs []uint, message []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.global = append(n.global, message)
+}
+
+func (n *recordingNotifier) SendToLocalUsers(userIDs []uint, message []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.local = append(n.local, message)
+}
+
+func (n *recordingNotifier) PublishPresence(userID uint, state State) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.published[userID] = append(n.published[userID], state)
+}
+
+// useMemoryStores подменяет глобальные хранилища пустыми на время теста
+func useMemoryStores(t *testing.T) {
+	t.Helper()
+	users, chats := models.GlobalUserStore, models.GlobalChatStore
+	t.Cleanup(func() {
+		models.GlobalUserStore, models.GlobalChatStore = users, chats
+	})
+	models.GlobalUserStore = models.NewUserStore()
+	models.GlobalChatStore = models.NewChatStore()
+}
+
+// expectStatus проверяет статус пользователя
+func expectStatus(t *testing.T, s *Service, userID uint, want string) {
+	t.Helper()
+	status, _, err := s.Status(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != want {
+		t.Fatalf("status = %q, want %q", status, want)
+	}
+}
+
+func TestRemoteStateExpires(t *testing.T) {
+	useMemoryStores(t)
+	user, err := models.GlobalUserStore.CreateUser("alice", "alice@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := newRecordingNotifier()
+	s := NewService()
+	s.SetNotifier(notifier)
+
+	start := time.Now()
+	s.RemoteState("node-b", user.ID, StateActive)
+	expectStatus(t, s, user.ID, models.UserStatusOnline)
+
+	// Повтор продлевает состояние узла
+	s.sweep(start.Add(remoteStateTTL / 2))
+	expectStatus(t, s, user.ID, models.UserStatusOnline)
+	s.RemoteState("node-b", user.ID, StateActive)
+	s.sweep(start.Add(remoteStateTTL))
+	expectStatus(t, s, user.ID, models.UserStatusOnline)
+
+	// Узел замолчал: пользователь уходит из сети, и об этом узнают только
+	// клиенты этого узла
+	s.sweep(time.Now().Add(remoteStateTTL + time.Second))
+	expectStatus(t, s, user.ID, models.UserStatusOffline)
+
+	if len(notifier.global) != 0 || len(notifier.local) != 1 {
+		t.Fatalf("got %d global and %d local events, want 0 and 1", len(notifier.global), len(notifier.local))
+	}
+	var event struct {
+		Payload models.WSStatusEvent `json:"payload"`
+	}
+	if err := json.Unmarshal(notifier.local[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Payload.UserID != user.ID || event.Payload.Status != models.UserStatusOffline || event.Payload.LastSeenAt == nil {
+		t.Fatalf("status event = %+v", event.Payload)
+	}
+	if _, exists := s.users[user.ID]; exists {
+		t.Fatal("presence of an offline user is kept")
+	}
+}
+
+func TestSweepRepublishesLocalStates(t *testing.T) {
+	useMemoryStores(t)
+	alice, err := models.GlobalUserStore.CreateUser("alice", "alice@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := models.GlobalUserStore.CreateUser("bob", "bob@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := newRecordingNotifier()
+	s := NewService()
+	s.SetNotifier(notifier)
+
+	s.Connect(alice.ID, 1)
+	s.RemoteState("node-b", bob.ID, StateActive)
+	s.sweep(time.Now())
+
+	// Повторяются только состояния соединений этого узла
+	want := []State{StateActive, StateActive}
+	if got := notifier.published[alice.ID]; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("published for alice = %v, want %v", got, want)
+	}
+	if got := notifier.published[bob.ID]; len(got) != 0 {
+		t.Fatalf("published for bob = %v, want none", got)
+	}
+
+	s.Disconnect(alice.ID, 1)
+	s.sweep(time.Now())
+	if got := notifier.published[alice.ID]; got[len(got)-1] != StateNone || len(got) != 3 {
+		t.Fatalf("published for alice after disconnect = %v", got)
+	}
+}
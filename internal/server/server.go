@@ -10,36 +10,106 @@ import (
 	"github.com/gin-gonic/gin"
 	"gomessage/internal/config"
 	"gomessage/internal/handlers"
+	"gomessage/internal/messaging"
 	"gomessage/internal/middleware"
+	"gomessage/internal/presence"
 	"gomessage/internal/websocket"
 )
 
 // Server представляет HTTP сервер
 type Server struct {
-	config *config.Config
-	router *gin.Engine
-	hub    *websocket.Hub
-	server *http.Server
+	config    *config.Config
+	router    *gin.Engine
+	hub       *websocket.Hub
+	backplane websocket.Backplane
+	tickets   *middleware.RedisTicketStore // nil - тикеты в памяти процесса
+	tokens    *middleware.TokenService
+	server    *http.Server
+	stop      chan struct{}
 }
 
 // New создает новый сервер
-func New(cfg *config.Config) *Server {
+func New(cfg *config.Config) (*Server, error) {
+	// Ключи подписи access токенов
+	tokens, err := middleware.NewTokenService(cfg.JWT)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	log.Printf("🔐 JWT: %s, активный ключ: %s", tokens.Keys().ActiveAlgorithm(), tokens.Keys().ActiveKeyID())
+	
 	gin.SetMode(gin.ReleaseMode)
 	
 	router := gin.New()
 	router.Use(gin.Logger(), gin.Recovery())
 	
 	hub := websocket.NewHub()
+	hub.SetTypingLimits(time.Duration(cfg.Hub.TypingThrottle)*time.Second, time.Duration(cfg.Hub.TypingTimeout)*time.Second)
+	hub.SetQueueLimits(cfg.Hub.ClientQueueSize, time.Duration(cfg.Hub.SlowClientTimeout)*time.Second)
+	
+	// События Hub между экземплярами сервера
+	backplane, err := openBackplane(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if backplane != nil {
+		if err := hub.SetBackplane(backplane); err != nil {
+			backplane.Close()
+			return nil, fmt.Errorf("backplane subscribe: %w", err)
+		}
+		log.Printf("📡 Backplane: %s", cfg.Hub.Backplane)
+	}
+	
+	// С backplane клиент может получить тикет на одном узле, а подключиться
+	// к другому, поэтому тикеты хранятся в Redis
+	var tickets *middleware.RedisTicketStore
+	if cfg.Hub.Backplane == "redis" {
+		addr := fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port)
+		tickets, err = middleware.NewRedisTicketStore(addr, cfg.Redis.Password, cfg.Redis.DB)
+		if err != nil {
+			backplane.Close()
+			return nil, fmt.Errorf("ticket store: %w", err)
+		}
+		middleware.GlobalTicketStore = tickets
+	}
+	
+	// Сообщения из REST API доставляются через тот же Hub
+	messaging.GlobalService.SetBroadcaster(hub)
+	messaging.GlobalService.SetEditWindow(time.Duration(cfg.Messages.EditWindow) * time.Minute)
+	messaging.GlobalService.SetDedupWindow(time.Duration(cfg.Messages.DedupWindow) * time.Minute)
+	messaging.GlobalService.SetReceiptsMaxMembers(cfg.Messages.ReceiptsMaxMembers)
+	
+	// Статусы пользователей рассылаются через тот же Hub
+	presence.GlobalService.SetNotifier(hub)
+	presence.GlobalService.SetIdleTimeout(time.Duration(cfg.Presence.IdleTimeout) * time.Minute)
+	
+	handlers.SetJWTConfig(cfg.JWT, tokens)
 	
 	server := &Server{
 		config: cfg,
-		router: router,
-		hub:    hub,
+		router:    router,
+		hub:       hub,
+		backplane: backplane,
+		tickets:   tickets,
+		tokens:    tokens,
+		stop:      make(chan struct{}),
 	}
 	
 	server.setupRoutes()
 	
-	return server
+	return server, nil
+}
+
+// openBackplane подключает backplane из конфигурации; nil - без backplane
+func openBackplane(cfg *config.Config) (websocket.Backplane, error) {
+	switch cfg.Hub.Backplane {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		addr := fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port)
+		return websocket.NewRedisBackplane(addr, cfg.Redis.Password, cfg.Redis.DB)
+	default:
+		return nil, fmt.Errorf("unknown hub backplane %q", cfg.Hub.Backplane)
+	}
 }
 
 // setupRoutes настраивает маршруты
@@ -58,42 +128,71 @@ func (s *Server) setupRoutes() {
 			auth.POST("/refresh", handlers.RefreshToken)
 		}
 		
+		// Сессии (устройства) и тикеты для WebSocket
+		authorized := api.Group("/auth")
+		authorized.Use(middleware.Auth(s.tokens))
+		{
+			authorized.GET("/sessions", handlers.GetSessions)
+			authorized.DELETE("/sessions/:id", handlers.RevokeSession)
+			authorized.POST("/ws-ticket", handlers.WebSocketTicket)
+		}
+		
 		// Пользователи
 		users := api.Group("/users")
-		users.Use(middleware.Auth())
+		users.Use(middleware.Auth(s.tokens))
 		{
 			users.GET("/profile", handlers.GetProfile)
 			users.PUT("/profile", handlers.UpdateProfile)
 			users.GET("/search", handlers.SearchUsers)
+			users.GET("/:id/presence", handlers.GetUserPresence)
 		}
 		
 		// Сообщения
 		messages := api.Group("/messages")
-		messages.Use(middleware.Auth())
+		messages.Use(middleware.Auth(s.tokens))
 		{
 			messages.POST("/", handlers.SendMessage)
 			messages.GET("/chat/:chatID", handlers.GetChatMessages)
 			messages.PUT("/:id", handlers.EditMessage)
 			messages.DELETE("/:id", handlers.DeleteMessage)
+			messages.GET("/:id/history", handlers.GetMessageHistory)
+			messages.GET("/:id/receipts", handlers.GetMessageReceipts)
 		}
 		
 		// Чаты
 		chats := api.Group("/chats")
-		chats.Use(middleware.Auth())
+		chats.Use(middleware.Auth(s.tokens))
 		{
 			chats.GET("/", handlers.GetUserChats)
 			chats.POST("/", handlers.CreateChat)
 			chats.GET("/:id", handlers.GetChat)
+			chats.PATCH("/:id", handlers.UpdateChat)
+			chats.POST("/:id/read", handlers.MarkChatRead)
 			chats.POST("/:id/join", handlers.JoinChat)
+			chats.POST("/:id/members", handlers.AddChatMember)
+			chats.PATCH("/:id/members/:userID", handlers.UpdateChatMemberRole)
 			chats.DELETE("/:id/leave", handlers.LeaveChat)
 		}
+		
+		// Ключи устройств для E2EE чатов
+		keys := api.Group("/keys")
+		keys.Use(middleware.Auth(s.tokens))
+		{
+			keys.PUT("/", handlers.UploadKeys)
+			keys.POST("/one-time", handlers.AddOneTimePreKeys)
+			keys.GET("/count", handlers.CountOneTimePreKeys)
+			keys.GET("/:userID", handlers.GetKeyBundles)
+		}
 	}
 	
 	// WebSocket endpoint
 	s.router.GET("/ws", func(c *gin.Context) {
-		websocket.ServeWebSocket(s.hub, c.Writer, c.Request)
+		websocket.ServeWebSocket(s.hub, s.tokens, c.Writer, c.Request)
 	})
 	
+	// Открытые ключи для проверки наших токенов другими сервисами
+	s.router.GET("/.well-known/jwks.json", handlers.JWKS)
+	
 	// Статические файлы для frontend
 	s.router.Static("/static", "./static")
 	s.router.StaticFile("/", "./static/index.html")
@@ -113,6 +212,15 @@ func (s *Server) Run() error {
 	// Запускаем WebSocket hub в горутине
 	go s.hub.Run()
 	
+	// Переводим неактивных пользователей в away
+	go presence.GlobalService.Run(s.stop)
+	
+	// Периодически удаляем ревизии сообщений старше срока хранения
+	go s.runRevisionRetention()
+	
+	// Перешифровываем текст сообщений активным ключом
+	go s.runReencryption()
+	
 	// Создаем HTTP сервер
 	s.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", s.config.Server.Host, s.config.Server.Port),
@@ -128,5 +236,71 @@ func (s *Server) Run() error {
 // Shutdown gracefully завершает работу сервера
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("🔄 Завершение работы сервера...")
-	return s.server.Shutdown(ctx)
+	close(s.stop)
+	err := s.server.Shutdown(ctx)
+	if s.backplane != nil {
+		s.backplane.Close()
+	}
+	if s.tickets != nil {
+		s.tickets.Close()
+	}
+	return err
+}
+
+// revisionRetentionInterval как часто запускается очистка ревизий
+const revisionRetentionInterval = time.Hour
+
+// runRevisionRetention удаляет устаревшие ревизии сообщений, пока сервер работает
+func (s *Server) runRevisionRetention() {
+	retention := time.Duration(s.config.Messages.RevisionRetention) * 24 * time.Hour
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(revisionRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := messaging.GlobalService.PurgeRevisions(retention)
+		if err != nil {
+			log.Printf("❌ Ошибка очистки ревизий сообщений: %v", err)
+		} else if deleted > 0 {
+			log.Printf("🧹 Удалено устаревших ревизий сообщений: %d", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// runReencryption перешифровывает текст сообщений активным ключом при
+// старте и затем с интервалом MESSAGE_REENCRYPT_INTERVAL, пока сервер
+// работает. После смены ключа старые шифротексты переходят на новый ключ,
+// и прежний ключ можно убрать из набора.
+func (s *Server) runReencryption() {
+	interval := time.Duration(s.config.Messages.ReencryptInterval) * time.Minute
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rotated, err := messaging.GlobalService.ReencryptContent()
+		if err != nil {
+			log.Printf("❌ Ошибка перешифрования сообщений: %v", err)
+		} else if rotated > 0 {
+			log.Printf("🔒 Перешифровано текстов сообщений: %d", rotated)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
 }
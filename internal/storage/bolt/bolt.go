@@ -0,0 +1,280 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// Бакеты хранилища
+var (
+	bucketMeta           = []byte("meta")
+	bucketUsers          = []byte("users")
+	bucketUsersByName    = []byte("users_by_username")
+	bucketUsersByEmail   = []byte("users_by_email")
+	bucketChats          = []byte("chats")
+	bucketChatMembers    = []byte("chat_members") // chatID|userID -> участник
+	bucketUserChats      = []byte("user_chats")   // userID|chatID -> пусто
+	bucketMessages       = []byte("messages")
+	bucketChatMessages   = []byte("chat_messages") // chatID|messageID -> пусто
+	bucketRevisions      = []byte("message_revisions")
+	bucketMessageRevs    = []byte("message_revision_index") // messageID|revisionID -> пусто
+	bucketClientMessages = []byte("client_messages")        // senderID|clientMsgID -> messageID
+	bucketChatSeqs       = []byte("chat_seqs")              // chatID -> последний номер сообщения
+	bucketChatSeqIndex   = []byte("chat_seq_index")         // chatID|seq -> messageID
+	bucketDeliveries     = []byte("message_deliveries")     // messageID|userID -> доставка
+	bucketDeviceKeys     = []byte("device_keys")            // userID|deviceID -> ключи устройства
+	bucketPreKeys        = []byte("one_time_prekeys")       // userID|len|deviceID|keyID -> одноразовый ключ
+	bucketEnvelopes      = []byte("message_envelopes")      // messageID|userID|deviceID -> конверт
+	bucketRefreshTokens  = []byte("refresh_tokens")         // token hash -> refresh токен
+	keySchemaVersion     = []byte("schema_version")
+)
+
+// migrations изменения схемы по порядку; номер версии - индекс + 1
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: начальная схема
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{
+			bucketUsers, bucketUsersByName, bucketUsersByEmail,
+			bucketChats, bucketChatMembers, bucketUserChats,
+			bucketMessages, bucketChatMessages,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: ревизии сообщений
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketRevisions, bucketMessageRevs} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 3: индекс клиентских ID сообщений
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketClientMessages)
+		return err
+	},
+	// 4: номера сообщений в чатах; существующие нумеруются по порядку отправки
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketChatSeqs, bucketChatSeqIndex} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		cursor := tx.Bucket(bucketChatMessages).Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			message, err := loadMessage(tx, idFromKey(key))
+			if err != nil {
+				return err
+			}
+			if err := assignSeq(tx, message); err != nil {
+				return err
+			}
+			if err := put(tx.Bucket(bucketMessages), itob(uint64(message.ID)), message); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 5: отметки о прочтении включены во всех существующих чатах
+	func(tx *bbolt.Tx) error {
+		chats := tx.Bucket(bucketChats)
+		cursor := chats.Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			var chat models.Chat
+			if err := json.Unmarshal(data, &chat); err != nil {
+				return err
+			}
+			chat.ReadReceipts = true
+			if err := put(chats, key, chat); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 6: отметки о доставке сообщений
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketDeliveries)
+		return err
+	},
+	// 7: ключи устройств и конверты E2EE чатов
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketDeviceKeys, bucketPreKeys, bucketEnvelopes} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 8: refresh токены
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketRefreshTokens)
+		return err
+	},
+}
+
+// Store встроенное хранилище в файле bbolt для одноузловых установок
+type Store struct {
+	db         *bbolt.DB
+	users      *UserRepository
+	chats      *ChatRepository
+	messages   *MessageRepository
+	revisions  *RevisionRepository
+	deliveries *DeliveryRepository
+	keys       *KeyRepository
+	envelopes  *EnvelopeRepository
+	refresh    *RefreshTokenRepository
+}
+
+// Open открывает файл базы и применяет миграции
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("открытие %s: %w", path, err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("миграции bbolt: %w", err)
+	}
+
+	return &Store{
+		db:         db,
+		users:      &UserRepository{db: db},
+		chats:      &ChatRepository{db: db},
+		messages:   &MessageRepository{db: db},
+		revisions:  &RevisionRepository{db: db},
+		deliveries: &DeliveryRepository{db: db},
+		keys:       &KeyRepository{db: db},
+		envelopes:  &EnvelopeRepository{db: db},
+		refresh:    &RefreshTokenRepository{db: db},
+	}, nil
+}
+
+// Users возвращает репозиторий пользователей
+func (s *Store) Users() *UserRepository {
+	return s.users
+}
+
+// Chats возвращает репозиторий чатов
+func (s *Store) Chats() *ChatRepository {
+	return s.chats
+}
+
+// Messages возвращает репозиторий сообщений
+func (s *Store) Messages() *MessageRepository {
+	return s.messages
+}
+
+// Revisions возвращает репозиторий ревизий сообщений
+func (s *Store) Revisions() *RevisionRepository {
+	return s.revisions
+}
+
+// Deliveries возвращает репозиторий отметок о доставке
+func (s *Store) Deliveries() *DeliveryRepository {
+	return s.deliveries
+}
+
+// Keys возвращает репозиторий ключей устройств
+func (s *Store) Keys() *KeyRepository {
+	return s.keys
+}
+
+// Envelopes возвращает репозиторий E2EE конвертов
+func (s *Store) Envelopes() *EnvelopeRepository {
+	return s.envelopes
+}
+
+// RefreshTokens возвращает репозиторий refresh токенов
+func (s *Store) RefreshTokens() *RefreshTokenRepository {
+	return s.refresh
+}
+
+// Close закрывает файл базы
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate применяет еще не примененные миграции, каждую в своей транзакции
+func migrate(db *bbolt.DB) error {
+	for {
+		done := false
+		err := db.Update(func(tx *bbolt.Tx) error {
+			meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+			if err != nil {
+				return err
+			}
+
+			var version uint64
+			if raw := meta.Get(keySchemaVersion); raw != nil {
+				version = binary.BigEndian.Uint64(raw)
+			}
+			if version >= uint64(len(migrations)) {
+				done = true
+				return nil
+			}
+
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("миграция %d: %w", version+1, err)
+			}
+			log.Printf("🗄️ Применена миграция bbolt %d", version+1)
+			return meta.Put(keySchemaVersion, itob(version+1))
+		})
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// itob кодирует ID в ключ; big-endian сохраняет порядок сортировки
+func itob(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// pairKey составной ключ из двух ID
+func pairKey(first, second uint) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(first))
+	binary.BigEndian.PutUint64(key[8:], uint64(second))
+	return key
+}
+
+// idFromKey читает второй ID из составного ключа
+func idFromKey(key []byte) uint {
+	return uint(binary.BigEndian.Uint64(key[8:]))
+}
+
+// put сериализует значение в JSON и сохраняет по ключу
+func put(bucket *bbolt.Bucket, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// get читает значение по ключу; возвращает false, если ключа нет
+func get(bucket *bbolt.Bucket, key []byte, value interface{}) (bool, error) {
+	data := bucket.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
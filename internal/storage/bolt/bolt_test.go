@@ -0,0 +1,24 @@
+package bolt_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gomessage/internal/config"
+	"gomessage/internal/storage"
+	"gomessage/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+		repos, err := storage.Open(config.DatabaseConfig{
+			Driver: storage.DriverBolt,
+			Path:   filepath.Join(t.TempDir(), "gomessage.db"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repos.Close() })
+		return repos
+	})
+}
@@ -0,0 +1,238 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// ChatRepository хранилище чатов и участников в bbolt
+type ChatRepository struct {
+	db *bbolt.DB
+}
+
+// CreateChat создает чат вместе с участниками в одной транзакции
+func (r *ChatRepository) CreateChat(chat *models.Chat, members []models.ChatMember) (*models.Chat, error) {
+	created := *chat
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if !userExists(tx, chat.CreatorID) {
+			return models.ErrUserNotFound
+		}
+
+		chats := tx.Bucket(bucketChats)
+		id, err := chats.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		created.ID = uint(id)
+		created.CreatedAt = now
+		created.UpdatedAt = now
+		if err := put(chats, itob(id), created); err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			member.ChatID = created.ID
+			if err := putMember(tx, member); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetChatByID получает чат по ID
+func (r *ChatRepository) GetChatByID(id uint) (*models.Chat, error) {
+	var chat *models.Chat
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		chat, err = loadChat(tx, id)
+		return err
+	})
+	return chat, err
+}
+
+// GetUserChats возвращает чаты, в которых состоит пользователь
+func (r *ChatRepository) GetUserChats(userID uint) ([]*models.Chat, error) {
+	chats := make([]*models.Chat, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(userID))
+		cursor := tx.Bucket(bucketUserChats).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			chat, err := loadChat(tx, idFromKey(key))
+			if err != nil {
+				return err
+			}
+			chats = append(chats, chat)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+// UpdateChat сохраняет название и настройки чата
+func (r *ChatRepository) UpdateChat(chat *models.Chat) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := loadChat(tx, chat.ID)
+		if err != nil {
+			return err
+		}
+		existing.Name = chat.Name
+		existing.ReadReceipts = chat.ReadReceipts
+		existing.Public = chat.Public
+		existing.UpdatedAt = time.Now()
+		return put(tx.Bucket(bucketChats), itob(uint64(chat.ID)), existing)
+	})
+}
+
+// AddMember добавляет участника в чат или обновляет его роль
+func (r *ChatRepository) AddMember(member models.ChatMember) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := loadChat(tx, member.ChatID); err != nil {
+			return err
+		}
+		return putMember(tx, member)
+	})
+}
+
+// RemoveMember удаляет участника из чата
+func (r *ChatRepository) RemoveMember(chatID, userID uint) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		members := tx.Bucket(bucketChatMembers)
+		key := pairKey(chatID, userID)
+		if members.Get(key) == nil {
+			if _, err := loadChat(tx, chatID); err != nil {
+				return err
+			}
+			return models.ErrMemberNotFound
+		}
+		if err := members.Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketUserChats).Delete(pairKey(userID, chatID))
+	})
+}
+
+// GetMember возвращает участника чата
+func (r *ChatRepository) GetMember(chatID, userID uint) (*models.ChatMember, error) {
+	var member models.ChatMember
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		found, err := get(tx.Bucket(bucketChatMembers), pairKey(chatID, userID), &member)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrMemberNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetMembers возвращает участников чата
+func (r *ChatRepository) GetMembers(chatID uint) ([]models.ChatMember, error) {
+	members := make([]models.ChatMember, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		if _, err := loadChat(tx, chatID); err != nil {
+			return err
+		}
+
+		prefix := itob(uint64(chatID))
+		cursor := tx.Bucket(bucketChatMembers).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			var member models.ChatMember
+			if err := json.Unmarshal(data, &member); err != nil {
+				return err
+			}
+			members = append(members, member)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// UpdateReadCursor сдвигает позицию чтения участника вперед до seq
+func (r *ChatRepository) UpdateReadCursor(chatID, userID uint, seq uint64, at time.Time) (bool, error) {
+	advanced := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		members := tx.Bucket(bucketChatMembers)
+		key := pairKey(chatID, userID)
+
+		var member models.ChatMember
+		found, err := get(members, key, &member)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrMemberNotFound
+		}
+		if seq <= member.LastReadSeq {
+			return nil
+		}
+
+		member.LastReadSeq = seq
+		member.LastReadAt = &at
+		advanced = true
+		return put(members, key, member)
+	})
+	return advanced, err
+}
+
+// putMember сохраняет участника и индекс чатов пользователя
+func putMember(tx *bbolt.Tx, member models.ChatMember) error {
+	if !userExists(tx, member.UserID) {
+		return models.ErrUserNotFound
+	}
+
+	members := tx.Bucket(bucketChatMembers)
+	key := pairKey(member.ChatID, member.UserID)
+
+	// При смене роли сохраняем исходное время вступления и позицию чтения
+	var existing models.ChatMember
+	if found, err := get(members, key, &existing); err != nil {
+		return err
+	} else if found {
+		member.JoinedAt = existing.JoinedAt
+		member.LastReadSeq = existing.LastReadSeq
+		member.LastReadAt = existing.LastReadAt
+	}
+	if member.JoinedAt.IsZero() {
+		member.JoinedAt = time.Now()
+	}
+
+	if err := put(members, key, member); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketUserChats).Put(pairKey(member.UserID, member.ChatID), []byte{})
+}
+
+// loadChat читает чат по ID
+func loadChat(tx *bbolt.Tx, id uint) (*models.Chat, error) {
+	var chat models.Chat
+	found, err := get(tx.Bucket(bucketChats), itob(uint64(id)), &chat)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, models.ErrChatNotFound
+	}
+	return &chat, nil
+}
@@ -0,0 +1,64 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// DeliveryRepository хранилище отметок о доставке в bbolt
+type DeliveryRepository struct {
+	db *bbolt.DB
+}
+
+// MarkDelivered сохраняет доставку, если ее еще не было
+func (r *DeliveryRepository) MarkDelivered(messageID, userID uint, at time.Time) (bool, error) {
+	added := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := loadMessage(tx, messageID); err != nil {
+			return err
+		}
+		if !userExists(tx, userID) {
+			return models.ErrUserNotFound
+		}
+
+		bucket := tx.Bucket(bucketDeliveries)
+		key := pairKey(messageID, userID)
+		if bucket.Get(key) != nil {
+			return nil
+		}
+
+		added = true
+		return put(bucket, key, models.MessageDelivery{
+			MessageID:   messageID,
+			UserID:      userID,
+			DeliveredAt: at,
+		})
+	})
+	return added, err
+}
+
+// GetMessageDeliveries возвращает доставки сообщения по возрастанию ID получателя
+func (r *DeliveryRepository) GetMessageDeliveries(messageID uint) ([]models.MessageDelivery, error) {
+	deliveries := make([]models.MessageDelivery, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(messageID))
+		cursor := tx.Bucket(bucketDeliveries).Cursor()
+
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			var delivery models.MessageDelivery
+			if err := json.Unmarshal(value, &delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
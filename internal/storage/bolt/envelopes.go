@@ -0,0 +1,86 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// EnvelopeRepository хранилище E2EE конвертов в bbolt
+type EnvelopeRepository struct {
+	db *bbolt.DB
+}
+
+// SaveEnvelopes сохраняет конверты в одной транзакции
+func (r *EnvelopeRepository) SaveEnvelopes(envelopes []models.Envelope) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketEnvelopes)
+		for _, envelope := range envelopes {
+			if _, err := loadMessage(tx, envelope.MessageID); err != nil {
+				return err
+			}
+			if !userExists(tx, envelope.UserID) {
+				return models.ErrUserNotFound
+			}
+
+			key := append(pairKey(envelope.MessageID, envelope.UserID), envelope.DeviceID...)
+			if err := put(bucket, key, envelope); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetUserEnvelopes возвращает конверты сообщений для устройств пользователя
+func (r *EnvelopeRepository) GetUserEnvelopes(userID uint, messageIDs []uint) ([]models.Envelope, error) {
+	// Сообщения по возрастанию ID, тогда конверты идут в нужном порядке
+	ids := append([]uint(nil), messageIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	envelopes := make([]models.Envelope, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketEnvelopes).Cursor()
+		for i, messageID := range ids {
+			if i > 0 && ids[i-1] == messageID {
+				continue
+			}
+			prefix := pairKey(messageID, userID)
+			for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+				var envelope models.Envelope
+				if err := json.Unmarshal(value, &envelope); err != nil {
+					return err
+				}
+				envelopes = append(envelopes, envelope)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return envelopes, nil
+}
+
+// DeleteMessageEnvelopes удаляет конверты сообщения
+func (r *EnvelopeRepository) DeleteMessageEnvelopes(messageID uint) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(messageID))
+		bucket := tx.Bucket(bucketEnvelopes)
+
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// KeyRepository хранилище открытых ключей устройств в bbolt
+type KeyRepository struct {
+	db *bbolt.DB
+}
+
+// deviceKey ключ устройства: userID|deviceID
+func deviceKey(userID uint, deviceID string) []byte {
+	return append(itob(uint64(userID)), deviceID...)
+}
+
+// preKeyPrefix начало ключей одноразовых ключей устройства:
+// userID|длина deviceID|deviceID. Длина нужна, чтобы устройство "a" не
+// совпадало по префиксу с устройством "ab".
+func preKeyPrefix(userID uint, deviceID string) []byte {
+	prefix := append(itob(uint64(userID)), byte(len(deviceID)))
+	return append(prefix, deviceID...)
+}
+
+// preKeyKey ключ одноразового ключа; big-endian KeyID сохраняет порядок
+func preKeyKey(userID uint, deviceID string, keyID uint32) []byte {
+	return binary.BigEndian.AppendUint32(preKeyPrefix(userID, deviceID), keyID)
+}
+
+// SaveDeviceKeys сохраняет ключи устройства; при смене identity ключа
+// одноразовые ключи устройства удаляются
+func (r *KeyRepository) SaveDeviceKeys(keys models.DeviceKeys) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		if !userExists(tx, keys.UserID) {
+			return models.ErrUserNotFound
+		}
+
+		bucket := tx.Bucket(bucketDeviceKeys)
+		key := deviceKey(keys.UserID, keys.DeviceID)
+		var existing models.DeviceKeys
+		found, err := get(bucket, key, &existing)
+		if err != nil {
+			return err
+		}
+		if found && !bytes.Equal(existing.IdentityKey, keys.IdentityKey) {
+			if err := deletePreKeys(tx, keys.UserID, keys.DeviceID); err != nil {
+				return err
+			}
+		}
+		return put(bucket, key, keys)
+	})
+}
+
+// GetDeviceKeys получает ключи устройства
+func (r *KeyRepository) GetDeviceKeys(userID uint, deviceID string) (*models.DeviceKeys, error) {
+	var keys models.DeviceKeys
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		found, err := get(tx.Bucket(bucketDeviceKeys), deviceKey(userID, deviceID), &keys)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrDeviceKeysNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// GetUserDeviceKeys возвращает ключи всех устройств пользователя
+func (r *KeyRepository) GetUserDeviceKeys(userID uint) ([]models.DeviceKeys, error) {
+	result := make([]models.DeviceKeys, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(userID))
+		cursor := tx.Bucket(bucketDeviceKeys).Cursor()
+
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			var keys models.DeviceKeys
+			if err := json.Unmarshal(value, &keys); err != nil {
+				return err
+			}
+			result = append(result, keys)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddOneTimePreKeys добавляет одноразовые ключи устройства
+func (r *KeyRepository) AddOneTimePreKeys(userID uint, deviceID string, keys []models.OneTimePreKey) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(bucketDeviceKeys).Get(deviceKey(userID, deviceID)) == nil {
+			return models.ErrDeviceKeysNotFound
+		}
+
+		bucket := tx.Bucket(bucketPreKeys)
+		for _, prekey := range keys {
+			if err := bucket.Put(preKeyKey(userID, deviceID, prekey.KeyID), prekey.PublicKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TakeOneTimePreKey выдает и удаляет одноразовый ключ с наименьшим KeyID
+func (r *KeyRepository) TakeOneTimePreKey(userID uint, deviceID string) (*models.OneTimePreKey, error) {
+	var taken *models.OneTimePreKey
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		prefix := preKeyPrefix(userID, deviceID)
+		cursor := tx.Bucket(bucketPreKeys).Cursor()
+
+		key, value := cursor.Seek(prefix)
+		if key == nil || !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		taken = &models.OneTimePreKey{
+			KeyID:     binary.BigEndian.Uint32(key[len(prefix):]),
+			PublicKey: append([]byte(nil), value...),
+		}
+		return cursor.Delete()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+// CountOneTimePreKeys считает оставшиеся одноразовые ключи устройства
+func (r *KeyRepository) CountOneTimePreKeys(userID uint, deviceID string) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := preKeyPrefix(userID, deviceID)
+		cursor := tx.Bucket(bucketPreKeys).Cursor()
+
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// deletePreKeys удаляет одноразовые ключи устройства
+func deletePreKeys(tx *bbolt.Tx, userID uint, deviceID string) error {
+	prefix := preKeyPrefix(userID, deviceID)
+	bucket := tx.Bucket(bucketPreKeys)
+
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
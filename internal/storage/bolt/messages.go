@@ -0,0 +1,350 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// MessageRepository хранилище сообщений в bbolt
+type MessageRepository struct {
+	db *bbolt.DB
+}
+
+// CreateMessage сохраняет сообщение
+func (r *MessageRepository) CreateMessage(message *models.Message) (*models.Message, error) {
+	return r.CreateSealedMessage(message, nil)
+}
+
+// CreateSealedMessage сохраняет сообщение, вызывая seal после присвоения
+// ID и номера в чате
+func (r *MessageRepository) CreateSealedMessage(message *models.Message, seal func(message *models.Message) error) (*models.Message, error) {
+	created := *message
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := loadChat(tx, message.ChatID); err != nil {
+			return err
+		}
+		if !userExists(tx, message.SenderID) {
+			return models.ErrUserNotFound
+		}
+
+		if message.ClientMsgID != "" && tx.Bucket(bucketClientMessages).Get(clientMsgKey(message.SenderID, message.ClientMsgID)) != nil {
+			return models.ErrDuplicateClientMsgID
+		}
+
+		messages := tx.Bucket(bucketMessages)
+		if message.ReplyToID != nil && messages.Get(itob(uint64(*message.ReplyToID))) == nil {
+			return models.ErrMessageNotFound
+		}
+
+		id, err := messages.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		created.ID = uint(id)
+		if created.CreatedAt.IsZero() {
+			created.CreatedAt = time.Now()
+		}
+		created.UpdatedAt = created.CreatedAt
+		if err := assignSeq(tx, &created); err != nil {
+			return err
+		}
+		if seal != nil {
+			if err := seal(&created); err != nil {
+				return err
+			}
+		}
+		if err := put(messages, itob(id), created); err != nil {
+			return err
+		}
+		if created.ClientMsgID != "" {
+			if err := tx.Bucket(bucketClientMessages).Put(clientMsgKey(created.SenderID, created.ClientMsgID), itob(id)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketChatMessages).Put(pairKey(created.ChatID, created.ID), []byte{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetMessageByID получает сообщение по ID
+func (r *MessageRepository) GetMessageByID(id uint) (*models.Message, error) {
+	var message *models.Message
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		message, err = loadMessage(tx, id)
+		return err
+	})
+	return message, err
+}
+
+// GetMessageByClientID получает сообщение отправителя по клиентскому ID
+func (r *MessageRepository) GetMessageByClientID(senderID uint, clientMsgID string) (*models.Message, error) {
+	var message *models.Message
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(bucketClientMessages).Get(clientMsgKey(senderID, clientMsgID))
+		if id == nil {
+			return models.ErrMessageNotFound
+		}
+
+		var err error
+		message, err = loadMessage(tx, uint(binary.BigEndian.Uint64(id)))
+		return err
+	})
+	return message, err
+}
+
+// ReleaseClientMsgID снимает клиентский ID с сообщения, если он все еще за ним
+func (r *MessageRepository) ReleaseClientMsgID(senderID uint, clientMsgID string, messageID uint) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(bucketClientMessages)
+		key := clientMsgKey(senderID, clientMsgID)
+		if id := index.Get(key); id == nil || uint(binary.BigEndian.Uint64(id)) != messageID {
+			return nil
+		}
+		if err := index.Delete(key); err != nil {
+			return err
+		}
+
+		message, err := loadMessage(tx, messageID)
+		if err != nil {
+			return err
+		}
+		message.ClientMsgID = ""
+		return put(tx.Bucket(bucketMessages), itob(uint64(messageID)), message)
+	})
+}
+
+// GetChatMessages возвращает последние limit сообщений чата в порядке отправки
+func (r *MessageRepository) GetChatMessages(chatID uint, limit int) ([]*models.Message, error) {
+	return r.GetChatMessagesBefore(chatID, 0, limit)
+}
+
+// GetChatMessagesBefore возвращает до limit сообщений перед beforeID в порядке отправки
+func (r *MessageRepository) GetChatMessagesBefore(chatID, beforeID uint, limit int) ([]*models.Message, error) {
+	messages := make([]*models.Message, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(chatID))
+		cursor := tx.Bucket(bucketChatMessages).Cursor()
+
+		// Встаем на первый ключ не меньше границы и идем назад
+		bound := itob(uint64(chatID) + 1)
+		if beforeID > 0 {
+			bound = pairKey(chatID, beforeID)
+		}
+		key, _ := cursor.Seek(bound)
+		if key == nil {
+			key, _ = cursor.Last()
+		} else {
+			key, _ = cursor.Prev()
+		}
+		for ; key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Prev() {
+			if limit > 0 && len(messages) >= limit {
+				break
+			}
+			message, err := loadMessage(tx, idFromKey(key))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Возвращаем в порядке отправки
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetChatMessagesAfter возвращает до limit сообщений после afterID в порядке отправки
+func (r *MessageRepository) GetChatMessagesAfter(chatID, afterID uint, limit int) ([]*models.Message, error) {
+	messages := make([]*models.Message, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(chatID))
+		cursor := tx.Bucket(bucketChatMessages).Cursor()
+
+		for key, _ := cursor.Seek(pairKey(chatID, afterID+1)); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			if limit > 0 && len(messages) >= limit {
+				break
+			}
+			message, err := loadMessage(tx, idFromKey(key))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetChatMessagesAfterSeq возвращает до limit сообщений с номером больше afterSeq
+func (r *MessageRepository) GetChatMessagesAfterSeq(chatID uint, afterSeq uint64, limit int) ([]*models.Message, error) {
+	messages := make([]*models.Message, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(chatID))
+		cursor := tx.Bucket(bucketChatSeqIndex).Cursor()
+
+		for key, value := cursor.Seek(append(itob(uint64(chatID)), itob(afterSeq+1)...)); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if limit > 0 && len(messages) >= limit {
+				break
+			}
+			message, err := loadMessage(tx, uint(binary.BigEndian.Uint64(value)))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// CountUnread считает чужие неудаленные сообщения с номером больше afterSeq
+func (r *MessageRepository) CountUnread(chatID uint, afterSeq uint64, userID uint) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(chatID))
+		cursor := tx.Bucket(bucketChatSeqIndex).Cursor()
+
+		for key, value := cursor.Seek(append(itob(uint64(chatID)), itob(afterSeq+1)...)); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			message, err := loadMessage(tx, uint(binary.BigEndian.Uint64(value)))
+			if err != nil {
+				return err
+			}
+			if message.SenderID != userID && !message.IsDeleted {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// UpdateMessage сохраняет изменения сообщения
+func (r *MessageRepository) UpdateMessage(message *models.Message) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := loadMessage(tx, message.ID)
+		if err != nil {
+			return err
+		}
+		if existing.IsDeleted {
+			return models.ErrMessageNotFound
+		}
+
+		// Чат, отправитель и время создания не меняются
+		updated := *message
+		updated.ChatID = existing.ChatID
+		updated.SenderID = existing.SenderID
+		updated.CreatedAt = existing.CreatedAt
+		updated.ClientMsgID = existing.ClientMsgID
+		updated.Seq = existing.Seq
+		updated.IsDeleted, updated.DeletedAt, updated.DeletedBy = false, nil, nil
+		return put(tx.Bucket(bucketMessages), itob(uint64(message.ID)), updated)
+	})
+}
+
+// DeleteMessage помечает сообщение удаленным и стирает его текст
+func (r *MessageRepository) DeleteMessage(id, deletedBy uint) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		message, err := loadMessage(tx, id)
+		if err != nil {
+			return err
+		}
+		if message.IsDeleted {
+			return models.ErrMessageNotFound
+		}
+
+		message.MarkDeleted(deletedBy, time.Now())
+		return put(tx.Bucket(bucketMessages), itob(uint64(id)), message)
+	})
+}
+
+// ScanContent возвращает непустые тексты сообщений по возрастанию ID
+func (r *MessageRepository) ScanContent(afterID uint, limit int) ([]models.StoredContent, error) {
+	return scanContent(r.db, bucketMessages, afterID, limit, func(value []byte) (models.StoredContent, error) {
+		var message models.Message
+		err := json.Unmarshal(value, &message)
+		return models.StoredContent{
+			ID:        message.ID,
+			ChatID:    message.ChatID,
+			MessageID: message.ID,
+			Content:   message.Content,
+		}, err
+	})
+}
+
+// ReplaceContent заменяет текст сообщения, если он не изменился
+func (r *MessageRepository) ReplaceContent(id uint, old, new string) (bool, error) {
+	replaced := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		message, err := loadMessage(tx, id)
+		if errors.Is(err, models.ErrMessageNotFound) {
+			return nil
+		}
+		if err != nil || message.Content != old {
+			return err
+		}
+
+		message.Content = new
+		replaced = true
+		return put(tx.Bucket(bucketMessages), itob(uint64(id)), message)
+	})
+	return replaced, err
+}
+
+// assignSeq присваивает сообщению следующий номер в его чате
+func assignSeq(tx *bbolt.Tx, message *models.Message) error {
+	seqs := tx.Bucket(bucketChatSeqs)
+	chatKey := itob(uint64(message.ChatID))
+
+	var seq uint64
+	if value := seqs.Get(chatKey); value != nil {
+		seq = binary.BigEndian.Uint64(value)
+	}
+	seq++
+
+	if err := seqs.Put(chatKey, itob(seq)); err != nil {
+		return err
+	}
+	message.Seq = seq
+	return tx.Bucket(bucketChatSeqIndex).Put(append(itob(uint64(message.ChatID)), itob(seq)...), itob(uint64(message.ID)))
+}
+
+// clientMsgKey ключ индекса клиентских ID: senderID|clientMsgID
+func clientMsgKey(senderID uint, clientMsgID string) []byte {
+	return append(itob(uint64(senderID)), clientMsgID...)
+}
+
+// loadMessage читает сообщение по ID
+func loadMessage(tx *bbolt.Tx, id uint) (*models.Message, error) {
+	var message models.Message
+	found, err := get(tx.Bucket(bucketMessages), itob(uint64(id)), &message)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, models.ErrMessageNotFound
+	}
+	return &message, nil
+}
@@ -0,0 +1,240 @@
+package bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// refreshTokenRecord запись refresh токена; в модели ReplacedBy не
+// сериализуется, а хеш токена служит ключом
+type refreshTokenRecord struct {
+	ID         string     `json:"id"`
+	UserID     uint       `json:"user_id"`
+	DeviceID   string     `json:"device_id"`
+	FamilyID   string     `json:"family_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+}
+
+func newRefreshTokenRecord(token *models.RefreshToken) refreshTokenRecord {
+	return refreshTokenRecord{
+		ID:         token.ID,
+		UserID:     token.UserID,
+		DeviceID:   token.DeviceID,
+		FamilyID:   token.FamilyID,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		RevokedAt:  token.RevokedAt,
+		ReplacedBy: token.ReplacedBy,
+	}
+}
+
+func (r refreshTokenRecord) token(hash string) *models.RefreshToken {
+	return &models.RefreshToken{
+		ID:         r.ID,
+		UserID:     r.UserID,
+		DeviceID:   r.DeviceID,
+		FamilyID:   r.FamilyID,
+		TokenHash:  hash,
+		CreatedAt:  r.CreatedAt,
+		ExpiresAt:  r.ExpiresAt,
+		RevokedAt:  r.RevokedAt,
+		ReplacedBy: r.ReplacedBy,
+	}
+}
+
+// RefreshTokenRepository хранилище refresh токенов в bbolt. Токенов на
+// пользователя немного, поэтому поиск по семье и пользователю идет перебором.
+type RefreshTokenRepository struct {
+	db *bbolt.DB
+}
+
+// Create сохраняет новый refresh токен, удаляет истекшие токены и отзывает
+// другие сессии того же устройства пользователя
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		if !userExists(tx, token.UserID) {
+			return models.ErrUserNotFound
+		}
+		bucket := tx.Bucket(bucketRefreshTokens)
+		if bucket.Get([]byte(token.TokenHash)) != nil {
+			return models.ErrRefreshTokenExists
+		}
+
+		now := time.Now()
+		err := updateRefreshTokens(bucket, func(record *refreshTokenRecord) (bool, bool) {
+			// Истекшие токены уже не нужны даже для обнаружения повторного использования
+			if now.After(record.ExpiresAt) {
+				return false, true
+			}
+			if record.UserID == token.UserID && record.DeviceID == token.DeviceID &&
+				record.FamilyID != token.FamilyID && record.RevokedAt == nil {
+				record.RevokedAt = &now
+				return true, false
+			}
+			return false, false
+		})
+		if err != nil {
+			return err
+		}
+
+		return put(bucket, []byte(token.TokenHash), newRefreshTokenRecord(token))
+	})
+}
+
+// Rotate заменяет refresh токен с хешем oldHash на next в той же семье.
+// Повторное использование уже замененного токена отзывает всю семью.
+func (r *RefreshTokenRepository) Rotate(oldHash string, next *models.RefreshToken) (*models.RefreshToken, error) {
+	var old *models.RefreshToken
+	reused := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRefreshTokens)
+		var record refreshTokenRecord
+		found, err := get(bucket, []byte(oldHash), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrRefreshTokenNotFound
+		}
+
+		now := time.Now()
+		if record.RevokedAt != nil {
+			// Токен уже использовался: скорее всего, он украден.
+			// Отзыв семьи сохраняется, ошибка возвращается после транзакции.
+			reused = true
+			return revokeRefreshFamily(bucket, record.FamilyID, now)
+		}
+		if now.After(record.ExpiresAt) {
+			return models.ErrRefreshTokenExpired
+		}
+
+		record.RevokedAt = &now
+		record.ReplacedBy = next.ID
+		if err := put(bucket, []byte(oldHash), record); err != nil {
+			return err
+		}
+
+		next.UserID = record.UserID
+		next.DeviceID = record.DeviceID
+		next.FamilyID = record.FamilyID
+		if bucket.Get([]byte(next.TokenHash)) != nil {
+			return models.ErrRefreshTokenExists
+		}
+		if err := put(bucket, []byte(next.TokenHash), newRefreshTokenRecord(next)); err != nil {
+			return err
+		}
+
+		old = record.token(oldHash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, models.ErrRefreshTokenReused
+	}
+	return old, nil
+}
+
+// RevokeFamily отзывает все токены семьи пользователя
+func (r *RefreshTokenRepository) RevokeFamily(userID uint, familyID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRefreshTokens)
+
+		found := false
+		err := bucket.ForEach(func(_, value []byte) error {
+			var record refreshTokenRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if record.FamilyID == familyID && record.UserID == userID {
+				found = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrRefreshTokenNotFound
+		}
+
+		return revokeRefreshFamily(bucket, familyID, time.Now())
+	})
+}
+
+// GetActiveByUser возвращает действующие токены пользователя
+func (r *RefreshTokenRepository) GetActiveByUser(userID uint) ([]*models.RefreshToken, error) {
+	tokens := make([]*models.RefreshToken, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(bucketRefreshTokens).ForEach(func(key, value []byte) error {
+			var record refreshTokenRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if record.UserID == userID && record.RevokedAt == nil && now.Before(record.ExpiresAt) {
+				tokens = append(tokens, record.token(string(key)))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// revokeRefreshFamily отзывает еще действующие токены семьи
+func revokeRefreshFamily(bucket *bbolt.Bucket, familyID string, now time.Time) error {
+	return updateRefreshTokens(bucket, func(record *refreshTokenRecord) (bool, bool) {
+		if record.FamilyID == familyID && record.RevokedAt == nil {
+			record.RevokedAt = &now
+			return true, false
+		}
+		return false, false
+	})
+}
+
+// updateRefreshTokens обходит все токены; update возвращает, нужно ли
+// сохранить измененную запись и нужно ли ее удалить
+func updateRefreshTokens(bucket *bbolt.Bucket, update func(record *refreshTokenRecord) (save, remove bool)) error {
+	// Менять бакет во время обхода курсором нельзя, поэтому изменения копятся
+	changed := make(map[string]*refreshTokenRecord)
+	err := bucket.ForEach(func(key, value []byte) error {
+		var record refreshTokenRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		save, remove := update(&record)
+		switch {
+		case remove:
+			changed[string(key)] = nil
+		case save:
+			changed[string(key)] = &record
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, record := range changed {
+		if record == nil {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := put(bucket, []byte(key), record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
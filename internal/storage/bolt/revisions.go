@@ -0,0 +1,161 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// RevisionRepository хранилище ревизий сообщений в bbolt
+type RevisionRepository struct {
+	db *bbolt.DB
+}
+
+// CreateRevision сохраняет ревизию
+func (r *RevisionRepository) CreateRevision(revision *models.MessageRevision) (*models.MessageRevision, error) {
+	created := *revision
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := loadMessage(tx, revision.MessageID); err != nil {
+			return err
+		}
+
+		revisions := tx.Bucket(bucketRevisions)
+		id, err := revisions.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		created.ID = uint(id)
+		if created.EditedAt.IsZero() {
+			created.EditedAt = time.Now()
+		}
+		if err := put(revisions, itob(id), created); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMessageRevs).Put(pairKey(created.MessageID, created.ID), []byte{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetMessageRevisions возвращает ревизии сообщения от старых к новым
+func (r *RevisionRepository) GetMessageRevisions(messageID uint) ([]models.MessageRevision, error) {
+	revisions := make([]models.MessageRevision, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := itob(uint64(messageID))
+		bucket := tx.Bucket(bucketRevisions)
+		cursor := tx.Bucket(bucketMessageRevs).Cursor()
+
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			var revision models.MessageRevision
+			found, err := get(bucket, itob(uint64(idFromKey(key))), &revision)
+			if err != nil {
+				return err
+			}
+			if found {
+				revisions = append(revisions, revision)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// DeleteRevisionsBefore удаляет ревизии старше cutoff. Ревизии лежат в
+// порядке создания, поэтому просмотр останавливается на первой свежей.
+func (r *RevisionRepository) DeleteRevisionsBefore(cutoff time.Time) (int, error) {
+	deleted := 0
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRevisions)
+		index := tx.Bucket(bucketMessageRevs)
+		cursor := bucket.Cursor()
+
+		for key, value := cursor.First(); key != nil; key, value = cursor.First() {
+			var revision models.MessageRevision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			if !revision.EditedAt.Before(cutoff) {
+				break
+			}
+
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			if err := index.Delete(pairKey(revision.MessageID, revision.ID)); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// ScanContent возвращает непустые тексты ревизий по возрастанию ID
+func (r *RevisionRepository) ScanContent(afterID uint, limit int) ([]models.StoredContent, error) {
+	return scanContent(r.db, bucketRevisions, afterID, limit, func(value []byte) (models.StoredContent, error) {
+		var revision models.MessageRevision
+		err := json.Unmarshal(value, &revision)
+		return models.StoredContent{
+			ID:        revision.ID,
+			ChatID:    revision.ChatID,
+			MessageID: revision.MessageID,
+			Content:   revision.Content,
+		}, err
+	})
+}
+
+// ReplaceContent заменяет текст ревизии, если он не изменился
+func (r *RevisionRepository) ReplaceContent(id uint, old, new string) (bool, error) {
+	replaced := false
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRevisions)
+		var revision models.MessageRevision
+		found, err := get(bucket, itob(uint64(id)), &revision)
+		if err != nil || !found || revision.Content != old {
+			return err
+		}
+
+		revision.Content = new
+		replaced = true
+		return put(bucket, itob(uint64(id)), revision)
+	})
+	return replaced, err
+}
+
+// scanContent читает до limit непустых текстов из бакета с ключами-ID,
+// начиная после afterID
+func scanContent(db *bbolt.DB, name []byte, afterID uint, limit int,
+	decode func(value []byte) (models.StoredContent, error)) ([]models.StoredContent, error) {
+	contents := make([]models.StoredContent, 0)
+	err := db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(name).Cursor()
+		for key, value := cursor.Seek(itob(uint64(afterID) + 1)); key != nil; key, value = cursor.Next() {
+			content, err := decode(value)
+			if err != nil {
+				return err
+			}
+			if content.Content == "" {
+				continue
+			}
+			contents = append(contents, content)
+			if limit > 0 && len(contents) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
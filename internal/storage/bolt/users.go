@@ -0,0 +1,279 @@
+package bolt
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+	"gomessage/internal/models"
+)
+
+// userRecord запись пользователя; models.User скрывает пароль и соль в JSON
+type userRecord struct {
+	ID         uint       `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Password   string     `json:"password"`
+	Salt       string     `json:"salt"`
+	Avatar     string     `json:"avatar"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (r *userRecord) user() *models.User {
+	return &models.User{
+		ID:         r.ID,
+		Username:   r.Username,
+		Email:      r.Email,
+		Password:   r.Password,
+		Salt:       r.Salt,
+		Avatar:     r.Avatar,
+		Status:     r.Status,
+		LastSeenAt: r.LastSeenAt,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+	}
+}
+
+// UserRepository хранилище пользователей в bbolt
+type UserRepository struct {
+	db *bbolt.DB
+}
+
+// CreateUser создает нового пользователя
+func (r *UserRepository) CreateUser(username, email, password, salt string) (*models.User, error) {
+	var record userRecord
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		byName := tx.Bucket(bucketUsersByName)
+		byEmail := tx.Bucket(bucketUsersByEmail)
+		if byName.Get([]byte(username)) != nil {
+			return models.ErrUsernameTaken
+		}
+		if byEmail.Get([]byte(email)) != nil {
+			return models.ErrEmailTaken
+		}
+
+		users := tx.Bucket(bucketUsers)
+		id, err := users.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		record = userRecord{
+			ID:        uint(id),
+			Username:  username,
+			Email:     email,
+			Password:  password,
+			Salt:      salt,
+			Status:    models.UserStatusOnline,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := put(users, itob(id), record); err != nil {
+			return err
+		}
+		if err := byName.Put([]byte(username), itob(id)); err != nil {
+			return err
+		}
+		return byEmail.Put([]byte(email), itob(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record.user(), nil
+}
+
+// GetUserByID получает пользователя по ID
+func (r *UserRepository) GetUserByID(id uint) (*models.User, error) {
+	var user *models.User
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		user, err = loadUser(tx, itob(uint64(id)))
+		return err
+	})
+	return user, err
+}
+
+// GetUserByUsername получает пользователя по username
+func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
+	return r.getByIndex(bucketUsersByName, username)
+}
+
+// GetUserByEmail получает пользователя по email
+func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+	return r.getByIndex(bucketUsersByEmail, email)
+}
+
+// IsUsernameTaken проверяет, занят ли username
+func (r *UserRepository) IsUsernameTaken(username string) bool {
+	_, err := r.GetUserByUsername(username)
+	return err == nil
+}
+
+// IsEmailTaken проверяет, занят ли email
+func (r *UserRepository) IsEmailTaken(email string) bool {
+	_, err := r.GetUserByEmail(email)
+	return err == nil
+}
+
+// GetAllUsers возвращает всех пользователей (для отладки)
+func (r *UserRepository) GetAllUsers() []*models.User {
+	users := make([]*models.User, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(_, data []byte) error {
+			var record userRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			users = append(users, record.user())
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка получения пользователей: %v", err)
+	}
+	return users
+}
+
+// GetUsersCount возвращает количество пользователей
+func (r *UserRepository) GetUsersCount() int {
+	var count int
+	r.db.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket(bucketUsers).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// UpdateUser обновляет данные пользователя
+func (r *UserRepository) UpdateUser(id uint, updates map[string]interface{}) (*models.User, error) {
+	var record userRecord
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		key := itob(uint64(id))
+		found, err := get(users, key, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrUserNotFound
+		}
+
+		byName := tx.Bucket(bucketUsersByName)
+		if username, ok := updates["username"].(string); ok && username != record.Username {
+			if byName.Get([]byte(username)) != nil {
+				return models.ErrUsernameTaken
+			}
+			if err := byName.Delete([]byte(record.Username)); err != nil {
+				return err
+			}
+			if err := byName.Put([]byte(username), key); err != nil {
+				return err
+			}
+			record.Username = username
+		}
+
+		byEmail := tx.Bucket(bucketUsersByEmail)
+		if email, ok := updates["email"].(string); ok && email != record.Email {
+			if byEmail.Get([]byte(email)) != nil {
+				return models.ErrEmailTaken
+			}
+			if err := byEmail.Delete([]byte(record.Email)); err != nil {
+				return err
+			}
+			if err := byEmail.Put([]byte(email), key); err != nil {
+				return err
+			}
+			record.Email = email
+		}
+
+		if status, ok := updates["status"].(string); ok {
+			record.Status = status
+		}
+		if avatar, ok := updates["avatar"].(string); ok {
+			record.Avatar = avatar
+		}
+		record.UpdatedAt = time.Now()
+
+		return put(users, key, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record.user(), nil
+}
+
+// UpdateLastSeen запоминает время, когда пользователь был в сети последний раз
+func (r *UserRepository) UpdateLastSeen(id uint, at time.Time) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		key := itob(uint64(id))
+		var record userRecord
+		found, err := get(users, key, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrUserNotFound
+		}
+		record.LastSeenAt = &at
+		return put(users, key, record)
+	})
+}
+
+// UpdatePassword заменяет хеш пароля пользователя
+func (r *UserRepository) UpdatePassword(id uint, password, salt string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		key := itob(uint64(id))
+		var record userRecord
+		found, err := get(users, key, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return models.ErrUserNotFound
+		}
+		record.Password = password
+		record.Salt = salt
+		record.UpdatedAt = time.Now()
+		return put(users, key, record)
+	})
+}
+
+// getByIndex ищет пользователя через индекс username или email
+func (r *UserRepository) getByIndex(index []byte, value string) (*models.User, error) {
+	var user *models.User
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(index).Get([]byte(value))
+		if id == nil {
+			return models.ErrUserNotFound
+		}
+		var err error
+		user, err = loadUser(tx, id)
+		return err
+	})
+	return user, err
+}
+
+// loadUser читает пользователя по ключу
+func loadUser(tx *bbolt.Tx, key []byte) (*models.User, error) {
+	var record userRecord
+	found, err := get(tx.Bucket(bucketUsers), key, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, models.ErrUserNotFound
+	}
+	return record.user(), nil
+}
+
+// userExists проверяет наличие пользователя в транзакции
+func userExists(tx *bbolt.Tx, id uint) bool {
+	return tx.Bucket(bucketUsers).Get(itob(uint64(id))) != nil
+}
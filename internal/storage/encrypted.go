@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+
+	"gomessage/internal/crypto"
+	"gomessage/internal/models"
+)
+
+// Encrypt включает шифрование текста сообщений и ревизий: текст
+// шифруется перед записью и расшифровывается при чтении, так что
+// остальной код видит открытый текст. Вызывается до Install.
+func (r *Repositories) Encrypt(ring *crypto.KeyRing) error {
+	messages, ok := r.Messages.(interface {
+		models.ContentStore
+		models.MessageSealer
+	})
+	if !ok {
+		return fmt.Errorf("хранилище сообщений %T не поддерживает шифрование", r.Messages)
+	}
+	revisions, ok := r.Revisions.(models.ContentStore)
+	if !ok {
+		return fmt.Errorf("хранилище ревизий %T не поддерживает шифрование", r.Revisions)
+	}
+
+	r.Messages = &encryptedMessages{
+		MessageRepository: r.Messages,
+		content:           messages,
+		sealer:            messages,
+		ring:              ring,
+	}
+	r.Revisions = &encryptedRevisions{
+		RevisionRepository: r.Revisions,
+		content:            revisions,
+		ring:               ring,
+	}
+	return nil
+}
+
+// messageAD привязывает шифротекст сообщения к чату и ID сообщения: его
+// нельзя переставить в другое сообщение или чат
+func messageAD(chatID, messageID uint) []byte {
+	return []byte(fmt.Sprintf("message:%d:%d", chatID, messageID))
+}
+
+// revisionAD привязывает шифротекст ревизии к чату и сообщению
+func revisionAD(chatID, messageID uint) []byte {
+	return []byte(fmt.Sprintf("revision:%d:%d", chatID, messageID))
+}
+
+// encryptedMessages хранилище сообщений с шифрованием текста
+type encryptedMessages struct {
+	models.MessageRepository
+	content models.ContentStore
+	sealer  models.MessageSealer
+	ring    *crypto.KeyRing
+}
+
+// CreateMessage шифрует текст, когда ID сообщения уже известен, и
+// возвращает сообщение с открытым текстом
+func (r *encryptedMessages) CreateMessage(message *models.Message) (*models.Message, error) {
+	created, err := r.sealer.CreateSealedMessage(message, func(pending *models.Message) error {
+		if pending.Content == "" {
+			return nil
+		}
+		sealed, err := r.ring.Seal(pending.Content, messageAD(pending.ChatID, pending.ID))
+		if err != nil {
+			return err
+		}
+		pending.Content = sealed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	created.Content = message.Content
+	return created, nil
+}
+
+// GetMessageByID получает сообщение по ID
+func (r *encryptedMessages) GetMessageByID(id uint) (*models.Message, error) {
+	return r.open(r.MessageRepository.GetMessageByID(id))
+}
+
+// GetMessageByClientID получает сообщение отправителя по клиентскому ID
+func (r *encryptedMessages) GetMessageByClientID(senderID uint, clientMsgID string) (*models.Message, error) {
+	return r.open(r.MessageRepository.GetMessageByClientID(senderID, clientMsgID))
+}
+
+// GetChatMessages получает последние сообщения чата
+func (r *encryptedMessages) GetChatMessages(chatID uint, limit int) ([]*models.Message, error) {
+	return r.openAll(r.MessageRepository.GetChatMessages(chatID, limit))
+}
+
+// GetChatMessagesBefore получает сообщения чата до beforeID
+func (r *encryptedMessages) GetChatMessagesBefore(chatID, beforeID uint, limit int) ([]*models.Message, error) {
+	return r.openAll(r.MessageRepository.GetChatMessagesBefore(chatID, beforeID, limit))
+}
+
+// GetChatMessagesAfter получает сообщения чата после afterID
+func (r *encryptedMessages) GetChatMessagesAfter(chatID, afterID uint, limit int) ([]*models.Message, error) {
+	return r.openAll(r.MessageRepository.GetChatMessagesAfter(chatID, afterID, limit))
+}
+
+// GetChatMessagesAfterSeq получает сообщения чата с номером больше afterSeq
+func (r *encryptedMessages) GetChatMessagesAfterSeq(chatID uint, afterSeq uint64, limit int) ([]*models.Message, error) {
+	return r.openAll(r.MessageRepository.GetChatMessagesAfterSeq(chatID, afterSeq, limit))
+}
+
+// UpdateMessage шифрует новый текст и сохраняет сообщение
+func (r *encryptedMessages) UpdateMessage(message *models.Message) error {
+	sealed := *message
+	if sealed.Content != "" {
+		content, err := r.ring.Seal(message.Content, messageAD(message.ChatID, message.ID))
+		if err != nil {
+			return err
+		}
+		sealed.Content = content
+	}
+	return r.MessageRepository.UpdateMessage(&sealed)
+}
+
+// RotateContent перешифровывает текст сообщений активным ключом
+func (r *encryptedMessages) RotateContent(afterID uint, limit int) (uint, int, error) {
+	return rotateContent(r.content, r.ring, afterID, limit, func(content models.StoredContent) []byte {
+		return messageAD(content.ChatID, content.MessageID)
+	})
+}
+
+// open расшифровывает текст сообщения. Сообщение, текст которого не
+// расшифровать, возвращается без текста и с отметкой Unreadable: одна
+// испорченная строка не закрывает историю, и такое сообщение все еще
+// можно найти по клиентскому ID и удалить.
+func (r *encryptedMessages) open(message *models.Message, err error) (*models.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	content, err := r.ring.Open(message.Content, messageAD(message.ChatID, message.ID))
+	if err != nil {
+		log.Printf("❌ Не удалось расшифровать сообщение %d: %v", message.ID, err)
+		message.Content = ""
+		message.Unreadable = true
+		return message, nil
+	}
+	message.Content = content
+	return message, nil
+}
+
+// openAll расшифровывает текст списка сообщений
+func (r *encryptedMessages) openAll(messages []*models.Message, err error) ([]*models.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		r.open(message, nil)
+	}
+	return messages, nil
+}
+
+// encryptedRevisions хранилище ревизий с шифрованием текста
+type encryptedRevisions struct {
+	models.RevisionRepository
+	content models.ContentStore
+	ring    *crypto.KeyRing
+}
+
+// CreateRevision шифрует текст ревизии и сохраняет ее
+func (r *encryptedRevisions) CreateRevision(revision *models.MessageRevision) (*models.MessageRevision, error) {
+	sealed := *revision
+	if sealed.Content != "" {
+		content, err := r.ring.Seal(revision.Content, revisionAD(revision.ChatID, revision.MessageID))
+		if err != nil {
+			return nil, err
+		}
+		sealed.Content = content
+	}
+
+	created, err := r.RevisionRepository.CreateRevision(&sealed)
+	if err != nil {
+		return nil, err
+	}
+	created.Content = revision.Content
+	return created, nil
+}
+
+// GetMessageRevisions возвращает ревизии сообщения с открытым текстом.
+// Ревизия, текст которой не расшифровать, остается в списке без текста и
+// с отметкой Unreadable.
+func (r *encryptedRevisions) GetMessageRevisions(messageID uint) ([]models.MessageRevision, error) {
+	revisions, err := r.RevisionRepository.GetMessageRevisions(messageID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		content, err := r.ring.Open(revisions[i].Content, revisionAD(revisions[i].ChatID, revisions[i].MessageID))
+		if err != nil {
+			log.Printf("❌ Не удалось расшифровать ревизию %d сообщения %d: %v", revisions[i].ID, messageID, err)
+			revisions[i].Content = ""
+			revisions[i].Unreadable = true
+			continue
+		}
+		revisions[i].Content = content
+	}
+	return revisions, nil
+}
+
+// RotateContent перешифровывает текст ревизий активным ключом
+func (r *encryptedRevisions) RotateContent(afterID uint, limit int) (uint, int, error) {
+	return rotateContent(r.content, r.ring, afterID, limit, func(content models.StoredContent) []byte {
+		return revisionAD(content.ChatID, content.MessageID)
+	})
+}
+
+// rotateContent перешифровывает активным ключом открытый текст и текст,
+// зашифрованный прежними ключами. Текст, измененный во время
+// перешифрования, не трогается: его уже записали активным ключом.
+// Текст, который не удалось расшифровать, пропускается.
+func rotateContent(store models.ContentStore, ring *crypto.KeyRing, afterID uint, limit int,
+	associatedData func(content models.StoredContent) []byte) (uint, int, error) {
+	contents, err := store.ScanContent(afterID, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rotated := 0
+	for _, content := range contents {
+		if !ring.NeedsReseal(content.Content) {
+			continue
+		}
+
+		// Открытый текст шифруется и в строгом режиме: перешифрование
+		// как раз убирает его из базы
+		ad := associatedData(content)
+		plaintext := content.Content
+		if crypto.IsSealed(content.Content) {
+			plaintext, err = ring.Open(content.Content, ad)
+			if err != nil {
+				log.Printf("❌ Не удалось расшифровать текст %d (сообщение %d): %v", content.ID, content.MessageID, err)
+				continue
+			}
+		}
+		sealed, err := ring.Seal(plaintext, ad)
+		if err != nil {
+			return 0, rotated, err
+		}
+		replaced, err := store.ReplaceContent(content.ID, content.Content, sealed)
+		if err != nil {
+			return 0, rotated, err
+		}
+		if replaced {
+			rotated++
+		}
+	}
+
+	if limit <= 0 || len(contents) < limit {
+		return 0, rotated, nil
+	}
+	return contents[len(contents)-1].ID, rotated, nil
+}
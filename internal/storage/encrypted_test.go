@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gomessage/internal/crypto"
+	"gomessage/internal/models"
+)
+
+// testKeys два ключа: старый AES-GCM и новый XChaCha20-Poly1305
+var testKeys = "old:" + crypto.CipherAES256GCM + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32))) +
+	",new:" + crypto.CipherXChaCha20Poly1305 + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32)))
+
+// newTestRing разбирает testKeys с активным ключом activeID
+func newTestRing(t *testing.T, activeID string) *crypto.KeyRing {
+	t.Helper()
+	ring, err := crypto.ParseKeyRing(testKeys, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+// storeContent сохраняет сообщение с уже готовым хранимым текстом
+func storeContent(t *testing.T, store *models.MessageStore, chatID uint, content func(id uint) string) uint {
+	t.Helper()
+	message, err := store.CreateSealedMessage(&models.Message{SenderID: 1, ChatID: chatID}, func(message *models.Message) error {
+		message.Content = content(message.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.ID
+}
+
+// storedContent возвращает хранимый текст сообщения в обход шифрования
+func storedContent(t *testing.T, store *models.MessageStore, id uint) string {
+	t.Helper()
+	message, err := store.GetMessageByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.Content
+}
+
+func TestRotateContent(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		name := "lenient"
+		if strict {
+			name = "strict"
+		}
+		t.Run(name, func(t *testing.T) {
+			oldRing := newTestRing(t, "old")
+			ring := newTestRing(t, "new")
+			ring.SetStrict(strict)
+			store := models.NewMessageStore()
+
+			seal := func(r *crypto.KeyRing, plaintext string) func(id uint) string {
+				return func(id uint) string {
+					sealed, err := r.Seal(plaintext, messageAD(1, id))
+					if err != nil {
+						t.Fatal(err)
+					}
+					return sealed
+				}
+			}
+			plain := storeContent(t, store, 1, func(uint) string { return "plain" })
+			old := storeContent(t, store, 1, seal(oldRing, "old"))
+			current := storeContent(t, store, 1, seal(ring, "current"))
+			// Шифротекст другого сообщения не расшифровать с этим AD
+			broken := storeContent(t, store, 1, func(uint) string { return seal(oldRing, "moved")(100) })
+			currentContent := storedContent(t, store, current)
+			brokenContent := storedContent(t, store, broken)
+
+			next, rotated, err := rotateContent(store, ring, 0, 2, func(content models.StoredContent) []byte {
+				return messageAD(content.ChatID, content.MessageID)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != old || rotated != 2 {
+				t.Fatalf("first batch = (%d, %d), want (%d, 2)", next, rotated, old)
+			}
+			next, rotated, err = rotateContent(store, ring, next, 2, func(content models.StoredContent) []byte {
+				return messageAD(content.ChatID, content.MessageID)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != broken || rotated != 0 {
+				t.Fatalf("second batch = (%d, %d), want (%d, 0)", next, rotated, broken)
+			}
+
+			for id, want := range map[uint]string{plain: "plain", old: "old", current: "current"} {
+				content := storedContent(t, store, id)
+				if ring.NeedsReseal(content) {
+					t.Fatalf("message %d is not sealed with the active key: %q", id, content)
+				}
+				if opened, err := ring.Open(content, messageAD(1, id)); err != nil || opened != want {
+					t.Fatalf("message %d = %q, %v", id, opened, err)
+				}
+			}
+			if storedContent(t, store, current) != currentContent {
+				t.Fatal("content sealed with the active key was resealed")
+			}
+			if storedContent(t, store, broken) != brokenContent {
+				t.Fatal("unreadable content was replaced")
+			}
+		})
+	}
+}
+
+func TestEncryptedMessagesUnreadable(t *testing.T) {
+	ring := newTestRing(t, "new")
+	store := models.NewMessageStore()
+	messages := &encryptedMessages{MessageRepository: store, content: store, sealer: store, ring: ring}
+
+	if _, err := messages.CreateMessage(&models.Message{SenderID: 1, ChatID: 1, Content: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	broken := storeContent(t, store, 1, func(uint) string { return "$aead$v=1$gone$AAAA" })
+	plain := storeContent(t, store, 1, func(uint) string { return "plain" })
+
+	history, err := messages.GetChatMessages(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("history has %d messages, want 3", len(history))
+	}
+	for _, message := range history {
+		switch message.ID {
+		case broken:
+			if !message.Unreadable || message.Content != "" {
+				t.Fatalf("broken message = %q, unreadable %v", message.Content, message.Unreadable)
+			}
+		case plain:
+			if message.Unreadable || message.Content != "plain" {
+				t.Fatalf("plaintext message = %q, unreadable %v", message.Content, message.Unreadable)
+			}
+		default:
+			if message.Unreadable || message.Content != "first" {
+				t.Fatalf("sealed message = %q, unreadable %v", message.Content, message.Unreadable)
+			}
+		}
+	}
+	// Отдельное сообщение тоже отдается с отметкой, а не ошибкой
+	single, err := messages.GetMessageByID(broken)
+	if err != nil {
+		t.Fatalf("GetMessageByID: %v", err)
+	}
+	if !single.Unreadable || single.Content != "" {
+		t.Fatalf("GetMessageByID = %q, unreadable %v", single.Content, single.Unreadable)
+	}
+
+	retried, err := store.CreateSealedMessage(&models.Message{SenderID: 1, ChatID: 1, ClientMsgID: "retry"}, func(message *models.Message) error {
+		message.Content = "$aead$v=1$gone$AAAA"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, err := messages.GetMessageByClientID(1, "retry"); err != nil || found.ID != retried.ID || !found.Unreadable {
+		t.Fatalf("GetMessageByClientID = %+v, %v", found, err)
+	}
+
+	// В строгом режиме открытый текст тоже не отдается
+	ring.SetStrict(true)
+	history, err = messages.GetChatMessages(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range history {
+		if message.ID == plain && (!message.Unreadable || message.Content != "") {
+			t.Fatalf("strict mode returned plaintext %q", message.Content)
+		}
+	}
+}
+
+func TestEncryptedRevisionsUnreadable(t *testing.T) {
+	ring := newTestRing(t, "new")
+	store := models.NewRevisionStore()
+	revisions := &encryptedRevisions{RevisionRepository: store, content: store, ring: ring}
+
+	for _, content := range []string{"first", "second", "third"} {
+		if _, err := revisions.CreateRevision(&models.MessageRevision{MessageID: 7, ChatID: 1, Content: content, Action: models.RevisionActionEdit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Портим шифротекст второй ревизии
+	stored, err := store.ScanContent(0, 0)
+	if err != nil || len(stored) != 3 {
+		t.Fatalf("ScanContent = %v, %v", stored, err)
+	}
+	content := []byte(stored[1].Content)
+	i := len(content) - 10
+	if content[i] == 'A' {
+		content[i] = 'B'
+	} else {
+		content[i] = 'A'
+	}
+	tampered := string(content)
+	if replaced, err := store.ReplaceContent(stored[1].ID, stored[1].Content, tampered); err != nil || !replaced {
+		t.Fatalf("ReplaceContent = %v, %v", replaced, err)
+	}
+
+	history, err := revisions.GetMessageRevisions(7)
+	if err != nil {
+		t.Fatalf("GetMessageRevisions: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("history has %d revisions, want 3", len(history))
+	}
+	for i, want := range []string{"first", "", "third"} {
+		if history[i].Content != want || history[i].Unreadable != (want == "") {
+			t.Fatalf("revision %d = %q, unreadable %v", i, history[i].Content, history[i].Unreadable)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"gomessage/internal/models"
+)
+
+const chatColumns = `id, name, type, creator_id, read_receipts, e2ee, public, created_at, updated_at`
+
+const memberColumns = `chat_id, user_id, role, joined_at, last_read_seq, last_read_at`
+
+// ChatRepository хранилище чатов и участников в PostgreSQL
+type ChatRepository struct {
+	db *sql.DB
+}
+
+// CreateChat создает чат вместе с участниками в одной транзакции
+func (r *ChatRepository) CreateChat(chat *models.Chat, members []models.ChatMember) (*models.Chat, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created, err := scanChat(tx.QueryRow(`
+		INSERT INTO chats (name, type, creator_id, read_receipts, e2ee, public)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+chatColumns,
+		chat.Name, chat.Type, chat.CreatorID, chat.ReadReceipts, chat.E2EE, chat.Public))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if _, err := tx.Exec(`
+			INSERT INTO chat_members (chat_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (chat_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+			created.ID, member.UserID, member.Role); err != nil {
+			return nil, foreignKeyViolation(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetChatByID получает чат по ID
+func (r *ChatRepository) GetChatByID(id uint) (*models.Chat, error) {
+	return scanChat(r.db.QueryRow(`SELECT `+chatColumns+` FROM chats WHERE id = $1`, id))
+}
+
+// GetUserChats возвращает чаты, в которых состоит пользователь
+func (r *ChatRepository) GetUserChats(userID uint) ([]*models.Chat, error) {
+	rows, err := r.db.Query(`
+		SELECT c.id, c.name, c.type, c.creator_id, c.read_receipts, c.e2ee, c.public, c.created_at, c.updated_at
+		FROM chats c
+		JOIN chat_members m ON m.chat_id = c.id
+		WHERE m.user_id = $1
+		ORDER BY c.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := make([]*models.Chat, 0)
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// UpdateChat сохраняет название и настройки чата
+func (r *ChatRepository) UpdateChat(chat *models.Chat) error {
+	result, err := r.db.Exec(`
+		UPDATE chats SET name = $2, read_receipts = $3, public = $4, updated_at = now()
+		WHERE id = $1`, chat.ID, chat.Name, chat.ReadReceipts, chat.Public)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return models.ErrChatNotFound
+	}
+	return nil
+}
+
+// AddMember добавляет участника в чат или обновляет его роль
+func (r *ChatRepository) AddMember(member models.ChatMember) error {
+	_, err := r.db.Exec(`
+		INSERT INTO chat_members (chat_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		member.ChatID, member.UserID, member.Role)
+	return foreignKeyViolation(err)
+}
+
+// RemoveMember удаляет участника из чата
+func (r *ChatRepository) RemoveMember(chatID, userID uint) error {
+	result, err := r.db.Exec(`DELETE FROM chat_members WHERE chat_id = $1 AND user_id = $2`, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return models.ErrMemberNotFound
+	}
+	return nil
+}
+
+// GetMember возвращает участника чата
+func (r *ChatRepository) GetMember(chatID, userID uint) (*models.ChatMember, error) {
+	member, err := scanMember(r.db.QueryRow(`
+		SELECT `+memberColumns+`
+		FROM chat_members
+		WHERE chat_id = $1 AND user_id = $2`, chatID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// GetMembers возвращает участников чата
+func (r *ChatRepository) GetMembers(chatID uint) ([]models.ChatMember, error) {
+	if _, err := r.GetChatByID(chatID); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+memberColumns+`
+		FROM chat_members
+		WHERE chat_id = $1
+		ORDER BY user_id`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]models.ChatMember, 0)
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, rows.Err()
+}
+
+// UpdateReadCursor сдвигает позицию чтения участника вперед до seq
+func (r *ChatRepository) UpdateReadCursor(chatID, userID uint, seq uint64, at time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE chat_members SET last_read_seq = $3, last_read_at = $4
+		WHERE chat_id = $1 AND user_id = $2 AND last_read_seq < $3`, chatID, userID, seq, at)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		return true, nil
+	}
+
+	// Позиция не сдвинулась: либо она уже дальше, либо участника нет
+	if _, err := r.GetMember(chatID, userID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// scanMember читает участника чата из строки результата
+func scanMember(row scanner) (*models.ChatMember, error) {
+	var member models.ChatMember
+	var lastReadAt sql.NullTime
+	err := row.Scan(&member.ChatID, &member.UserID, &member.Role, &member.JoinedAt, &member.LastReadSeq, &lastReadAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastReadAt.Valid {
+		member.LastReadAt = &lastReadAt.Time
+	}
+	return &member, nil
+}
+
+// scanChat читает чат из строки результата
+func scanChat(row scanner) (*models.Chat, error) {
+	var chat models.Chat
+	err := row.Scan(&chat.ID, &chat.Name, &chat.Type, &chat.CreatorID, &chat.ReadReceipts, &chat.E2EE, &chat.Public, &chat.CreatedAt, &chat.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrChatNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// foreignKeyViolation переводит нарушение внешнего ключа в ошибки модели
+func foreignKeyViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		switch pqErr.Constraint {
+		case "chat_members_chat_id_fkey", "messages_chat_id_fkey", "message_revisions_chat_id_fkey":
+			return models.ErrChatNotFound
+		case "chat_members_user_id_fkey", "messages_sender_id_fkey", "message_revisions_editor_id_fkey",
+			"message_deliveries_user_id_fkey", "device_keys_user_id_fkey", "message_envelopes_user_id_fkey",
+			"refresh_tokens_user_id_fkey":
+			return models.ErrUserNotFound
+		case "messages_reply_to_id_fkey", "message_revisions_message_id_fkey", "message_deliveries_message_id_fkey",
+			"message_envelopes_message_id_fkey":
+			return models.ErrMessageNotFound
+		case "one_time_prekeys_user_id_device_id_fkey":
+			return models.ErrDeviceKeysNotFound
+		}
+	}
+	return err
+}
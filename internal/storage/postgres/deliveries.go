@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// DeliveryRepository хранилище отметок о доставке в PostgreSQL
+type DeliveryRepository struct {
+	db *sql.DB
+}
+
+// MarkDelivered сохраняет доставку, если ее еще не было
+func (r *DeliveryRepository) MarkDelivered(messageID, userID uint, at time.Time) (bool, error) {
+	result, err := r.db.Exec(`
+		INSERT INTO message_deliveries (message_id, user_id, delivered_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id) DO NOTHING`, messageID, userID, at)
+	if err != nil {
+		return false, foreignKeyViolation(err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetMessageDeliveries возвращает доставки сообщения по возрастанию ID получателя
+func (r *DeliveryRepository) GetMessageDeliveries(messageID uint) ([]models.MessageDelivery, error) {
+	rows, err := r.db.Query(`
+		SELECT message_id, user_id, delivered_at
+		FROM message_deliveries
+		WHERE message_id = $1
+		ORDER BY user_id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.MessageDelivery, 0)
+	for rows.Next() {
+		var delivery models.MessageDelivery
+		if err := rows.Scan(&delivery.MessageID, &delivery.UserID, &delivery.DeliveredAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"gomessage/internal/models"
+)
+
+// EnvelopeRepository хранилище E2EE конвертов в PostgreSQL
+type EnvelopeRepository struct {
+	db *sql.DB
+}
+
+// SaveEnvelopes сохраняет конверты в одной транзакции
+func (r *EnvelopeRepository) SaveEnvelopes(envelopes []models.Envelope) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, envelope := range envelopes {
+		if _, err := tx.Exec(`
+			INSERT INTO message_envelopes (message_id, user_id, device_id, sender_device_id, ciphertext)
+			VALUES ($1, $2, $3, $4, $5)`,
+			envelope.MessageID, envelope.UserID, envelope.DeviceID, envelope.SenderDeviceID, envelope.Ciphertext); err != nil {
+			return foreignKeyViolation(err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetUserEnvelopes возвращает конверты сообщений для устройств пользователя
+func (r *EnvelopeRepository) GetUserEnvelopes(userID uint, messageIDs []uint) ([]models.Envelope, error) {
+	envelopes := make([]models.Envelope, 0)
+	if len(messageIDs) == 0 {
+		return envelopes, nil
+	}
+
+	ids := make([]int64, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := r.db.Query(`
+		SELECT message_id, user_id, device_id, sender_device_id, ciphertext
+		FROM message_envelopes
+		WHERE user_id = $1 AND message_id = ANY($2)
+		ORDER BY message_id, device_id`, userID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var envelope models.Envelope
+		if err := rows.Scan(&envelope.MessageID, &envelope.UserID, &envelope.DeviceID,
+			&envelope.SenderDeviceID, &envelope.Ciphertext); err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, rows.Err()
+}
+
+// DeleteMessageEnvelopes удаляет конверты сообщения
+func (r *EnvelopeRepository) DeleteMessageEnvelopes(messageID uint) error {
+	_, err := r.db.Exec(`DELETE FROM message_envelopes WHERE message_id = $1`, messageID)
+	return err
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+
+	"gomessage/internal/models"
+)
+
+const deviceKeyColumns = `user_id, device_id, identity_key, signing_key, signed_prekey_id, signed_prekey, signed_prekey_signature, updated_at`
+
+// KeyRepository хранилище открытых ключей устройств в PostgreSQL
+type KeyRepository struct {
+	db *sql.DB
+}
+
+// SaveDeviceKeys сохраняет ключи устройства; при смене identity ключа
+// одноразовые ключи устройства удаляются
+func (r *KeyRepository) SaveDeviceKeys(keys models.DeviceKeys) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var identityKey []byte
+	err = tx.QueryRow(`
+		SELECT identity_key FROM device_keys
+		WHERE user_id = $1 AND device_id = $2
+		FOR UPDATE`, keys.UserID, keys.DeviceID).Scan(&identityKey)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case !bytes.Equal(identityKey, keys.IdentityKey):
+		if _, err := tx.Exec(`DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2`,
+			keys.UserID, keys.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO device_keys (`+deviceKeyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET
+			identity_key = EXCLUDED.identity_key,
+			signing_key = EXCLUDED.signing_key,
+			signed_prekey_id = EXCLUDED.signed_prekey_id,
+			signed_prekey = EXCLUDED.signed_prekey,
+			signed_prekey_signature = EXCLUDED.signed_prekey_signature,
+			updated_at = EXCLUDED.updated_at`,
+		keys.UserID, keys.DeviceID, keys.IdentityKey, keys.SigningKey, keys.SignedPreKeyID,
+		keys.SignedPreKey, keys.SignedPreKeySignature, keys.UpdatedAt); err != nil {
+		return foreignKeyViolation(err)
+	}
+	return tx.Commit()
+}
+
+// GetDeviceKeys получает ключи устройства
+func (r *KeyRepository) GetDeviceKeys(userID uint, deviceID string) (*models.DeviceKeys, error) {
+	keys, err := scanDeviceKeys(r.db.QueryRow(`
+		SELECT `+deviceKeyColumns+`
+		FROM device_keys
+		WHERE user_id = $1 AND device_id = $2`, userID, deviceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrDeviceKeysNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetUserDeviceKeys возвращает ключи всех устройств пользователя
+func (r *KeyRepository) GetUserDeviceKeys(userID uint) ([]models.DeviceKeys, error) {
+	rows, err := r.db.Query(`
+		SELECT `+deviceKeyColumns+`
+		FROM device_keys
+		WHERE user_id = $1
+		ORDER BY device_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.DeviceKeys, 0)
+	for rows.Next() {
+		keys, err := scanDeviceKeys(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *keys)
+	}
+	return result, rows.Err()
+}
+
+// AddOneTimePreKeys добавляет одноразовые ключи устройства
+func (r *KeyRepository) AddOneTimePreKeys(userID uint, deviceID string, keys []models.OneTimePreKey) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, prekey := range keys {
+		if _, err := tx.Exec(`
+			INSERT INTO one_time_prekeys (user_id, device_id, key_id, public_key)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, device_id, key_id) DO UPDATE SET public_key = EXCLUDED.public_key`,
+			userID, deviceID, prekey.KeyID, prekey.PublicKey); err != nil {
+			return foreignKeyViolation(err)
+		}
+	}
+	return tx.Commit()
+}
+
+// TakeOneTimePreKey выдает и удаляет одноразовый ключ с наименьшим KeyID.
+// SKIP LOCKED не дает двум собеседникам получить один и тот же ключ.
+func (r *KeyRepository) TakeOneTimePreKey(userID uint, deviceID string) (*models.OneTimePreKey, error) {
+	var prekey models.OneTimePreKey
+	err := r.db.QueryRow(`
+		DELETE FROM one_time_prekeys
+		WHERE (user_id, device_id, key_id) = (
+			SELECT user_id, device_id, key_id
+			FROM one_time_prekeys
+			WHERE user_id = $1 AND device_id = $2
+			ORDER BY key_id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING key_id, public_key`, userID, deviceID).Scan(&prekey.KeyID, &prekey.PublicKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prekey, nil
+}
+
+// CountOneTimePreKeys считает оставшиеся одноразовые ключи устройства
+func (r *KeyRepository) CountOneTimePreKeys(userID uint, deviceID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT count(*) FROM one_time_prekeys
+		WHERE user_id = $1 AND device_id = $2`, userID, deviceID).Scan(&count)
+	return count, err
+}
+
+// scanDeviceKeys читает ключи устройства из строки результата
+func scanDeviceKeys(row scanner) (*models.DeviceKeys, error) {
+	var keys models.DeviceKeys
+	err := row.Scan(&keys.UserID, &keys.DeviceID, &keys.IdentityKey, &keys.SigningKey, &keys.SignedPreKeyID,
+		&keys.SignedPreKey, &keys.SignedPreKeySignature, &keys.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
@@ -0,0 +1,256 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"gomessage/internal/models"
+)
+
+const messageColumns = `id, content, type, sender_id, chat_id, seq, reply_to_id, client_msg_id, is_edited,
+	is_deleted, deleted_at, deleted_by, created_at, updated_at`
+
+// MessageRepository хранилище сообщений в PostgreSQL
+type MessageRepository struct {
+	db *sql.DB
+}
+
+// CreateMessage сохраняет сообщение. Номер в чате берется из chats.last_seq
+// в той же транзакции: строка чата блокируется до конца вставки.
+func (r *MessageRepository) CreateMessage(message *models.Message) (*models.Message, error) {
+	return r.CreateSealedMessage(message, nil)
+}
+
+// CreateSealedMessage сохраняет сообщение, вызывая seal после присвоения
+// ID и номера в чате. ID берется из последовательности заранее, пока
+// строка чата заблокирована, поэтому порядок ID в чате совпадает с
+// порядком номеров.
+func (r *MessageRepository) CreateSealedMessage(message *models.Message, seal func(message *models.Message) error) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var seq uint64
+	err = tx.QueryRow(`UPDATE chats SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`,
+		message.ChatID).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrChatNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pending := *message
+	pending.Seq = seq
+	if err := tx.QueryRow(`SELECT nextval(pg_get_serial_sequence('messages', 'id'))`).Scan(&pending.ID); err != nil {
+		return nil, err
+	}
+	if seal != nil {
+		if err := seal(&pending); err != nil {
+			return nil, err
+		}
+	}
+
+	// Занятый клиентский ID не вставляет строку; откат транзакции
+	// возвращает и номер в чате
+	created, err := scanMessage(tx.QueryRow(`
+		INSERT INTO messages (id, content, type, sender_id, chat_id, seq, reply_to_id, client_msg_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		ON CONFLICT (sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
+		RETURNING `+messageColumns,
+		pending.ID, pending.Content, pending.Type, pending.SenderID, pending.ChatID, pending.Seq,
+		nullableID(pending.ReplyToID), pending.ClientMsgID))
+	if errors.Is(err, models.ErrMessageNotFound) {
+		return nil, models.ErrDuplicateClientMsgID
+	}
+	if err != nil {
+		return nil, foreignKeyViolation(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetMessageByID получает сообщение по ID
+func (r *MessageRepository) GetMessageByID(id uint) (*models.Message, error) {
+	return scanMessage(r.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = $1`, id))
+}
+
+// GetMessageByClientID получает сообщение отправителя по клиентскому ID
+func (r *MessageRepository) GetMessageByClientID(senderID uint, clientMsgID string) (*models.Message, error) {
+	return scanMessage(r.db.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE sender_id = $1 AND client_msg_id = $2`, senderID, clientMsgID))
+}
+
+// ReleaseClientMsgID снимает клиентский ID с сообщения, если он все еще за ним
+func (r *MessageRepository) ReleaseClientMsgID(senderID uint, clientMsgID string, messageID uint) error {
+	_, err := r.db.Exec(`
+		UPDATE messages SET client_msg_id = NULL
+		WHERE id = $1 AND sender_id = $2 AND client_msg_id = $3`, messageID, senderID, clientMsgID)
+	return err
+}
+
+// GetChatMessages возвращает последние limit сообщений чата в порядке отправки
+func (r *MessageRepository) GetChatMessages(chatID uint, limit int) ([]*models.Message, error) {
+	return r.GetChatMessagesBefore(chatID, 0, limit)
+}
+
+// GetChatMessagesBefore возвращает до limit сообщений перед beforeID в порядке отправки
+func (r *MessageRepository) GetChatMessagesBefore(chatID, beforeID uint, limit int) ([]*models.Message, error) {
+	return r.queryMessages(`
+		SELECT * FROM (
+			SELECT `+messageColumns+`
+			FROM messages
+			WHERE chat_id = $1 AND ($2 = 0 OR id < $2)
+			ORDER BY id DESC
+			LIMIT NULLIF($3, -1)
+		) page
+		ORDER BY id`, chatID, beforeID, sqlLimit(limit))
+}
+
+// GetChatMessagesAfter возвращает до limit сообщений после afterID в порядке отправки
+func (r *MessageRepository) GetChatMessagesAfter(chatID, afterID uint, limit int) ([]*models.Message, error) {
+	return r.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE chat_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT NULLIF($3, -1)`, chatID, afterID, sqlLimit(limit))
+}
+
+// GetChatMessagesAfterSeq возвращает до limit сообщений с номером больше afterSeq
+func (r *MessageRepository) GetChatMessagesAfterSeq(chatID uint, afterSeq uint64, limit int) ([]*models.Message, error) {
+	return r.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE chat_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT NULLIF($3, -1)`, chatID, afterSeq, sqlLimit(limit))
+}
+
+// queryMessages выполняет запрос и читает список сообщений
+func (r *MessageRepository) queryMessages(query string, args ...interface{}) ([]*models.Message, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// CountUnread считает чужие неудаленные сообщения с номером больше afterSeq
+func (r *MessageRepository) CountUnread(chatID uint, afterSeq uint64, userID uint) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT count(*) FROM messages
+		WHERE chat_id = $1 AND seq > $2 AND sender_id <> $3 AND NOT is_deleted`,
+		chatID, afterSeq, userID).Scan(&count)
+	return count, err
+}
+
+// UpdateMessage сохраняет изменения сообщения
+func (r *MessageRepository) UpdateMessage(message *models.Message) error {
+	result, err := r.db.Exec(`
+		UPDATE messages
+		SET content = $2, type = $3, is_edited = $4, updated_at = $5
+		WHERE id = $1 AND NOT is_deleted`,
+		message.ID, message.Content, message.Type, message.IsEdited, message.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return models.ErrMessageNotFound
+	}
+	return nil
+}
+
+// DeleteMessage помечает сообщение удаленным и стирает его текст
+func (r *MessageRepository) DeleteMessage(id, deletedBy uint) error {
+	result, err := r.db.Exec(`
+		UPDATE messages
+		SET content = '', is_deleted = true, deleted_at = now(), deleted_by = $2, updated_at = now()
+		WHERE id = $1 AND NOT is_deleted`, id, deletedBy)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return models.ErrMessageNotFound
+	}
+	return nil
+}
+
+// ScanContent возвращает непустые тексты сообщений по возрастанию ID
+func (r *MessageRepository) ScanContent(afterID uint, limit int) ([]models.StoredContent, error) {
+	return queryContent(r.db, `
+		SELECT id, chat_id, id, content FROM messages
+		WHERE id > $1 AND content <> ''
+		ORDER BY id
+		LIMIT NULLIF($2, -1)`, afterID, sqlLimit(limit))
+}
+
+// ReplaceContent заменяет текст сообщения, если он не изменился.
+// Время изменения не трогается: текст для пользователя тот же.
+func (r *MessageRepository) ReplaceContent(id uint, old, new string) (bool, error) {
+	return replaceContent(r.db, `UPDATE messages SET content = $3 WHERE id = $1 AND content = $2`, id, old, new)
+}
+
+// scanMessage читает сообщение из строки результата
+func scanMessage(row scanner) (*models.Message, error) {
+	var message models.Message
+	var replyToID, deletedBy sql.NullInt64
+	var clientMsgID sql.NullString
+	var deletedAt sql.NullTime
+	err := row.Scan(&message.ID, &message.Content, &message.Type, &message.SenderID, &message.ChatID,
+		&message.Seq, &replyToID, &clientMsgID, &message.IsEdited, &message.IsDeleted, &deletedAt, &deletedBy,
+		&message.CreatedAt, &message.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if replyToID.Valid {
+		id := uint(replyToID.Int64)
+		message.ReplyToID = &id
+	}
+	message.ClientMsgID = clientMsgID.String
+	if deletedAt.Valid {
+		message.DeletedAt = &deletedAt.Time
+	}
+	if deletedBy.Valid {
+		id := uint(deletedBy.Int64)
+		message.DeletedBy = &id
+	}
+	return &message, nil
+}
+
+// sqlLimit переводит limit <= 0 ("без ограничения") в -1 для LIMIT NULLIF($n, -1)
+func sqlLimit(limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	return limit
+}
+
+// nullableID переводит необязательный ID в значение для запроса
+func nullableID(id *uint) interface{} {
+	if id == nil {
+		return nil
+	}
+	return int64(*id)
+}
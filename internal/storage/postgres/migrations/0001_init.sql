@@ -0,0 +1,44 @@
+CREATE TABLE users (
+    id         BIGSERIAL PRIMARY KEY,
+    username   TEXT NOT NULL UNIQUE,
+    email      TEXT NOT NULL UNIQUE,
+    password   TEXT NOT NULL,
+    salt       TEXT NOT NULL,
+    avatar     TEXT NOT NULL DEFAULT '',
+    status     TEXT NOT NULL DEFAULT 'offline',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE chats (
+    id         BIGSERIAL PRIMARY KEY,
+    name       TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    creator_id BIGINT NOT NULL REFERENCES users (id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE chat_members (
+    chat_id   BIGINT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    user_id   BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role      TEXT NOT NULL,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX chat_members_user_id_idx ON chat_members (user_id);
+
+CREATE TABLE messages (
+    id          BIGSERIAL PRIMARY KEY,
+    chat_id     BIGINT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    sender_id   BIGINT NOT NULL REFERENCES users (id),
+    content     TEXT NOT NULL,
+    type        TEXT NOT NULL,
+    reply_to_id BIGINT REFERENCES messages (id) ON DELETE SET NULL,
+    is_edited   BOOLEAN NOT NULL DEFAULT false,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX messages_chat_id_id_idx ON messages (chat_id, id);
@@ -0,0 +1,5 @@
+-- Мягкое удаление сообщений: запись остается в истории как tombstone
+ALTER TABLE messages
+    ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN deleted_by BIGINT REFERENCES users (id);
@@ -0,0 +1,13 @@
+-- Прежние версии текста сообщений для аудита
+CREATE TABLE message_revisions (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    chat_id    BIGINT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    content    TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    editor_id  BIGINT NOT NULL REFERENCES users (id),
+    edited_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX message_revisions_message_id_idx ON message_revisions (message_id, id);
+CREATE INDEX message_revisions_edited_at_idx ON message_revisions (edited_at);
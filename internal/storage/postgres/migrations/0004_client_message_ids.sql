@@ -0,0 +1,5 @@
+-- Клиентские ID сообщений для идемпотентной отправки
+ALTER TABLE messages ADD COLUMN client_msg_id TEXT;
+
+CREATE INDEX messages_sender_client_msg_id_idx ON messages (sender_id, client_msg_id, id)
+    WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,17 @@
+-- Порядковые номера сообщений внутри чата для докачки после переподключения
+ALTER TABLE chats ADD COLUMN last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN seq BIGINT;
+
+UPDATE messages m
+SET seq = numbered.seq
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY chat_id ORDER BY id) AS seq
+    FROM messages
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE chats c
+SET last_seq = COALESCE((SELECT max(seq) FROM messages WHERE chat_id = c.id), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX messages_chat_id_seq_idx ON messages (chat_id, seq);
@@ -0,0 +1,2 @@
+-- Время, когда пользователь был в сети последний раз
+ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMPTZ;
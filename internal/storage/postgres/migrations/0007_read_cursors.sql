@@ -0,0 +1,6 @@
+-- Позиции чтения участников и настройка рассылки отметок о прочтении
+ALTER TABLE chats ADD COLUMN read_receipts BOOLEAN NOT NULL DEFAULT true;
+
+ALTER TABLE chat_members
+    ADD COLUMN last_read_seq BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN last_read_at  TIMESTAMPTZ;
@@ -0,0 +1,7 @@
+-- Отметки о доставке сообщений получателям (первое подтвердившее устройство)
+CREATE TABLE message_deliveries (
+    message_id   BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id      BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    delivered_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (message_id, user_id)
+);
@@ -0,0 +1,35 @@
+-- Личные чаты со сквозным шифрованием
+ALTER TABLE chats ADD COLUMN e2ee BOOLEAN NOT NULL DEFAULT false;
+
+-- Открытые ключи устройств для установки E2EE сессий
+CREATE TABLE device_keys (
+    user_id                 BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device_id               TEXT NOT NULL,
+    identity_key            BYTEA NOT NULL,
+    signing_key             BYTEA NOT NULL,
+    signed_prekey_id        BIGINT NOT NULL,
+    signed_prekey           BYTEA NOT NULL,
+    signed_prekey_signature BYTEA NOT NULL,
+    updated_at              TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, device_id)
+);
+
+-- Одноразовые ключи устройств; удаляются при выдаче собеседнику
+CREATE TABLE one_time_prekeys (
+    user_id    BIGINT NOT NULL,
+    device_id  TEXT NOT NULL,
+    key_id     BIGINT NOT NULL,
+    public_key BYTEA NOT NULL,
+    PRIMARY KEY (user_id, device_id, key_id),
+    FOREIGN KEY (user_id, device_id) REFERENCES device_keys (user_id, device_id) ON DELETE CASCADE
+);
+
+-- Шифротексты E2EE сообщений для устройств получателей
+CREATE TABLE message_envelopes (
+    message_id       BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id          BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device_id        TEXT NOT NULL,
+    sender_device_id TEXT NOT NULL,
+    ciphertext       BYTEA NOT NULL,
+    PRIMARY KEY (message_id, user_id, device_id)
+);
@@ -0,0 +1,17 @@
+-- Refresh токены; хранится только SHA-256 хеш токена.
+-- Токены одной сессии устройства образуют семью (family_id).
+CREATE TABLE refresh_tokens (
+    id          TEXT PRIMARY KEY,
+    user_id     BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device_id   TEXT NOT NULL,
+    family_id   TEXT NOT NULL,
+    token_hash  TEXT NOT NULL UNIQUE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    revoked_at  TIMESTAMPTZ,
+    replaced_by TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX refresh_tokens_user_device_idx ON refresh_tokens (user_id, device_id);
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
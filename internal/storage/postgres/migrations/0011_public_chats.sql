@@ -0,0 +1,3 @@
+-- Открытые группы и каналы, в которые можно вступить без приглашения.
+-- Существующие чаты закрыты: открыть их может владелец или администратор.
+ALTER TABLE chats ADD COLUMN public BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,15 @@
+-- Клиентский ID сообщения уникален для отправителя, чтобы повторы на разных
+-- узлах не создавали дублей. У старых дублей ID снимается, остается только
+-- у последнего сообщения.
+UPDATE messages m SET client_msg_id = NULL
+WHERE client_msg_id IS NOT NULL AND EXISTS (
+    SELECT 1 FROM messages newer
+    WHERE newer.sender_id = m.sender_id
+      AND newer.client_msg_id = m.client_msg_id
+      AND newer.id > m.id
+);
+
+DROP INDEX messages_sender_client_msg_id_idx;
+
+CREATE UNIQUE INDEX messages_sender_client_msg_id_key ON messages (sender_id, client_msg_id)
+    WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"gomessage/internal/config"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockID ключ advisory lock, чтобы реплики не применяли миграции одновременно
+const migrationLockID = 7291001
+
+// scanner общий интерфейс *sql.Row и *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Store хранилище в PostgreSQL
+type Store struct {
+	db         *sql.DB
+	users      *UserRepository
+	chats      *ChatRepository
+	messages   *MessageRepository
+	revisions  *RevisionRepository
+	deliveries *DeliveryRepository
+	keys       *KeyRepository
+	envelopes  *EnvelopeRepository
+	refresh    *RefreshTokenRepository
+}
+
+// Open подключается к PostgreSQL и применяет миграции
+func Open(cfg config.DatabaseConfig) (*Store, error) {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("подключение к PostgreSQL: %w", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("миграции PostgreSQL: %w", err)
+	}
+
+	return &Store{
+		db:         db,
+		users:      &UserRepository{db: db},
+		chats:      &ChatRepository{db: db},
+		messages:   &MessageRepository{db: db},
+		revisions:  &RevisionRepository{db: db},
+		deliveries: &DeliveryRepository{db: db},
+		keys:       &KeyRepository{db: db},
+		envelopes:  &EnvelopeRepository{db: db},
+		refresh:    &RefreshTokenRepository{db: db},
+	}, nil
+}
+
+// Users возвращает репозиторий пользователей
+func (s *Store) Users() *UserRepository {
+	return s.users
+}
+
+// Chats возвращает репозиторий чатов
+func (s *Store) Chats() *ChatRepository {
+	return s.chats
+}
+
+// Messages возвращает репозиторий сообщений
+func (s *Store) Messages() *MessageRepository {
+	return s.messages
+}
+
+// Revisions возвращает репозиторий ревизий сообщений
+func (s *Store) Revisions() *RevisionRepository {
+	return s.revisions
+}
+
+// Deliveries возвращает репозиторий отметок о доставке
+func (s *Store) Deliveries() *DeliveryRepository {
+	return s.deliveries
+}
+
+// Keys возвращает репозиторий ключей устройств
+func (s *Store) Keys() *KeyRepository {
+	return s.keys
+}
+
+// Envelopes возвращает репозиторий E2EE конвертов
+func (s *Store) Envelopes() *EnvelopeRepository {
+	return s.envelopes
+}
+
+// RefreshTokens возвращает репозиторий refresh токенов
+func (s *Store) RefreshTokens() *RefreshTokenRepository {
+	return s.refresh
+}
+
+// Close закрывает соединения с базой
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate применяет еще не примененные миграции из migrations/ по порядку номеров.
+// Каждая миграция выполняется в своей транзакции.
+func migrate(db *sql.DB) error {
+	// Advisory lock принадлежит сессии, поэтому все делаем на одном соединении
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version, err := strconv.Atoi(strings.SplitN(entry.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("некорректное имя миграции %s", entry.Name())
+		}
+
+		var applied bool
+		if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Printf("🗄️ Применена миграция %s", entry.Name())
+	}
+
+	return nil
+}
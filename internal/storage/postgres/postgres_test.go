@@ -0,0 +1,30 @@
+package postgres_test
+
+import (
+	"os"
+	"testing"
+
+	"gomessage/internal/config"
+	"gomessage/internal/storage"
+	"gomessage/internal/storage/storagetest"
+)
+
+// TestConformance работает с базой из переменных DB_HOST, DB_PORT, DB_USER,
+// DB_PASSWORD, DB_NAME и запускается, только если задана GOMESSAGE_TEST_POSTGRES=1.
+// Тесты создают свои данные и не очищают базу, поэтому нужна отдельная база.
+func TestConformance(t *testing.T) {
+	if os.Getenv("GOMESSAGE_TEST_POSTGRES") == "" {
+		t.Skip("GOMESSAGE_TEST_POSTGRES не задана")
+	}
+
+	cfg := config.Load().Database
+	cfg.Driver = storage.DriverPostgres
+	storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+		repos, err := storage.Open(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repos.Close() })
+		return repos
+	})
+}
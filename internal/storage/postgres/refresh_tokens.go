@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+const refreshTokenColumns = `id, user_id, device_id, family_id, token_hash, created_at, expires_at, revoked_at, replaced_by`
+
+// RefreshTokenRepository хранилище refresh токенов в PostgreSQL
+type RefreshTokenRepository struct {
+	db *sql.DB
+}
+
+// Create сохраняет новый refresh токен, удаляет истекшие токены пользователя
+// и отзывает другие сессии того же устройства
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < $2`,
+		token.UserID, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at = $4
+		WHERE user_id = $1 AND device_id = $2 AND family_id <> $3 AND revoked_at IS NULL`,
+		token.UserID, token.DeviceID, token.FamilyID, now); err != nil {
+		return err
+	}
+	if err := insertRefreshToken(tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rotate заменяет refresh токен на next в той же семье. Строка старого
+// токена блокируется, поэтому один токен нельзя обменять дважды параллельно.
+func (r *RefreshTokenRepository) Rotate(oldHash string, next *models.RefreshToken) (*models.RefreshToken, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanRefreshToken(tx.QueryRow(`
+		SELECT `+refreshTokenColumns+`
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE`, oldHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if old.RevokedAt != nil {
+		// Токен уже использовался: скорее всего, он украден
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`,
+			old.FamilyID, now); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, models.ErrRefreshTokenReused
+	}
+
+	if now.After(old.ExpiresAt) {
+		return nil, models.ErrRefreshTokenExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = $2, replaced_by = $3 WHERE id = $1`,
+		old.ID, now, next.ID); err != nil {
+		return nil, err
+	}
+	old.RevokedAt = &now
+	old.ReplacedBy = next.ID
+
+	next.UserID = old.UserID
+	next.DeviceID = old.DeviceID
+	next.FamilyID = old.FamilyID
+	if err := insertRefreshToken(tx, next); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// RevokeFamily отзывает все токены семьи пользователя
+func (r *RefreshTokenRepository) RevokeFamily(userID uint, familyID string) error {
+	var found bool
+	err := r.db.QueryRow(`
+		WITH family AS (
+			SELECT id, revoked_at FROM refresh_tokens
+			WHERE user_id = $1 AND family_id = $2
+		), revoked AS (
+			UPDATE refresh_tokens SET revoked_at = $3
+			WHERE id IN (SELECT id FROM family WHERE revoked_at IS NULL)
+		)
+		SELECT EXISTS (SELECT 1 FROM family)`, userID, familyID, time.Now()).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return models.ErrRefreshTokenNotFound
+	}
+	return nil
+}
+
+// GetActiveByUser возвращает действующие токены пользователя
+func (r *RefreshTokenRepository) GetActiveByUser(userID uint) ([]*models.RefreshToken, error) {
+	rows, err := r.db.Query(`
+		SELECT `+refreshTokenColumns+`
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY created_at`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*models.RefreshToken, 0)
+	for rows.Next() {
+		token, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// insertRefreshToken вставляет токен в транзакции
+func insertRefreshToken(tx *sql.Tx, token *models.RefreshToken) error {
+	_, err := tx.Exec(`
+		INSERT INTO refresh_tokens (`+refreshTokenColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		token.ID, token.UserID, token.DeviceID, token.FamilyID, token.TokenHash,
+		token.CreatedAt, token.ExpiresAt, token.RevokedAt, token.ReplacedBy)
+	if err != nil {
+		return foreignKeyViolation(uniqueViolation(err))
+	}
+	return nil
+}
+
+// scanRefreshToken читает строку refresh_tokens
+func scanRefreshToken(row scanner) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var revokedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.UserID, &token.DeviceID, &token.FamilyID, &token.TokenHash,
+		&token.CreatedAt, &token.ExpiresAt, &revokedAt, &token.ReplacedBy); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+const revisionColumns = `id, message_id, chat_id, content, action, editor_id, edited_at`
+
+// RevisionRepository хранилище ревизий сообщений в PostgreSQL
+type RevisionRepository struct {
+	db *sql.DB
+}
+
+// CreateRevision сохраняет ревизию
+func (r *RevisionRepository) CreateRevision(revision *models.MessageRevision) (*models.MessageRevision, error) {
+	created, err := scanRevision(r.db.QueryRow(`
+		INSERT INTO message_revisions (message_id, chat_id, content, action, editor_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+revisionColumns,
+		revision.MessageID, revision.ChatID, revision.Content, revision.Action, revision.EditorID))
+	if err != nil {
+		return nil, foreignKeyViolation(err)
+	}
+	return created, nil
+}
+
+// GetMessageRevisions возвращает ревизии сообщения от старых к новым
+func (r *RevisionRepository) GetMessageRevisions(messageID uint) ([]models.MessageRevision, error) {
+	rows, err := r.db.Query(`
+		SELECT `+revisionColumns+`
+		FROM message_revisions
+		WHERE message_id = $1
+		ORDER BY id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.MessageRevision, 0)
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+	return revisions, rows.Err()
+}
+
+// DeleteRevisionsBefore удаляет ревизии старше cutoff
+func (r *RevisionRepository) DeleteRevisionsBefore(cutoff time.Time) (int, error) {
+	result, err := r.db.Exec(`DELETE FROM message_revisions WHERE edited_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// ScanContent возвращает непустые тексты ревизий по возрастанию ID
+func (r *RevisionRepository) ScanContent(afterID uint, limit int) ([]models.StoredContent, error) {
+	return queryContent(r.db, `
+		SELECT id, chat_id, message_id, content FROM message_revisions
+		WHERE id > $1 AND content <> ''
+		ORDER BY id
+		LIMIT NULLIF($2, -1)`, afterID, sqlLimit(limit))
+}
+
+// ReplaceContent заменяет текст ревизии, если он не изменился
+func (r *RevisionRepository) ReplaceContent(id uint, old, new string) (bool, error) {
+	return replaceContent(r.db, `UPDATE message_revisions SET content = $3 WHERE id = $1 AND content = $2`, id, old, new)
+}
+
+// queryContent читает тексты запросом, возвращающим id, chat_id, message_id, content
+func queryContent(db *sql.DB, query string, args ...interface{}) ([]models.StoredContent, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contents := make([]models.StoredContent, 0)
+	for rows.Next() {
+		var content models.StoredContent
+		if err := rows.Scan(&content.ID, &content.ChatID, &content.MessageID, &content.Content); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
+
+// replaceContent выполняет условную замену текста и сообщает, была ли она
+func replaceContent(db *sql.DB, query string, id uint, old, new string) (bool, error) {
+	result, err := db.Exec(query, id, old, new)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// scanRevision читает ревизию из строки результата
+func scanRevision(row scanner) (*models.MessageRevision, error) {
+	var revision models.MessageRevision
+	err := row.Scan(&revision.ID, &revision.MessageID, &revision.ChatID, &revision.Content,
+		&revision.Action, &revision.EditorID, &revision.EditedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
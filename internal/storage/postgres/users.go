@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"gomessage/internal/models"
+)
+
+const userColumns = `id, username, email, password, salt, avatar, status, last_seen_at, created_at, updated_at`
+
+// UserRepository хранилище пользователей в PostgreSQL
+type UserRepository struct {
+	db *sql.DB
+}
+
+// CreateUser создает нового пользователя
+func (r *UserRepository) CreateUser(username, email, password, salt string) (*models.User, error) {
+	row := r.db.QueryRow(`
+		INSERT INTO users (username, email, password, salt, avatar, status)
+		VALUES ($1, $2, $3, $4, '', $5)
+		RETURNING `+userColumns,
+		username, email, password, salt, models.UserStatusOnline)
+
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, uniqueViolation(err)
+	}
+	return user, nil
+}
+
+// GetUserByID получает пользователя по ID
+func (r *UserRepository) GetUserByID(id uint) (*models.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+// GetUserByUsername получает пользователя по username
+func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+// GetUserByEmail получает пользователя по email
+func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = $1`, email))
+}
+
+// IsUsernameTaken проверяет, занят ли username
+func (r *UserRepository) IsUsernameTaken(username string) bool {
+	return r.exists(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, username)
+}
+
+// IsEmailTaken проверяет, занят ли email
+func (r *UserRepository) IsEmailTaken(email string) bool {
+	return r.exists(`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email)
+}
+
+// GetAllUsers возвращает всех пользователей (для отладки)
+func (r *UserRepository) GetAllUsers() []*models.User {
+	rows, err := r.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		log.Printf("❌ Ошибка получения пользователей: %v", err)
+		return []*models.User{}
+	}
+	defer rows.Close()
+
+	users := make([]*models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Printf("❌ Ошибка чтения пользователя: %v", err)
+			continue
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// GetUsersCount возвращает количество пользователей
+func (r *UserRepository) GetUsersCount() int {
+	var count int
+	if err := r.db.QueryRow(`SELECT count(*) FROM users`).Scan(&count); err != nil {
+		log.Printf("❌ Ошибка подсчета пользователей: %v", err)
+	}
+	return count
+}
+
+// UpdateUser обновляет данные пользователя
+func (r *UserRepository) UpdateUser(id uint, updates map[string]interface{}) (*models.User, error) {
+	sets := []string{"updated_at = now()"}
+	args := []interface{}{id}
+	for _, column := range []string{"username", "email", "status", "avatar"} {
+		if value, ok := updates[column].(string); ok {
+			args = append(args, value)
+			sets = append(sets, column+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+
+	row := r.db.QueryRow(`UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = $1 RETURNING `+userColumns, args...)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, uniqueViolation(err)
+	}
+	return user, nil
+}
+
+// UpdateLastSeen запоминает время, когда пользователь был в сети последний раз
+func (r *UserRepository) UpdateLastSeen(id uint, at time.Time) error {
+	result, err := r.db.Exec(`UPDATE users SET last_seen_at = $2 WHERE id = $1`, id, at)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdatePassword заменяет хеш пароля пользователя
+func (r *UserRepository) UpdatePassword(id uint, password, salt string) error {
+	result, err := r.db.Exec(`
+		UPDATE users SET password = $2, salt = $3, updated_at = now()
+		WHERE id = $1`, id, password, salt)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
+
+// exists выполняет запрос вида SELECT EXISTS (...)
+func (r *UserRepository) exists(query string, args ...interface{}) bool {
+	var exists bool
+	if err := r.db.QueryRow(query, args...).Scan(&exists); err != nil {
+		log.Printf("❌ Ошибка проверки пользователя: %v", err)
+		return false
+	}
+	return exists
+}
+
+// scanUser читает пользователя из строки результата
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var lastSeenAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Salt,
+		&user.Avatar, &user.Status, &lastSeenAt, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastSeenAt.Valid {
+		user.LastSeenAt = &lastSeenAt.Time
+	}
+	return &user, nil
+}
+
+// uniqueViolation переводит нарушение уникальности в ошибки модели
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_username_key":
+			return models.ErrUsernameTaken
+		case "users_email_key":
+			return models.ErrEmailTaken
+		case "refresh_tokens_pkey", "refresh_tokens_token_hash_key":
+			return models.ErrRefreshTokenExists
+		}
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"fmt"
+
+	"gomessage/internal/config"
+	"gomessage/internal/models"
+	"gomessage/internal/storage/bolt"
+	"gomessage/internal/storage/postgres"
+)
+
+// Драйверы хранилища
+const (
+	DriverMemory   = "memory"
+	DriverPostgres = "postgres"
+	DriverBolt     = "bolt"
+)
+
+// Repositories набор репозиториев выбранного хранилища
+type Repositories struct {
+	Users         models.UserRepository
+	Chats         models.ChatRepository
+	Messages      models.MessageRepository
+	Revisions     models.RevisionRepository
+	Deliveries    models.DeliveryRepository
+	Keys          models.KeyRepository
+	Envelopes     models.EnvelopeRepository
+	RefreshTokens models.RefreshTokenRepository
+	close         func() error
+}
+
+// Open открывает хранилище, выбранное в конфигурации (DB_DRIVER)
+func Open(cfg config.DatabaseConfig) (*Repositories, error) {
+	switch cfg.Driver {
+	case "", DriverMemory:
+		return &Repositories{
+			Users:         models.NewUserStore(),
+			Chats:         models.NewChatStore(),
+			Messages:      models.NewMessageStore(),
+			Revisions:     models.NewRevisionStore(),
+			Deliveries:    models.NewDeliveryStore(),
+			Keys:          models.NewKeyStore(),
+			Envelopes:     models.NewEnvelopeStore(),
+			RefreshTokens: models.NewRefreshTokenStore(),
+			close:         func() error { return nil },
+		}, nil
+
+	case DriverPostgres:
+		store, err := postgres.Open(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &Repositories{
+			Users:         store.Users(),
+			Chats:         store.Chats(),
+			Messages:      store.Messages(),
+			Revisions:     store.Revisions(),
+			Deliveries:    store.Deliveries(),
+			Keys:          store.Keys(),
+			Envelopes:     store.Envelopes(),
+			RefreshTokens: store.RefreshTokens(),
+			close:         store.Close,
+		}, nil
+
+	case DriverBolt:
+		store, err := bolt.Open(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Repositories{
+			Users:         store.Users(),
+			Chats:         store.Chats(),
+			Messages:      store.Messages(),
+			Revisions:     store.Revisions(),
+			Deliveries:    store.Deliveries(),
+			Keys:          store.Keys(),
+			Envelopes:     store.Envelopes(),
+			RefreshTokens: store.RefreshTokens(),
+			close:         store.Close,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("неизвестный драйвер хранилища %q", cfg.Driver)
+	}
+}
+
+// Install делает репозитории глобальными хранилищами приложения
+func (r *Repositories) Install() {
+	models.GlobalUserStore = r.Users
+	models.GlobalChatStore = r.Chats
+	models.GlobalMessageStore = r.Messages
+	models.GlobalRevisionStore = r.Revisions
+	models.GlobalDeliveryStore = r.Deliveries
+	models.GlobalKeyStore = r.Keys
+	models.GlobalEnvelopeStore = r.Envelopes
+	models.GlobalRefreshTokenStore = r.RefreshTokens
+}
+
+// Close закрывает хранилище
+func (r *Repositories) Close() error {
+	return r.close()
+}
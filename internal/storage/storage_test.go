@@ -0,0 +1,20 @@
+package storage_test
+
+import (
+	"testing"
+
+	"gomessage/internal/config"
+	"gomessage/internal/storage"
+	"gomessage/internal/storage/storagetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+		repos, err := storage.Open(config.DatabaseConfig{Driver: storage.DriverMemory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repos.Close() })
+		return repos
+	})
+}
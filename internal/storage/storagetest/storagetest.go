@@ -0,0 +1,765 @@
+// Package storagetest общий набор проверок, которые должна проходить каждая
+// реализация хранилища: in-memory, PostgreSQL и bbolt.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gomessage/internal/models"
+	"gomessage/internal/storage"
+)
+
+// Open открывает чистый набор репозиториев для одного теста. Хранилище
+// закрывается через t.Cleanup. Базу можно не очищать: тесты создают
+// пользователей с уникальными именами и работают только со своими данными.
+type Open func(t *testing.T) *storage.Repositories
+
+// Run прогоняет проверки на хранилище
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repos *storage.Repositories)
+	}{
+		{"Users", testUsers},
+		{"Chats", testChats},
+		{"ReadCursor", testReadCursor},
+		{"Messages", testMessages},
+		{"MessagePages", testMessagePages},
+		{"DeleteMessage", testDeleteMessage},
+		{"ClientMsgID", testClientMsgID},
+		{"Revisions", testRevisions},
+		{"Deliveries", testDeliveries},
+		{"Keys", testKeys},
+		{"Envelopes", testEnvelopes},
+		{"RefreshTokens", testRefreshTokens},
+		{"RefreshTokenReuse", testRefreshTokenReuse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// unique суффикс имен, чтобы тесты не мешали друг другу в общей базе
+func unique() string {
+	return fmt.Sprintf("%x", time.Now().UnixNano())
+}
+
+// createUser создает пользователя с уникальным именем
+func createUser(t *testing.T, repos *storage.Repositories, name string) *models.User {
+	t.Helper()
+	suffix := unique()
+	user, err := repos.Users.CreateUser(name+suffix, name+suffix+"@example.com", "hash", "salt")
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", name, err)
+	}
+	return user
+}
+
+// createChat создает групповой чат с владельцем и участниками
+func createChat(t *testing.T, repos *storage.Repositories, owner *models.User, members ...*models.User) *models.Chat {
+	t.Helper()
+	list := []models.ChatMember{{UserID: owner.ID, Role: models.ChatRoleOwner}}
+	for _, member := range members {
+		list = append(list, models.ChatMember{UserID: member.ID, Role: models.ChatRoleMember})
+	}
+	chat, err := repos.Chats.CreateChat(&models.Chat{
+		Name:         "chat " + unique(),
+		Type:         models.ChatTypeGroup,
+		CreatorID:    owner.ID,
+		ReadReceipts: true,
+	}, list)
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	return chat
+}
+
+// sendMessages отправляет count сообщений в чат
+func sendMessages(t *testing.T, repos *storage.Repositories, chat *models.Chat, sender *models.User, count int) []*models.Message {
+	t.Helper()
+	messages := make([]*models.Message, 0, count)
+	for i := 0; i < count; i++ {
+		message, err := repos.Messages.CreateMessage(&models.Message{
+			Content:  fmt.Sprintf("message %d", i+1),
+			Type:     models.MessageTypeText,
+			SenderID: sender.ID,
+			ChatID:   chat.ID,
+		})
+		if err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// messageIDs ID сообщений по порядку
+func messageIDs(messages []*models.Message) []uint {
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+// expectIDs сравнивает ID сообщений с ожидаемыми
+func expectIDs(t *testing.T, what string, got []*models.Message, want []*models.Message) {
+	t.Helper()
+	if fmt.Sprint(messageIDs(got)) != fmt.Sprint(messageIDs(want)) {
+		t.Fatalf("%s: ID = %v, want %v", what, messageIDs(got), messageIDs(want))
+	}
+}
+
+// expectErr проверяет, что err соответствует want
+func expectErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: err = %v, want %v", what, err, want)
+	}
+}
+
+func testUsers(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	if alice.ID == 0 || alice.CreatedAt.IsZero() {
+		t.Fatalf("created user = %+v", alice)
+	}
+
+	byID, err := repos.Users.GetUserByID(alice.ID)
+	if err != nil || byID.Username != alice.Username || byID.Password != "hash" || byID.Salt != "salt" {
+		t.Fatalf("GetUserByID = %+v, %v", byID, err)
+	}
+	if byName, err := repos.Users.GetUserByUsername(alice.Username); err != nil || byName.ID != alice.ID {
+		t.Fatalf("GetUserByUsername = %+v, %v", byName, err)
+	}
+	if byEmail, err := repos.Users.GetUserByEmail(alice.Email); err != nil || byEmail.ID != alice.ID {
+		t.Fatalf("GetUserByEmail = %+v, %v", byEmail, err)
+	}
+	if !repos.Users.IsUsernameTaken(alice.Username) || !repos.Users.IsEmailTaken(alice.Email) {
+		t.Fatal("username and email of the created user must be taken")
+	}
+	if repos.Users.IsUsernameTaken("nobody" + unique()) {
+		t.Fatal("unknown username is taken")
+	}
+
+	_, err = repos.Users.CreateUser(alice.Username, "other"+unique()+"@example.com", "hash", "")
+	expectErr(t, "duplicate username", err, models.ErrUsernameTaken)
+	_, err = repos.Users.CreateUser("other"+unique(), alice.Email, "hash", "")
+	expectErr(t, "duplicate email", err, models.ErrEmailTaken)
+
+	_, err = repos.Users.GetUserByID(alice.ID + 1000000)
+	expectErr(t, "GetUserByID unknown", err, models.ErrUserNotFound)
+	_, err = repos.Users.GetUserByUsername("nobody" + unique())
+	expectErr(t, "GetUserByUsername unknown", err, models.ErrUserNotFound)
+
+	bob := createUser(t, repos, "bob")
+	oldName := bob.Username
+	_, err = repos.Users.UpdateUser(bob.ID, map[string]interface{}{"username": alice.Username})
+	expectErr(t, "rename to taken username", err, models.ErrUsernameTaken)
+
+	renamed := "bobby" + unique()
+	updated, err := repos.Users.UpdateUser(bob.ID, map[string]interface{}{"username": renamed, "status": "away"})
+	if err != nil || updated.Username != renamed || updated.Status != "away" {
+		t.Fatalf("UpdateUser = %+v, %v", updated, err)
+	}
+	if _, err := repos.Users.GetUserByUsername(oldName); !errors.Is(err, models.ErrUserNotFound) {
+		t.Fatalf("old username still resolves: %v", err)
+	}
+	if byName, err := repos.Users.GetUserByUsername(renamed); err != nil || byName.ID != bob.ID {
+		t.Fatalf("GetUserByUsername(renamed) = %+v, %v", byName, err)
+	}
+
+	seen := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := repos.Users.UpdateLastSeen(bob.ID, seen); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.UpdatePassword(bob.ID, "$argon2id$new", ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repos.Users.GetUserByID(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastSeenAt == nil || !got.LastSeenAt.Equal(seen) {
+		t.Fatalf("LastSeenAt = %v, want %v", got.LastSeenAt, seen)
+	}
+	if got.Password != "$argon2id$new" || got.Salt != "" {
+		t.Fatalf("password = %q, salt = %q", got.Password, got.Salt)
+	}
+
+	expectErr(t, "UpdateLastSeen unknown", repos.Users.UpdateLastSeen(bob.ID+1000000, seen), models.ErrUserNotFound)
+	expectErr(t, "UpdatePassword unknown", repos.Users.UpdatePassword(bob.ID+1000000, "x", ""), models.ErrUserNotFound)
+}
+
+func testChats(t *testing.T, repos *storage.Repositories) {
+	owner := createUser(t, repos, "owner")
+	member := createUser(t, repos, "member")
+	outsider := createUser(t, repos, "outsider")
+
+	chat := createChat(t, repos, owner, member)
+	if chat.ID == 0 || chat.CreatedAt.IsZero() {
+		t.Fatalf("created chat = %+v", chat)
+	}
+
+	got, err := repos.Chats.GetChatByID(chat.ID)
+	if err != nil || got.Name != chat.Name || got.Type != models.ChatTypeGroup || got.CreatorID != owner.ID || !got.ReadReceipts {
+		t.Fatalf("GetChatByID = %+v, %v", got, err)
+	}
+	_, err = repos.Chats.GetChatByID(chat.ID + 1000000)
+	expectErr(t, "GetChatByID unknown", err, models.ErrChatNotFound)
+
+	second := createChat(t, repos, member)
+	chats, err := repos.Chats.GetUserChats(member.ID)
+	if err != nil || len(chats) != 2 || chats[0].ID != chat.ID || chats[1].ID != second.ID {
+		t.Fatalf("GetUserChats = %v, %v", chats, err)
+	}
+	if chats, err := repos.Chats.GetUserChats(outsider.ID); err != nil || len(chats) != 0 {
+		t.Fatalf("GetUserChats(outsider) = %v, %v", chats, err)
+	}
+
+	chat.Name = "renamed"
+	chat.ReadReceipts = false
+	chat.Public = true
+	if err := repos.Chats.UpdateChat(chat); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repos.Chats.GetChatByID(chat.ID); got.Name != "renamed" || got.ReadReceipts || !got.Public {
+		t.Fatalf("after UpdateChat = %+v", got)
+	}
+	expectErr(t, "UpdateChat unknown", repos.Chats.UpdateChat(&models.Chat{ID: chat.ID + 1000000}), models.ErrChatNotFound)
+
+	members, err := repos.Chats.GetMembers(chat.ID)
+	if err != nil || len(members) != 2 || members[0].UserID != owner.ID || members[1].UserID != member.ID {
+		t.Fatalf("GetMembers = %+v, %v", members, err)
+	}
+	if members[0].Role != models.ChatRoleOwner || members[0].JoinedAt.IsZero() {
+		t.Fatalf("owner = %+v", members[0])
+	}
+
+	if err := repos.Chats.AddMember(models.ChatMember{ChatID: chat.ID, UserID: outsider.ID, Role: models.ChatRoleMember}); err != nil {
+		t.Fatal(err)
+	}
+	joined, err := repos.Chats.GetMember(chat.ID, outsider.ID)
+	if err != nil || joined.Role != models.ChatRoleMember {
+		t.Fatalf("GetMember = %+v, %v", joined, err)
+	}
+
+	// Смена роли сохраняет время вступления
+	if err := repos.Chats.AddMember(models.ChatMember{ChatID: chat.ID, UserID: outsider.ID, Role: models.ChatRoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	promoted, err := repos.Chats.GetMember(chat.ID, outsider.ID)
+	if err != nil || promoted.Role != models.ChatRoleAdmin || !promoted.JoinedAt.Equal(joined.JoinedAt) {
+		t.Fatalf("promoted = %+v, joined = %+v, %v", promoted, joined, err)
+	}
+
+	expectErr(t, "AddMember unknown chat",
+		repos.Chats.AddMember(models.ChatMember{ChatID: chat.ID + 1000000, UserID: outsider.ID, Role: models.ChatRoleMember}),
+		models.ErrChatNotFound)
+
+	if err := repos.Chats.RemoveMember(chat.ID, outsider.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repos.Chats.GetMember(chat.ID, outsider.ID)
+	expectErr(t, "GetMember removed", err, models.ErrMemberNotFound)
+	expectErr(t, "RemoveMember twice", repos.Chats.RemoveMember(chat.ID, outsider.ID), models.ErrMemberNotFound)
+}
+
+func testReadCursor(t *testing.T, repos *storage.Repositories) {
+	owner := createUser(t, repos, "owner")
+	reader := createUser(t, repos, "reader")
+	outsider := createUser(t, repos, "outsider")
+	chat := createChat(t, repos, owner, reader)
+
+	at := time.Now().Truncate(time.Second)
+	if moved, err := repos.Chats.UpdateReadCursor(chat.ID, reader.ID, 3, at); err != nil || !moved {
+		t.Fatalf("UpdateReadCursor(3) = %v, %v", moved, err)
+	}
+	if moved, err := repos.Chats.UpdateReadCursor(chat.ID, reader.ID, 2, at); err != nil || moved {
+		t.Fatalf("UpdateReadCursor(2) = %v, %v, cursor must not move back", moved, err)
+	}
+	if moved, err := repos.Chats.UpdateReadCursor(chat.ID, reader.ID, 3, at); err != nil || moved {
+		t.Fatalf("UpdateReadCursor(3) again = %v, %v", moved, err)
+	}
+
+	member, err := repos.Chats.GetMember(chat.ID, reader.ID)
+	if err != nil || member.LastReadSeq != 3 || member.LastReadAt == nil || !member.LastReadAt.Equal(at) {
+		t.Fatalf("member = %+v, %v", member, err)
+	}
+
+	// Смена роли не сбрасывает позицию чтения
+	if err := repos.Chats.AddMember(models.ChatMember{ChatID: chat.ID, UserID: reader.ID, Role: models.ChatRoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if member, _ := repos.Chats.GetMember(chat.ID, reader.ID); member.LastReadSeq != 3 {
+		t.Fatalf("LastReadSeq after role change = %d", member.LastReadSeq)
+	}
+
+	_, err = repos.Chats.UpdateReadCursor(chat.ID, outsider.ID, 1, at)
+	expectErr(t, "UpdateReadCursor outsider", err, models.ErrMemberNotFound)
+}
+
+func testMessages(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	chat := createChat(t, repos, alice, bob)
+	other := createChat(t, repos, alice)
+
+	first := sendMessages(t, repos, chat, alice, 2)
+	sendMessages(t, repos, other, alice, 1)
+	reply, err := repos.Messages.CreateMessage(&models.Message{
+		Content:     "reply",
+		Type:        models.MessageTypeText,
+		SenderID:    bob.ID,
+		ChatID:      chat.ID,
+		ReplyToID:   &first[0].ID,
+		ClientMsgID: "client-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Номера в чате идут подряд и не зависят от других чатов
+	for i, message := range append(first, reply) {
+		if message.Seq != uint64(i+1) {
+			t.Fatalf("message %d seq = %d, want %d", message.ID, message.Seq, i+1)
+		}
+	}
+
+	got, err := repos.Messages.GetMessageByID(reply.ID)
+	if err != nil || got.Content != "reply" || got.SenderID != bob.ID || got.ChatID != chat.ID ||
+		got.ReplyToID == nil || *got.ReplyToID != first[0].ID || got.ClientMsgID != "client-1" || got.Seq != 3 {
+		t.Fatalf("GetMessageByID = %+v, %v", got, err)
+	}
+	_, err = repos.Messages.GetMessageByID(reply.ID + 1000000)
+	expectErr(t, "GetMessageByID unknown", err, models.ErrMessageNotFound)
+
+	byClient, err := repos.Messages.GetMessageByClientID(bob.ID, "client-1")
+	if err != nil || byClient.ID != reply.ID {
+		t.Fatalf("GetMessageByClientID = %+v, %v", byClient, err)
+	}
+	_, err = repos.Messages.GetMessageByClientID(alice.ID, "client-1")
+	expectErr(t, "GetMessageByClientID other sender", err, models.ErrMessageNotFound)
+
+	if unread, err := repos.Messages.CountUnread(chat.ID, 0, bob.ID); err != nil || unread != 2 {
+		t.Fatalf("CountUnread(bob) = %d, %v", unread, err)
+	}
+	if unread, err := repos.Messages.CountUnread(chat.ID, 1, alice.ID); err != nil || unread != 1 {
+		t.Fatalf("CountUnread(alice, 1) = %d, %v", unread, err)
+	}
+
+	got.Content = "edited"
+	got.IsEdited = true
+	if err := repos.Messages.UpdateMessage(got); err != nil {
+		t.Fatal(err)
+	}
+	if edited, _ := repos.Messages.GetMessageByID(reply.ID); edited.Content != "edited" || !edited.IsEdited {
+		t.Fatalf("after UpdateMessage = %+v", edited)
+	}
+	expectErr(t, "UpdateMessage unknown",
+		repos.Messages.UpdateMessage(&models.Message{ID: reply.ID + 1000000, Content: "x"}), models.ErrMessageNotFound)
+}
+
+func testMessagePages(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	chat := createChat(t, repos, alice)
+	messages := sendMessages(t, repos, chat, alice, 6)
+
+	page, err := repos.Messages.GetChatMessages(chat.ID, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "GetChatMessages", page, messages[2:])
+
+	page, err = repos.Messages.GetChatMessagesBefore(chat.ID, messages[4].ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "GetChatMessagesBefore", page, messages[2:4])
+
+	page, err = repos.Messages.GetChatMessagesBefore(chat.ID, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "GetChatMessagesBefore(0)", page, messages[4:])
+
+	page, err = repos.Messages.GetChatMessagesAfter(chat.ID, messages[1].ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "GetChatMessagesAfter", page, messages[2:5])
+
+	page, err = repos.Messages.GetChatMessagesAfterSeq(chat.ID, 4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "GetChatMessagesAfterSeq", page, messages[4:])
+
+	empty := createChat(t, repos, alice)
+	if page, err := repos.Messages.GetChatMessages(empty.ID, 10); err != nil || len(page) != 0 {
+		t.Fatalf("GetChatMessages(empty) = %v, %v", page, err)
+	}
+}
+
+func testDeleteMessage(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	chat := createChat(t, repos, alice, bob)
+	messages := sendMessages(t, repos, chat, alice, 3)
+
+	if err := repos.Messages.DeleteMessage(messages[1].ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectErr(t, "DeleteMessage twice", repos.Messages.DeleteMessage(messages[1].ID, bob.ID), models.ErrMessageNotFound)
+
+	// Удаленное сообщение остается в истории как tombstone без текста
+	deleted, err := repos.Messages.GetMessageByID(messages[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.IsDeleted || deleted.Content != "" || deleted.DeletedAt == nil || deleted.DeletedBy == nil || *deleted.DeletedBy != bob.ID {
+		t.Fatalf("tombstone = %+v", deleted)
+	}
+	page, err := repos.Messages.GetChatMessages(chat.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, "history with tombstone", page, messages)
+
+	if unread, err := repos.Messages.CountUnread(chat.ID, 0, bob.ID); err != nil || unread != 2 {
+		t.Fatalf("CountUnread = %d, %v, deleted message must not count", unread, err)
+	}
+}
+
+func testClientMsgID(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	chat := createChat(t, repos, alice, bob)
+
+	send := func(sender *models.User, clientMsgID string) (*models.Message, error) {
+		return repos.Messages.CreateMessage(&models.Message{
+			Content:     "hello",
+			Type:        models.MessageTypeText,
+			SenderID:    sender.ID,
+			ChatID:      chat.ID,
+			ClientMsgID: clientMsgID,
+		})
+	}
+
+	first, err := send(alice, "c-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = send(alice, "c-1")
+	expectErr(t, "CreateMessage with taken client ID", err, models.ErrDuplicateClientMsgID)
+
+	// Клиентский ID уникален только для отправителя; отказ не тратит номер в чате
+	other, err := send(bob, "c-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Seq != first.Seq+1 {
+		t.Fatalf("seq after rejected duplicate = %d, want %d", other.Seq, first.Seq+1)
+	}
+
+	// Освобождение чужого сообщения ничего не меняет
+	if err := repos.Messages.ReleaseClientMsgID(alice.ID, "c-1", other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repos.Messages.GetMessageByClientID(alice.ID, "c-1"); err != nil || got.ID != first.ID {
+		t.Fatalf("GetMessageByClientID = %+v, %v", got, err)
+	}
+
+	if err := repos.Messages.ReleaseClientMsgID(alice.ID, "c-1", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if released, _ := repos.Messages.GetMessageByID(first.ID); released.ClientMsgID != "" {
+		t.Fatalf("released message keeps client ID %q", released.ClientMsgID)
+	}
+	second, err := send(alice, "c-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repos.Messages.GetMessageByClientID(alice.ID, "c-1"); err != nil || got.ID != second.ID {
+		t.Fatalf("GetMessageByClientID after release = %+v, %v", got, err)
+	}
+}
+
+func testRevisions(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	chat := createChat(t, repos, alice)
+	message := sendMessages(t, repos, chat, alice, 1)[0]
+
+	old := time.Now().Add(-48 * time.Hour)
+	revisions := []models.MessageRevision{
+		{MessageID: message.ID, ChatID: chat.ID, Content: "first", Action: models.RevisionActionEdit, EditorID: alice.ID, EditedAt: old},
+		{MessageID: message.ID, ChatID: chat.ID, Content: "second", Action: models.RevisionActionEdit, EditorID: alice.ID, EditedAt: time.Now()},
+	}
+	for i := range revisions {
+		created, err := repos.Revisions.CreateRevision(&revisions[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == 0 {
+			t.Fatalf("revision without ID: %+v", created)
+		}
+	}
+
+	got, err := repos.Revisions.GetMessageRevisions(message.ID)
+	if err != nil || len(got) != 2 || got[0].Content != "first" || got[1].Content != "second" {
+		t.Fatalf("GetMessageRevisions = %+v, %v", got, err)
+	}
+
+	removed, err := repos.Revisions.DeleteRevisionsBefore(time.Now().Add(-24 * time.Hour))
+	if err != nil || removed < 1 {
+		t.Fatalf("DeleteRevisionsBefore = %d, %v", removed, err)
+	}
+	got, err = repos.Revisions.GetMessageRevisions(message.ID)
+	if err != nil || len(got) != 1 || got[0].Content != "second" {
+		t.Fatalf("after DeleteRevisionsBefore = %+v, %v", got, err)
+	}
+}
+
+func testDeliveries(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	carol := createUser(t, repos, "carol")
+	chat := createChat(t, repos, alice, bob, carol)
+	message := sendMessages(t, repos, chat, alice, 1)[0]
+
+	at := time.Now().Truncate(time.Second)
+	for _, user := range []*models.User{carol, bob} {
+		if added, err := repos.Deliveries.MarkDelivered(message.ID, user.ID, at); err != nil || !added {
+			t.Fatalf("MarkDelivered(%d) = %v, %v", user.ID, added, err)
+		}
+	}
+	if added, err := repos.Deliveries.MarkDelivered(message.ID, bob.ID, at.Add(time.Minute)); err != nil || added {
+		t.Fatalf("MarkDelivered twice = %v, %v", added, err)
+	}
+
+	deliveries, err := repos.Deliveries.GetMessageDeliveries(message.ID)
+	if err != nil || len(deliveries) != 2 || deliveries[0].UserID != bob.ID || deliveries[1].UserID != carol.ID {
+		t.Fatalf("GetMessageDeliveries = %+v, %v", deliveries, err)
+	}
+	if !deliveries[0].DeliveredAt.Equal(at) {
+		t.Fatalf("DeliveredAt = %v, want the first delivery %v", deliveries[0].DeliveredAt, at)
+	}
+}
+
+func testKeys(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+
+	_, err := repos.Keys.GetDeviceKeys(alice.ID, "phone")
+	expectErr(t, "GetDeviceKeys unknown", err, models.ErrDeviceKeysNotFound)
+
+	keys := models.DeviceKeys{
+		UserID:                alice.ID,
+		DeviceID:              "phone",
+		IdentityKey:           []byte("identity-1"),
+		SigningKey:            []byte("signing"),
+		SignedPreKeyID:        1,
+		SignedPreKey:          []byte("signed-prekey"),
+		SignedPreKeySignature: []byte("signature"),
+		UpdatedAt:             time.Now().Truncate(time.Second),
+	}
+	if err := repos.Keys.SaveDeviceKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	laptop := keys
+	laptop.DeviceID = "laptop"
+	if err := repos.Keys.SaveDeviceKeys(laptop); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repos.Keys.GetDeviceKeys(alice.ID, "phone")
+	if err != nil || string(got.IdentityKey) != "identity-1" || string(got.SignedPreKeySignature) != "signature" || got.SignedPreKeyID != 1 {
+		t.Fatalf("GetDeviceKeys = %+v, %v", got, err)
+	}
+	devices, err := repos.Keys.GetUserDeviceKeys(alice.ID)
+	if err != nil || len(devices) != 2 || devices[0].DeviceID != "laptop" || devices[1].DeviceID != "phone" {
+		t.Fatalf("GetUserDeviceKeys = %+v, %v", devices, err)
+	}
+
+	prekeys := []models.OneTimePreKey{{KeyID: 3, PublicKey: []byte("c")}, {KeyID: 1, PublicKey: []byte("a")}, {KeyID: 2, PublicKey: []byte("b")}}
+	if err := repos.Keys.AddOneTimePreKeys(alice.ID, "phone", prekeys); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := repos.Keys.CountOneTimePreKeys(alice.ID, "phone"); err != nil || count != 3 {
+		t.Fatalf("CountOneTimePreKeys = %d, %v", count, err)
+	}
+	taken, err := repos.Keys.TakeOneTimePreKey(alice.ID, "phone")
+	if err != nil || taken == nil || taken.KeyID != 1 || string(taken.PublicKey) != "a" {
+		t.Fatalf("TakeOneTimePreKey = %+v, %v", taken, err)
+	}
+	if count, _ := repos.Keys.CountOneTimePreKeys(alice.ID, "phone"); count != 2 {
+		t.Fatalf("CountOneTimePreKeys after take = %d", count)
+	}
+	if count, _ := repos.Keys.CountOneTimePreKeys(alice.ID, "laptop"); count != 0 {
+		t.Fatalf("prekeys leaked to another device: %d", count)
+	}
+	if taken, err := repos.Keys.TakeOneTimePreKey(alice.ID, "laptop"); err != nil || taken != nil {
+		t.Fatalf("TakeOneTimePreKey(empty) = %+v, %v", taken, err)
+	}
+
+	// Тот же identity ключ сохраняет одноразовые ключи, новый - удаляет их
+	keys.SignedPreKeyID = 2
+	if err := repos.Keys.SaveDeviceKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := repos.Keys.CountOneTimePreKeys(alice.ID, "phone"); count != 2 {
+		t.Fatalf("prekeys after signed prekey rotation = %d, want 2", count)
+	}
+	keys.IdentityKey = []byte("identity-2")
+	if err := repos.Keys.SaveDeviceKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := repos.Keys.CountOneTimePreKeys(alice.ID, "phone"); count != 0 {
+		t.Fatalf("prekeys after identity change = %d, want 0", count)
+	}
+}
+
+func testEnvelopes(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	chat := createChat(t, repos, alice, bob)
+	messages := sendMessages(t, repos, chat, alice, 2)
+
+	envelopes := []models.Envelope{
+		{MessageID: messages[1].ID, UserID: bob.ID, DeviceID: "phone", SenderDeviceID: "laptop", Ciphertext: []byte("m2-phone")},
+		{MessageID: messages[0].ID, UserID: bob.ID, DeviceID: "tablet", SenderDeviceID: "laptop", Ciphertext: []byte("m1-tablet")},
+		{MessageID: messages[0].ID, UserID: bob.ID, DeviceID: "phone", SenderDeviceID: "laptop", Ciphertext: []byte("m1-phone")},
+		{MessageID: messages[0].ID, UserID: alice.ID, DeviceID: "phone", SenderDeviceID: "laptop", Ciphertext: []byte("m1-alice")},
+	}
+	if err := repos.Envelopes.SaveEnvelopes(envelopes); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repos.Envelopes.GetUserEnvelopes(bob.ID, []uint{messages[0].ID, messages[1].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, envelope := range got {
+		order = append(order, string(envelope.Ciphertext))
+	}
+	if fmt.Sprint(order) != "[m1-phone m1-tablet m2-phone]" {
+		t.Fatalf("GetUserEnvelopes = %v", order)
+	}
+
+	if err := repos.Envelopes.DeleteMessageEnvelopes(messages[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = repos.Envelopes.GetUserEnvelopes(bob.ID, []uint{messages[0].ID, messages[1].ID})
+	if err != nil || len(got) != 1 || string(got[0].Ciphertext) != "m2-phone" {
+		t.Fatalf("after DeleteMessageEnvelopes = %+v, %v", got, err)
+	}
+}
+
+// refreshToken запись refresh токена устройства в семье
+func refreshToken(user *models.User, deviceID, familyID string, ttl time.Duration) *models.RefreshToken {
+	id := unique()
+	now := time.Now()
+	return &models.RefreshToken{
+		ID:        "id-" + id,
+		UserID:    user.ID,
+		DeviceID:  deviceID,
+		FamilyID:  familyID,
+		TokenHash: "hash-" + id,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+func testRefreshTokens(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	family := "family-" + unique()
+
+	first := refreshToken(alice, "phone", family, time.Hour)
+	if err := repos.RefreshTokens.Create(first); err != nil {
+		t.Fatal(err)
+	}
+	expectErr(t, "Create duplicate hash", repos.RefreshTokens.Create(first), models.ErrRefreshTokenExists)
+
+	next := refreshToken(alice, "", "", time.Hour)
+	old, err := repos.RefreshTokens.Rotate(first.TokenHash, next)
+	if err != nil || old.ID != first.ID || old.RevokedAt == nil || old.ReplacedBy != next.ID {
+		t.Fatalf("Rotate = %+v, %v", old, err)
+	}
+	if next.UserID != alice.ID || next.DeviceID != "phone" || next.FamilyID != family {
+		t.Fatalf("next token must join the family: %+v", next)
+	}
+
+	active, err := repos.RefreshTokens.GetActiveByUser(alice.ID)
+	if err != nil || len(active) != 1 || active[0].ID != next.ID || active[0].TokenHash != next.TokenHash {
+		t.Fatalf("GetActiveByUser = %+v, %v", active, err)
+	}
+
+	_, err = repos.RefreshTokens.Rotate("hash-unknown-"+unique(), refreshToken(alice, "", "", time.Hour))
+	expectErr(t, "Rotate unknown", err, models.ErrRefreshTokenNotFound)
+
+	expired := refreshToken(alice, "tablet", "family-"+unique(), -time.Minute)
+	if err := repos.RefreshTokens.Create(expired); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repos.RefreshTokens.Rotate(expired.TokenHash, refreshToken(alice, "", "", time.Hour))
+	if err != nil && !errors.Is(err, models.ErrRefreshTokenExpired) && !errors.Is(err, models.ErrRefreshTokenNotFound) {
+		t.Fatalf("Rotate expired: %v", err)
+	}
+	if err == nil {
+		t.Fatal("expired token rotated")
+	}
+
+	// Новый вход с того же устройства отзывает прежнюю сессию
+	relogin := refreshToken(alice, "phone", "family-"+unique(), time.Hour)
+	if err := repos.RefreshTokens.Create(relogin); err != nil {
+		t.Fatal(err)
+	}
+	active, err = repos.RefreshTokens.GetActiveByUser(alice.ID)
+	if err != nil || len(active) != 1 || active[0].ID != relogin.ID {
+		t.Fatalf("GetActiveByUser after relogin = %+v, %v", active, err)
+	}
+
+	laptop := refreshToken(alice, "laptop", "family-"+unique(), time.Hour)
+	if err := repos.RefreshTokens.Create(laptop); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := repos.RefreshTokens.GetActiveByUser(alice.ID); len(active) != 2 {
+		t.Fatalf("sessions on two devices = %+v", active)
+	}
+
+	bob := createUser(t, repos, "bob")
+	expectErr(t, "RevokeFamily of another user", repos.RefreshTokens.RevokeFamily(bob.ID, laptop.FamilyID), models.ErrRefreshTokenNotFound)
+	if err := repos.RefreshTokens.RevokeFamily(alice.ID, laptop.FamilyID); err != nil {
+		t.Fatal(err)
+	}
+	active, err = repos.RefreshTokens.GetActiveByUser(alice.ID)
+	if err != nil || len(active) != 1 || active[0].ID != relogin.ID {
+		t.Fatalf("GetActiveByUser after RevokeFamily = %+v, %v", active, err)
+	}
+}
+
+func testRefreshTokenReuse(t *testing.T, repos *storage.Repositories) {
+	alice := createUser(t, repos, "alice")
+	first := refreshToken(alice, "phone", "family-"+unique(), time.Hour)
+	if err := repos.RefreshTokens.Create(first); err != nil {
+		t.Fatal(err)
+	}
+	second := refreshToken(alice, "", "", time.Hour)
+	if _, err := repos.RefreshTokens.Rotate(first.TokenHash, second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Повторный обмен уже замененного токена отзывает всю семью
+	_, err := repos.RefreshTokens.Rotate(first.TokenHash, refreshToken(alice, "", "", time.Hour))
+	expectErr(t, "Rotate reused", err, models.ErrRefreshTokenReused)
+	if active, err := repos.RefreshTokens.GetActiveByUser(alice.ID); err != nil || len(active) != 0 {
+		t.Fatalf("GetActiveByUser after reuse = %+v, %v", active, err)
+	}
+	_, err = repos.RefreshTokens.Rotate(second.TokenHash, refreshToken(alice, "", "", time.Hour))
+	expectErr(t, "Rotate revoked successor", err, models.ErrRefreshTokenReused)
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"gomessage/internal/middleware"
+	"gomessage/internal/models"
+)
+
+// Subprotocol протокол, который сервер выбирает при аутентификации через
+// Sec-WebSocket-Protocol. Клиент предлагает его вместе с "bearer.<токен>".
+const Subprotocol = "gomessage"
+
+// bearerProtocolPrefix префикс подпротокола с токеном в base64url без паддинга
+const bearerProtocolPrefix = "bearer."
+
+// CloseTokenExpired код закрытия соединения, когда истек токен клиента
+const CloseTokenExpired = 4001
+
+var (
+	// errNoCredentials клиент не передал ни тикет, ни токен
+	errNoCredentials = errors.New("credentials required")
+	// errUserNotFound пользователь удален после выдачи токена
+	errUserNotFound = errors.New("user not found")
+)
+
+// authenticate проверяет учетные данные клиента и что пользователь
+// по-прежнему существует
+func authenticate(r *http.Request, tokens *middleware.TokenService) (*middleware.Claims, error) {
+	claims, err := parseCredentials(r, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Пользователь мог быть удален после выдачи токена
+	user, err := models.GlobalUserStore.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, errUserNotFound
+	}
+	claims.Username = user.Username
+	return claims, nil
+}
+
+// authErrorCode код ошибки аутентификации для клиента, как в middleware.Auth
+func authErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		return "token_missing"
+	case errors.Is(err, errUserNotFound):
+		return "user_not_found"
+	case errors.Is(err, middleware.ErrTokenExpired):
+		return "token_expired"
+	case errors.Is(err, middleware.ErrTokenSignature), errors.Is(err, middleware.ErrTokenClaims),
+		errors.Is(err, middleware.ErrTicketInvalid):
+		return "token_invalid"
+	default:
+		return "token_malformed"
+	}
+}
+
+// rejectUnauthorized отвечает на запрос подключения ошибкой авторизации с
+// кодом для клиента
+func rejectUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+		"code":  authErrorCode(err),
+	})
+}
+
+// parseCredentials проверяет учетные данные клиента. Поддерживаются
+// одноразовый тикет (?ticket=), заголовок Authorization и подпротокол
+// "bearer.<токен>".
+func parseCredentials(r *http.Request, tokens *middleware.TokenService) (*middleware.Claims, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return middleware.GlobalTicketStore.Redeem(ticket)
+	}
+
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+			return nil, middleware.ErrTokenMalformed
+		}
+		return tokens.Parse(parts[1])
+	}
+
+	for _, protocol := range websocket.Subprotocols(r) {
+		if !strings.HasPrefix(protocol, bearerProtocolPrefix) {
+			continue
+		}
+		token, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(protocol, bearerProtocolPrefix))
+		if err != nil {
+			return nil, middleware.ErrTokenMalformed
+		}
+		return tokens.Parse(string(token))
+	}
+
+	return nil, errNoCredentials
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gomessage/internal/config"
+	"gomessage/internal/middleware"
+	"gomessage/internal/models"
+)
+
+func TestServeWebSocketRejectsCredentials(t *testing.T) {
+	users, tickets := models.GlobalUserStore, middleware.GlobalTicketStore
+	t.Cleanup(func() { models.GlobalUserStore, middleware.GlobalTicketStore = users, tickets })
+	models.GlobalUserStore = models.NewUserStore()
+	middleware.GlobalTicketStore = middleware.NewMemoryTicketStore()
+
+	tokens, err := middleware.NewTokenService(config.JWTConfig{
+		SecretKey: "0123456789abcdef0123456789abcdef",
+		ExpiresIn: 1,
+		Issuer:    "gomessage",
+		Audience:  "gomessage",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := models.GlobalUserStore.CreateUser("alice", "alice@example.com", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := tokens.Issue(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghost := &models.User{ID: user.ID + 100, Username: "ghost"}
+	deleted, err := tokens.Issue(ghost, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedClaims, err := tokens.Parse(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedTicket, err := middleware.GlobalTicketStore.Issue(*deletedClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		header   string
+		protocol string
+		code     string
+	}{
+		{"no credentials", "/ws", "", "", "token_missing"},
+		{"malformed header", "/ws", "Bearer", "", "token_malformed"},
+		{"bad token", "/ws", "Bearer abc", "", "token_malformed"},
+		{"unknown ticket", "/ws?ticket=unknown", "", "", "token_invalid"},
+		{"deleted user by header", "/ws", "Bearer " + deleted, "", "user_not_found"},
+		{"deleted user by protocol", "/ws", "", bearerProtocolPrefix + base64.RawURLEncoding.EncodeToString([]byte(deleted)), "user_not_found"},
+		{"deleted user by ticket", "/ws?ticket=" + deletedTicket, "", "", "user_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.protocol != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", Subprotocol+", "+tt.protocol)
+			}
+			rec := httptest.NewRecorder()
+			ServeWebSocket(nil, tokens, rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["code"] != tt.code {
+				t.Fatalf("code = %q, want %q", body["code"], tt.code)
+			}
+		})
+	}
+
+	// Имя берется из хранилища, а не из токена
+	if _, err := models.GlobalUserStore.UpdateUser(user.ID, map[string]interface{}{"username": "alice2"}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+valid)
+	claims, err := authenticate(req, tokens)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if claims.UserID != user.ID || claims.Username != "alice2" {
+		t.Fatalf("claims = %+v", claims)
+	}
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"gomessage/internal/presence"
+)
+
+// ErrBackplaneClosed backplane закрыт
+var ErrBackplaneClosed = errors.New("backplane is closed")
+
+// Виды событий, которыми узлы обмениваются через backplane
+const (
+	backplaneChat        = "chat"        // кадр для участников чата
+	backplaneUser        = "user"        // кадр для всех соединений пользователя
+	backplaneUsers       = "users"       // кадр для всех соединений нескольких пользователей
+	backplaneSubscribe   = "subscribe"   // пользователь вступил в чат
+	backplaneUnsubscribe = "unsubscribe" // пользователь вышел из чата
+	backplanePresence    = "presence"    // состояние пользователя на узле-отправителе
+)
+
+// BackplaneEvent событие Hub, пересылаемое между экземплярами сервера.
+// Node - узел-отправитель: свои события узел уже доставил локально.
+// В событии chat UserID - пользователь, которому кадр не доставляется.
+type BackplaneEvent struct {
+	Node      string          `json:"node"`
+	Kind      string          `json:"kind"`
+	ChatID    uint            `json:"chat_id,omitempty"`
+	UserID    uint            `json:"user_id,omitempty"`
+	UserIDs   []uint          `json:"user_ids,omitempty"`
+	State     presence.State  `json:"state,omitempty"`
+	Droppable bool            `json:"droppable,omitempty"` // кадр можно вытеснить из очереди клиента
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Backplane шина между экземплярами сервера. Publish рассылает событие
+// всем подписчикам, включая сам узел-отправитель; handler вызывается
+// последовательно в порядке публикации.
+type Backplane interface {
+	Publish(event BackplaneEvent) error
+	Subscribe(handler func(BackplaneEvent)) error
+	Close() error
+}
+
+// newNodeID случайный ID экземпляра сервера
+func newNodeID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// memoryBackplaneBuffer размер очереди событий одного подписчика
+const memoryBackplaneBuffer = 256
+
+// MemoryBackplane backplane внутри одного процесса: связывает несколько
+// Hub без внешних сервисов, например в тестах
+type MemoryBackplane struct {
+	subscribers []chan BackplaneEvent
+	closed      bool
+	mutex       sync.RWMutex
+}
+
+// NewMemoryBackplane создает backplane в памяти
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{}
+}
+
+// Publish кладет событие в очередь каждого подписчика
+func (b *MemoryBackplane) Publish(event BackplaneEvent) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrBackplaneClosed
+	}
+	for _, events := range b.subscribers {
+		events <- event
+	}
+	return nil
+}
+
+// Subscribe запускает доставку событий в handler
+func (b *MemoryBackplane) Subscribe(handler func(BackplaneEvent)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return ErrBackplaneClosed
+	}
+	events := make(chan BackplaneEvent, memoryBackplaneBuffer)
+	b.subscribers = append(b.subscribers, events)
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+// Close останавливает доставку событий
+func (b *MemoryBackplane) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, events := range b.subscribers {
+		close(events)
+	}
+	b.subscribers = nil
+	return nil
+}
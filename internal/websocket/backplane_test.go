@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gomessage/internal/config"
+)
+
+// probeUserID пользователь, через которого тест дожидается доставки
+// событий backplane
+const probeUserID = 999
+
+// startNode запускает Hub, подключенный к backplane
+func startNode(t *testing.T, backplane Backplane) *Hub {
+	t.Helper()
+	hub := NewHub()
+	if err := hub.SetBackplane(backplane); err != nil {
+		t.Fatalf("SetBackplane: %v", err)
+	}
+	go hub.Run()
+	return hub
+}
+
+// flush дожидается, пока to обработает все события, опубликованные from
+// до вызова: события одного подписчика доставляются по порядку
+func flush(t *testing.T, from *Hub, probe *Client) {
+	t.Helper()
+	marker := fmt.Sprintf("flush-%d", time.Now().UnixNano())
+	from.SendToUser(probeUserID, []byte(marker))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		frames, _ := probe.queue.drain()
+		for _, data := range frames {
+			if string(data) == marker {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("backplane event was not delivered")
+}
+
+// testBackplaneFanOut проверяет доставку между двумя узлами; connect
+// возвращает backplane для очередного узла
+func testBackplaneFanOut(t *testing.T, connect func(t *testing.T) Backplane) {
+	const chatID = 1
+
+	first := startNode(t, connect(t))
+	second := startNode(t, connect(t))
+
+	alice := newTestClient(t, first, 1)
+	bob := newTestClient(t, second, 2)
+	firstProbe := newTestClient(t, first, probeUserID)
+	secondProbe := newTestClient(t, second, probeUserID)
+	settle := func() {
+		t.Helper()
+		flush(t, first, secondProbe)
+		flush(t, second, firstProbe)
+	}
+
+	// Подписка расходится по узлам, но только туда, где пользователь подключен
+	first.AddUserToChat(alice.UserID, chatID)
+	first.AddUserToChat(bob.UserID, chatID)
+	first.AddUserToChat(3, chatID)
+	settle()
+	if !second.IsSubscribed(bob.UserID, chatID) {
+		t.Fatal("bob is not subscribed on the second node")
+	}
+	if second.IsSubscribed(3, chatID) || second.IsSubscribed(alice.UserID, chatID) {
+		t.Fatal("node subscribed users that are not connected to it")
+	}
+
+	tests := []struct {
+		name       string
+		send       func()
+		alice, bob []string
+	}{
+		{"chat from first node", func() { first.BroadcastToChat(chatID, []byte("hello")) }, []string{"hello"}, []string{"hello"}},
+		{"chat from second node", func() { second.BroadcastToChat(chatID, []byte("hi")) }, []string{"hi"}, []string{"hi"}},
+		{"chat except sender", func() { second.broadcastToChatExcept(chatID, bob.UserID, frame{data: []byte("typing")}) }, []string{"typing"}, nil},
+		{"user on other node", func() { first.SendToUser(bob.UserID, []byte("direct")) }, nil, []string{"direct"}},
+		{"user on same node", func() { first.SendToUser(alice.UserID, []byte("self")) }, []string{"self"}, nil},
+		{"several users", func() { second.SendToUsers([]uint{alice.UserID, bob.UserID}, []byte("both")) }, []string{"both"}, []string{"both"}},
+		{"local users only", func() { first.SendToLocalUsers([]uint{alice.UserID, bob.UserID}, []byte("local")) }, []string{"local"}, nil},
+		{"other chat", func() { first.BroadcastToChat(chatID+1, []byte("elsewhere")) }, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.send()
+			settle()
+			// Свое событие, вернувшееся через backplane, не доставляется второй раз
+			expectFrames(t, alice.queue, tt.alice...)
+			expectFrames(t, bob.queue, tt.bob...)
+		})
+	}
+
+	// Отписка тоже расходится по узлам
+	first.RemoveUserFromChat(bob.UserID, chatID)
+	first.BroadcastToChat(chatID, []byte("after leave"))
+	settle()
+	if second.IsSubscribed(bob.UserID, chatID) {
+		t.Fatal("bob is still subscribed on the second node")
+	}
+	expectFrames(t, alice.queue, "after leave")
+	expectFrames(t, bob.queue)
+}
+
+func TestMemoryBackplaneFanOut(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	t.Cleanup(func() { backplane.Close() })
+	testBackplaneFanOut(t, func(*testing.T) Backplane { return backplane })
+}
+
+func TestHubIgnoresOwnBackplaneEvents(t *testing.T) {
+	hub := startHub(t)
+	alice := newTestClient(t, hub, 1)
+	hub.subscribe(alice.UserID, 1)
+
+	hub.receive(BackplaneEvent{Node: hub.node, Kind: backplaneChat, ChatID: 1, Data: []byte("echo")})
+	hub.receive(BackplaneEvent{Node: hub.node, Kind: backplaneUser, UserID: alice.UserID, Data: []byte("echo")})
+	hub.receive(BackplaneEvent{Node: hub.node, Kind: backplaneUnsubscribe, UserID: alice.UserID, ChatID: 1})
+	expectFrames(t, alice.queue)
+	if !hub.IsSubscribed(alice.UserID, 1) {
+		t.Fatal("own unsubscribe event was applied")
+	}
+
+	hub.receive(BackplaneEvent{Node: "other", Kind: backplaneUser, UserID: alice.UserID, Data: []byte("remote")})
+	expectFrames(t, alice.queue, "remote")
+}
+
+func TestMemoryBackplaneClosed(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	if err := backplane.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backplane.Publish(BackplaneEvent{Kind: backplaneUser}); err != ErrBackplaneClosed {
+		t.Fatalf("Publish = %v, want %v", err, ErrBackplaneClosed)
+	}
+	if err := backplane.Subscribe(func(BackplaneEvent) {}); err != ErrBackplaneClosed {
+		t.Fatalf("Subscribe = %v, want %v", err, ErrBackplaneClosed)
+	}
+}
+
+// TestRedisBackplaneFanOut работает с Redis из переменных REDIS_HOST,
+// REDIS_PORT, REDIS_PASSWORD, REDIS_DB и запускается, только если задана
+// GOMESSAGE_TEST_REDIS=1
+func TestRedisBackplaneFanOut(t *testing.T) {
+	if os.Getenv("GOMESSAGE_TEST_REDIS") == "" {
+		t.Skip("GOMESSAGE_TEST_REDIS не задана")
+	}
+
+	cfg := config.Load().Redis
+	testBackplaneFanOut(t, func(t *testing.T) Backplane {
+		backplane, err := NewRedisBackplane(cfg.Host+":"+cfg.Port, cfg.Password, cfg.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { backplane.Close() })
+		return backplane
+	})
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestHubConcurrentFanOut подключает, подписывает и отключает клиентов
+// одновременно с рассылкой; запускайте с -race
+func TestHubConcurrentFanOut(t *testing.T) {
+	const (
+		workers    = 8
+		iterations = 50
+		chatID     = 1
+	)
+
+	hub := NewHub()
+	hub.SetQueueLimits(2*workers*iterations, 0)
+	go hub.Run()
+
+	// Наблюдатель подключен все время и получает каждый кадр чата
+	watcher := newTestClient(t, hub, 1000)
+	hub.AddUserToChat(watcher.UserID, chatID)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		userID := uint(w + 1)
+		wg.Add(2)
+
+		// Клиенты пользователя подключаются, входят в чат и отключаются
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				client := newTestClient(t, hub, userID)
+				hub.AddUserToChat(userID, chatID)
+				hub.IsSubscribed(userID, chatID)
+				client.queue.drain()
+				hub.RemoveUserFromChat(userID, chatID)
+				hub.unregister <- client
+			}
+		}()
+
+		// Рассылка в чат, пользователю и нескольким пользователям
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				hub.BroadcastToChat(chatID, []byte("chat"))
+				hub.SendToUser(userID, []byte("user"))
+				hub.SendToUsers([]uint{userID, watcher.UserID}, []byte("users"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	frames, closed := watcher.queue.drain()
+	if closed {
+		t.Fatal("watcher queue is closed")
+	}
+	if want := 2 * workers * iterations; len(frames) != want {
+		t.Fatalf("watcher got %d frames, want %d", len(frames), want)
+	}
+
+	// Run обрабатывает запросы по очереди: когда учтен новый клиент,
+	// все отключения уже обработаны
+	hub.unregister <- watcher
+	newTestClient(t, hub, watcher.UserID)
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	if len(hub.clients) != 1 {
+		t.Fatalf("hub has %d clients, want 1", len(hub.clients))
+	}
+	for userID := uint(1); userID <= workers; userID++ {
+		if hub.userChats[userID][chatID] {
+			t.Fatalf("user %d is still subscribed", userID)
+		}
+	}
+}
+
+// TestHubSkipsClosedQueues рассылка не блокируется на отключенных клиентах
+func TestHubSkipsClosedQueues(t *testing.T) {
+	hub := startHub(t)
+	alice := newTestClient(t, hub, 1)
+	bob := newTestClient(t, hub, 2)
+	hub.AddUserToChat(alice.UserID, 1)
+	hub.AddUserToChat(bob.UserID, 1)
+
+	// Клиент, признанный медленным, остается в Hub до unregister
+	bob.queue.close()
+	hub.BroadcastToChat(1, []byte("hello"))
+
+	frames, _ := alice.queue.drain()
+	if len(frames) != 1 || string(frames[0]) != "hello" {
+		t.Fatalf("alice got %q", frames)
+	}
+	if _, closed := bob.queue.drain(); !closed {
+		t.Fatal("bob queue reopened")
+	}
+}
@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// Значения по умолчанию для очереди отправки клиента
+const (
+	defaultQueueSize   = 256
+	defaultSlowTimeout = 10 * time.Second
+)
+
+// CloseSlowConsumer код закрытия соединения, когда клиент не успевает
+// принимать кадры
+const CloseSlowConsumer = 4002
+
+// frame кадр для клиента. Droppable кадры (набор текста) при переполнении
+// очереди вытесняются первыми: следующий такой кадр их заменит.
+type frame struct {
+	data      []byte
+	droppable bool
+}
+
+// sendQueue ограниченная очередь кадров одного клиента. Кадры кладут
+// Hub и обработчики запросов, забирает writePump.
+//
+// Когда в очереди size кадров, новый кадр вытесняет самый старый
+// droppable; если таких нет, новый droppable кадр отбрасывается, а важный
+// принимается сверх размера. Если очередь не разгружается дольше
+// slowTimeout или вырастает вдвое, клиент считается медленным: очередь
+// закрывается, а соединение - с кодом CloseSlowConsumer. Пропущенные
+// сообщения клиент докачивает через resume.
+type sendQueue struct {
+	frames      []frame
+	size        int
+	slowTimeout time.Duration
+	fullSince   time.Time     // с какого момента очередь переполнена
+	wake        chan struct{} // сигнал writePump, что в очереди есть кадры
+	closed      bool
+	slow        bool // закрыта из-за медленного клиента
+	mutex       sync.Mutex
+}
+
+// newSendQueue создает очередь на size кадров
+func newSendQueue(size int, slowTimeout time.Duration) *sendQueue {
+	return &sendQueue{
+		frames:      make([]frame, 0, size),
+		size:        size,
+		slowTimeout: slowTimeout,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// push кладет кадр в очередь; false - очередь закрыта или клиент
+// только что признан медленным
+func (q *sendQueue) push(f frame) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	if len(q.frames) >= q.size {
+		if !q.evictDroppable() {
+			if f.droppable {
+				return true
+			}
+
+			now := time.Now()
+			if q.fullSince.IsZero() {
+				q.fullSince = now
+			}
+			if now.Sub(q.fullSince) > q.slowTimeout || len(q.frames) >= 2*q.size {
+				q.slow = true
+				q.closeLocked()
+				return false
+			}
+		}
+	}
+
+	q.frames = append(q.frames, f)
+	q.signal()
+	return true
+}
+
+// evictDroppable удаляет самый старый droppable кадр; вызывается под блокировкой
+func (q *sendQueue) evictDroppable() bool {
+	for i, queued := range q.frames {
+		if queued.droppable {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// drain забирает все кадры из очереди. После закрытия кадры больше не
+// отдаются, closed = true.
+func (q *sendQueue) drain() (frames [][]byte, closed bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, true
+	}
+
+	frames = make([][]byte, len(q.frames))
+	for i, queued := range q.frames {
+		frames[i] = queued.data
+	}
+	q.frames = q.frames[:0]
+	q.fullSince = time.Time{}
+	return frames, false
+}
+
+// close закрывает очередь; повторные вызовы ничего не делают
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closeLocked()
+}
+
+// closeLocked закрывает очередь и будит writePump; вызывается под блокировкой
+func (q *sendQueue) closeLocked() {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.frames = nil
+	q.signal()
+}
+
+// isSlow проверяет, закрыта ли очередь из-за медленного клиента
+func (q *sendQueue) isSlow() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.slow
+}
+
+// signal будит writePump, не дожидаясь его
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
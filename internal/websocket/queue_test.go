@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// important кадр, который нельзя вытеснить
+func important(name string) frame {
+	return frame{data: []byte(name)}
+}
+
+// droppable кадр, который вытесняется первым
+func droppable(name string) frame {
+	return frame{data: []byte(name), droppable: true}
+}
+
+// expectFrames забирает кадры из очереди и сравнивает их с want
+func expectFrames(t *testing.T, q *sendQueue, want ...string) {
+	t.Helper()
+	frames, closed := q.drain()
+	if closed {
+		t.Fatal("queue is closed")
+	}
+	got := make([]string, len(frames))
+	for i, data := range frames {
+		got[i] = string(data)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("frames = %v, want %v", got, want)
+	}
+}
+
+func TestSendQueueEvictsOldestDroppable(t *testing.T) {
+	q := newSendQueue(3, time.Minute)
+	for _, f := range []frame{droppable("typing-1"), important("msg-1"), droppable("typing-2"), important("msg-2")} {
+		if !q.push(f) {
+			t.Fatalf("push %s rejected", f.data)
+		}
+	}
+	expectFrames(t, q, "msg-1", "typing-2", "msg-2")
+
+	// Вытесняется и droppable кадр, если новый тоже droppable
+	for _, f := range []frame{droppable("typing-1"), important("msg-1"), important("msg-2"), droppable("typing-2")} {
+		q.push(f)
+	}
+	expectFrames(t, q, "msg-1", "msg-2", "typing-2")
+}
+
+func TestSendQueueDropsDroppableWhenFull(t *testing.T) {
+	q := newSendQueue(2, time.Minute)
+	q.push(important("msg-1"))
+	q.push(important("msg-2"))
+
+	if !q.push(droppable("typing")) {
+		t.Fatal("dropped frame closed the queue")
+	}
+	// Важный кадр принимается сверх размера
+	if !q.push(important("msg-3")) {
+		t.Fatal("push msg-3 rejected")
+	}
+	expectFrames(t, q, "msg-1", "msg-2", "msg-3")
+	if q.isSlow() {
+		t.Fatal("queue is slow")
+	}
+}
+
+func TestSendQueueClosesSlowConsumer(t *testing.T) {
+	tests := []struct {
+		name        string
+		slowTimeout time.Duration
+		wait        time.Duration // пауза перед последним кадром
+		pushes      int           // сколько важных кадров принимается
+	}{
+		{"grows to twice the size", time.Minute, 0, 4},
+		{"stays full longer than slowTimeout", 50 * time.Millisecond, 80 * time.Millisecond, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(2, tt.slowTimeout)
+			for i := 0; i < tt.pushes; i++ {
+				if !q.push(important(fmt.Sprint("msg-", i))) {
+					t.Fatalf("push %d rejected", i)
+				}
+			}
+			time.Sleep(tt.wait)
+
+			if q.push(important("last")) {
+				t.Fatal("slow consumer was not detected")
+			}
+			if !q.isSlow() {
+				t.Fatal("queue is not marked slow")
+			}
+			if frames, closed := q.drain(); !closed || frames != nil {
+				t.Fatalf("drain = %v, %v, want closed queue", frames, closed)
+			}
+			if q.push(important("after close")) {
+				t.Fatal("push into a closed queue accepted")
+			}
+		})
+	}
+}
+
+func TestSendQueueDrainResetsSlowTimer(t *testing.T) {
+	q := newSendQueue(2, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		q.push(important(fmt.Sprint("msg-", i)))
+	}
+	expectFrames(t, q, "msg-0", "msg-1", "msg-2")
+
+	time.Sleep(80 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if !q.push(important(fmt.Sprint("msg-", i))) {
+			t.Fatalf("push %d after drain rejected", i)
+		}
+	}
+	if q.isSlow() {
+		t.Fatal("queue is slow after drain")
+	}
+}
+
+func TestSendQueueCloseWakesWriter(t *testing.T) {
+	q := newSendQueue(2, time.Minute)
+	q.close()
+	q.close()
+
+	select {
+	case <-q.wake:
+	default:
+		t.Fatal("close did not wake the writer")
+	}
+	if _, closed := q.drain(); !closed {
+		t.Fatal("queue is not closed")
+	}
+	if q.isSlow() {
+		t.Fatal("closed queue is marked slow")
+	}
+}
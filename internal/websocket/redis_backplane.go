@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisChannel канал Redis pub/sub, через который узлы обмениваются событиями
+const RedisChannel = "gomessage:hub"
+
+// redisTimeout таймаут подключения и публикации в Redis
+const redisTimeout = 5 * time.Second
+
+// RedisBackplane backplane поверх Redis pub/sub. Pub/sub не хранит
+// события: узел, потерявший связь с Redis, пропускает их, а клиенты
+// докачивают сообщения по seq при переподключении.
+type RedisBackplane struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+}
+
+// NewRedisBackplane подключается к Redis и проверяет соединение
+func NewRedisBackplane(addr, password string, db int) (*RedisBackplane, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return &RedisBackplane{client: client}, nil
+}
+
+// Publish публикует событие в канал
+func (b *RedisBackplane) Publish(event BackplaneEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, RedisChannel, data).Err()
+}
+
+// Subscribe подписывается на канал и передает события в handler. При
+// обрыве соединения go-redis переподписывается сам.
+func (b *RedisBackplane) Subscribe(handler func(BackplaneEvent)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	pubsub := b.client.Subscribe(ctx, RedisChannel)
+	// Дожидаемся подтверждения подписки, чтобы не терять первые события
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("redis subscribe: %w", err)
+	}
+	b.pubsub = pubsub
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			var event BackplaneEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("❌ Некорректное событие backplane: %v", err)
+				continue
+			}
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+// Close закрывает подписку и соединение с Redis
+func (b *RedisBackplane) Close() error {
+	if b.pubsub != nil {
+		b.pubsub.Close()
+	}
+	return b.client.Close()
+}
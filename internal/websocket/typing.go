@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// Значения по умолчанию для индикатора набора текста
+const (
+	defaultTypingThrottle = 3 * time.Second
+	defaultTypingTimeout  = 10 * time.Second
+)
+
+// typingKey пользователь в чате
+type typingKey struct {
+	userID uint
+	chatID uint
+}
+
+// typingState пользователь набирает текст в чате
+type typingState struct {
+	connID   uint // соединение, из которого пришел последний typing: true
+	username string
+	sentAt   time.Time // когда участникам последний раз ушло typing: true
+	deadline time.Time // когда индикатор снимется сам
+	timer    *time.Timer
+}
+
+// typingTracker схлопывает кадры typing: участники чата получают
+// typing: true не чаще раза в throttle на пользователя и чат, а
+// typing: false - один раз, когда пользователь закончил, его соединение
+// закрылось или от него не было typing: true дольше timeout. Сам
+// пользователь свои события не получает. Состояние хранит узел, к
+// которому подключено соединение; остальные узлы получают только события.
+type typingTracker struct {
+	hub      *Hub
+	throttle time.Duration
+	timeout  time.Duration
+	states   map[typingKey]*typingState
+	mutex    sync.Mutex
+}
+
+// newTypingTracker создает трекер с ограничениями по умолчанию
+func newTypingTracker(hub *Hub) *typingTracker {
+	return &typingTracker{
+		hub:      hub,
+		throttle: defaultTypingThrottle,
+		timeout:  defaultTypingTimeout,
+		states:   make(map[typingKey]*typingState),
+	}
+}
+
+// setLimits задает интервал между повторными typing: true (0 - без
+// ограничения) и время, через которое индикатор снимается сам
+func (t *typingTracker) setLimits(throttle, timeout time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if throttle >= 0 {
+		t.throttle = throttle
+	}
+	if timeout > 0 {
+		t.timeout = timeout
+	}
+}
+
+// Update обрабатывает кадр typing от клиента
+func (t *typingTracker) Update(client *Client, chatID uint, typing bool) {
+	key := typingKey{userID: client.UserID, chatID: chatID}
+	now := time.Now()
+
+	t.mutex.Lock()
+	state, active := t.states[key]
+
+	if !typing {
+		if active {
+			t.stop(key, state)
+		}
+		t.mutex.Unlock()
+		if active {
+			t.send(key, state.username, false)
+		}
+		return
+	}
+
+	send := !active || now.Sub(state.sentAt) >= t.throttle
+	if active {
+		state.timer.Reset(t.timeout)
+	} else {
+		state = &typingState{username: client.Username}
+		created := state
+		state.timer = time.AfterFunc(t.timeout, func() { t.expire(key, created) })
+		t.states[key] = state
+	}
+	state.connID = client.ID
+	state.deadline = now.Add(t.timeout)
+	if send {
+		state.sentAt = now
+	}
+	t.mutex.Unlock()
+
+	if send {
+		t.send(key, client.Username, true)
+	}
+}
+
+// Disconnect снимает индикаторы, выставленные закрывшимся соединением
+func (t *typingTracker) Disconnect(client *Client) {
+	t.mutex.Lock()
+	var stopped []typingKey
+	for key, state := range t.states {
+		if key.userID == client.UserID && state.connID == client.ID {
+			t.stop(key, state)
+			stopped = append(stopped, key)
+		}
+	}
+	t.mutex.Unlock()
+
+	for _, key := range stopped {
+		t.send(key, client.Username, false)
+	}
+}
+
+// expire снимает индикатор, если typing: true не приходил дольше timeout
+func (t *typingTracker) expire(key typingKey, state *typingState) {
+	t.mutex.Lock()
+	// Таймер мог сработать одновременно с продлением индикатора
+	if t.states[key] != state || time.Now().Before(state.deadline) {
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mutex.Unlock()
+
+	t.send(key, state.username, false)
+}
+
+// stop удаляет состояние; вызывается под блокировкой
+func (t *typingTracker) stop(key typingKey, state *typingState) {
+	state.timer.Stop()
+	delete(t.states, key)
+}
+
+// send рассылает событие участникам чата, кроме самого пользователя.
+// Вытеснить из переполненной очереди можно только typing: true:
+// следующий такой кадр его заменит, а потерянный typing: false оставил бы
+// индикатор висеть.
+func (t *typingTracker) send(key typingKey, username string, typing bool) {
+	data, err := models.EncodeWebSocketMessage(models.WSMessageTypeTyping, "", models.WSTypingEvent{
+		UserID:   key.userID,
+		Username: username,
+		ChatID:   key.chatID,
+		Typing:   typing,
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации события %s: %v", models.WSMessageTypeTyping, err)
+		return
+	}
+	t.hub.broadcastToChatExcept(key.chatID, key.userID, frame{data: data, droppable: typing})
+}
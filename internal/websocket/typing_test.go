@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+// newTestClient подключает к hub клиента без WebSocket соединения: кадры
+// остаются в его очереди
+func newTestClient(t *testing.T, hub *Hub, userID uint) *Client {
+	t.Helper()
+	client := &Client{
+		ID:       hub.nextClientID(),
+		UserID:   userID,
+		Username: "user",
+		Hub:      hub,
+		queue:    newSendQueue(hub.queueSize, hub.slowTimeout),
+	}
+	hub.registerClient(client)
+	return client
+}
+
+// startHub запускает Hub для теста
+func startHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := NewHub()
+	go hub.Run()
+	return hub
+}
+
+// typingEvents забирает из очереди клиента события набора текста
+func typingEvents(t *testing.T, client *Client) []models.WSTypingEvent {
+	t.Helper()
+	frames, closed := client.queue.drain()
+	if closed {
+		t.Fatal("queue is closed")
+	}
+
+	var events []models.WSTypingEvent
+	for _, data := range frames {
+		message, err := models.DecodeWebSocketMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != models.WSMessageTypeTyping {
+			continue
+		}
+		var event models.WSTypingEvent
+		if err := json.Unmarshal(message.Payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// expectTyping проверяет, что клиент получил ровно эти события typing
+func expectTyping(t *testing.T, client *Client, want ...bool) {
+	t.Helper()
+	events := typingEvents(t, client)
+	if len(events) != len(want) {
+		t.Fatalf("got %d typing events %+v, want %v", len(events), events, want)
+	}
+	for i, event := range events {
+		if event.Typing != want[i] {
+			t.Fatalf("event %d typing = %v, want %v", i, event.Typing, want[i])
+		}
+	}
+}
+
+// typingChat чат, в котором у alice два соединения, а у bob одно
+type typingChat struct {
+	hub                  *Hub
+	alicePhone, aliceWeb *Client
+	bob                  *Client
+}
+
+const typingChatID = 1
+
+func newTypingChat(t *testing.T, throttle, timeout time.Duration) *typingChat {
+	t.Helper()
+	hub := startHub(t)
+	hub.SetTypingLimits(throttle, timeout)
+
+	chat := &typingChat{
+		hub:        hub,
+		alicePhone: newTestClient(t, hub, 1),
+		aliceWeb:   newTestClient(t, hub, 1),
+		bob:        newTestClient(t, hub, 2),
+	}
+	hub.subscribe(1, typingChatID)
+	hub.subscribe(2, typingChatID)
+	return chat
+}
+
+func TestTypingThrottle(t *testing.T) {
+	chat := newTypingChat(t, 200*time.Millisecond, time.Minute)
+	typing := chat.hub.typing
+
+	for i := 0; i < 5; i++ {
+		typing.Update(chat.alicePhone, typingChatID, true)
+		typing.Update(chat.aliceWeb, typingChatID, true)
+	}
+	expectTyping(t, chat.bob, true)
+
+	time.Sleep(250 * time.Millisecond)
+	typing.Update(chat.alicePhone, typingChatID, true)
+	typing.Update(chat.alicePhone, typingChatID, true)
+	expectTyping(t, chat.bob, true)
+
+	typing.Update(chat.alicePhone, typingChatID, false)
+	typing.Update(chat.alicePhone, typingChatID, false)
+	expectTyping(t, chat.bob, false)
+
+	// Свои события пользователь не получает ни на одном устройстве
+	expectTyping(t, chat.alicePhone)
+	expectTyping(t, chat.aliceWeb)
+}
+
+func TestTypingExpires(t *testing.T) {
+	chat := newTypingChat(t, 0, 100*time.Millisecond)
+	typing := chat.hub.typing
+
+	typing.Update(chat.alicePhone, typingChatID, true)
+	expectTyping(t, chat.bob, true)
+
+	// Продление сдвигает срок индикатора
+	time.Sleep(60 * time.Millisecond)
+	typing.Update(chat.alicePhone, typingChatID, true)
+	time.Sleep(60 * time.Millisecond)
+	expectTyping(t, chat.bob, true)
+
+	time.Sleep(200 * time.Millisecond)
+	expectTyping(t, chat.bob, false)
+
+	// Индикатор уже снят: typing: false больше не рассылается
+	typing.Update(chat.alicePhone, typingChatID, false)
+	expectTyping(t, chat.bob)
+	expectTyping(t, chat.alicePhone)
+	expectTyping(t, chat.aliceWeb)
+}
+
+func TestTypingDisconnect(t *testing.T) {
+	chat := newTypingChat(t, time.Minute, time.Minute)
+	typing := chat.hub.typing
+
+	typing.Update(chat.alicePhone, typingChatID, true)
+	expectTyping(t, chat.bob, true)
+
+	// Индикатор снимает только соединение, которое его выставило
+	typing.Disconnect(chat.aliceWeb)
+	expectTyping(t, chat.bob)
+
+	typing.Disconnect(chat.alicePhone)
+	expectTyping(t, chat.bob, false)
+
+	typing.Disconnect(chat.alicePhone)
+	expectTyping(t, chat.bob)
+	expectTyping(t, chat.aliceWeb)
+}
+
+func TestTypingStopSurvivesFullQueue(t *testing.T) {
+	chat := newTypingChat(t, 0, time.Minute)
+	typing := chat.hub.typing
+
+	// Очередь bob занята важными кадрами
+	message, err := models.EncodeWebSocketMessage(models.WSMessageTypeChat, "", models.WSChatEvent{ID: 1, ChatID: typingChatID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < chat.hub.queueSize; i++ {
+		if !chat.bob.queue.push(frame{data: message}) {
+			t.Fatal("queue closed while filling")
+		}
+	}
+
+	typing.Update(chat.alicePhone, typingChatID, true)
+	typing.Update(chat.alicePhone, typingChatID, false)
+	typing.Update(chat.aliceWeb, typingChatID, true)
+	typing.Disconnect(chat.aliceWeb)
+
+	// typing: true отброшены, оба typing: false дошли
+	expectTyping(t, chat.bob, false, false)
+}
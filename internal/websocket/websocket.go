@@ -1,90 +1,190 @@
 package websocket
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"gomessage/internal/messaging"
+	"gomessage/internal/middleware"
 	"gomessage/internal/models"
+	"gomessage/internal/presence"
 )
 
 // Client представляет WebSocket клиента
 type Client struct {
-	ID       uint
-	UserID   uint
-	Username string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	Hub      *Hub
+	ID        uint
+	UserID    uint
+	Username  string
+	DeviceID  string    // устройство из токена; от его имени отправляются E2EE сообщения
+	ExpiresAt time.Time // срок действия токена, по которому подключился клиент
+	Conn      *websocket.Conn
+	Hub       *Hub
+	queue     *sendQueue
 }
 
-// Hub управляет всеми WebSocket соединениями
+// Hub управляет всеми WebSocket соединениями. Список клиентов меняет
+// только Run; доставка кадров читает его и кладет кадры в очереди клиентов.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	userChats  map[uint]map[uint]bool // userID -> chatIDs
-	chatHistory map[uint][]models.Message // chatID -> messages
-	messageUsernames map[uint]string // ID сообщения -> имя пользователя
-	nextMessageID uint // Следующий ID для сообщения
-	mutex      sync.RWMutex
+	clients     map[*Client]bool
+	register    chan registration
+	unregister  chan *Client
+	userChats   map[uint]map[uint]bool // userID -> chatIDs
+	mutex       sync.RWMutex
+	node        string    // ID экземпляра сервера в backplane
+	backplane   Backplane // nil - сервер работает в одном экземпляре
+	lastID      uint64    // последний выданный ID соединения
+	typing      *typingTracker
+	queueSize   int           // размер очереди отправки клиента
+	slowTimeout time.Duration // сколько очередь клиента может быть переполнена
+}
+
+// registration запрос на добавление клиента; done закрывается, когда
+// Run добавил клиента
+type registration struct {
+	client *Client
+	done   chan struct{}
 }
 
 // NewHub создает новый Hub
 func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		userChats:  make(map[uint]map[uint]bool),
-		chatHistory: make(map[uint][]models.Message),
-		messageUsernames: make(map[uint]string),
-		nextMessageID: 1,
+	hub := &Hub{
+		clients:     make(map[*Client]bool),
+		register:    make(chan registration),
+		unregister:  make(chan *Client),
+		userChats:   make(map[uint]map[uint]bool),
+		node:        newNodeID(),
+		queueSize:   defaultQueueSize,
+		slowTimeout: defaultSlowTimeout,
+	}
+	hub.typing = newTypingTracker(hub)
+	return hub
+}
+
+// SetQueueLimits задает размер очереди отправки клиента и сколько она
+// может оставаться переполненной, прежде чем клиент будет отключен.
+// Вызывается до Run.
+func (h *Hub) SetQueueLimits(size int, slowTimeout time.Duration) {
+	if size > 0 {
+		h.queueSize = size
+	}
+	if slowTimeout > 0 {
+		h.slowTimeout = slowTimeout
+	}
+}
+
+// SetTypingLimits задает, как часто участники получают typing: true от
+// одного пользователя в чате (0 - при каждом кадре) и через сколько
+// времени без typing: true индикатор снимается сам
+func (h *Hub) SetTypingLimits(throttle, timeout time.Duration) {
+	h.typing.setLimits(throttle, timeout)
+}
+
+// nextClientID выдает ID нового соединения, уникальный на этом узле
+func (h *Hub) nextClientID() uint {
+	return uint(atomic.AddUint64(&h.lastID, 1))
+}
+
+// SetBackplane подключает Hub к шине между экземплярами сервера: события
+// чатов, набора текста и статусов дойдут до клиентов на любом узле.
+// Вызывается до Run.
+func (h *Hub) SetBackplane(backplane Backplane) error {
+	h.backplane = backplane
+	return backplane.Subscribe(h.receive)
+}
+
+// publish отправляет событие остальным узлам
+func (h *Hub) publish(event BackplaneEvent) {
+	if h.backplane == nil {
+		return
+	}
+	event.Node = h.node
+	if err := h.backplane.Publish(event); err != nil {
+		log.Printf("❌ Ошибка публикации события %s в backplane: %v", event.Kind, err)
+	}
+}
+
+// receive доставляет локальным клиентам событие с другого узла
+func (h *Hub) receive(event BackplaneEvent) {
+	if event.Node == h.node {
+		return // свои события уже доставлены
+	}
+
+	switch event.Kind {
+	case backplaneChat:
+		h.deliverToChat(event.ChatID, event.UserID, frame{data: event.Data, droppable: event.Droppable})
+	case backplaneUser:
+		h.deliverToUser(event.UserID, frame{data: event.Data})
+	case backplaneUsers:
+		h.deliverToUsers(event.UserIDs, frame{data: event.Data})
+	case backplanePresence:
+		presence.GlobalService.RemoteState(event.Node, event.UserID, event.State)
+	case backplaneSubscribe:
+		// Подписка нужна, только если пользователь подключен к этому узлу
+		if h.hasClient(event.UserID) {
+			h.subscribe(event.UserID, event.ChatID)
+		}
+	case backplaneUnsubscribe:
+		h.unsubscribe(event.UserID, event.ChatID)
+	default:
+		log.Printf("❌ Неизвестное событие backplane: %s", event.Kind)
 	}
 }
 
-// Run запускает Hub
+// Run запускает Hub: добавляет и удаляет клиентов
 func (h *Hub) Run() {
 	for {
 		select {
-		case client := <-h.register:
+		case request := <-h.register:
 			h.mutex.Lock()
-			h.clients[client] = true
+			h.clients[request.client] = true
 			h.mutex.Unlock()
-			log.Printf("🔌 Клиент %s подключился (ID: %d)", client.Username, client.UserID)
+			close(request.done)
+			log.Printf("🔌 Клиент %s подключился (ID: %d)", request.client.Username, request.client.UserID)
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.Send)
+				// Подписки нужны, пока подключено хоть одно устройство
+				if !h.hasClientLocked(client.UserID) {
+					delete(h.userChats, client.UserID)
+				}
 			}
 			h.mutex.Unlock()
+			client.queue.close()
 			log.Printf("🔌 Клиент %s отключился (ID: %d)", client.Username, client.UserID)
-
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-				}
-			}
-			h.mutex.RUnlock()
 		}
 	}
 }
 
-// AddUserToChat добавляет пользователя в чат
+// registerClient добавляет клиента и ждет, пока Run его учтет: после
+// этого клиент получает все события, в том числе подписки с других узлов
+func (h *Hub) registerClient(client *Client) {
+	done := make(chan struct{})
+	h.register <- registration{client: client, done: done}
+	<-done
+}
+
+// AddUserToChat подписывает пользователя на чат на всех узлах
 func (h *Hub) AddUserToChat(userID, chatID uint) {
+	h.subscribe(userID, chatID)
+	h.publish(BackplaneEvent{Kind: backplaneSubscribe, UserID: userID, ChatID: chatID})
+}
+
+// RemoveUserFromChat отписывает пользователя от чата на всех узлах
+func (h *Hub) RemoveUserFromChat(userID, chatID uint) {
+	h.unsubscribe(userID, chatID)
+	h.publish(BackplaneEvent{Kind: backplaneUnsubscribe, UserID: userID, ChatID: chatID})
+}
+
+// subscribe добавляет пользователя в чат на этом узле
+func (h *Hub) subscribe(userID, chatID uint) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 	
@@ -94,8 +194,8 @@ func (h *Hub) AddUserToChat(userID, chatID uint) {
 	h.userChats[userID][chatID] = true
 }
 
-// RemoveUserFromChat удаляет пользователя из чата
-func (h *Hub) RemoveUserFromChat(userID, chatID uint) {
+// unsubscribe удаляет пользователя из чата на этом узле
+func (h *Hub) unsubscribe(userID, chatID uint) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 	
@@ -104,97 +204,125 @@ func (h *Hub) RemoveUserFromChat(userID, chatID uint) {
 	}
 }
 
-// GetChatHistory возвращает историю сообщений для чата
-func (h *Hub) GetChatHistory(chatID uint) []models.Message {
+// IsSubscribed проверяет, подписан ли пользователь на чат
+func (h *Hub) IsSubscribed(userID, chatID uint) bool {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	
-	if history, exists := h.chatHistory[chatID]; exists {
-		return history
-	}
-	return []models.Message{}
+	return h.userChats[userID][chatID]
 }
 
-// GetMessageUsername возвращает имя пользователя для сообщения
-func (h *Hub) GetMessageUsername(messageID uint) string {
+// hasClient проверяет, подключен ли пользователь к этому узлу
+func (h *Hub) hasClient(userID uint) bool {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	
-	if username, exists := h.messageUsernames[messageID]; exists {
-		return username
-	}
-	return "Неизвестный"
+	return h.hasClientLocked(userID)
 }
 
-// getNextMessageID возвращает следующий уникальный ID для сообщения
-func (h *Hub) getNextMessageID() uint {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	
-	id := h.nextMessageID
-	h.nextMessageID++
-	return id
+// hasClientLocked то же, что hasClient; вызывается под блокировкой
+func (h *Hub) hasClientLocked(userID uint) bool {
+	for client := range h.clients {
+		if client.UserID == userID {
+			return true
+		}
+	}
+	return false
 }
 
-// AddMessageToHistory добавляет сообщение в историю чата
-func (h *Hub) AddMessageToHistory(chatID uint, message models.Message, username string) {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	
-	if h.chatHistory[chatID] == nil {
-		h.chatHistory[chatID] = make([]models.Message, 0)
-	}
-	
-	// Создаем расширенную структуру для хранения имени пользователя
-	type MessageWithUsername struct {
-		models.Message
-		Username string
-	}
-	
-	// Ограничиваем историю последними 100 сообщениями
-	if len(h.chatHistory[chatID]) >= 100 {
-		h.chatHistory[chatID] = h.chatHistory[chatID][1:]
+// SubscribeUserChats подписывает пользователя на все чаты, в которых он состоит
+func (h *Hub) SubscribeUserChats(userID uint) {
+	chats, err := models.GlobalChatStore.GetUserChats(userID)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки чатов пользователя %d: %v", userID, err)
+		return
 	}
 	
-	h.chatHistory[chatID] = append(h.chatHistory[chatID], message)
-	
-	// Сохраняем маппинг ID сообщения -> имя пользователя
-	if h.messageUsernames == nil {
-		h.messageUsernames = make(map[uint]string)
+	// Остальные узлы подписывают пользователя сами при его подключении
+	for _, chat := range chats {
+		h.subscribe(userID, chat.ID)
 	}
-	h.messageUsernames[message.ID] = username
 }
 
-// BroadcastToChat отправляет сообщение всем пользователям в чате
+// historyLimit сколько последних сообщений отправлять при входе в чат
+const historyLimit = 50
+
+// maxFrameSize максимальный размер входящего кадра: 2000 символов
+// сообщения в UTF-8 с конвертом
+const maxFrameSize = 16 * 1024
+
+// BroadcastToChat отправляет сообщение всем пользователям в чате на всех узлах
 func (h *Hub) BroadcastToChat(chatID uint, message []byte) {
+	h.broadcastToChatExcept(chatID, 0, frame{data: message})
+}
+
+// broadcastToChatExcept отправляет кадр участникам чата на всех узлах,
+// кроме соединений пользователя exceptUserID
+func (h *Hub) broadcastToChatExcept(chatID, exceptUserID uint, f frame) {
+	h.deliverToChat(chatID, exceptUserID, f)
+	h.publish(BackplaneEvent{Kind: backplaneChat, ChatID: chatID, UserID: exceptUserID, Droppable: f.droppable, Data: f.data})
+}
+
+// SendToUser отправляет сообщение всем соединениям пользователя на всех узлах
+func (h *Hub) SendToUser(userID uint, message []byte) {
+	h.deliverToUser(userID, frame{data: message})
+	h.publish(BackplaneEvent{Kind: backplaneUser, UserID: userID, Data: message})
+}
+
+// SendToUsers отправляет сообщение всем соединениям пользователей на всех узлах
+func (h *Hub) SendToUsers(userIDs []uint, message []byte) {
+	h.deliverToUsers(userIDs, frame{data: message})
+	h.publish(BackplaneEvent{Kind: backplaneUsers, UserIDs: userIDs, Data: message})
+}
+
+// SendToLocalUsers отправляет сообщение соединениям пользователей только на этом узле
+func (h *Hub) SendToLocalUsers(userIDs []uint, message []byte) {
+	h.deliverToUsers(userIDs, frame{data: message})
+}
+
+// PublishPresence сообщает остальным узлам состояние пользователя на этом узле
+func (h *Hub) PublishPresence(userID uint, state presence.State) {
+	h.publish(BackplaneEvent{Kind: backplanePresence, UserID: userID, State: state})
+}
+
+// deliverToChat кладет кадр в очереди участников чата, подключенных к
+// этому узлу, кроме пользователя exceptUserID (0 - без исключений)
+func (h *Hub) deliverToChat(chatID, exceptUserID uint, f frame) {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	
 	for client := range h.clients {
-		if h.userChats[client.UserID][chatID] {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-			}
+		if h.userChats[client.UserID][chatID] && client.UserID != exceptUserID {
+			client.queue.push(f)
 		}
 	}
 }
 
-// SendToUser отправляет сообщение конкретному пользователю
-func (h *Hub) SendToUser(userID uint, message []byte) {
+// deliverToUser кладет кадр в очереди соединений пользователя на этом узле
+func (h *Hub) deliverToUser(userID uint, f frame) {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	
 	for client := range h.clients {
 		if client.UserID == userID {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-			}
+			client.queue.push(f)
+		}
+	}
+}
+
+// deliverToUsers кладет кадр в очереди соединений пользователей на этом узле
+func (h *Hub) deliverToUsers(userIDs []uint, f frame) {
+	recipients := make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		recipients[userID] = true
+	}
+	
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	
+	for client := range h.clients {
+		if recipients[client.UserID] {
+			client.queue.push(f)
 		}
 	}
 }
@@ -204,9 +332,11 @@ func (c *Client) readPump() {
 	defer func() {
 		c.Hub.unregister <- c
 		c.Conn.Close()
+		c.Hub.typing.Disconnect(c)
+		presence.GlobalService.Disconnect(c.UserID, c.ID)
 	}()
 
-	c.Conn.SetReadLimit(2048) // Увеличиваем лимит для поддержки длинных сообщений
+	c.Conn.SetReadLimit(maxFrameSize)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -222,16 +352,23 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Обрабатываем сообщение
-		var wsMessage models.WebSocketMessage
-		if err := json.Unmarshal(message, &wsMessage); err != nil {
-			log.Printf("❌ Ошибка парсинга WebSocket сообщения: %v", err)
-			continue
-		}
+		// Любой кадр от клиента - признак активности пользователя
+		presence.GlobalService.Touch(c.UserID, c.ID)
 
 		// Логируем размер сообщения для отладки
 		log.Printf("📨 Получено WebSocket сообщение размером %d байт от пользователя %s", len(message), c.Username)
 
+		// Некорректный кадр не обрывает соединение: клиент получает ошибку
+		wsMessage, err := models.DecodeWebSocketMessage(message)
+		if err != nil {
+			log.Printf("❌ Ошибка парсинга WebSocket сообщения от %s: %v", c.Username, err)
+			if wsMessage == nil {
+				wsMessage = &models.WebSocketMessage{}
+			}
+			c.sendError(wsMessage, 0, err)
+			continue
+		}
+
 		c.handleMessage(wsMessage)
 	}
 }
@@ -239,185 +376,301 @@ func (c *Client) readPump() {
 // writePump отправляет сообщения клиенту
 func (c *Client) writePump() {
 	ticker := time.NewTicker(54 * time.Second)
+	expiry := time.NewTimer(time.Until(c.ExpiresAt))
 	defer func() {
 		ticker.Stop()
+		expiry.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.queue.wake:
+			messages, closed := c.queue.drain()
+			if closed {
+				c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if c.queue.isSlow() {
+					log.Printf("🐢 Клиент %s не успевает принимать кадры, закрываем соединение", c.Username)
+					c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseSlowConsumer, "slow consumer"))
+				} else {
+					c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				}
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
+			for _, message := range messages {
+				c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				w, err := c.Conn.NextWriter(websocket.TextMessage)
+				if err != nil {
+					return
+				}
+				w.Write(message)
 
-			if err := w.Close(); err != nil {
-				return
+				if err := w.Close(); err != nil {
+					return
+				}
 			}
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-expiry.C:
+			// Токен истек: клиент должен обновить его и переподключиться
+			log.Printf("⌛ Токен клиента %s истек, закрываем соединение", c.Username)
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseTokenExpired, "token expired"))
+			return
 		}
 	}
 }
 
-// handleMessage обрабатывает входящие WebSocket сообщения
-func (c *Client) handleMessage(message models.WebSocketMessage) {
+// sendHistory отправляет клиенту страницу истории чата, а затем кадр
+// "history" с курсорами для догрузки
+func (c *Client) sendHistory(request *models.WebSocketMessage, chatID uint, query messaging.PageQuery) {
+	page, err := messaging.GlobalService.GetChatMessages(c.UserID, chatID, query)
+	if err != nil {
+		log.Printf("❌ Ошибка получения истории чата %d для %s: %v", chatID, c.Username, err)
+		c.sendError(request, chatID, err)
+		return
+	}
+
+	var lastSeq uint64
+	for _, msg := range page.Messages {
+		c.send(models.WSMessageTypeChat, "", messaging.HistoryEvent(msg))
+		lastSeq = msg.Seq
+	}
+	c.send(models.WSMessageTypeHistory, request.ID, models.WSHistoryEvent{
+		ChatID:     chatID,
+		Count:      len(page.Messages),
+		LastSeq:    lastSeq,
+		PrevCursor: page.PrevCursor,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// sendError отправляет клиенту кадр "error" в ответ на его запрос
+func (c *Client) sendError(request *models.WebSocketMessage, chatID uint, err error) {
+	c.send(models.WSMessageTypeError, request.ID, models.WSErrorPayload{
+		Code:        errorCode(err),
+		Message:     err.Error(),
+		RequestType: request.Type,
+		ChatID:      chatID,
+	})
+}
+
+// send сериализует кадр и кладет его в очередь отправки клиента
+func (c *Client) send(messageType, ackID string, payload interface{}) {
+	data, err := models.EncodeWebSocketMessage(messageType, ackID, payload)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации кадра %s: %v", messageType, err)
+		return
+	}
+	c.queue.push(frame{data: data})
+}
+
+// errorCode код ошибки для кадра "error"
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, models.ErrWSInvalidFrame):
+		return "invalid_frame"
+	case errors.Is(err, models.ErrWSUnsupportedVersion):
+		return "unsupported_version"
+	case errors.Is(err, models.ErrWSUnknownType):
+		return "unknown_type"
+	case errors.Is(err, models.ErrWSInvalidPayload):
+		return "invalid_payload"
+	case errors.Is(err, messaging.ErrNotChatMember):
+		return "not_chat_member"
+	case errors.Is(err, models.ErrChatNotFound):
+		return "chat_not_found"
+	case errors.Is(err, models.ErrMessageNotFound):
+		return "message_not_found"
+	case errors.Is(err, messaging.ErrInvalidMessageType), errors.Is(err, messaging.ErrInvalidContent),
+		errors.Is(err, messaging.ErrInvalidReply), errors.Is(err, messaging.ErrInvalidCursor),
+		errors.Is(err, messaging.ErrInvalidReadSeq), errors.Is(err, messaging.ErrInvalidDeliveryAck),
+		errors.Is(err, messaging.ErrEncryptionRequired), errors.Is(err, messaging.ErrNotEncryptedChat),
+		errors.Is(err, messaging.ErrInvalidEnvelopes):
+		return "invalid_message"
+	default:
+		return "internal_error"
+	}
+}
+
+// handleMessage обрабатывает входящие WebSocket сообщения. Payload
+// разбирается в структуру своего типа; на любую ошибку клиент получает
+// кадр "error".
+func (c *Client) handleMessage(message *models.WebSocketMessage) {
 	switch message.Type {
 	case models.WSMessageTypeJoin:
-		// Подписка пользователя на чат
-		if joinData, ok := message.Payload.(map[string]interface{}); ok {
-			if chatID, ok := joinData["chat_id"].(float64); ok {
-				c.Hub.AddUserToChat(c.UserID, uint(chatID))
-				
-				// Отправляем историю чата новому пользователю
-				history := c.Hub.GetChatHistory(uint(chatID))
-				for _, msg := range history {
-					username := c.Hub.GetMessageUsername(msg.ID)
-					response := models.WebSocketMessage{
-						Type: models.WSMessageTypeChat,
-						Payload: map[string]interface{}{
-							"id":        msg.ID,
-							"content":   msg.Content,
-							"sender_id": msg.SenderID,
-							"username":  username,
-							"chat_id":   msg.ChatID,
-							"timestamp": msg.CreatedAt,
-							"is_history": true,
-						},
-					}
-					
-					responseBytes, _ := json.Marshal(response)
-					select {
-					case c.Send <- responseBytes:
-					default:
-						// Если канал переполнен, пропускаем
-					}
-				}
-			}
+		var join models.WSJoinPayload
+		if err := message.DecodePayload(&join); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+
+		// Подписка пользователя на чат: только для участников
+		if err := messaging.GlobalService.RequireMember(join.ChatID, c.UserID); err != nil {
+			log.Printf("🚫 %s не может войти в чат %d: %v", c.Username, join.ChatID, err)
+			c.sendError(message, join.ChatID, err)
+			return
+		}
+		c.Hub.subscribe(c.UserID, join.ChatID)
+
+		// Отправляем последнюю страницу истории; более старые сообщения
+		// клиент догружает по prev_cursor через REST. С after_seq приходят
+		// только сообщения, пропущенные с прошлого подключения.
+		c.sendHistory(message, join.ChatID, messaging.PageQuery{
+			Before:   join.Before,
+			After:    join.After,
+			AfterSeq: join.AfterSeq,
+			Around:   join.Around,
+			Limit:    historyLimit,
+		})
+
+	case models.WSMessageTypeResume:
+		// Докачка пропущенных сообщений по всем чатам из запроса. На каждый
+		// чат приходят его сообщения и кадр "history"; если next_cursor не
+		// null, клиент продолжает join с after_seq = last_seq. Чат с
+		// after_seq 0 клиент еще не видел, для него приходит последняя страница.
+		var resume models.WSResumePayload
+		if err := message.DecodePayload(&resume); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+		for _, chat := range resume.Chats {
+			c.sendHistory(message, chat.ChatID, messaging.PageQuery{
+				AfterSeq: chat.AfterSeq,
+				Limit:    historyLimit,
+			})
 		}
 
 	case models.WSMessageTypeLeave:
 		// Отписка пользователя от чата
-		if leaveData, ok := message.Payload.(map[string]interface{}); ok {
-			if chatID, ok := leaveData["chat_id"].(float64); ok {
-				c.Hub.RemoveUserFromChat(c.UserID, uint(chatID))
-			}
+		var leave models.WSLeavePayload
+		if err := message.DecodePayload(&leave); err != nil {
+			c.sendError(message, 0, err)
+			return
 		}
+		c.Hub.unsubscribe(c.UserID, leave.ChatID)
+
 	case models.WSMessageTypeChat:
-		// Обработка чат сообщения
-		if chatMsg, ok := message.Payload.(map[string]interface{}); ok {
-			if chatID, ok := chatMsg["chat_id"].(float64); ok {
-				// Генерируем уникальный ID для сообщения
-				messageID := c.Hub.getNextMessageID()
-				
-				// Создаем сообщение для истории
-				msg := models.Message{
-					ID:        messageID,
-					Content:   chatMsg["content"].(string),
-					Type:      models.MessageTypeText,
-					SenderID:  c.UserID,
-					ChatID:    uint(chatID),
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				}
-				
-				// Сохраняем в историю с именем пользователя
-				c.Hub.AddMessageToHistory(uint(chatID), msg, c.Username)
-				
-				// Отправляем сообщение всем в чате
-				response := models.WebSocketMessage{
-					Type: models.WSMessageTypeChat,
-					Payload: map[string]interface{}{
-						"id":        messageID,
-						"content":   chatMsg["content"],
-						"sender_id": c.UserID,
-						"username":  c.Username,
-						"chat_id":   uint(chatID),
-						"timestamp": time.Now(),
-					},
-				}
-				
-				responseBytes, _ := json.Marshal(response)
-				c.Hub.BroadcastToChat(uint(chatID), responseBytes)
-			}
+		var chat models.WSChatPayload
+		if err := message.DecodePayload(&chat); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+
+		// Сохраняем и рассылаем участникам чата так же, как REST SendMessage;
+		// сервис отклоняет сообщения не от участников
+		sent, duplicate, err := messaging.GlobalService.Send(c.UserID, models.MessageRequest{
+			Content:        chat.Content,
+			Type:           chat.Type,
+			ChatID:         chat.ChatID,
+			ReplyToID:      chat.ReplyToID,
+			ClientMsgID:    chat.ClientMsgID,
+			Envelopes:      chat.Envelopes,
+			SenderDeviceID: c.DeviceID,
+		})
+		if err != nil {
+			log.Printf("❌ Ошибка отправки сообщения от %s: %v", c.Username, err)
+			c.sendError(message, chat.ChatID, err)
+			return
 		}
-		
+
+		// Подтверждаем отправителю, что сообщение сохранено; после ack
+		// клиент может убрать его из очереди на повторную отправку
+		c.send(models.WSMessageTypeAck, message.ID, models.WSAckEvent{
+			ClientMsgID: sent.ClientMsgID,
+			MessageID:   sent.ID,
+			ChatID:      sent.ChatID,
+			Timestamp:   sent.CreatedAt,
+			Duplicate:   duplicate,
+		})
+
 	case models.WSMessageTypeTyping:
-		// Обработка статуса печати
-		if typingData, ok := message.Payload.(map[string]interface{}); ok {
-			if chatID, ok := typingData["chat_id"].(float64); ok {
-				response := models.WebSocketMessage{
-					Type: models.WSMessageTypeTyping,
-					Payload: map[string]interface{}{
-						"user_id":  c.UserID,
-						"username": c.Username,
-						"chat_id":  uint(chatID),
-						"typing":   typingData["typing"],
-					},
-				}
-				
-				responseBytes, _ := json.Marshal(response)
-				c.Hub.BroadcastToChat(uint(chatID), responseBytes)
-			}
+		var typing models.WSTypingPayload
+		if err := message.DecodePayload(&typing); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+
+		// Печатать можно только в чатах, на которые клиент подписан
+		if !c.Hub.IsSubscribed(c.UserID, typing.ChatID) {
+			c.sendError(message, typing.ChatID, messaging.ErrNotChatMember)
+			return
 		}
-		
+
+		// Участники получают не каждый кадр: повторы схлопываются,
+		// а индикатор снимается сам по таймауту и при отключении
+		c.Hub.typing.Update(c, typing.ChatID, typing.Typing)
+
+	case models.WSMessageTypeRead:
+		// Сдвиг позиции чтения; ответ - кадр "read_state" с числом непрочитанных
+		var read models.WSReadPayload
+		if err := message.DecodePayload(&read); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+
+		state, err := messaging.GlobalService.MarkRead(c.UserID, read.ChatID, read.Seq)
+		if err != nil {
+			c.sendError(message, read.ChatID, err)
+			return
+		}
+		c.send(models.WSMessageTypeReadState, message.ID, state)
+
+	case models.WSMessageTypeDelivered:
+		// Клиент подтверждает, что полученные кадры "chat" дошли до
+		// устройства; отправители узнают об этом кадром "delivered".
+		// Ответа на успешное подтверждение нет.
+		var delivered models.WSDeliveredPayload
+		if err := message.DecodePayload(&delivered); err != nil {
+			c.sendError(message, 0, err)
+			return
+		}
+
+		if err := messaging.GlobalService.MarkDelivered(c.UserID, delivered.MessageIDs); err != nil {
+			c.sendError(message, 0, err)
+		}
+
 	case models.WSMessageTypeStatus:
 		// Обновление статуса пользователя
-		if statusData, ok := message.Payload.(map[string]interface{}); ok {
-			if status, ok := statusData["status"].(string); ok {
-				response := models.WebSocketMessage{
-					Type: models.WSMessageTypeStatus,
-					Payload: map[string]interface{}{
-						"user_id": c.UserID,
-						"username": c.Username,
-						"status":   status,
-					},
-				}
-				
-				responseBytes, _ := json.Marshal(response)
-				c.Hub.broadcast <- responseBytes
-			}
+		var status models.WSStatusPayload
+		if err := message.DecodePayload(&status); err != nil {
+			c.sendError(message, 0, err)
+			return
 		}
+
+		// Выбранный статус сохраняется, а участники общих чатов узнают
+		// об изменении от сервиса присутствия
+		if _, err := models.GlobalUserStore.UpdateUser(c.UserID, map[string]interface{}{"status": status.Status}); err != nil {
+			log.Printf("❌ Ошибка сохранения статуса %s: %v", c.Username, err)
+			c.sendError(message, 0, err)
+			return
+		}
+		presence.GlobalService.StatusChanged(c.UserID)
+
+	default:
+		c.sendError(message, 0, fmt.Errorf("%w: %q", models.ErrWSUnknownType, message.Type))
 	}
 }
 
 // ServeWebSocket обрабатывает WebSocket соединения
-func ServeWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	// Получаем userID из query параметров или заголовков
-	userIDStr := r.URL.Query().Get("user_id")
-	if userIDStr == "" {
-		http.Error(w, "user_id required", http.StatusBadRequest)
-		return
-	}
-
-	// Парсим user_id
-	userIDUint64, err := strconv.ParseUint(userIDStr, 10, 64)
+func ServeWebSocket(hub *Hub, tokens *middleware.TokenService, w http.ResponseWriter, r *http.Request) {
+	// Пользователь определяется только по проверенному токену
+	claims, err := authenticate(r, tokens)
 	if err != nil {
-		http.Error(w, "invalid user_id", http.StatusBadRequest)
+		log.Printf("❌ Отказ в WebSocket подключении: %v", err)
+		rejectUnauthorized(w, err)
 		return
 	}
-
-	// Необязательное имя пользователя
-	username := r.URL.Query().Get("username")
-	
-	// В реальном приложении здесь должна быть проверка JWT токена
-	// и получение userID из токена
 	
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true // В продакшене проверяйте origin
 		},
+		Subprotocols: []string{Subprotocol},
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -427,15 +680,21 @@ func ServeWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		ID:       0, // Генерируем уникальный ID
-		UserID:   uint(userIDUint64),
-		Username: username,
-		Hub:      hub,
-		Conn:     conn,
-		Send:     make(chan []byte, 1024), // Увеличиваем размер канала для длинных сообщений
+		ID:        hub.nextClientID(),
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		DeviceID:  claims.DeviceID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		Hub:       hub,
+		Conn:      conn,
+		queue:     newSendQueue(hub.queueSize, hub.slowTimeout),
 	}
 
-	client.Hub.register <- client
+	client.Hub.registerClient(client)
+	
+	// Сразу подписываем на все чаты пользователя, без явного join
+	client.Hub.SubscribeUserChats(client.UserID)
+	presence.GlobalService.Connect(client.UserID, client.ID)
 
 	go client.writePump()
 	go client.readPump()
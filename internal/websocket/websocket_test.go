@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gomessage/internal/models"
+)
+
+func TestDecodeWebSocketMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		wantErr error
+		code    string
+	}{
+		{"valid", `{"version":1,"type":"join","id":"r1","payload":{"chat_id":1}}`, nil, ""},
+		{"version defaults to current", `{"type":"join","payload":{"chat_id":1}}`, nil, ""},
+		{"id is optional", `{"version":1,"type":"typing","payload":{"chat_id":1,"typing":true}}`, nil, ""},
+		{"not json", `join chat 1`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"empty frame", ``, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"array frame", `[1,2]`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"unknown field", `{"version":1,"type":"join","chat_id":1}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"trailing object", `{"type":"join"}{"type":"leave"}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"trailing brace", `{"type":"join"}}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"trailing bracket", `{"type":"join"}]`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"missing type", `{"version":1,"payload":{"chat_id":1}}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"version is string", `{"version":"1","type":"join"}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"unsupported version", `{"version":2,"type":"join"}`, models.ErrWSUnsupportedVersion, "unsupported_version"},
+		{"negative version", `{"version":-1,"type":"join"}`, models.ErrWSUnsupportedVersion, "unsupported_version"},
+		{"id is number", `{"type":"join","id":7}`, models.ErrWSInvalidFrame, "invalid_frame"},
+		{"id too long", `{"type":"join","id":"` + strings.Repeat("x", 65) + `"}`, models.ErrWSInvalidFrame, "invalid_frame"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := models.DecodeWebSocketMessage([]byte(tt.frame))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("DecodeWebSocketMessage: %v", err)
+				}
+				if message.Version != models.WSProtocolVersion {
+					t.Fatalf("version = %d, want %d", message.Version, models.WSProtocolVersion)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("errorCode = %q, want %q", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string // без payload, если пусто
+		dst     models.WSPayload
+		wantErr bool
+	}{
+		{"join", `{"chat_id":1,"after_seq":5}`, &models.WSJoinPayload{}, false},
+		{"join missing payload", ``, &models.WSJoinPayload{}, true},
+		{"join null payload", `null`, &models.WSJoinPayload{}, true},
+		{"join payload is array", `[1]`, &models.WSJoinPayload{}, true},
+		{"join chat_id is string", `{"chat_id":"1"}`, &models.WSJoinPayload{}, true},
+		{"join negative chat_id", `{"chat_id":-1}`, &models.WSJoinPayload{}, true},
+		{"join unknown field", `{"chat_id":1,"limit":10}`, &models.WSJoinPayload{}, true},
+		{"join two cursors", `{"chat_id":1,"before":10,"after":5}`, &models.WSJoinPayload{}, true},
+		{"leave without chat_id", `{}`, &models.WSLeavePayload{}, true},
+		{"resume", `{"chats":[{"chat_id":1,"after_seq":3},{"chat_id":2,"after_seq":0}]}`, &models.WSResumePayload{}, false},
+		{"resume without chats", `{"chats":[]}`, &models.WSResumePayload{}, true},
+		{"resume chat twice", `{"chats":[{"chat_id":1},{"chat_id":1}]}`, &models.WSResumePayload{}, true},
+		{"resume too many chats", resumePayload(101), &models.WSResumePayload{}, true},
+		{"chat", `{"chat_id":1,"content":"hi"}`, &models.WSChatPayload{}, false},
+		{"chat blank content", `{"chat_id":1,"content":"  "}`, &models.WSChatPayload{}, true},
+		{"chat content too long", `{"chat_id":1,"content":"` + strings.Repeat("я", 2001) + `"}`, &models.WSChatPayload{}, true},
+		{"chat client_msg_id too long", `{"chat_id":1,"content":"hi","client_msg_id":"` + strings.Repeat("x", 65) + `"}`, &models.WSChatPayload{}, true},
+		{"chat reply_to_id is string", `{"chat_id":1,"content":"hi","reply_to_id":"2"}`, &models.WSChatPayload{}, true},
+		{"encrypted chat without content", `{"chat_id":1,"content":"","type":"encrypted"}`, &models.WSChatPayload{}, false},
+		{"typing is string", `{"chat_id":1,"typing":"yes"}`, &models.WSTypingPayload{}, true},
+		{"read without seq", `{"chat_id":1}`, &models.WSReadPayload{}, true},
+		{"delivered zero id", `{"message_ids":[1,0]}`, &models.WSDeliveredPayload{}, true},
+		{"delivered is object", `{"message_ids":{"1":true}}`, &models.WSDeliveredPayload{}, true},
+		{"unknown status", `{"status":"sleeping"}`, &models.WSStatusPayload{}, true},
+		{"status", `{"status":"away"}`, &models.WSStatusPayload{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &models.WebSocketMessage{Version: models.WSProtocolVersion, Type: "test"}
+			if tt.payload != "" {
+				message.Payload = json.RawMessage(tt.payload)
+			}
+
+			err := message.DecodePayload(tt.dst)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("DecodePayload: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, models.ErrWSInvalidPayload) {
+				t.Fatalf("err = %v, want %v", err, models.ErrWSInvalidPayload)
+			}
+			if code := errorCode(err); code != "invalid_payload" {
+				t.Fatalf("errorCode = %q, want %q", code, "invalid_payload")
+			}
+		})
+	}
+}
+
+// resumePayload запрос resume на n чатов
+func resumePayload(n int) string {
+	chats := make([]models.WSResumeChat, n)
+	for i := range chats {
+		chats[i].ChatID = uint(i + 1)
+	}
+	data, _ := json.Marshal(models.WSResumePayload{Chats: chats})
+	return string(data)
+}
+
+func TestHandleMessageRejectsMalformedRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		code  string
+	}{
+		{"unknown type", `{"type":"subscribe","id":"r1","payload":{"chat_id":1}}`, "unknown_type"},
+		{"server-only type", `{"type":"ack","id":"r1","payload":{}}`, "unknown_type"},
+		{"join without payload", `{"type":"join","id":"r1"}`, "invalid_payload"},
+		{"chat with wrong payload type", `{"type":"chat","id":"r1","payload":{"chat_id":1,"content":5}}`, "invalid_payload"},
+		{"delivered without ids", `{"type":"delivered","id":"r1","payload":{"message_ids":[]}}`, "invalid_payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{UserID: 1, Username: "alice", queue: newSendQueue(defaultQueueSize, time.Second)}
+			message, err := models.DecodeWebSocketMessage([]byte(tt.frame))
+			if err != nil {
+				t.Fatalf("DecodeWebSocketMessage: %v", err)
+			}
+			client.handleMessage(message)
+
+			frames, _ := client.queue.drain()
+			if len(frames) != 1 {
+				t.Fatalf("got %d frames, want 1", len(frames))
+			}
+			reply, err := models.DecodeWebSocketMessage(frames[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload models.WSErrorPayload
+			if err := json.Unmarshal(reply.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Type != models.WSMessageTypeError || reply.AckID != "r1" {
+				t.Fatalf("reply = %s ack %q, want error ack %q", reply.Type, reply.AckID, "r1")
+			}
+			if payload.Code != tt.code || payload.RequestType != message.Type {
+				t.Fatalf("error = %+v, want code %q for %q", payload, tt.code, message.Type)
+			}
+		})
+	}
+}
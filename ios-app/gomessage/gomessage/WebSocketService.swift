@@ -18,25 +18,20 @@ class WebSocketService: ObservableObject {
     func connect() {
         guard let url = URL(string: baseURL) else { return }
         
-        // Добавляем параметры для подключения к WebSocket - ТОЧНО КАК НА САЙТЕ
-        var components = URLComponents(url: url, resolvingAgainstBaseURL: false)
-        var queryItems: [URLQueryItem] = []
-        
-        // Добавляем user_id и username как на сайте - БЕЗ ТОКЕНА!
-        if let currentUser = APIService.shared.currentUser {
-            queryItems.append(URLQueryItem(name: "user_id", value: "\(currentUser.id)"))
-            queryItems.append(URLQueryItem(name: "username", value: currentUser.username))
+        // Сервер больше не принимает user_id в query: соединение
+        // аутентифицируется access токеном в заголовке Authorization
+        guard let token = APIService.shared.authToken else {
+            print("❌ Нет токена для подключения к WebSocket")
+            return
         }
         
-        // НЕ добавляем токен - как на сайте!
-        
-        components?.queryItems = queryItems
-        guard let finalURL = components?.url else { return }
+        var request = URLRequest(url: url)
+        request.setValue("Bearer \(token)", forHTTPHeaderField: "Authorization")
         
-        print("🔌 Подключаемся к WebSocket: \(finalURL)")
+        print("🔌 Подключаемся к WebSocket: \(url)")
         
         let session = URLSession(configuration: .default)
-        webSocket = session.webSocketTask(with: finalURL)
+        webSocket = session.webSocketTask(with: request)
         webSocket?.resume()
         
         isConnected = true
@@ -1,28 +1,71 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"gomessage/internal/server"
 	"gomessage/internal/config"
 	"gomessage/internal/models"
 	"gomessage/internal/crypto"
+	"gomessage/internal/storage"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// run запускает сервер и возвращает ошибку, не завершая процесс, чтобы
+// отложенное закрытие хранилища выполнилось при любом исходе
+func run() error {
 	// Загружаем конфигурацию
 	cfg := config.Load()
 
-	// Создаем тестового пользователя для демонстрации
+	// Параметры хеширования паролей нужны до создания тестового пользователя
+	if err := crypto.SetPasswordConfig(cfg.Password); err != nil {
+		return fmt.Errorf("ошибка конфигурации паролей: %w", err)
+	}
+
+	// Подключаем хранилище
+	repos, err := storage.Open(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("ошибка подключения хранилища: %w", err)
+	}
+	defer repos.Close()
+
+	// Шифрование текста сообщений, если заданы ключи
+	ring, err := crypto.ParseKeyRing(cfg.Messages.EncryptionKeys, cfg.Messages.EncryptionKeyID)
+	if err != nil {
+		return fmt.Errorf("ошибка конфигурации шифрования: %w", err)
+	}
+	if ring != nil {
+		ring.SetStrict(cfg.Messages.EncryptionStrict)
+		if err := repos.Encrypt(ring); err != nil {
+			return fmt.Errorf("ошибка включения шифрования: %w", err)
+		}
+		log.Printf("🔒 Шифрование сообщений включено, активный ключ: %s", ring.ActiveKeyID())
+	}
+
+	repos.Install()
+	log.Printf("🗄️ Хранилище: %s", cfg.Database.Driver)
+
+	// Создаем тестового пользователя и общий чат для демонстрации
 	createTestUser()
+	createGeneralChat()
 
 	// Создаем и запускаем сервер
-	srv := server.New(cfg)
-	
+	srv, err := server.New(cfg)
+	if err != nil {
+		return fmt.Errorf("ошибка создания сервера: %w", err)
+	}
+
 	log.Printf("🚀 GoMessage сервер запускается на порту %s", cfg.Server.Port)
-	
+
 	if err := srv.Run(); err != nil {
-		log.Fatalf("❌ Ошибка запуска сервера: %v", err)
+		return fmt.Errorf("ошибка запуска сервера: %w", err)
 	}
+	return nil
 }
 
 // createTestUser создает тестового пользователя для демонстрации
@@ -41,21 +84,15 @@ func createTestUser() {
 		return
 	}
 	
-	// Генерируем соль и хешируем пароль
-	salt, err := cryptoService.GenerateSalt()
-	if err != nil {
-		log.Printf("❌ Ошибка генерации соли: %v", err)
-		return
-	}
-	
-	hash, err := cryptoService.HashPassword(password, salt)
+	// Хешируем пароль; соль хранится в строке хеша
+	hash, err := cryptoService.NewPasswordHash(password)
 	if err != nil {
 		log.Printf("❌ Ошибка хеширования пароля: %v", err)
 		return
 	}
 	
 	// Создаем пользователя
-	user, err := models.GlobalUserStore.CreateUser(username, email, hash, salt)
+	user, err := models.GlobalUserStore.CreateUser(username, email, hash, "")
 	if err != nil {
 		log.Printf("❌ Ошибка создания тестового пользователя: %v", err)
 		return
@@ -69,3 +106,38 @@ func createTestUser() {
 	// Показываем общее количество пользователей
 	log.Printf("📊 Всего пользователей в системе: %d", models.GlobalUserStore.GetUsersCount())
 }
+
+// createGeneralChat создает общий групповой чат, к которому подключается веб-клиент.
+// Чат открытый: веб-клиент вступает в него при каждом подключении.
+func createGeneralChat() {
+	if chat, err := models.GlobalChatStore.GetChatByID(1); err == nil {
+		// Чат, созданный до появления закрытых чатов, остался закрытым
+		if !chat.Public {
+			chat.Public = true
+			if err := models.GlobalChatStore.UpdateChat(chat); err != nil {
+				log.Printf("❌ Ошибка открытия общего чата: %v", err)
+			}
+		}
+		return
+	}
+	
+	owner, err := models.GlobalUserStore.GetUserByUsername("testuser")
+	if err != nil {
+		log.Printf("❌ Не найден владелец общего чата: %v", err)
+		return
+	}
+	
+	chat, err := models.GlobalChatStore.CreateChat(&models.Chat{
+		Name:         "Общий чат",
+		Type:         models.ChatTypeGroup,
+		CreatorID:    owner.ID,
+		ReadReceipts: true,
+		Public:       true,
+	}, []models.ChatMember{{UserID: owner.ID, Role: models.ChatRoleOwner}})
+	if err != nil {
+		log.Printf("❌ Ошибка создания общего чата: %v", err)
+		return
+	}
+	
+	log.Printf("✅ Создан общий чат: %s (ID: %d)", chat.Name, chat.ID)
+}
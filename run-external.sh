@@ -18,6 +18,8 @@ echo ""
 # Устанавливаем переменные окружения для внешнего доступа
 export SERVER_HOST="0.0.0.0"
 export SERVER_PORT="8080"
+# Без JWT_SECRET сервер не запускается; для локального запуска подойдет случайный
+export JWT_SECRET="${JWT_SECRET:-$(head -c 32 /dev/urandom | base64)}"
 
 echo "🚀 Запуск сервера на $SERVER_HOST:$SERVER_PORT..."
 echo ""